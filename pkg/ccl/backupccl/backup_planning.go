@@ -1042,6 +1042,7 @@ func backupPlanHook(
 			if err != nil {
 				return err
 			}
+			p.RecordStatementJob(*aj.ID(), true /* inStmtTxn */)
 
 			// The protect timestamp logic for a DETACHED BACKUP can be run within the
 			// same txn as the BACKUP is being planned in, because we do not wait for
@@ -1074,6 +1075,7 @@ func backupPlanHook(
 			}
 			return err
 		}
+		p.RecordStatementJob(*sj.ID(), false /* inStmtTxn */)
 
 		collectTelemetry()
 
@@ -1616,6 +1616,7 @@ func doRestorePlan(
 		if err != nil {
 			return err
 		}
+		p.RecordStatementJob(*aj.ID(), true /* inStmtTxn */)
 		resultsCh <- tree.Datums{tree.NewDInt(tree.DInt(*aj.ID()))}
 		collectTelemetry()
 		return nil
@@ -1636,6 +1637,7 @@ func doRestorePlan(
 		}
 		return err
 	}
+	p.RecordStatementJob(*sj.ID(), false /* inStmtTxn */)
 
 	collectTelemetry()
 	return sj.Run(ctx)
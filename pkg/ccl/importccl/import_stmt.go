@@ -867,6 +867,7 @@ func importPlanHook(
 			}
 			return err
 		}
+		p.RecordStatementJob(*sj.ID(), false /* inStmtTxn */)
 
 		err = sj.Run(ctx)
 		if err != nil {
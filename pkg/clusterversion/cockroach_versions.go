@@ -60,6 +60,7 @@ const (
 	Version20_2
 	VersionStart21_1
 	VersionEmptyArraysInInvertedIndexes
+	VersionStatementDiagnosticsRequestOptions
 
 	// Add new versions here (step one of two).
 )
@@ -268,6 +269,18 @@ var versionsSingleton = keyedVersions([]keyedVersion{
 		Key:     VersionEmptyArraysInInvertedIndexes,
 		Version: roachpb.Version{Major: 20, Minor: 2, Internal: 2},
 	},
+	{
+		// VersionStatementDiagnosticsRequestOptions is when the columns storing
+		// the options of diagnostics requests (plan_gist, max_captures,
+		// span_filters, verbosity, skip_executions, user_name, database_name,
+		// prepared_statement_name, log_verbosity, tags and min_rows), as well as
+		// the num_captures column, were added to
+		// system.statement_diagnostics_requests, and the request_id,
+		// sample_index and trace_hash columns were added to
+		// system.statement_diagnostics.
+		Key:     VersionStatementDiagnosticsRequestOptions,
+		Version: roachpb.Version{Major: 20, Minor: 2, Internal: 3},
+	},
 
 	// Add new versions here (step two of two).
 })
@@ -12,6 +12,7 @@ package roachpb
 
 import (
 	"math"
+	"sort"
 
 	"github.com/cockroachdb/cockroach/pkg/util"
 )
@@ -130,10 +131,26 @@ func (s *StatementStatistics) Add(other *StatementStatistics) {
 	s.BytesRead.Add(other.BytesRead, s.Count, other.Count)
 	s.RowsRead.Add(other.RowsRead, s.Count, other.Count)
 	s.BytesSentOverNetwork.Add(other.BytesSentOverNetwork, s.Count, other.Count)
+	s.FullScan = s.FullScan || other.FullScan
+	s.RowsWritten.Add(other.RowsWritten, s.Count, other.Count)
+	s.FullyVectorized = s.FullyVectorized || other.FullyVectorized
+	s.AddIndexes(other.Indexes)
+	s.LockWaitLat.Add(other.LockWaitLat, s.Count, other.Count)
+	s.LatchWaitLat.Add(other.LatchWaitLat, s.Count, other.Count)
+	s.TxnQueueWaitLat.Add(other.TxnQueueWaitLat, s.Count, other.Count)
+	s.RecordProcessorsPerNode(other.ProcessorsPerNode)
+	for _, n := range other.GatewayNodes {
+		s.RecordGatewayNode(n)
+	}
+	s.VectorizedRowConversions.Add(other.VectorizedRowConversions, s.Count, other.Count)
+	s.RangesScanned.Add(other.RangesScanned, s.Count, other.Count)
 
 	if other.SensitiveInfo.LastErr != "" {
 		s.SensitiveInfo.LastErr = other.SensitiveInfo.LastErr
 	}
+	if other.SensitiveInfo.LastRetryCauses != "" {
+		s.SensitiveInfo.LastRetryCauses = other.SensitiveInfo.LastRetryCauses
+	}
 
 	if s.SensitiveInfo.MostRecentPlanTimestamp.Before(other.SensitiveInfo.MostRecentPlanTimestamp) {
 		s.SensitiveInfo = other.SensitiveInfo
@@ -157,5 +174,102 @@ func (s *StatementStatistics) AlmostEqual(other *StatementStatistics, eps float6
 		s.SensitiveInfo.Equal(other.SensitiveInfo) &&
 		s.BytesRead.AlmostEqual(other.BytesRead, eps) &&
 		s.RowsRead.AlmostEqual(other.RowsRead, eps) &&
-		s.BytesSentOverNetwork.AlmostEqual(other.BytesSentOverNetwork, eps)
+		s.BytesSentOverNetwork.AlmostEqual(other.BytesSentOverNetwork, eps) &&
+		s.FullScan == other.FullScan &&
+		s.RowsWritten.AlmostEqual(other.RowsWritten, eps) &&
+		s.FullyVectorized == other.FullyVectorized &&
+		indexesEqual(s.Indexes, other.Indexes) &&
+		s.LockWaitLat.AlmostEqual(other.LockWaitLat, eps) &&
+		s.LatchWaitLat.AlmostEqual(other.LatchWaitLat, eps) &&
+		s.TxnQueueWaitLat.AlmostEqual(other.TxnQueueWaitLat, eps) &&
+		processorsPerNodeEqual(s.ProcessorsPerNode, other.ProcessorsPerNode) &&
+		gatewayNodesEqual(s.GatewayNodes, other.GatewayNodes) &&
+		s.VectorizedRowConversions.AlmostEqual(other.VectorizedRowConversions, eps) &&
+		s.RangesScanned.AlmostEqual(other.RangesScanned, eps)
+}
+
+// AddIndexes adds the given indexes (in the form tableID@indexID) to the set of
+// indexes read by the statement. The set is kept sorted.
+func (s *StatementStatistics) AddIndexes(indexes []string) {
+	for _, idx := range indexes {
+		i := sort.SearchStrings(s.Indexes, idx)
+		if i < len(s.Indexes) && s.Indexes[i] == idx {
+			continue
+		}
+		// Don't modify the slice in place, as it may be shared with a copy of the
+		// statistics.
+		merged := make([]string, 0, len(s.Indexes)+1)
+		merged = append(merged, s.Indexes[:i]...)
+		merged = append(merged, idx)
+		s.Indexes = append(merged, s.Indexes[i:]...)
+	}
+}
+
+// RecordGatewayNode adds the SQL instance ID of a gateway node that executed
+// the statement to the set of gateway nodes. The set is kept sorted.
+func (s *StatementStatistics) RecordGatewayNode(id int64) {
+	i := sort.Search(len(s.GatewayNodes), func(i int) bool { return s.GatewayNodes[i] >= id })
+	if i < len(s.GatewayNodes) && s.GatewayNodes[i] == id {
+		return
+	}
+	// Don't modify the slice in place, as it may be shared with a copy of the
+	// statistics.
+	merged := make([]int64, 0, len(s.GatewayNodes)+1)
+	merged = append(merged, s.GatewayNodes[:i]...)
+	merged = append(merged, id)
+	s.GatewayNodes = append(merged, s.GatewayNodes[i:]...)
+}
+
+// RecordProcessorsPerNode adds the number of processors assigned to each node
+// by the physical plan of an execution of the statement to ProcessorsPerNode.
+func (s *StatementStatistics) RecordProcessorsPerNode(counts map[NodeID]int64) {
+	if len(counts) == 0 {
+		return
+	}
+	// Don't modify the map in place, as it may be shared with a copy of the
+	// statistics.
+	merged := make(map[NodeID]int64, len(s.ProcessorsPerNode)+len(counts))
+	for nodeID, count := range s.ProcessorsPerNode {
+		merged[nodeID] = count
+	}
+	for nodeID, count := range counts {
+		merged[nodeID] += count
+	}
+	s.ProcessorsPerNode = merged
+}
+
+func indexesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func gatewayNodesEqual(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func processorsPerNodeEqual(a, b map[NodeID]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for nodeID, count := range a {
+		if otherCount, ok := b[nodeID]; !ok || otherCount != count {
+			return false
+		}
+	}
+	return true
 }
@@ -81,3 +81,60 @@ func TestAddNumericStats(t *testing.T) {
 		t.Fatalf("a.Add(b) should match add(a, b): %+v vs %+v", a, combined)
 	}
 }
+
+func TestAddIndexes(t *testing.T) {
+	a := StatementStatistics{Count: 1}
+	a.AddIndexes([]string{"53@2", "52@1"})
+	b := StatementStatistics{Count: 1}
+	b.AddIndexes([]string{"52@1", "54@1"})
+
+	// Keep a copy of a to check that adding to a doesn't modify it.
+	aCopy := a
+	a.Add(&b)
+
+	if exp := []string{"52@1", "53@2", "54@1"}; !indexesEqual(a.Indexes, exp) {
+		t.Fatalf("expected indexes %v, got %v", exp, a.Indexes)
+	}
+	if exp := []string{"52@1", "53@2"}; !indexesEqual(aCopy.Indexes, exp) {
+		t.Fatalf("expected copied indexes %v, got %v", exp, aCopy.Indexes)
+	}
+}
+
+func TestAddProcessorsPerNode(t *testing.T) {
+	a := StatementStatistics{Count: 1}
+	a.RecordProcessorsPerNode(map[NodeID]int64{10: 1, 2: 3})
+	b := StatementStatistics{Count: 1}
+	b.RecordProcessorsPerNode(map[NodeID]int64{2: 1, 3: 2})
+
+	// Keep a copy of a to check that adding to a doesn't modify it.
+	aCopy := a
+	a.Add(&b)
+
+	if exp := map[NodeID]int64{2: 4, 3: 2, 10: 1}; !processorsPerNodeEqual(a.ProcessorsPerNode, exp) {
+		t.Fatalf("expected processors per node %v, got %v", exp, a.ProcessorsPerNode)
+	}
+	if exp := map[NodeID]int64{2: 3, 10: 1}; !processorsPerNodeEqual(aCopy.ProcessorsPerNode, exp) {
+		t.Fatalf("expected copied processors per node %v, got %v", exp, aCopy.ProcessorsPerNode)
+	}
+}
+
+func TestRecordGatewayNode(t *testing.T) {
+	a := StatementStatistics{Count: 1}
+	a.RecordGatewayNode(3)
+	a.RecordGatewayNode(1)
+	a.RecordGatewayNode(3)
+	b := StatementStatistics{Count: 1}
+	b.RecordGatewayNode(2)
+	b.RecordGatewayNode(1)
+
+	// Keep a copy of a to check that adding to a doesn't modify it.
+	aCopy := a
+	a.Add(&b)
+
+	if exp := []int64{1, 2, 3}; !gatewayNodesEqual(a.GatewayNodes, exp) {
+		t.Fatalf("expected gateway nodes %v, got %v", exp, a.GatewayNodes)
+	}
+	if exp := []int64{1, 3}; !gatewayNodesEqual(aCopy.GatewayNodes, exp) {
+		t.Fatalf("expected copied gateway nodes %v, got %v", exp, aCopy.GatewayNodes)
+	}
+}
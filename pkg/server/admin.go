@@ -11,12 +11,14 @@
 package server
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -50,6 +52,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/ts/catalog"
 	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
 	"github.com/cockroachdb/cockroach/pkg/util/envutil"
+	"github.com/cockroachdb/cockroach/pkg/util/httputil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/mon"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
@@ -159,7 +162,54 @@ func (s *adminServer) RegisterGateway(
 			http.Error(w, "invalid id", http.StatusBadRequest)
 			return
 		}
-		s.getStatementBundle(ctx, id, w)
+		s.getStatementBundle(ctx, id, w, req)
+	})
+
+	// Register the /_admin/v1/stmtbundle/{id}/files endpoint, which lists the
+	// files in a statement bundle without serving the whole bundle.
+	stmtBundleFilesPattern := gwruntime.MustPattern(gwruntime.NewPattern(
+		1, /* version */
+		[]int{
+			int(gwutil.OpLitPush), 0, int(gwutil.OpLitPush), 1, int(gwutil.OpLitPush), 2,
+			int(gwutil.OpPush), 0, int(gwutil.OpConcatN), 1, int(gwutil.OpCapture), 3,
+			int(gwutil.OpLitPush), 4},
+		[]string{"_admin", "v1", "stmtbundle", "id", "files"},
+		"", /* verb */
+	))
+
+	mux.Handle("GET", stmtBundleFilesPattern, func(
+		w http.ResponseWriter, req *http.Request, pathParams map[string]string,
+	) {
+		id, err := strconv.ParseInt(pathParams["id"], 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		s.getStatementBundleFiles(ctx, id, w)
+	})
+
+	// Register the /_admin/v1/stmtbundle/{id}/files/{name} endpoint, which
+	// serves a single file of a statement bundle.
+	stmtBundleFilePattern := gwruntime.MustPattern(gwruntime.NewPattern(
+		1, /* version */
+		[]int{
+			int(gwutil.OpLitPush), 0, int(gwutil.OpLitPush), 1, int(gwutil.OpLitPush), 2,
+			int(gwutil.OpPush), 0, int(gwutil.OpConcatN), 1, int(gwutil.OpCapture), 3,
+			int(gwutil.OpLitPush), 4,
+			int(gwutil.OpPush), 0, int(gwutil.OpConcatN), 1, int(gwutil.OpCapture), 5},
+		[]string{"_admin", "v1", "stmtbundle", "id", "files", "name"},
+		"", /* verb */
+	))
+
+	mux.Handle("GET", stmtBundleFilePattern, func(
+		w http.ResponseWriter, req *http.Request, pathParams map[string]string,
+	) {
+		id, err := strconv.ParseInt(pathParams["id"], 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		s.getStatementBundleFile(ctx, id, pathParams["name"], w)
 	})
 
 	// Register the endpoints defined in the proto.
@@ -1606,7 +1656,9 @@ func (s *adminServer) QueryPlan(
 
 // getStatementBundle retrieves the statement bundle with the given id and
 // writes it out as an attachment.
-func (s *adminServer) getStatementBundle(ctx context.Context, id int64, w http.ResponseWriter) {
+func (s *adminServer) getStatementBundle(
+	ctx context.Context, id int64, w http.ResponseWriter, req *http.Request,
+) {
 	sessionUser, err := userFromContext(ctx)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -1649,14 +1701,241 @@ func (s *adminServer) getStatementBundle(ctx context.Context, id int64, w http.R
 		bundle.WriteString(string(*data))
 	}
 
+	// The bundle is always a zip archive; when zstd compression is enabled it
+	// applies to the files inside the archive. Independently of that, the
+	// response is gzip-compressed on the wire if the client accepts it.
+	w.Header().Set("Content-Type", "application/zip")
 	w.Header().Set(
 		"Content-Disposition",
 		fmt.Sprintf("attachment; filename=stmt-bundle-%d.zip", id),
 	)
+	switch {
+	case w.Header().Get(httputil.ContentEncodingHeader) == httputil.GzipEncoding:
+		// The response is already being compressed (see Server.ServeHTTP), so the
+		// length on the wire is not known upfront.
+	case strings.Contains(req.Header.Get(httputil.AcceptEncodingHeader), httputil.GzipEncoding):
+		w.Header().Set(httputil.ContentEncodingHeader, httputil.GzipEncoding)
+		gzw := newGzipResponseWriter(w)
+		defer func() {
+			if err := gzw.Close(); err != nil {
+				log.Warningf(ctx, "error closing gzip response writer: %v", err)
+			}
+		}()
+		w = gzw
+	default:
+		w.Header().Set("Content-Length", strconv.Itoa(bundle.Len()))
+	}
 
 	_, _ = io.Copy(w, &bundle)
 }
 
+// stmtBundleFile describes a file in a statement bundle, as returned by the
+// /_admin/v1/stmtbundle/{id}/files endpoint.
+type stmtBundleFile struct {
+	Name           string `json:"name"`
+	Size           uint64 `json:"size"`
+	CompressedSize uint64 `json:"compressed_size"`
+}
+
+// getStatementBundleFiles writes out the list of files in the statement bundle
+// with the given id, along with their sizes, as JSON. Only the zip central
+// directory of the bundle is read, which is at the end of the bundle; the
+// chunks that hold the contents of the files are not read.
+func (s *adminServer) getStatementBundleFiles(
+	ctx context.Context, id int64, w http.ResponseWriter,
+) {
+	zr := s.openStatementBundle(ctx, id, w)
+	if zr == nil {
+		return
+	}
+	files := make([]stmtBundleFile, len(zr.File))
+	for i, f := range zr.File {
+		files[i] = stmtBundleFile{
+			Name:           f.Name,
+			Size:           f.UncompressedSize64,
+			CompressedSize: f.CompressedSize64,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Files []stmtBundleFile `json:"files"`
+	}{Files: files}); err != nil {
+		log.Warningf(ctx, "error writing statement bundle files: %v", err)
+	}
+}
+
+// getStatementBundleFile writes out the file with the given name in the
+// statement bundle with the given id as an attachment. Only the zip central
+// directory and the chunks that hold the file are read.
+func (s *adminServer) getStatementBundleFile(
+	ctx context.Context, id int64, name string, w http.ResponseWriter,
+) {
+	zr := s.openStatementBundle(ctx, id, w)
+	if zr == nil {
+		return
+	}
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rc.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(
+			"Content-Disposition",
+			fmt.Sprintf("attachment; filename=stmt-bundle-%d-%s", id, path.Base(name)),
+		)
+		w.Header().Set("Content-Length", strconv.FormatUint(f.UncompressedSize64, 10))
+		if _, err := io.Copy(w, rc); err != nil {
+			log.Warningf(ctx, "error writing statement bundle file: %v", err)
+		}
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+}
+
+// openStatementBundle returns a zip.Reader over the statement bundle with the
+// given id, which reads the chunks of the bundle as they are needed. If the
+// bundle can't be opened, an error is written to w and nil is returned.
+func (s *adminServer) openStatementBundle(
+	ctx context.Context, id int64, w http.ResponseWriter,
+) *zip.Reader {
+	sessionUser, err := userFromContext(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	bundle, err := newStmtBundleReader(ctx, s.server.sqlServer.internalExecutor, sessionUser, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	if bundle == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return nil
+	}
+	zr, err := sql.OpenBundle(bundle, bundle.size())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	return zr
+}
+
+// stmtBundleReader is an io.ReaderAt over a statement bundle stored in
+// system.statement_bundle_chunks. Chunks are only read the first time they are
+// accessed, which allows reading parts of a bundle (for example the zip
+// central directory) without reading the entire bundle.
+type stmtBundleReader struct {
+	ctx  context.Context
+	ie   *sql.InternalExecutor
+	user security.SQLUsername
+
+	chunkIDs []tree.Datum
+	// offsets contains the offset of each chunk in the bundle, followed by the
+	// size of the bundle.
+	offsets []int64
+	// chunks contains the data of the chunks that were read so far.
+	chunks map[int][]byte
+}
+
+var _ io.ReaderAt = (*stmtBundleReader)(nil)
+
+// newStmtBundleReader returns a stmtBundleReader for the statement bundle with
+// the given id, or nil if there is no such bundle. Only the sizes of the chunks
+// of the bundle are retrieved.
+func newStmtBundleReader(
+	ctx context.Context, ie *sql.InternalExecutor, user security.SQLUsername, id int64,
+) (*stmtBundleReader, error) {
+	override := sessiondata.InternalExecutorOverride{User: user}
+	row, err := ie.QueryRowEx(
+		ctx, "admin-stmt-bundle", nil, /* txn */
+		override,
+		"SELECT bundle_chunks FROM system.statement_diagnostics WHERE id=$1 AND bundle_chunks IS NOT NULL",
+		id,
+	)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	r := &stmtBundleReader{
+		ctx:      ctx,
+		ie:       ie,
+		user:     user,
+		chunkIDs: row[0].(*tree.DArray).Array,
+		chunks:   make(map[int][]byte),
+	}
+	r.offsets = make([]int64, len(r.chunkIDs)+1)
+	for i, chunkID := range r.chunkIDs {
+		sizeRow, err := ie.QueryRowEx(
+			ctx, "admin-stmt-bundle-chunk-size", nil, /* txn */
+			override,
+			"SELECT length(data) FROM system.statement_bundle_chunks WHERE id=$1",
+			chunkID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if sizeRow == nil {
+			return nil, errors.Errorf("missing chunk %s of statement bundle %d", chunkID, id)
+		}
+		r.offsets[i+1] = r.offsets[i] + int64(tree.MustBeDInt(sizeRow[0]))
+	}
+	return r, nil
+}
+
+// size returns the size of the bundle, in bytes.
+func (r *stmtBundleReader) size() int64 {
+	return r.offsets[len(r.chunkIDs)]
+}
+
+// chunk returns the data of the i-th chunk of the bundle.
+func (r *stmtBundleReader) chunk(i int) ([]byte, error) {
+	if data, ok := r.chunks[i]; ok {
+		return data, nil
+	}
+	row, err := r.ie.QueryRowEx(
+		r.ctx, "admin-stmt-bundle", nil, /* txn */
+		sessiondata.InternalExecutorOverride{User: r.user},
+		"SELECT data FROM system.statement_bundle_chunks WHERE id=$1",
+		r.chunkIDs[i],
+	)
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, errors.Errorf("missing chunk %s of statement bundle", r.chunkIDs[i])
+	}
+	data := []byte(*row[0].(*tree.DBytes))
+	r.chunks[i] = data
+	return data, nil
+}
+
+// ReadAt is part of the io.ReaderAt interface.
+func (r *stmtBundleReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	for n < len(p) {
+		if off >= r.size() {
+			return n, io.EOF
+		}
+		// Find the chunk that contains off.
+		i := sort.Search(len(r.chunkIDs), func(i int) bool { return r.offsets[i+1] > off })
+		data, err := r.chunk(i)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], data[off-r.offsets[i]:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
 // DecommissionStatus returns the DecommissionStatus for all or the given nodes.
 func (s *adminServer) DecommissionStatus(
 	ctx context.Context, req *serverpb.DecommissionStatusRequest,
@@ -16,6 +16,7 @@ import (
 	gosql "database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math"
 	"net/http"
@@ -1999,3 +2000,72 @@ func TestEndpointTelemetryBasic(t *testing.T) {
 		"/cockroach.server.serverpb.Status/Statements",
 	)))
 }
+
+func TestAdminAPIStatementBundleFiles(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, sqlDB, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(sqlDB)
+
+	r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT 1")
+	var id int64
+	r.QueryRow(t, "SELECT max(id) FROM system.statement_diagnostics").Scan(&id)
+
+	filesURL := func(id int64) string {
+		return fmt.Sprintf("%s/_admin/v1/stmtbundle/%d/files", s.AdminURL(), id)
+	}
+	var resp struct {
+		Files []stmtBundleFile `json:"files"`
+	}
+	body, err := getText(s, filesURL(id))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(body, &resp), "body is:\n%s", body)
+	var statement *stmtBundleFile
+	for i := range resp.Files {
+		if resp.Files[i].Name == "statement.txt" {
+			statement = &resp.Files[i]
+		}
+	}
+	require.NotNil(t, statement, "statement.txt not found in %v", resp.Files)
+	require.NotZero(t, statement.Size)
+
+	// A single file can be downloaded.
+	body, err = getText(s, filesURL(id)+"/statement.txt")
+	require.NoError(t, err)
+	require.Equal(t, int(statement.Size), len(body))
+	require.Contains(t, string(body), "SELECT 1")
+
+	// Unknown bundles and files are not found.
+	httpClient, err := s.GetAdminAuthenticatedHTTPClient()
+	require.NoError(t, err)
+	for _, u := range []string{filesURL(id + 1), filesURL(id) + "/missing.txt"} {
+		httpResp, err := httpClient.Get(u)
+		require.NoError(t, err)
+		require.NoError(t, httpResp.Body.Close())
+		require.Equal(t, http.StatusNotFound, httpResp.StatusCode, u)
+	}
+}
+
+// TestStmtBundleReader verifies that reads from a stmtBundleReader can span
+// multiple chunks.
+func TestStmtBundleReader(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	r := &stmtBundleReader{
+		chunkIDs: []tree.Datum{tree.NewDInt(1), tree.NewDInt(2), tree.NewDInt(3)},
+		offsets:  []int64{0, 3, 5, 9},
+		chunks:   map[int][]byte{0: []byte("abc"), 1: []byte("de"), 2: []byte("fghi")},
+	}
+	require.Equal(t, int64(9), r.size())
+
+	buf := make([]byte, 5)
+	n, err := r.ReadAt(buf, 2)
+	require.NoError(t, err)
+	require.Equal(t, "cdefg", string(buf[:n]))
+
+	n, err = r.ReadAt(buf, 6)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, "ghi", string(buf[:n]))
+}
@@ -634,6 +634,8 @@ func newSQLServer(ctx context.Context, cfg sqlServerArgs) (*sqlServer, error) {
 		cfg.Settings,
 	)
 	execCfg.StmtDiagnosticsRecorder = stmtDiagnosticsRegistry
+	cfg.registry.AddMetricStruct(stmtDiagnosticsRegistry.Metrics())
+	execCfg.BundleCollectionLimiter = sql.NewBundleCollectionLimiter(cfg.Settings)
 
 	if cfg.TenantID == roachpb.SystemTenantID {
 		// We only need to attach a version upgrade hook if we're the system
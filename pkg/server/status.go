@@ -53,6 +53,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql"
 	"github.com/cockroachdb/cockroach/pkg/sql/roleoption"
+	"github.com/cockroachdb/cockroach/pkg/sql/stmtdiagnostics"
 	"github.com/cockroachdb/cockroach/pkg/storage"
 	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
 	"github.com/cockroachdb/cockroach/pkg/util/httputil"
@@ -311,10 +312,10 @@ type statusServer struct {
 type StmtDiagnosticsRequester interface {
 
 	// InsertRequest adds an entry to system.statement_diagnostics_requests for
-	// tracing a query with the given fingerprint. Once this returns, calling
-	// shouldCollectDiagnostics() on the current node will return true for the given
-	// fingerprint.
-	InsertRequest(ctx context.Context, fprint string) error
+	// tracing a query as described by the given options. Once this returns,
+	// calling shouldCollectDiagnostics() on the current node will return true for
+	// the given fingerprint.
+	InsertRequest(ctx context.Context, opts stmtdiagnostics.RequestOptions) error
 }
 
 // newStatusServer allocates and returns a statusServer.
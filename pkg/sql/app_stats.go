@@ -21,6 +21,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/server/telemetry"
 	"github.com/cockroachdb/cockroach/pkg/settings"
@@ -60,8 +61,23 @@ type appStats struct {
 	stmts     map[stmtKey]*stmtStats
 	txnCounts transactionCounts
 	txns      map[txnKey]*txnStats
+
+	// pendingTraceStats buffers, by statement key, the statistics derived from
+	// the traces of the statements until they are recorded in stmts (see
+	// flushTraceStatsLocked). It has a lock of its own, so that buffering them
+	// doesn't contend with the other accesses to the statistics.
+	pendingTraceStats struct {
+		syncutil.Mutex
+		updates map[stmtKey][]traceStatsUpdate
+		count   int
+	}
 }
 
+// traceStatsFlushThreshold is the number of pending trace statistics of an
+// application after which they are recorded, even if the statistics of the
+// application aren't read or reset in the meantime.
+const traceStatsFlushThreshold = 128
+
 type txnStats struct {
 	statementIDs []roachpb.StmtID
 
@@ -90,6 +106,15 @@ type stmtStats struct {
 		// vectorization.
 		vectorized bool
 
+		// planGist is the gist of the most recently sampled plan of this
+		// statement.
+		planGist string
+
+		// planHistory contains the distinct plans sampled for this statement,
+		// in the order in which they were first seen. It is exposed by
+		// crdb_internal.node_statement_plan_history.
+		planHistory []planHistoryEntry
+
 		data roachpb.StatementStatistics
 	}
 }
@@ -169,10 +194,12 @@ func (a *appStats) recordStatement(
 	vectorized bool,
 	implicitTxn bool,
 	automaticRetryCount int,
+	retryCauses []string,
 	numRows int,
 	err error,
 	parseLat, planLat, runLat, svcLat, ovhLat float64,
 	stats topLevelQueryStats,
+	gateway base.SQLInstanceID,
 ) roachpb.StmtID {
 	createIfNonExistent := true
 	// If the statement is below the latency threshold, or stats aren't being
@@ -213,6 +240,9 @@ func (a *appStats) recordStatement(
 	} else if int64(automaticRetryCount) > s.mu.data.MaxRetries {
 		s.mu.data.MaxRetries = int64(automaticRetryCount)
 	}
+	if len(retryCauses) > 0 {
+		s.mu.data.SensitiveInfo.LastRetryCauses = strings.Join(retryCauses, "; ")
+	}
 	s.mu.data.NumRows.Record(s.mu.data.Count, float64(numRows))
 	s.mu.data.ParseLat.Record(s.mu.data.Count, parseLat)
 	s.mu.data.PlanLat.Record(s.mu.data.Count, planLat)
@@ -221,6 +251,7 @@ func (a *appStats) recordStatement(
 	s.mu.data.OverheadLat.Record(s.mu.data.Count, ovhLat)
 	s.mu.data.BytesRead.Record(s.mu.data.Count, float64(stats.bytesRead))
 	s.mu.data.RowsRead.Record(s.mu.data.Count, float64(stats.rowsRead))
+	s.mu.data.RecordGatewayNode(int64(gateway))
 	// Note that some fields derived from tracing statements (such as
 	// BytesSentOverNetwork) are not updated here because they are collected
 	// on-demand.
@@ -258,6 +289,61 @@ func (a *appStats) getStatsForStmt(
 	return s, s.ID
 }
 
+// recordTraceStats buffers the statistics derived from the trace of one
+// execution of a statement. They are recorded in the statistics of the
+// statement when the statistics of the application are read or reset, or once
+// traceStatsFlushThreshold of them are pending.
+func (a *appStats) recordTraceStats(
+	anonymizedStmt string, implicitTxn bool, err error, update traceStatsUpdate,
+) {
+	key := stmtKey{
+		anonymizedStmt: anonymizedStmt,
+		failed:         err != nil,
+		implicitTxn:    implicitTxn,
+	}
+	a.pendingTraceStats.Lock()
+	if a.pendingTraceStats.updates == nil {
+		a.pendingTraceStats.updates = make(map[stmtKey][]traceStatsUpdate)
+	}
+	a.pendingTraceStats.updates[key] = append(a.pendingTraceStats.updates[key], update)
+	a.pendingTraceStats.count++
+	flush := a.pendingTraceStats.count >= traceStatsFlushThreshold
+	a.pendingTraceStats.Unlock()
+
+	if flush {
+		a.Lock()
+		a.flushTraceStatsLocked()
+		a.Unlock()
+	}
+}
+
+// flushTraceStatsLocked records the pending trace statistics in the statistics
+// of their statements. The lock of the statistics of each statement is only
+// acquired once, however many executions of the statement are pending. The
+// trace statistics of statements without statistics (e.g. because
+// sql.metrics.statement_details.enabled is false) are dropped.
+//
+// a must be locked.
+func (a *appStats) flushTraceStatsLocked() {
+	a.pendingTraceStats.Lock()
+	pending := a.pendingTraceStats.updates
+	a.pendingTraceStats.updates = nil
+	a.pendingTraceStats.count = 0
+	a.pendingTraceStats.Unlock()
+
+	for key, updates := range pending {
+		s, ok := a.stmts[key]
+		if !ok {
+			continue
+		}
+		s.mu.Lock()
+		for i := range updates {
+			updates[i].apply(&s.mu.data)
+		}
+		s.mu.Unlock()
+	}
+}
+
 func (a *appStats) getStatsForStmtWithKey(
 	key stmtKey, stmtID roachpb.StmtID, createIfNonexistent bool,
 ) *stmtStats {
@@ -466,6 +552,62 @@ func (a *appStats) shouldSaveLogicalPlanDescription(anonymizedStmt string, impli
 	return now.Sub(timeLastSampled) >= period
 }
 
+// maxPlanHistoryEntries is the maximum number of distinct plans which are
+// remembered for a statement in its plan history. When it is exceeded, the
+// least recently seen plan is forgotten.
+const maxPlanHistoryEntries = 16
+
+// planHistoryEntry summarizes the sampled executions of a statement which used
+// plans with a given gist (see explain.PlanGist).
+type planHistoryEntry struct {
+	planGist  string
+	firstSeen time.Time
+	lastSeen  time.Time
+	count     int64
+}
+
+// recordPlanGist records the gist of the most recently sampled plan of the
+// given statement, adding it to the plan history of the statement, and returns
+// the gist of the previously sampled plan, or the empty string if there was
+// none. It is a no-op if statistics aren't recorded for the statement.
+func (a *appStats) recordPlanGist(
+	anonymizedStmt string, implicitTxn bool, err error, planGist string, now time.Time,
+) string {
+	stats, _ := a.getStatsForStmt(anonymizedStmt, implicitTxn, err, false /* createIfNonexistent */)
+	if stats == nil {
+		return ""
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	prev := stats.mu.planGist
+	stats.mu.planGist = planGist
+
+	history := stats.mu.planHistory
+	for i := range history {
+		if history[i].planGist == planGist {
+			history[i].lastSeen = now
+			history[i].count++
+			return prev
+		}
+	}
+	if len(history) >= maxPlanHistoryEntries {
+		oldest := 0
+		for i := range history {
+			if history[i].lastSeen.Before(history[oldest].lastSeen) {
+				oldest = i
+			}
+		}
+		history = append(history[:oldest], history[oldest+1:]...)
+	}
+	stats.mu.planHistory = append(history, planHistoryEntry{
+		planGist:  planGist,
+		firstSeen: now,
+		lastSeen:  now,
+		count:     1,
+	})
+	return prev
+}
+
 // sqlStats carries per-application statistics for all applications.
 type sqlStats struct {
 	syncutil.Mutex
@@ -524,6 +666,7 @@ func (s *sqlStats) resetAndMaybeDumpStats(ctx context.Context, target *sqlStats)
 	// application_name).
 	for appName, a := range s.apps {
 		a.Lock()
+		a.flushTraceStatsLocked()
 
 		// Save the existing data to logs.
 		// TODO(knz/dt): instead of dumping the stats to the log, save
@@ -668,6 +811,7 @@ func (s *sqlStats) getStmtStats(
 	salt := ClusterSecret.Get(&s.st.SV)
 	for appName, a := range s.apps {
 		a.Lock()
+		a.flushTraceStatsLocked()
 		if cap(ret) == 0 {
 			// guesstimate that we'll need apps*(queries-per-app).
 			ret = make([]roachpb.CollectedStatementStatistics, 0, len(a.stmts)*len(s.apps))
@@ -75,6 +75,8 @@ const (
 	CrdbInternalTxnStatsTableID
 	CrdbInternalZonesTableID
 	CrdbInternalInvalidDescriptorsTableID
+	CrdbInternalStmtPlanHistoryTableID
+	CrdbInternalStatementBundlesTableID
 	InformationSchemaID
 	InformationSchemaAdministrableRoleAuthorizationsID
 	InformationSchemaApplicableRolesID
@@ -296,9 +296,21 @@ CREATE TABLE system.statement_diagnostics_requests(
 	statement_fingerprint STRING NOT NULL,
 	statement_diagnostics_id INT8,
 	requested_at TIMESTAMPTZ NOT NULL,
+	plan_gist STRING,
+	max_captures INT8,
+	span_filters STRING[],
+	verbosity STRING,
+	skip_executions INT8,
+	user_name STRING,
+	database_name STRING,
+	prepared_statement_name STRING,
+	log_verbosity INT8,
+	tags JSONB,
+	min_rows INT8,
+	num_captures INT8,
 	INDEX completed_idx (completed, id) STORING (statement_fingerprint),
 
-	FAMILY "primary" (id, completed, statement_fingerprint, statement_diagnostics_id, requested_at)
+	FAMILY "primary" (id, completed, statement_fingerprint, statement_diagnostics_id, requested_at, plan_gist, max_captures, span_filters, verbosity, skip_executions, user_name, database_name, prepared_statement_name, log_verbosity, tags, min_rows, num_captures)
 );`
 
 	StatementDiagnosticsTableSchema = `
@@ -310,8 +322,11 @@ create table system.statement_diagnostics(
   trace JSONB,
   bundle_chunks INT ARRAY,
 	error STRING,
+	request_id INT8,
+	sample_index INT8,
+	trace_hash STRING,
 
-	FAMILY "primary" (id, statement_fingerprint, statement, collected_at, trace, bundle_chunks, error)
+	FAMILY "primary" (id, statement_fingerprint, statement, collected_at, trace, bundle_chunks, error, request_id, sample_index, trace_hash)
 );`
 
 	ScheduledJobsTableSchema = `
@@ -1532,13 +1547,28 @@ var (
 			{Name: "statement_fingerprint", ID: 3, Type: types.String, Nullable: false},
 			{Name: "statement_diagnostics_id", ID: 4, Type: types.Int, Nullable: true},
 			{Name: "requested_at", ID: 5, Type: types.TimestampTZ, Nullable: false},
-		},
-		NextColumnID: 6,
+			{Name: "plan_gist", ID: 6, Type: types.String, Nullable: true},
+			{Name: "max_captures", ID: 7, Type: types.Int, Nullable: true},
+			{Name: "span_filters", ID: 8, Type: types.StringArray, Nullable: true},
+			{Name: "verbosity", ID: 9, Type: types.String, Nullable: true},
+			{Name: "skip_executions", ID: 10, Type: types.Int, Nullable: true},
+			{Name: "user_name", ID: 11, Type: types.String, Nullable: true},
+			{Name: "database_name", ID: 12, Type: types.String, Nullable: true},
+			{Name: "prepared_statement_name", ID: 13, Type: types.String, Nullable: true},
+			{Name: "log_verbosity", ID: 14, Type: types.Int, Nullable: true},
+			{Name: "tags", ID: 15, Type: types.Jsonb, Nullable: true},
+			{Name: "min_rows", ID: 16, Type: types.Int, Nullable: true},
+			{Name: "num_captures", ID: 17, Type: types.Int, Nullable: true},
+		},
+		NextColumnID: 18,
 		Families: []descpb.ColumnFamilyDescriptor{
 			{
-				Name:        "primary",
-				ColumnNames: []string{"id", "completed", "statement_fingerprint", "statement_diagnostics_id", "requested_at"},
-				ColumnIDs:   []descpb.ColumnID{1, 2, 3, 4, 5},
+				Name: "primary",
+				ColumnNames: []string{"id", "completed", "statement_fingerprint", "statement_diagnostics_id",
+					"requested_at", "plan_gist", "max_captures", "span_filters", "verbosity",
+					"skip_executions", "user_name", "database_name", "prepared_statement_name",
+					"log_verbosity", "tags", "min_rows", "num_captures"},
+				ColumnIDs: []descpb.ColumnID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17},
 			},
 		},
 		NextFamilyID: 1,
@@ -1578,14 +1608,18 @@ var (
 			{Name: "trace", ID: 5, Type: types.Jsonb, Nullable: true},
 			{Name: "bundle_chunks", ID: 6, Type: types.IntArray, Nullable: true},
 			{Name: "error", ID: 7, Type: types.String, Nullable: true},
+			{Name: "request_id", ID: 8, Type: types.Int, Nullable: true},
+			{Name: "sample_index", ID: 9, Type: types.Int, Nullable: true},
+			{Name: "trace_hash", ID: 10, Type: types.String, Nullable: true},
 		},
-		NextColumnID: 8,
+		NextColumnID: 11,
 		Families: []descpb.ColumnFamilyDescriptor{
 			{
 				Name: "primary",
 				ColumnNames: []string{"id", "statement_fingerprint", "statement",
-					"collected_at", "trace", "bundle_chunks", "error"},
-				ColumnIDs: []descpb.ColumnID{1, 2, 3, 4, 5, 6, 7},
+					"collected_at", "trace", "bundle_chunks", "error", "request_id", "sample_index",
+					"trace_hash"},
+				ColumnIDs: []descpb.ColumnID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
 			},
 		},
 		NextFamilyID: 1,
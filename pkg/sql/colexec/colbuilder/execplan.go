@@ -166,6 +166,13 @@ func IsSupported(mode sessiondatapb.VectorizeExecMode, spec *execinfrapb.Process
 	return err
 }
 
+// IsWrapped returns whether the given spec is executed by wrapping the
+// corresponding row execution processor (rather than by a native columnar
+// operator) when its flow is vectorized.
+func IsWrapped(spec *execinfrapb.ProcessorSpec) bool {
+	return supportedNatively(spec) != nil
+}
+
 // supportedNatively checks whether we have a columnar operator equivalent to a
 // processor described by spec. Note that it doesn't perform any other checks
 // (like validity of the number of inputs).
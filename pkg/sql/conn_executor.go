@@ -311,6 +311,8 @@ func makeMetrics(internal bool) Metrics {
 				6*metricsSampleInterval),
 			SQLTxnLatency: metric.NewLatency(getMetricMeta(MetaSQLTxnLatency, internal),
 				6*metricsSampleInterval),
+			SQLInstrumentationOverhead: metric.NewLatency(
+				getMetricMeta(MetaSQLInstrumentationOverhead, internal), 6*metricsSampleInterval),
 
 			TxnAbortCount: metric.NewCounter(getMetricMeta(MetaTxnAbort, internal)),
 			FailureCount:  metric.NewCounter(getMetricMeta(MetaFailure, internal)),
@@ -957,6 +959,10 @@ type connExecutor struct {
 		// stateOpen.
 		autoRetryCounter int
 
+		// autoRetryCauses describes the causes of the automatic retries counted
+		// by autoRetryCounter (up to maxAutoRetryCauses of them).
+		autoRetryCauses []string
+
 		// numDDL keeps track of how many DDL statements have been
 		// executed so far.
 		numDDL int
@@ -1121,6 +1127,15 @@ type connExecutor struct {
 	// stmtDiagnosticsRecorder is used to track which queries need to have
 	// information collected.
 	stmtDiagnosticsRecorder *stmtdiagnostics.Registry
+
+	// sessionBundles tracks the bundles collected because of the
+	// collect_all_statement_bundles session variable.
+	sessionBundles sessionBundleState
+
+	// stmtDiagnosticsHistory retains lightweight diagnostics for the last
+	// statements of the session, if the statement_diagnostics_history_size
+	// session variable is set.
+	stmtDiagnosticsHistory stmtDiagnosticsHistory
 }
 
 // ctxHolder contains a connection's context and, while session tracing is
@@ -2116,6 +2131,7 @@ func (ex *connExecutor) initEvalCtx(ctx context.Context, evalCtx *extendedEvalCo
 		SchemaChangeJobCache: ex.extraTxnState.schemaChangeJobsCache,
 		schemaAccessors:      scInterface,
 		sqlStatsCollector:    ex.statsCollector,
+		stmtHistory:          &ex.stmtDiagnosticsHistory,
 	}
 }
 
@@ -2140,6 +2156,7 @@ func (ex *connExecutor) resetEvalCtx(evalCtx *extendedEvalContext, txn *kv.Txn,
 	evalCtx.Mon = ex.state.mon
 	evalCtx.PrepareOnly = false
 	evalCtx.SkipNormalize = false
+	evalCtx.ResetRand()
 }
 
 // getTransactionState retrieves a text representation of the given state.
@@ -2196,6 +2213,20 @@ func (ex *connExecutor) resetPlanner(
 	p.avoidCachedDescriptors = false
 }
 
+// maxAutoRetryCauses is the maximum number of automatic retry causes that are
+// kept for a transaction.
+const maxAutoRetryCauses = 10
+
+// autoRetryCause returns a description of the error that caused an automatic
+// retry.
+func autoRetryCause(err error) string {
+	var retryErr *roachpb.TransactionRetryWithProtoRefreshError
+	if errors.As(err, &retryErr) {
+		return retryErr.Msg
+	}
+	return err.Error()
+}
+
 // txnStateTransitionsApplyWrapper is a wrapper on top of Machine built with the
 // TxnStateTransitions above. Its point is to detect when we go in and out of
 // transactions and update some state.
@@ -2222,6 +2253,12 @@ func (ex *connExecutor) txnStateTransitionsApplyWrapper(
 
 	if advInfo.code == rewind {
 		ex.extraTxnState.autoRetryCounter++
+		if p, ok := payload.(payloadWithError); ok &&
+			len(ex.extraTxnState.autoRetryCauses) < maxAutoRetryCauses {
+			ex.extraTxnState.autoRetryCauses = append(
+				ex.extraTxnState.autoRetryCauses, autoRetryCause(p.errorCause()),
+			)
+		}
 	}
 
 	// Handle transaction events which cause updates to txnState.
@@ -2229,6 +2266,7 @@ func (ex *connExecutor) txnStateTransitionsApplyWrapper(
 	case noEvent:
 	case txnStart:
 		ex.extraTxnState.autoRetryCounter = 0
+		ex.extraTxnState.autoRetryCauses = nil
 		ex.extraTxnState.onTxnFinish, ex.extraTxnState.onTxnRestart = ex.recordTransactionStart()
 	case txnCommit:
 		if res.Err() != nil {
@@ -28,6 +28,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/parser"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgnotice"
 	"github.com/cockroachdb/cockroach/pkg/sql/physicalplan"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sqlerrors"
@@ -346,11 +347,17 @@ func (ex *connExecutor) execStmtInOpenState(
 		(e.Mode == tree.ExplainDebug || e.Mode == tree.ExplainPlan) {
 		if e.Mode == tree.ExplainDebug {
 			telemetry.Inc(sqltelemetry.ExplainAnalyzeDebugUseCounter)
-			ih.SetOutputMode(explainAnalyzeDebugOutput, explain.Flags{})
+			ih.SetOutputMode(explainAnalyzeDebugOutput, textEncoding, explain.Flags{})
 		} else {
 			telemetry.Inc(sqltelemetry.ExplainAnalyzeUseCounter)
 			flags := explain.MakeFlags(&e.ExplainOptions)
-			ih.SetOutputMode(explainAnalyzePlanOutput, flags)
+			encoding := textEncoding
+			if e.Flags[tree.ExplainFlagJSON] {
+				encoding = jsonEncoding
+			} else if e.Flags[tree.ExplainFlagYAML] {
+				encoding = yamlEncoding
+			}
+			ih.SetOutputMode(explainAnalyzePlanOutput, encoding, flags)
 		}
 		// Strip off the explain node to execute the inner statement.
 		stmt.AST = e.Statement
@@ -364,15 +371,33 @@ func (ex *connExecutor) execStmtInOpenState(
 		stmt.ExpectedTypes = nil
 	}
 
+	ih.SetRetries(ex.extraTxnState.autoRetryCounter, ex.extraTxnState.autoRetryCauses)
+
+	// The name of the prepared statement is used to match diagnostics requests
+	// that target it. Statements prepared through the wire protocol already
+	// reference their prepared statement; for EXECUTE, the prepared statement is
+	// only resolved below.
+	var preparedName string
+	if stmt.Prepared != nil {
+		preparedName = stmt.Prepared.name
+	} else if e, ok := ast.(*tree.Execute); ok {
+		preparedName = e.Name.String()
+	}
+
 	var needFinish bool
 	ctx, needFinish = ih.Setup(
-		ctx, ex.server.cfg, ex.appStats, p, ex.stmtDiagnosticsRecorder,
-		stmt.AnonymizedStr, os.ImplicitTxn.Get(),
+		ctx, ex.server.cfg, ex.appStats, p, ex.stmtDiagnosticsRecorder, &ex.sessionBundles,
+		&ex.stmtDiagnosticsHistory, stmt.AnonymizedStr, preparedName, os.ImplicitTxn.Get(),
 	)
 	if needFinish {
 		sql := stmt.SQL
 		defer func() {
 			retErr = ih.Finish(ex.server.cfg, ex.appStats, ex.statsCollector, p, ast, sql, res, retErr)
+			ex.metrics.EngineMetrics.SQLInstrumentationOverhead.RecordValue(ih.overhead.Nanoseconds())
+			ih.reportExemplar(
+				ex.server.cfg, ex.metrics.EngineMetrics.SQLServiceLatency.GetName(),
+				ex.statsCollector.phaseTimes.getServiceLatency(),
+			)
 		}()
 		// TODO(radu): consider removing this if/when #46164 is addressed.
 		p.extendedEvalCtx.Context = ctx
@@ -395,6 +420,9 @@ func (ex *connExecutor) execStmtInOpenState(
 		timeoutTicker = time.AfterFunc(
 			timerDuration,
 			func() {
+				// Capture the stacks of the statement's goroutines before they are
+				// canceled, in case we are collecting a bundle.
+				ih.CaptureStacks()
 				ex.cancelQuery(queryID)
 				queryTimedOut = true
 				doneAfterFunc <- struct{}{}
@@ -805,15 +833,38 @@ func (ex *connExecutor) dispatchToExecutionEngine(
 	}
 
 	ex.sessionTracing.TracePlanCheckStart(ctx)
-	distributePlan := getPlanDistribution(
+	distributePlan, notDistributedReason := getPlanDistributionWithReason(
 		ctx, planner, planner.execCfg.NodeID, ex.sessionData.DistSQLMode, planner.curPlan.main,
 	)
+	planner.curPlan.notDistributedReason = notDistributedReason
 	ex.sessionTracing.TracePlanCheckEnd(ctx, nil, distributePlan.WillDistribute())
 
 	if ex.server.cfg.TestingKnobs.BeforeExecute != nil {
 		ex.server.cfg.TestingKnobs.BeforeExecute(ctx, stmt.String())
 	}
 
+	if cl := ex.server.cfg.ConcurrencyLimiter; cl != nil {
+		ex.statsCollector.phaseTimes[plannerStartConcurrencyWait] = timeutil.Now()
+		release, err := cl.Acquire(ctx)
+		ex.statsCollector.phaseTimes[plannerEndConcurrencyWait] = timeutil.Now()
+		if err != nil {
+			res.SetError(err)
+			return nil
+		}
+		defer release()
+	}
+
+	if ac := ex.server.cfg.AdmissionController; ac != nil {
+		ex.statsCollector.phaseTimes[plannerStartAdmissionWait] = timeutil.Now()
+		priority, err := ac.Admit(ctx)
+		ex.statsCollector.phaseTimes[plannerEndAdmissionWait] = timeutil.Now()
+		if err != nil {
+			res.SetError(err)
+			return nil
+		}
+		planner.instrumentation.SetAdmissionPriority(priority)
+	}
+
 	ex.statsCollector.phaseTimes[plannerStartExecStmt] = timeutil.Now()
 
 	ex.mu.Lock()
@@ -857,12 +908,27 @@ func (ex *connExecutor) dispatchToExecutionEngine(
 	)
 	ex.sessionTracing.TraceExecEnd(ctx, res.Err(), res.RowsAffected())
 	ex.statsCollector.phaseTimes[plannerEndExecStmt] = timeutil.Now()
+	planner.instrumentation.OverridePhaseTimes(&ex.statsCollector.phaseTimes)
+
+	if err == nil && res.Err() == nil && planner.instrumentation.ShouldRepeatExecution() {
+		if planner.curPlan.canMutate() {
+			planner.BufferClientNotice(ctx, pgnotice.Newf(
+				"statements that can modify data are only executed once by "+
+					"EXPLAIN ANALYZE (PLAN, REPEAT)",
+			))
+		} else {
+			err = ex.repeatExecution(
+				ctx, planner, stmt.AST.StatementType(), res, distributePlan.WillDistribute(), progAtomic,
+			)
+		}
+	}
 
 	// Record the statement summary. This also closes the plan if the
 	// plan has not been closed earlier.
 	ex.recordStatementSummary(
 		ctx, planner,
-		ex.extraTxnState.autoRetryCounter, res.RowsAffected(), res.Err(), stats,
+		ex.extraTxnState.autoRetryCounter, ex.extraTxnState.autoRetryCauses,
+		res.RowsAffected(), res.Err(), stats,
 	)
 	if ex.server.cfg.TestingKnobs.AfterExecute != nil {
 		ex.server.cfg.TestingKnobs.AfterExecute(ctx, stmt.String(), res.Err())
@@ -871,6 +937,69 @@ func (ex *connExecutor) dispatchToExecutionEngine(
 	return err
 }
 
+// repeatExecution executes the statement again until it has been executed the
+// number of times requested with EXPLAIN ANALYZE (PLAN, REPEAT), and records
+// the execution latency of each run (including the first one, which must have
+// already happened).
+//
+// A plan can't be executed more than once: subqueries store their results in
+// the plan, and the planNodes wrapped into the flows (e.g. VALUES and virtual
+// table scans) are drained by the first run. So the statement is planned again
+// for each run, with an optimizer of its own: the memo of the first plan, which
+// is used to build statement bundles, must not be reused.
+//
+// The repeated runs are neither traced nor instrumented, so the plan and the
+// execution statistics shown only reflect the first run. The phase times also
+// reflect the first run.
+func (ex *connExecutor) repeatExecution(
+	ctx context.Context,
+	planner *planner,
+	stmtType tree.StatementType,
+	res RestrictedCommandResult,
+	distribute bool,
+	progressAtomic *uint64,
+) error {
+	phaseTimes := &ex.statsCollector.phaseTimes
+	firstRowTime := phaseTimes[plannerFirstRowExecStmt]
+	firstPlan := planner.curPlan
+	firstOptPlanningCtx := planner.optPlanningCtx
+	planner.optPlanningCtx = optPlanningCtx{}
+	planner.optPlanningCtx.init(planner)
+	planner.instrumentation.SetRepeating(true)
+	defer func() {
+		planner.instrumentation.SetRepeating(false)
+		planner.optPlanningCtx = firstOptPlanningCtx
+		planner.curPlan = firstPlan
+		phaseTimes[plannerFirstRowExecStmt] = firstRowTime
+	}()
+
+	n := planner.instrumentation.RepeatCount(&ex.server.cfg.Settings.SV)
+	latencies := make([]time.Duration, 1, n)
+	latencies[0] = phaseTimes.getRunLatency()
+	untracedCtx := tracing.ContextWithSpan(ctx, nil /* sp */)
+	for len(latencies) < n {
+		if err := ex.makeExecPlan(untracedCtx, planner); err != nil {
+			planner.curPlan.close(ctx)
+			return err
+		}
+		if planner.autoCommit {
+			planner.curPlan.flags.Set(planFlagImplicitTxn)
+		}
+		start := timeutil.Now()
+		_, err := ex.execWithDistSQLEngine(
+			untracedCtx, planner, stmtType, res, distribute, progressAtomic,
+		)
+		latency := timeutil.Since(start)
+		planner.curPlan.close(ctx)
+		if err != nil || res.Err() != nil {
+			return err
+		}
+		latencies = append(latencies, latency)
+	}
+	planner.instrumentation.RecordRepeatedExecutions(latencies)
+	return nil
+}
+
 // makeExecPlan creates an execution plan and populates planner.curPlan using
 // the cost-based optimizer.
 func (ex *connExecutor) makeExecPlan(ctx context.Context, planner *planner) error {
@@ -933,6 +1062,7 @@ func (ex *connExecutor) execWithDistSQLEngine(
 		&ex.sessionTracing,
 	)
 	recv.progressAtomic = progressAtomic
+	recv.firstRowTime = &ex.statsCollector.phaseTimes[plannerFirstRowExecStmt]
 	defer recv.Release()
 
 	evalCtx := planner.ExtendedEvalContext()
@@ -940,9 +1070,12 @@ func (ex *connExecutor) execWithDistSQLEngine(
 	planCtx.stmtType = recv.stmtType
 	if ex.server.cfg.TestingKnobs.TestingSaveFlows != nil {
 		planCtx.saveFlows = ex.server.cfg.TestingKnobs.TestingSaveFlows(planner.stmt.SQL)
-	} else if planner.instrumentation.ShouldCollectBundle() {
+	} else if planner.instrumentation.ShouldSaveFlows() {
 		planCtx.saveFlows = planCtx.getDefaultSaveFlowsFunc(ctx, planner, planComponentTypeMainQuery)
 	}
+	if planner.instrumentation.ShouldBuildExplainPlan() {
+		planCtx.processorOwners = make(map[interface{}]planNode)
+	}
 
 	var evalCtxFactory func() *extendedEvalContext
 	if len(planner.curPlan.subqueryPlans) != 0 ||
@@ -1355,6 +1488,7 @@ func (ex *connExecutor) handleAutoCommit(
 	txn := ex.state.mu.txn
 	if txn.IsCommitted() {
 		log.Event(ctx, "statement execution committed the txn")
+		ex.planner.instrumentation.SetCommittedByExecution()
 		return eventTxnFinishCommitted{}, nil
 	}
 
@@ -1364,7 +1498,10 @@ func (ex *connExecutor) handleAutoCommit(
 		}
 	}
 
+	ex.statsCollector.phaseTimes[plannerStartAutoCommit] = timeutil.Now()
 	ev, payload := ex.commitSQLTransaction(ctx, stmt)
+	ex.statsCollector.phaseTimes[plannerEndAutoCommit] = timeutil.Now()
+	ex.planner.instrumentation.OverridePhaseTimes(&ex.statsCollector.phaseTimes)
 	var err error
 	if perr, ok := payload.(payloadWithError); ok {
 		err = perr.errorCause()
@@ -126,6 +126,7 @@ func (ex *connExecutor) addPreparedStmt(
 	if err := prepared.memAcc.Grow(ctx, int64(len(name))); err != nil {
 		return nil, err
 	}
+	prepared.name = name
 	ex.extraTxnState.prepStmtsNamespace.prepStmts[name] = prepared
 	return prepared, nil
 }
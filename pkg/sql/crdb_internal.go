@@ -123,6 +123,8 @@ var crdbInternal = virtualSchema{
 		catconstants.CrdbInternalTxnStatsTableID:             crdbInternalTxnStatsTable,
 		catconstants.CrdbInternalZonesTableID:                crdbInternalZonesTable,
 		catconstants.CrdbInternalInvalidDescriptorsTableID:   crdbInternalInvalidDescriptorsTable,
+		catconstants.CrdbInternalStmtPlanHistoryTableID:      crdbInternalStmtPlanHistoryTable,
+		catconstants.CrdbInternalStatementBundlesTableID:     crdbInternalStatementBundlesTable,
 	},
 	validWithNoDatabaseContext: true,
 }
@@ -819,6 +821,7 @@ CREATE TABLE crdb_internal.node_statement_statistics (
 			// output is deterministic.
 			var stmtKeys stmtList
 			appStats.Lock()
+			appStats.flushTraceStatsLocked()
 			for k := range appStats.stmts {
 				stmtKeys = append(stmtKeys, k)
 			}
@@ -886,6 +889,154 @@ CREATE TABLE crdb_internal.node_statement_statistics (
 	},
 }
 
+// crdbInternalStatementBundlesTable exposes the statement diagnostics bundles
+// stored in system.statement_diagnostics, so that they can be browsed without
+// the Admin UI. The download URL of a bundle is returned by the
+// crdb_internal.statement_bundle_url builtin. Like the system tables it reads,
+// the table only contains the bundles collected by the current tenant.
+var crdbInternalStatementBundlesTable = virtualSchemaTable{
+	comment: `statement diagnostics bundles, most recent first (KV scan)`,
+	schema: `
+CREATE TABLE crdb_internal.statement_bundles (
+  id                    INT NOT NULL,
+  request_id            INT,
+  statement_fingerprint STRING NOT NULL,
+  collected_at          TIMESTAMPTZ NOT NULL,
+  size                  INT NOT NULL,
+  error                 STRING,
+  tags                  JSONB
+)`,
+	populate: func(ctx context.Context, p *planner, _ *dbdesc.Immutable, addRow func(...tree.Datum) error) error {
+		if err := p.RequireAdminRole(ctx, "read statement diagnostics bundles"); err != nil {
+			return err
+		}
+
+		// The request_id and tags columns don't exist until the corresponding
+		// migrations have run.
+		st := p.ExecCfg().Settings
+		requestIDCol, tagsCol, tagsJoin := "NULL", "NULL", ""
+		if st.Version.IsActive(ctx, clusterversion.VersionStatementDiagnosticsRequestOptions) {
+			requestIDCol = "d.request_id"
+			tagsCol = "r.tags"
+			tagsJoin = "LEFT JOIN system.statement_diagnostics_requests AS r ON r.id = d.request_id"
+		}
+		query := fmt.Sprintf(`
+SELECT d.id, %[1]s, d.statement_fingerprint, d.collected_at,
+       (SELECT COALESCE(sum(length(c.data)), 0)::INT8
+          FROM system.statement_bundle_chunks AS c
+         WHERE c.id = ANY d.bundle_chunks),
+       d.error, %[2]s
+  FROM system.statement_diagnostics AS d %[3]s
+ ORDER BY d.collected_at DESC, d.id`, requestIDCol, tagsCol, tagsJoin)
+		rows, err := p.ExtendedEvalContext().ExecCfg.InternalExecutor.QueryEx(
+			ctx, "crdb-internal-statement-bundles-table", p.txn,
+			sessiondata.InternalExecutorOverride{User: security.RootUserName()},
+			query)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := addRow(row...); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var crdbInternalStmtPlanHistoryTable = virtualSchemaTable{
+	comment: `distinct plans sampled for each statement (in-memory, not durable; local node only). ` +
+		`This table is wiped periodically (by default, at least every two hours)`,
+	schema: `
+CREATE TABLE crdb_internal.node_statement_plan_history (
+  node_id          INT NOT NULL,
+  application_name STRING NOT NULL,
+  key              STRING NOT NULL,
+  implicit_txn     BOOL NOT NULL,
+  plan_gist        STRING NOT NULL,
+  first_seen       TIMESTAMPTZ NOT NULL,
+  last_seen        TIMESTAMPTZ NOT NULL,
+  count            INT NOT NULL
+)`,
+	populate: func(ctx context.Context, p *planner, _ *dbdesc.Immutable, addRow func(...tree.Datum) error) error {
+		hasViewActivity, err := p.HasRoleOption(ctx, roleoption.VIEWACTIVITY)
+		if err != nil {
+			return err
+		}
+		if !hasViewActivity {
+			return pgerror.Newf(pgcode.InsufficientPrivilege,
+				"user %s does not have %s privilege", p.User(), roleoption.VIEWACTIVITY)
+		}
+
+		sqlStats := p.extendedEvalCtx.sqlStatsCollector.sqlStats
+		if sqlStats == nil {
+			return errors.AssertionFailedf(
+				"cannot access sql statistics from this context")
+		}
+
+		nodeID, _ := p.execCfg.NodeID.OptionalNodeID() // zero if not available
+
+		// Retrieve the application names and sort them to ensure the
+		// output is deterministic.
+		var appNames []string
+		sqlStats.Lock()
+		for n := range sqlStats.apps {
+			appNames = append(appNames, n)
+		}
+		sqlStats.Unlock()
+		sort.Strings(appNames)
+
+		for _, appName := range appNames {
+			appStats := sqlStats.getStatsForApplication(appName)
+
+			var stmtKeys stmtList
+			appStats.Lock()
+			appStats.flushTraceStatsLocked()
+			for k := range appStats.stmts {
+				stmtKeys = append(stmtKeys, k)
+			}
+			appStats.Unlock()
+			sort.Sort(stmtKeys)
+
+			for _, stmtKey := range stmtKeys {
+				stmtID := constructStatementIDFromStmtKey(stmtKey)
+				s := appStats.getStatsForStmtWithKey(stmtKey, stmtID, false /* createIfNonexistent */)
+				if s == nil {
+					continue
+				}
+
+				s.mu.Lock()
+				history := append([]planHistoryEntry(nil), s.mu.planHistory...)
+				s.mu.Unlock()
+
+				for _, e := range history {
+					firstSeen, err := tree.MakeDTimestampTZ(e.firstSeen, time.Microsecond)
+					if err != nil {
+						return err
+					}
+					lastSeen, err := tree.MakeDTimestampTZ(e.lastSeen, time.Microsecond)
+					if err != nil {
+						return err
+					}
+					if err := addRow(
+						tree.NewDInt(tree.DInt(nodeID)),
+						tree.NewDString(appName),
+						tree.NewDString(stmtKey.anonymizedStmt),
+						tree.MakeDBool(tree.DBool(stmtKey.implicitTxn)),
+						tree.NewDString(e.planGist),
+						firstSeen,
+						lastSeen,
+						tree.NewDInt(tree.DInt(e.count)),
+					); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	},
+}
+
 // TODO(arul): Explore updating the schema below to have key be an INT and
 // statement_ids be INT[] now that we've moved to having uint64 as the type of
 // StmtID and TxnKey. Issue #55284
@@ -146,6 +146,7 @@ func (d *deleteNode) BatchedNext(params runParams) (bool, error) {
 		d.run.td.tableDesc().GetID(),
 		d.run.td.lastBatchSize,
 	)
+	recordRowsWritten(params.ctx, d.run.td.tableDesc().GetID(), d.run.td.lastBatchSize)
 
 	return d.run.td.lastBatchSize > 0, nil
 }
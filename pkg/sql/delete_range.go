@@ -168,6 +168,7 @@ func (d *deleteRangeNode) startExec(params runParams) error {
 
 	// Possibly initiate a run of CREATE STATISTICS.
 	params.ExecCfg().StatsRefresher.NotifyMutation(d.desc.ID, d.rowCount)
+	recordRowsWritten(params.ctx, d.desc.ID, d.rowCount)
 
 	return nil
 }
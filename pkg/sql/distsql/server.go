@@ -361,6 +361,14 @@ func (ds *ServerImpl) setupFlow(
 	// set above so it's fine. If we're using a LeafTxn on the gateway, though,
 	// then the processors have erroneously captured the Root. See #41992.
 	f.SetTxn(txn)
+	if txn != nil {
+		// Record the timestamps used by the flow, so that they can be compared
+		// across the flows of a statement (see execstats.FlowTimestamps).
+		sp.SetTag(execinfrapb.FlowIDTagKey, req.Flow.FlowID.String())
+		sp.SetTag(execinfrapb.FlowNodeIDTagKey, ds.ServerConfig.NodeID.SQLInstanceID())
+		sp.SetTag(execinfrapb.ReadTimestampTagKey, txn.ReadTimestamp())
+		sp.SetTag(execinfrapb.WriteTimestampTagKey, txn.ProvisionalCommitTimestamp())
+	}
 
 	return ctx, f, nil
 }
@@ -37,6 +37,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/physicalplan/replicaoracle"
 	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondatapb"
 	"github.com/cockroachdb/cockroach/pkg/sql/types"
 	"github.com/cockroachdb/cockroach/pkg/util"
 	"github.com/cockroachdb/cockroach/pkg/util/encoding"
@@ -275,11 +276,6 @@ func newQueryNotSupportedErrorf(format string, args ...interface{}) error {
 	return &queryNotSupportedError{msg: fmt.Sprintf(format, args...)}
 }
 
-// planNodeNotSupportedErr is the catch-all error value returned from
-// checkSupportForPlanNode when a planNode type does not support distributed
-// execution.
-var planNodeNotSupportedErr = newQueryNotSupportedError("unsupported node")
-
 var cannotDistributeRowLevelLockingErr = newQueryNotSupportedError(
 	"scans with row-level locking are not supported by distsql",
 )
@@ -526,7 +522,9 @@ func checkSupportForPlanNode(node planNode) (distRecommendation, error) {
 		return shouldDistribute, nil
 
 	default:
-		return cannotDistribute, planNodeNotSupportedErr
+		// This is the catch-all case for planNode types that don't support
+		// distributed execution.
+		return cannotDistribute, newQueryNotSupportedErrorf("unsupported node: %s", nodeName(n))
 	}
 }
 
@@ -613,6 +611,12 @@ type PlanningCtx struct {
 	saveFlows func(map[roachpb.NodeID]*execinfrapb.FlowSpec) error
 	// If set, the result of flowSpecsToDiagram will show the types of each stream.
 	saveDiagramShowInputTypes bool
+
+	// If set, processorOwners maps the core of each processor of the physical
+	// plan (see execinfrapb.ProcessorCoreUnion.GetValue) to the planNode for
+	// which it was planned. It is used to determine how each node of the plan was
+	// executed (see instrumentationHelper.RecordWrappedProcessors).
+	processorOwners map[interface{}]planNode
 }
 
 var _ physicalplan.ExprContext = &PlanningCtx{}
@@ -649,13 +653,101 @@ func (p *PlanningCtx) getDefaultSaveFlowsFunc(
 		if err != nil {
 			return err
 		}
+		var explainVec []string
+		if planner.instrumentation.ShouldCollectBundle() {
+			explainVec = p.explainVecForBundle(ctx, planner, flows)
+		}
+		var outputProcessors map[planNode][]execinfrapb.ProcessorID
+		if p.processorOwners != nil {
+			outputProcessors = getOutputProcessors(p.processorOwners, flows)
+		}
 		planner.curPlan.distSQLFlowInfos = append(
-			planner.curPlan.distSQLFlowInfos, flowInfo{typ: typ, diagram: diagram, analyzer: execstats.NewTraceAnalyzer(flows)},
+			planner.curPlan.distSQLFlowInfos,
+			flowInfo{
+				typ:              typ,
+				diagram:          diagram,
+				analyzer:         execstats.NewTraceAnalyzer(flows),
+				explainVec:       explainVec,
+				outputProcessors: outputProcessors,
+			},
 		)
 		return nil
 	}
 }
 
+// getOutputProcessors returns, for each planNode which owns processors in the
+// given flows, the IDs of the processors that produce the output of the node.
+// These are the processors owned by the node whose output is not consumed by
+// another processor owned by the same node; for example, only the final stage
+// of a distributed aggregation produces the output of the group node.
+//
+// Note that a node which doesn't own any processors (like a filter merged into
+// the post-processing stage of its input) doesn't have output processors; its
+// output is instead included in the output of the node it was merged into.
+func getOutputProcessors(
+	processorOwners map[interface{}]planNode, flows map[roachpb.NodeID]*execinfrapb.FlowSpec,
+) map[planNode][]execinfrapb.ProcessorID {
+	owners := make(map[execinfrapb.ProcessorID]planNode)
+	consumers := make(map[execinfrapb.StreamID]execinfrapb.ProcessorID)
+	for _, flow := range flows {
+		for i := range flow.Processors {
+			proc := &flow.Processors[i]
+			id := execinfrapb.ProcessorID(proc.ProcessorID)
+			if owner, ok := processorOwners[proc.Core.GetValue()]; ok {
+				owners[id] = owner
+			}
+			for _, input := range proc.Input {
+				for _, stream := range input.Streams {
+					consumers[stream.StreamID] = id
+				}
+			}
+		}
+	}
+
+	res := make(map[planNode][]execinfrapb.ProcessorID)
+	for _, flow := range flows {
+		for i := range flow.Processors {
+			proc := &flow.Processors[i]
+			id := execinfrapb.ProcessorID(proc.ProcessorID)
+			owner, ok := owners[id]
+			if !ok {
+				// Processors added during the finalization of the plan (like
+				// synchronizers) are not owned by any node.
+				continue
+			}
+			isOutput := true
+			for _, output := range proc.Output {
+				for _, stream := range output.Streams {
+					if consumer, ok := consumers[stream.StreamID]; ok && owners[consumer] == owner {
+						isOutput = false
+					}
+				}
+			}
+			if isOutput {
+				res[owner] = append(res[owner], id)
+			}
+		}
+	}
+	return res
+}
+
+// explainVecForBundle returns the EXPLAIN (VEC) output for the given flows, to
+// be included in a statement bundle. Errors are returned as part of the output.
+func (p *PlanningCtx) explainVecForBundle(
+	ctx context.Context, planner *planner, flows map[roachpb.NodeID]*execinfrapb.FlowSpec,
+) []string {
+	flowCtx := newFlowCtxForExplainPurposes(p, planner)
+	flowCtx.Cfg.ClusterID = &planner.execCfg.DistSQLPlanner.rpcCtx.ClusterID
+	if flowCtx.EvalCtx.SessionData.VectorizeMode == sessiondatapb.VectorizeOff {
+		return []string{"vectorize is set to 'off'"}
+	}
+	lines, err := explainVec(ctx, flowCtx, flows, p.isLocal, false /* verbose */)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return lines
+}
+
 // flowSpecsToDiagram is a helper function used to convert flowSpecs into a
 // FlowDiagram using this PlanningCtx's information.
 func (p *PlanningCtx) flowSpecsToDiagram(
@@ -2688,6 +2780,17 @@ func (dsp *DistSQLPlanner) createPhysPlanForPlanNode(
 		return plan, err
 	}
 
+	if planCtx.processorOwners != nil {
+		// The children of the node have already claimed their processors; the
+		// remaining ones belong to this node.
+		for i := range plan.Processors {
+			core := plan.Processors[i].Spec.Core.GetValue()
+			if _, ok := planCtx.processorOwners[core]; !ok {
+				planCtx.processorOwners[core] = node
+			}
+		}
+	}
+
 	if dsp.shouldPlanTestMetadata() {
 		if err := plan.CheckLastStagePost(); err != nil {
 			log.Fatalf(planCtx.ctx, "%v", err)
@@ -16,6 +16,7 @@ import (
 	"math"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/kv"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvclient/kvcoord"
@@ -44,6 +45,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
 	"github.com/cockroachdb/errors"
 )
@@ -368,6 +370,9 @@ func (dsp *DistSQLPlanner) Run(
 
 	if planCtx.planner != nil && flow.IsVectorized() {
 		planCtx.planner.curPlan.flags.Set(planFlagVectorized)
+		if planCtx.processorOwners != nil {
+			planCtx.planner.instrumentation.RecordWrappedProcessors(planCtx.processorOwners, flows)
+		}
 	}
 
 	// Check that flows that were forced to be planned locally also have no concurrency.
@@ -469,6 +474,10 @@ type DistSQLReceiver struct {
 
 	expectedRowsRead int64
 	progressAtomic   *uint64
+
+	// firstRowTime, if set, is populated with the time at which the first row
+	// was pushed to the receiver (regardless of whether the row is discarded).
+	firstRowTime *time.Time
 }
 
 // rowResultWriter is a subset of CommandResult to be used with the
@@ -686,6 +695,10 @@ func (r *DistSQLReceiver) Push(
 		return r.status
 	}
 
+	if r.firstRowTime != nil && r.firstRowTime.IsZero() {
+		*r.firstRowTime = timeutil.Now()
+	}
+
 	if r.discardRows {
 		// Discard rows.
 		return r.status
@@ -834,9 +847,12 @@ func (dsp *DistSQLPlanner) planAndRunSubquery(
 	).WillDistribute()
 	subqueryPlanCtx := dsp.NewPlanningCtx(ctx, evalCtx, planner, planner.txn, distributeSubquery)
 	subqueryPlanCtx.stmtType = tree.Rows
-	if planner.instrumentation.ShouldCollectBundle() {
+	if planner.instrumentation.ShouldSaveFlows() {
 		subqueryPlanCtx.saveFlows = subqueryPlanCtx.getDefaultSaveFlowsFunc(ctx, planner, planComponentTypeSubquery)
 	}
+	if planner.instrumentation.ShouldBuildExplainPlan() {
+		subqueryPlanCtx.processorOwners = make(map[interface{}]planNode)
+	}
 	// Don't close the top-level plan from subqueries - someone else will handle
 	// that.
 	subqueryPlanCtx.ignoreClose = true
@@ -1125,9 +1141,12 @@ func (dsp *DistSQLPlanner) planAndRunPostquery(
 	postqueryPlanCtx := dsp.NewPlanningCtx(ctx, evalCtx, planner, planner.txn, distributePostquery)
 	postqueryPlanCtx.stmtType = tree.Rows
 	postqueryPlanCtx.ignoreClose = true
-	if planner.instrumentation.ShouldCollectBundle() {
+	if planner.instrumentation.ShouldSaveFlows() {
 		postqueryPlanCtx.saveFlows = postqueryPlanCtx.getDefaultSaveFlowsFunc(ctx, planner, planComponentTypePostquery)
 	}
+	if planner.instrumentation.ShouldBuildExplainPlan() {
+		postqueryPlanCtx.processorOwners = make(map[interface{}]planNode)
+	}
 
 	postqueryPhysPlan, err := dsp.createPhysPlan(postqueryPlanCtx, postqueryPlan)
 	if err != nil {
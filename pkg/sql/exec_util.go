@@ -439,6 +439,12 @@ var (
 		Measurement: "Latency",
 		Unit:        metric.Unit_NANOSECONDS,
 	}
+	MetaSQLInstrumentationOverhead = metric.Metadata{
+		Name:        "sql.instrumentation.overhead",
+		Help:        "Time spent instrumenting SQL statements (tracing, diagnostics bundles)",
+		Measurement: "Latency",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
 
 	// Below are the metadata for the statement started counters.
 
@@ -661,6 +667,29 @@ type nodeStatusGenerator interface {
 	GenerateNodeStatus(ctx context.Context) *statuspb.NodeStatus
 }
 
+// AdmissionController is consulted before a statement is executed, allowing
+// statements to be queued when the cluster is overloaded.
+type AdmissionController interface {
+	// Admit blocks until the statement is allowed to execute. It returns the
+	// admission priority (or queue) under which the statement was admitted,
+	// which is shown by EXPLAIN ANALYZE and in statement bundles; it can be
+	// empty if the controller doesn't distinguish priorities. If an error is
+	// returned, the statement is not executed and the error is returned to the
+	// client.
+	Admit(ctx context.Context) (priority string, _ error)
+}
+
+// ConcurrencyLimiter limits the number of statements that execute concurrently,
+// for example to enforce connection pool or per-tenant concurrency limits.
+// Statements wait for a slot before their execution starts.
+type ConcurrencyLimiter interface {
+	// Acquire blocks until the statement is allowed to execute. If no error is
+	// returned, the returned function must be called once the execution of the
+	// statement has ended; otherwise the statement is not executed and the
+	// error is returned to the client.
+	Acquire(ctx context.Context) (release func(), _ error)
+}
+
 // An ExecutorConfig encompasses the auxiliary objects and configuration
 // required to create an executor.
 // All fields holding a pointer or an interface are required to create
@@ -729,6 +758,44 @@ type ExecutorConfig struct {
 	// StmtDiagnosticsRecorder deals with recording statement diagnostics.
 	StmtDiagnosticsRecorder *stmtdiagnostics.Registry
 
+	// BundleContributors are invoked when building a statement diagnostics
+	// bundle and can add custom files to the bundle.
+	BundleContributors []BundleContributor
+
+	// BundleCollectionLimiter limits the number of statement diagnostics
+	// bundles built concurrently. If nil, the number is not limited.
+	BundleCollectionLimiter *BundleCollectionLimiter
+
+	// LargeBundleCallback, if set, is invoked when a statement diagnostics
+	// bundle exceeds sql.stmt_diagnostics.large_bundle_threshold.
+	LargeBundleCallback LargeBundleCallback
+
+	// StatementExemplarCallback, if set, is invoked after the execution of a
+	// statement for which a diagnostics bundle was collected, so that the
+	// bundle can be attached as an exemplar to the statement latency metric.
+	StatementExemplarCallback StatementExemplarCallback
+
+	// PlanChangeCallback, if set, is invoked when the plan sampled for the
+	// statement statistics of a fingerprint differs from the previously
+	// sampled plan.
+	PlanChangeCallback PlanChangeCallback
+
+	// FingerprintAnonymizer, if set, is applied to statement fingerprints
+	// before they are exported outside of the SQL layer (on trace spans, in
+	// logs and to the PlanChangeCallback). Fingerprints used internally, e.g.
+	// by the statement statistics, are not affected.
+	FingerprintAnonymizer FingerprintAnonymizer
+
+	// AdmissionController, if set, is consulted before each statement is
+	// executed. The time spent waiting for admission is recorded separately
+	// from the execution time.
+	AdmissionController AdmissionController
+
+	// ConcurrencyLimiter, if set, is consulted before each statement is
+	// executed. The time spent waiting for a slot is recorded separately from
+	// the execution time.
+	ConcurrencyLimiter ConcurrencyLimiter
+
 	ExternalIODirConfig base.ExternalIODirConfig
 
 	// HydratedTables is a node-level cache of table descriptors which utilize
@@ -834,11 +901,36 @@ type ExecutorTestingKnobs struct {
 	TestingSaveFlows func(stmt string) func(map[roachpb.NodeID]*execinfrapb.FlowSpec) error
 
 	// DeterministicExplainAnalyze, if set, will result in overriding fields in
-	// EXPLAIN ANALYZE (PLAN) that can vary between runs (like elapsed times).
+	// EXPLAIN ANALYZE (PLAN) that can vary between runs (like wait times). Note
+	// that the phase times are controlled by PhaseTimeSource.
 	//
 	// Should be set together with execinfra.TestingKnobs.DeterministicStats.
 	// TODO(radu): figure out how to unify these two.
 	DeterministicExplainAnalyze bool
+
+	// PhaseTimeSource, if set, is called with the fingerprint of each statement
+	// that is executed. If it returns non-nil durations, they replace the
+	// measured durations of the phases of the statement everywhere they are
+	// used (statement statistics, EXPLAIN ANALYZE and the statement span).
+	PhaseTimeSource func(fingerprint string) *PhaseDurations
+
+	// BundleSink, if set, receives every statement diagnostics bundle that is
+	// collected, right after it is inserted into the system tables.
+	BundleSink *TestingBundleSink
+}
+
+// PhaseDurations contains the durations of the phases of the execution of a
+// statement. See ExecutorTestingKnobs.PhaseTimeSource.
+type PhaseDurations struct {
+	Parse time.Duration
+	Plan  time.Duration
+	Run   time.Duration
+	// Commit is the duration of the auto-commit of implicit transactions.
+	Commit time.Duration
+	// Custom contains the durations of the custom phases, keyed by name (see
+	// RegisterCustomPhase). The custom phases recorded for the statement which
+	// are not in the map have a zero duration.
+	Custom map[string]time.Duration
 }
 
 // PGWireTestingKnobs contains knobs for the pgwire module.
@@ -915,40 +1007,58 @@ func getPlanDistribution(
 	distSQLMode sessiondata.DistSQLExecMode,
 	plan planMaybePhysical,
 ) physicalplan.PlanDistribution {
+	distribution, _ := getPlanDistributionWithReason(ctx, p, nodeID, distSQLMode, plan)
+	return distribution
+}
+
+// getPlanDistributionWithReason is like getPlanDistribution but it also
+// returns the reason for which the plan will not be distributed; the reason is
+// empty if the plan will be distributed or if it already has physical
+// representation.
+func getPlanDistributionWithReason(
+	ctx context.Context,
+	p *planner,
+	nodeID *base.SQLIDContainer,
+	distSQLMode sessiondata.DistSQLExecMode,
+	plan planMaybePhysical,
+) (_ physicalplan.PlanDistribution, notDistributedReason string) {
 	if plan.isPhysicalPlan() {
-		return plan.physPlan.Distribution
+		return plan.physPlan.Distribution, ""
 	}
 
 	// If this transaction has modified or created any types, it is not safe to
 	// distribute due to limitations around leasing descriptors modified in the
 	// current transaction.
 	if p.Descriptors().HasUncommittedTypes() {
-		return physicalplan.LocalPlan
+		return physicalplan.LocalPlan, "transaction modified types"
 	}
 
 	if _, singleTenant := nodeID.OptionalNodeID(); !singleTenant {
-		return physicalplan.LocalPlan
+		return physicalplan.LocalPlan, "not supported for tenants"
 	}
 	if distSQLMode == sessiondata.DistSQLOff {
-		return physicalplan.LocalPlan
+		return physicalplan.LocalPlan, "disabled by distsql session variable"
 	}
 
 	// Don't try to run empty nodes (e.g. SET commands) with distSQL.
 	if _, ok := plan.planNode.(*zeroNode); ok {
-		return physicalplan.LocalPlan
+		return physicalplan.LocalPlan, "empty plan"
 	}
 
 	rec, err := checkSupportForPlanNode(plan.planNode)
 	if err != nil {
 		// Don't use distSQL for this request.
 		log.VEventf(ctx, 1, "query not supported for distSQL: %s", err)
-		return physicalplan.LocalPlan
+		return physicalplan.LocalPlan, err.Error()
 	}
 
 	if shouldDistributeGivenRecAndMode(rec, distSQLMode) {
-		return physicalplan.FullyDistributedPlan
+		return physicalplan.FullyDistributedPlan, ""
 	}
-	return physicalplan.LocalPlan
+	if rec == cannotDistribute {
+		return physicalplan.LocalPlan, "plan cannot be distributed"
+	}
+	return physicalplan.LocalPlan, "plan is not expected to benefit from distribution"
 }
 
 // golangFillQueryArguments transforms Go values into datums.
@@ -2159,6 +2269,26 @@ func (m *sessionDataMutator) SetDisallowFullTableScans(val bool) {
 	m.data.DisallowFullTableScans = val
 }
 
+func (m *sessionDataMutator) SetCollectAllStatementBundles(val bool) {
+	m.data.CollectAllStatementBundles = val
+}
+
+func (m *sessionDataMutator) SetExplainAnalyzeTraceSummary(val bool) {
+	m.data.ExplainAnalyzeTraceSummary = val
+}
+
+func (m *sessionDataMutator) SetExplainAnalyzeMaxRows(val int) {
+	m.data.ExplainAnalyzeMaxRows = val
+}
+
+func (m *sessionDataMutator) SetRandomSeed(val int64) {
+	m.data.RandomSeed = val
+}
+
+func (m *sessionDataMutator) SetStatementDiagnosticsHistorySize(val int) {
+	m.data.StatementDiagnosticsHistorySize = val
+}
+
 func (m *sessionDataMutator) SetAlterColumnTypeGeneral(val bool) {
 	m.data.AlterColumnTypeGeneralEnabled = val
 }
@@ -2216,15 +2346,17 @@ func (s *sqlStatsCollector) recordStatement(
 	vectorized bool,
 	implicitTxn bool,
 	automaticRetryCount int,
+	retryCauses []string,
 	numRows int,
 	err error,
 	parseLat, planLat, runLat, svcLat, ovhLat float64,
 	stats topLevelQueryStats,
+	gateway base.SQLInstanceID,
 ) roachpb.StmtID {
 	return s.appStats.recordStatement(
 		stmt, samplePlanDescription, distSQLUsed, vectorized, implicitTxn,
-		automaticRetryCount, numRows, err, parseLat, planLat, runLat, svcLat,
-		ovhLat, stats,
+		automaticRetryCount, retryCauses, numRows, err, parseLat, planLat, runLat, svcLat,
+		ovhLat, stats, gateway,
 	)
 }
 
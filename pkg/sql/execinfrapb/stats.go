@@ -21,6 +21,22 @@ const StreamIDTagKey = tracing.TagPrefix + "streamid"
 // ProcessorIDTagKey is the key used for processor id tags in tracing spans.
 const ProcessorIDTagKey = tracing.TagPrefix + "processorid"
 
+// TableIDTagKey is the key used for table id tags in tracing spans which
+// record writes to a table.
+const TableIDTagKey = tracing.TagPrefix + "tableid"
+
+// FlowNodeIDTagKey is the key used for the tag of the span of a flow which
+// records the ID of the node that runs the flow.
+const FlowNodeIDTagKey = tracing.TagPrefix + "flownodeid"
+
+// ReadTimestampTagKey and WriteTimestampTagKey are the keys used for the tags
+// of the span of a flow which record the read and write timestamps of the
+// transaction that the flow runs in, as of the setup of the flow.
+const (
+	ReadTimestampTagKey  = tracing.TagPrefix + "readts"
+	WriteTimestampTagKey = tracing.TagPrefix + "writets"
+)
+
 // DistSQLSpanStats is a tracing.SpanStats that returns a list of stats to
 // output on a query plan.
 type DistSQLSpanStats interface {
@@ -72,6 +72,9 @@ func (s *ComponentStats) formatStats(fn func(suffix string, value interface{}))
 	if s.KV.BytesRead.HasValue() {
 		fn("KV bytes read", humanize.IBytes(s.KV.BytesRead.Value()))
 	}
+	if s.KV.RowsWritten.HasValue() {
+		fn("KV rows written", s.KV.RowsWritten.Value())
+	}
 
 	// Exec stats.
 	if s.Exec.ExecTime != 0 {
@@ -11,12 +11,19 @@
 package execstats
 
 import (
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/kv/kvbase"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
 	"github.com/cockroachdb/cockroach/pkg/sql/execstats/execstatspb"
 	"github.com/cockroachdb/cockroach/pkg/sql/flowinfra"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowexec"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing/tracingpb"
 	"github.com/cockroachdb/errors"
 	"github.com/gogo/protobuf/types"
@@ -24,12 +31,26 @@ import (
 
 type processorStats struct {
 	nodeID roachpb.NodeID
-	stats  execinfrapb.DistSQLSpanStats
+	// tableID, tableName, indexName and spans are set if this processor is a
+	// TableReader.
+	tableID   descpb.ID
+	tableName string
+	indexName string
+	spans     roachpb.Spans
+	stats     execinfrapb.DistSQLSpanStats
+	// kvBatches contains the KV batches sent by this processor, as observed in
+	// the trace.
+	kvBatches KVBatchStats
+	// rangeIDs contains the ranges that this processor sent KV batches to, as
+	// observed in the trace.
+	rangeIDs map[roachpb.RangeID]struct{}
 }
 
 type streamStats struct {
 	originNodeID      roachpb.NodeID
 	destinationNodeID roachpb.NodeID
+	// originProcessorID is the processor whose output is sent on the stream.
+	originProcessorID execinfrapb.ProcessorID
 	stats             execinfrapb.DistSQLSpanStats
 }
 
@@ -50,26 +71,61 @@ type TraceAnalyzer struct {
 	// streamStats to have nil stats, which indicates that no stats were found
 	// for the given stream in the trace.
 	streamStats map[execinfrapb.StreamID]*streamStats
+	// rowsWrittenByTable maps a table ID to the number of rows written to that
+	// table, as recorded in the trace by mutations.
+	rowsWrittenByTable map[descpb.ID]int64
+	// skippedSpans is the number of spans of the trace that AddTrace could not
+	// analyze. See Partial.
+	skippedSpans int
+	// flowID is the ID of the flows of the physical plan.
+	flowID execinfrapb.FlowID
+	// flowTimestamps contains the timestamps used by each flow of the physical
+	// plan, as recorded in the trace.
+	flowTimestamps []FlowTimestamps
+}
+
+// FlowTimestamps contains the read and write timestamps of the transaction
+// that a flow ran in (the leaf transaction for remote flows), as of the setup
+// of the flow.
+type FlowTimestamps struct {
+	NodeID         roachpb.NodeID
+	ReadTimestamp  hlc.Timestamp
+	WriteTimestamp hlc.Timestamp
 }
 
 // NewTraceAnalyzer creates a TraceAnalyzer with the corresponding physical
 // plan. Call AddTrace to calculate meaningful stats.
 func NewTraceAnalyzer(flows map[roachpb.NodeID]*execinfrapb.FlowSpec) *TraceAnalyzer {
 	a := &TraceAnalyzer{
-		processorStats: make(map[execinfrapb.ProcessorID]*processorStats),
-		streamStats:    make(map[execinfrapb.StreamID]*streamStats),
+		processorStats:     make(map[execinfrapb.ProcessorID]*processorStats),
+		streamStats:        make(map[execinfrapb.StreamID]*streamStats),
+		rowsWrittenByTable: make(map[descpb.ID]int64),
 	}
 
 	// Annotate the maps with physical plan information.
 	for nodeID, flow := range flows {
+		a.flowID = flow.FlowID
 		for _, proc := range flow.Processors {
-			a.processorStats[execinfrapb.ProcessorID(proc.ProcessorID)] = &processorStats{nodeID: nodeID}
+			ps := &processorStats{nodeID: nodeID}
+			if tr := proc.Core.TableReader; tr != nil {
+				ps.tableID = tr.Table.ID
+				ps.tableName = tr.Table.Name
+				ps.indexName = tr.Table.PrimaryIndex.Name
+				if tr.IndexIdx > 0 {
+					ps.indexName = tr.Table.Indexes[tr.IndexIdx-1].Name
+				}
+				for i := range tr.Spans {
+					ps.spans = append(ps.spans, tr.Spans[i].Span)
+				}
+			}
+			a.processorStats[execinfrapb.ProcessorID(proc.ProcessorID)] = ps
 			for _, output := range proc.Output {
 				for _, stream := range output.Streams {
 					if stream.Type == execinfrapb.StreamEndpointSpec_REMOTE {
 						a.streamStats[stream.StreamID] = &streamStats{
 							originNodeID:      nodeID,
 							destinationNodeID: stream.TargetNodeID,
+							originProcessorID: execinfrapb.ProcessorID(proc.ProcessorID),
 						}
 					}
 				}
@@ -80,52 +136,271 @@ func NewTraceAnalyzer(flows map[roachpb.NodeID]*execinfrapb.FlowSpec) *TraceAnal
 	return a
 }
 
-// AddTrace adds the stats from the given trace to the TraceAnalyzer.
+// AddTrace adds the stats from the given trace to the TraceAnalyzer. The trace
+// may be partial (e.g. truncated, or recorded from a statement which was
+// canceled): spans which can't be analyzed are skipped, the stats of all the
+// other spans are still added, and an error describing the first skipped span
+// is returned. The stats computed by the TraceAnalyzer are then a best-effort
+// lower bound; see Partial.
 func (a *TraceAnalyzer) AddTrace(trace []tracingpb.RecordedSpan) error {
+	var firstErr error
+	skip := func(err error) {
+		a.skippedSpans++
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
 	// Annotate the maps with stats extracted from the trace.
-	for _, span := range trace {
-		if span.Stats == nil {
-			// No stats to unmarshal (e.g. noop processors at time of writing).
-			continue
+	for i := range trace {
+		if err := a.addSpanStats(&trace[i]); err != nil {
+			skip(err)
+		}
+		if err := a.addFlowTimestamps(&trace[i]); err != nil {
+			skip(err)
 		}
+	}
+	if err := a.addKVBatches(trace); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if a.skippedSpans > 1 {
+		firstErr = errors.Wrapf(firstErr, "%d spans skipped", a.skippedSpans)
+	}
+	return firstErr
+}
+
+// addSpanStats adds the stats of the given span, if any, to the TraceAnalyzer.
+func (a *TraceAnalyzer) addSpanStats(span *tracingpb.RecordedSpan) error {
+	if span.Stats == nil {
+		// No stats to unmarshal (e.g. noop processors at time of writing).
+		return nil
+	}
+
+	var da types.DynamicAny
+	if err := types.UnmarshalAny(span.Stats, &da); err != nil {
+		return errors.Wrap(err, "unable to unmarshal in TraceAnalyzer")
+	}
+	stats, ok := da.Message.(execinfrapb.DistSQLSpanStats)
+	if !ok {
+		return nil
+	}
 
-		var da types.DynamicAny
-		if err := types.UnmarshalAny(span.Stats, &da); err != nil {
-			return errors.Wrap(err, "unable to unmarshal in TraceAnalyzer")
+	// Get the processor or stream id for this span. If neither exists, this
+	// span doesn't belong to a processor or stream.
+	if pid, ok := span.Tags[execinfrapb.ProcessorIDTagKey]; ok {
+		stringID := pid
+		id, err := strconv.Atoi(stringID)
+		if err != nil {
+			return errors.Wrap(err, "unable to convert span processor ID tag in TraceAnalyzer")
 		}
-		stats, ok := da.Message.(execinfrapb.DistSQLSpanStats)
-		if !ok {
-			continue
+		processorStats := a.processorStats[execinfrapb.ProcessorID(id)]
+		if processorStats == nil {
+			return errors.Errorf("trace has span for processor %d but the processor does not exist in the physical plan", id)
+		}
+		processorStats.stats = stats
+	} else if sid, ok := span.Tags[execinfrapb.StreamIDTagKey]; ok {
+		stringID := sid
+		id, err := strconv.Atoi(stringID)
+		if err != nil {
+			return errors.Wrap(err, "unable to convert span processor ID tag in TraceAnalyzer")
+		}
+		streamStats := a.streamStats[execinfrapb.StreamID(id)]
+		if streamStats == nil {
+			return errors.Errorf("trace has span for stream %d but the stream does not exist in the physical plan", id)
 		}
+		streamStats.stats = stats
+	} else if tid, ok := span.Tags[execinfrapb.TableIDTagKey]; ok {
+		id, err := strconv.Atoi(tid)
+		if err != nil {
+			return errors.Wrap(err, "unable to convert span table ID tag in TraceAnalyzer")
+		}
+		if cs, ok := stats.(*execstatspb.ComponentStats); ok {
+			a.rowsWrittenByTable[descpb.ID(id)] += int64(cs.KV.RowsWritten.Value())
+		}
+	}
+	return nil
+}
 
-		// Get the processor or stream id for this span. If neither exists, this
-		// span doesn't belong to a processor or stream.
-		if pid, ok := span.Tags[execinfrapb.ProcessorIDTagKey]; ok {
-			stringID := pid
-			id, err := strconv.Atoi(stringID)
-			if err != nil {
-				return errors.Wrap(err, "unable to convert span processor ID tag in TraceAnalyzer")
+// addFlowTimestamps records the timestamps used by a flow of the physical plan,
+// if the given span is the span of such a flow.
+func (a *TraceAnalyzer) addFlowTimestamps(span *tracingpb.RecordedSpan) error {
+	readTS, ok := span.Tags[execinfrapb.ReadTimestampTagKey]
+	if !ok || span.Tags[execinfrapb.FlowIDTagKey] != a.flowID.String() {
+		return nil
+	}
+	nodeID, err := strconv.Atoi(span.Tags[execinfrapb.FlowNodeIDTagKey])
+	if err != nil {
+		return errors.Wrap(err, "unable to convert span flow node ID tag in TraceAnalyzer")
+	}
+	ts := FlowTimestamps{NodeID: roachpb.NodeID(nodeID)}
+	if ts.ReadTimestamp, err = hlc.ParseTimestamp(readTS); err != nil {
+		return errors.Wrap(err, "unable to parse span read timestamp tag in TraceAnalyzer")
+	}
+	writeTS := span.Tags[execinfrapb.WriteTimestampTagKey]
+	if ts.WriteTimestamp, err = hlc.ParseTimestamp(writeTS); err != nil {
+		return errors.Wrap(err, "unable to parse span write timestamp tag in TraceAnalyzer")
+	}
+	a.flowTimestamps = append(a.flowTimestamps, ts)
+	return nil
+}
+
+// Partial returns whether the stats computed by the TraceAnalyzer are
+// incomplete, either because some spans of the trace could not be analyzed or
+// because the trace only has stats for some of the streams of the physical
+// plan (which happens when the trace is truncated). In that case, the stats
+// (e.g. the network bytes sent) only account for the parts of the trace which
+// were analyzed.
+func (a *TraceAnalyzer) Partial() bool {
+	if a.skippedSpans > 0 {
+		return true
+	}
+	var withStats, withoutStats bool
+	for _, stats := range a.streamStats {
+		if stats.stats == nil {
+			withoutStats = true
+		} else {
+			withStats = true
+		}
+	}
+	return withStats && withoutStats
+}
+
+// The operation names of the spans created by the DistSender for each batch
+// and of the spans created for each Internal.Batch RPC (on the client and on
+// the server side, or once if the RPC is local).
+const (
+	kvBatchOperation = "dist sender send"
+	kvRPCOperation   = "/cockroach.roachpb.Internal/Batch"
+)
+
+// kvRangeEvent is part of the event logged by the DistSender when it first
+// sends a batch to a range, which is of the form "r<range ID>: sending batch
+// ..." (see kvcoord.DistSender.sendToReplicas).
+const kvRangeEvent = ": sending batch "
+
+// parseKVRangeEvent returns the ID of the range that a batch is sent to, if
+// the given event is a kvRangeEvent.
+func parseKVRangeEvent(msg string) (roachpb.RangeID, bool) {
+	i := strings.Index(msg, kvRangeEvent)
+	if i < 0 || !strings.HasPrefix(msg, "r") {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(msg[1:i], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return roachpb.RangeID(id), true
+}
+
+// followerReadRejectedEvents are parts of the events logged by a replica which
+// can't serve a read as a follower read, in which case the read is redirected
+// to the leaseholder (see kvserver.Replica.canServeFollowerRead). A read served
+// as a follower read logs kvbase.FollowerReadServingMsg instead.
+var followerReadRejectedEvents = []string{
+	"can't serve follower read",
+	"replicas cannot serve follower reads",
+}
+
+// isFollowerReadRejectedEvent returns whether the given event is one of the
+// followerReadRejectedEvents.
+func isFollowerReadRejectedEvent(msg string) bool {
+	for _, e := range followerReadRejectedEvents {
+		if strings.Contains(msg, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// addKVBatches attributes the KV batches and RPCs in the trace, as well as the
+// ranges the batches were sent to and the follower reads they were served by,
+// to the processors that issued them, i.e. to
+// the closest ancestor span of each batch that belongs to a processor. Batches
+// that aren't issued by a processor (e.g. by the planNodes of the local
+// execution engine) are ignored.
+func (a *TraceAnalyzer) addKVBatches(trace []tracingpb.RecordedSpan) error {
+	spans := make(map[uint64]*tracingpb.RecordedSpan, len(trace))
+	for i := range trace {
+		spans[trace[i].SpanID] = &trace[i]
+	}
+	processor := func(span *tracingpb.RecordedSpan) (*processorStats, error) {
+		for ; span != nil; span = spans[span.ParentSpanID] {
+			if pid, ok := span.Tags[execinfrapb.ProcessorIDTagKey]; ok {
+				id, err := strconv.Atoi(pid)
+				if err != nil {
+					return nil, errors.Wrap(err, "unable to convert span processor ID tag in TraceAnalyzer")
+				}
+				return a.processorStats[execinfrapb.ProcessorID(id)], nil
 			}
-			processorStats := a.processorStats[execinfrapb.ProcessorID(id)]
-			if processorStats == nil {
-				return errors.Errorf("trace has span for processor %d but the processor does not exist in the physical plan", id)
+		}
+		return nil, nil
+	}
+	// Spans which can't be attributed to a processor are skipped, like in
+	// AddTrace, and the first error is returned.
+	var firstErr error
+	for i := range trace {
+		span := &trace[i]
+		for _, l := range span.Logs {
+			msg := l.Msg()
+			rangeID, isRangeEvent := parseKVRangeEvent(msg)
+			isFollowerRead := strings.HasPrefix(msg, kvbase.FollowerReadServingMsg)
+			isFollowerReadRejected := isFollowerReadRejectedEvent(msg)
+			if !isRangeEvent && !isFollowerRead && !isFollowerReadRejected {
+				continue
 			}
-			processorStats.stats = stats
-		} else if sid, ok := span.Tags[execinfrapb.StreamIDTagKey]; ok {
-			stringID := sid
-			id, err := strconv.Atoi(stringID)
+			ps, err := processor(span)
 			if err != nil {
-				return errors.Wrap(err, "unable to convert span processor ID tag in TraceAnalyzer")
+				a.skippedSpans++
+				if firstErr == nil {
+					firstErr = err
+				}
+				break
 			}
-			streamStats := a.streamStats[execinfrapb.StreamID(id)]
-			if streamStats == nil {
-				return errors.Errorf("trace has span for stream %d but the stream does not exist in the physical plan", id)
+			if ps == nil {
+				break
 			}
-			streamStats.stats = stats
+			switch {
+			case isRangeEvent:
+				if ps.rangeIDs == nil {
+					ps.rangeIDs = make(map[roachpb.RangeID]struct{})
+				}
+				ps.rangeIDs[rangeID] = struct{}{}
+			case isFollowerRead:
+				ps.kvBatches.FollowerReads++
+			default:
+				ps.kvBatches.FollowerReadFallbacks++
+			}
+		}
+		var isBatch, isRPC bool
+		switch span.Operation {
+		case kvBatchOperation:
+			isBatch = true
+		case kvRPCOperation:
+			// The server side span of a remote RPC is a child of the client side
+			// span; only count the outermost one.
+			parent := spans[span.ParentSpanID]
+			isRPC = parent == nil || parent.Operation != kvRPCOperation
+		}
+		if !isBatch && !isRPC {
+			continue
+		}
+		ps, err := processor(span)
+		if err != nil {
+			a.skippedSpans++
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if ps == nil {
+			continue
+		}
+		if isBatch {
+			ps.kvBatches.BatchCount++
+		} else {
+			ps.kvBatches.RoundTrips++
 		}
 	}
-
-	return nil
+	return firstErr
 }
 
 func getNetworkBytesFromDistSQLSpanStats(dss execinfrapb.DistSQLSpanStats) (int64, error) {
@@ -154,3 +429,335 @@ func (a *TraceAnalyzer) GetNetworkBytesSent() (map[roachpb.NodeID]int64, error)
 	}
 	return result, nil
 }
+
+// GetNetworkBytesSentByProcessor returns the number of bytes sent over the
+// network the trace reports, grouped by the processor whose output was sent.
+// This allows the network traffic to be attributed to the stages of the plan
+// (e.g. a shuffle before a hash join or the final gather on the gateway).
+func (a *TraceAnalyzer) GetNetworkBytesSentByProcessor() (
+	map[execinfrapb.ProcessorID]int64,
+	error,
+) {
+	result := make(map[execinfrapb.ProcessorID]int64)
+	for _, stats := range a.streamStats {
+		if stats.stats == nil {
+			continue
+		}
+		bytes, err := getNetworkBytesFromDistSQLSpanStats(stats.stats)
+		if err != nil {
+			return nil, err
+		}
+		result[stats.originProcessorID] += bytes
+	}
+	return result, nil
+}
+
+// GetProcessorsPerNode returns the number of processors of the physical plan
+// assigned to each node. It doesn't depend on the trace.
+func (a *TraceAnalyzer) GetProcessorsPerNode() map[roachpb.NodeID]int64 {
+	result := make(map[roachpb.NodeID]int64)
+	for _, stats := range a.processorStats {
+		result[stats.nodeID]++
+	}
+	return result
+}
+
+// GetNetworkDeserializationTime returns the time spent deserializing the
+// data received over the network the trace reports, grouped by the NodeID of
+// the receiving node. Only streams received by the vectorized engine record
+// this time; other streams are ignored.
+func (a *TraceAnalyzer) GetNetworkDeserializationTime() map[roachpb.NodeID]time.Duration {
+	result := make(map[roachpb.NodeID]time.Duration)
+	for _, stats := range a.streamStats {
+		cs, ok := stats.stats.(*execstatspb.ComponentStats)
+		if ok && cs.NetRx.DeserializationTime != 0 {
+			result[stats.destinationNodeID] += cs.NetRx.DeserializationTime
+		}
+	}
+	return result
+}
+
+// GetNetworkDeserializationTimeByProcessor returns the time spent
+// deserializing the data received over the network the trace reports, grouped
+// by the processor whose output was sent. Together with
+// GetNetworkBytesSentByProcessor, this shows the CPU cost of moving the output
+// of a stage of the plan and not only its volume.
+func (a *TraceAnalyzer) GetNetworkDeserializationTimeByProcessor() map[execinfrapb.ProcessorID]time.Duration {
+	result := make(map[execinfrapb.ProcessorID]time.Duration)
+	for _, stats := range a.streamStats {
+		cs, ok := stats.stats.(*execstatspb.ComponentStats)
+		if ok && cs.NetRx.DeserializationTime != 0 {
+			result[stats.originProcessorID] += cs.NetRx.DeserializationTime
+		}
+	}
+	return result
+}
+
+func getKVRowsReadFromDistSQLSpanStats(dss execinfrapb.DistSQLSpanStats) (int64, error) {
+	switch v := dss.(type) {
+	case *rowexec.TableReaderStats:
+		return v.InputStats.NumRows, nil
+	case *execstatspb.ComponentStats:
+		return int64(v.KV.TuplesRead.Value()), nil
+	}
+	return 0, errors.Errorf("could not get KV rows read from %T", dss)
+}
+
+// GetProcessorOutputRows returns the number of rows produced by each processor
+// of the plan. Only processors which report their output in the trace are
+// included; at the time of writing, these are the processors executed by the
+// vectorized engine (the stats of row execution processors only describe their
+// inputs).
+func (a *TraceAnalyzer) GetProcessorOutputRows() map[execinfrapb.ProcessorID]int64 {
+	result := make(map[execinfrapb.ProcessorID]int64)
+	for id, stats := range a.processorStats {
+		if cs, ok := stats.stats.(*execstatspb.ComponentStats); ok && cs.Output.NumTuples.HasValue() {
+			result[id] = int64(cs.Output.NumTuples.Value())
+		}
+	}
+	return result
+}
+
+// GetExecTimeByProcessor returns the time spent executing each processor of
+// the plan. Only processors which report their execution time in the trace are
+// included; at the time of writing, these are the processors executed by the
+// vectorized engine.
+func (a *TraceAnalyzer) GetExecTimeByProcessor() map[execinfrapb.ProcessorID]time.Duration {
+	result := make(map[execinfrapb.ProcessorID]time.Duration)
+	for id, stats := range a.processorStats {
+		if cs, ok := stats.stats.(*execstatspb.ComponentStats); ok && cs.Exec.ExecTime > 0 {
+			result[id] = cs.Exec.ExecTime
+		}
+	}
+	return result
+}
+
+// KVBatchStats contains the number of KV batches sent by a processor and the
+// number of RPCs (round trips to the KV servers) they required. A batch that
+// spans multiple ranges is split into several RPCs, whereas a batch that is
+// served by the local node doesn't go over the network but is still counted
+// as a round trip.
+//
+// FollowerReads is the number of round trips that were served by a follower
+// replica (see kvbase.FollowerReadServingMsg), and FollowerReadFallbacks is the
+// number of times that a replica could not serve a read as a follower read, so
+// that the read fell back to the leaseholder.
+type KVBatchStats struct {
+	BatchCount            int64
+	RoundTrips            int64
+	FollowerReads         int64
+	FollowerReadFallbacks int64
+}
+
+// GetFlowTimestamps returns the timestamps used by each flow of the physical
+// plan, as observed in the trace, ordered by node ID. All the flows of a
+// statement are expected to use the same read timestamp.
+func (a *TraceAnalyzer) GetFlowTimestamps() []FlowTimestamps {
+	result := append([]FlowTimestamps(nil), a.flowTimestamps...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].NodeID < result[j].NodeID
+	})
+	return result
+}
+
+// GetKVBatchesByProcessor returns the KV batches sent by each processor, as
+// observed in the trace. Only processors that sent at least one batch are
+// included.
+func (a *TraceAnalyzer) GetKVBatchesByProcessor() map[execinfrapb.ProcessorID]KVBatchStats {
+	result := make(map[execinfrapb.ProcessorID]KVBatchStats)
+	for id, stats := range a.processorStats {
+		if stats.kvBatches.BatchCount > 0 || stats.kvBatches.RoundTrips > 0 {
+			result[id] = stats.kvBatches
+		}
+	}
+	return result
+}
+
+// GetRangeIDsByProcessor returns the IDs of the distinct ranges that each
+// processor sent KV batches to, as observed in the trace, in increasing order.
+// Only processors that sent at least one batch are included. A high number of
+// ranges for a scan indicates poor locality or missing constraints.
+func (a *TraceAnalyzer) GetRangeIDsByProcessor() map[execinfrapb.ProcessorID][]roachpb.RangeID {
+	result := make(map[execinfrapb.ProcessorID][]roachpb.RangeID)
+	for id, stats := range a.processorStats {
+		if len(stats.rangeIDs) > 0 {
+			result[id] = sortedRangeIDs(stats.rangeIDs)
+		}
+	}
+	return result
+}
+
+func sortedRangeIDs(rangeIDs map[roachpb.RangeID]struct{}) []roachpb.RangeID {
+	res := make([]roachpb.RangeID, 0, len(rangeIDs))
+	for id := range rangeIDs {
+		res = append(res, id)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// GetKVRowsReadByProcessor returns the number of rows read from KV by each
+// TableReader in the plan which recorded its stats in the trace.
+func (a *TraceAnalyzer) GetKVRowsReadByProcessor() (map[execinfrapb.ProcessorID]int64, error) {
+	result := make(map[execinfrapb.ProcessorID]int64)
+	for id, stats := range a.processorStats {
+		if stats.tableID == descpb.InvalidID || stats.stats == nil {
+			continue
+		}
+		rows, err := getKVRowsReadFromDistSQLSpanStats(stats.stats)
+		if err != nil {
+			return nil, err
+		}
+		result[id] = rows
+	}
+	return result, nil
+}
+
+// TableReadStats contains the KV read statistics for a single table.
+type TableReadStats struct {
+	TableName  string
+	KVRowsRead int64
+}
+
+// GetKVRowsReadByTable returns the number of rows read from KV by the
+// TableReaders in the plan, grouped by the ID of the table being read.
+func (a *TraceAnalyzer) GetKVRowsReadByTable() (map[descpb.ID]*TableReadStats, error) {
+	result := make(map[descpb.ID]*TableReadStats)
+	for _, stats := range a.processorStats {
+		if stats.tableID == descpb.InvalidID || stats.stats == nil {
+			continue
+		}
+		rows, err := getKVRowsReadFromDistSQLSpanStats(stats.stats)
+		if err != nil {
+			return nil, err
+		}
+		tableStats := result[stats.tableID]
+		if tableStats == nil {
+			tableStats = &TableReadStats{TableName: stats.tableName}
+			result[stats.tableID] = tableStats
+		}
+		tableStats.KVRowsRead += rows
+	}
+	return result, nil
+}
+
+// IndexSpans contains the spans of an index that were scanned by the
+// TableReaders in the plan, along with the distinct ranges that the scans sent
+// KV batches to (in increasing order).
+type IndexSpans struct {
+	TableName string
+	IndexName string
+	Spans     roachpb.Spans
+	RangeIDs  []roachpb.RangeID
+}
+
+// GetScannedSpansByIndex returns the spans scanned by the TableReaders in the
+// plan, grouped by table and index and sorted by table and index name. Unlike
+// the other statistics, the spans come from the physical plan, so they don't
+// depend on the trace; the ranges do, and are nil if the trace doesn't show
+// any batches sent by the TableReaders.
+func (a *TraceAnalyzer) GetScannedSpansByIndex() []IndexSpans {
+	byIndex := make(map[string]*IndexSpans)
+	rangeIDs := make(map[string]map[roachpb.RangeID]struct{})
+	for _, stats := range a.processorStats {
+		if stats.tableID == descpb.InvalidID {
+			continue
+		}
+		key := stats.tableName + "@" + stats.indexName
+		idx := byIndex[key]
+		if idx == nil {
+			idx = &IndexSpans{TableName: stats.tableName, IndexName: stats.indexName}
+			byIndex[key] = idx
+		}
+		idx.Spans = append(idx.Spans, stats.spans...)
+		for id := range stats.rangeIDs {
+			if rangeIDs[key] == nil {
+				rangeIDs[key] = make(map[roachpb.RangeID]struct{})
+			}
+			rangeIDs[key][id] = struct{}{}
+		}
+	}
+	result := make([]IndexSpans, 0, len(byIndex))
+	for key, idx := range byIndex {
+		if ids := rangeIDs[key]; len(ids) > 0 {
+			idx.RangeIDs = sortedRangeIDs(ids)
+		}
+		result = append(result, *idx)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TableName != result[j].TableName {
+			return result[i].TableName < result[j].TableName
+		}
+		return result[i].IndexName < result[j].IndexName
+	})
+	return result
+}
+
+// GetRowsWrittenByTable returns the number of rows written by mutations,
+// grouped by the ID of the table being written to. Note that mutations are not
+// associated with a particular flow, so the result includes all the writes in
+// the trace.
+func (a *TraceAnalyzer) GetRowsWrittenByTable() map[descpb.ID]int64 {
+	return a.rowsWrittenByTable
+}
+
+// WaitTimes is a breakdown of the time that a statement spent waiting on
+// conflicting requests and transactions, as observed in a trace of its
+// execution.
+type WaitTimes struct {
+	// LockWait is the time spent waiting in lock wait-queues.
+	LockWait time.Duration
+	// LatchWait is the time spent waiting to acquire latches.
+	LatchWait time.Duration
+	// TxnQueueWait is the time spent waiting in the txn wait queue for a
+	// conflicting transaction to finish. Note that pushes are performed while
+	// waiting in lock wait-queues, so this time is usually also part of
+	// LockWait.
+	TxnQueueWait time.Duration
+}
+
+// The events that are logged by the concurrency manager and the txn wait queue
+// right before they start waiting. See concurrency.managerImpl.sequenceReqWithGuard
+// and txnwait.Queue.
+const (
+	lockWaitEvent  = "waiting in lock wait-queues"
+	latchWaitEvent = "acquiring latches"
+)
+
+// isTxnQueueWaitEvent returns whether the given event is logged by the txn
+// wait queue right before it starts waiting.
+func isTxnQueueWaitEvent(msg string) bool {
+	return (strings.Contains(msg, "pushing ") && strings.HasSuffix(msg, " pending)")) ||
+		strings.HasPrefix(msg, "waiting on query for ")
+}
+
+// GetWaitTimes categorizes the wait events in the given trace into lock, latch
+// and txn wait queue waits. A wait is assumed to last from its event until the
+// next event in the same span (or until the end of the span).
+func GetWaitTimes(trace []tracingpb.RecordedSpan) WaitTimes {
+	var res WaitTimes
+	for i := range trace {
+		span := &trace[i]
+		for j, l := range span.Logs {
+			var bucket *time.Duration
+			switch msg := l.Msg(); {
+			case msg == lockWaitEvent:
+				bucket = &res.LockWait
+			case msg == latchWaitEvent:
+				bucket = &res.LatchWait
+			case isTxnQueueWaitEvent(msg):
+				bucket = &res.TxnQueueWait
+			default:
+				continue
+			}
+			end := span.StartTime.Add(span.Duration)
+			if j+1 < len(span.Logs) {
+				end = span.Logs[j+1].Time
+			}
+			if wait := end.Sub(l.Time); wait > 0 {
+				*bucket += wait
+			}
+		}
+	}
+	return res
+}
@@ -13,22 +13,31 @@ package execstats_test
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/security"
 	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
 	"github.com/cockroachdb/cockroach/pkg/sql/execstats"
+	"github.com/cockroachdb/cockroach/pkg/sql/execstats/execstatspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowexec"
 	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
 	"github.com/cockroachdb/cockroach/pkg/sql/sessiondatapb"
 	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing/tracingpb"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/gogo/protobuf/types"
 	"github.com/stretchr/testify/require"
 )
 
@@ -174,4 +183,665 @@ func TestTraceAnalyzer(t *testing.T) {
 			require.LessOrEqual(t, actualBytes, tc.expectedBytesRange[1])
 		}
 	})
+
+	t.Run("KVRowsReadByTable", func(t *testing.T) {
+		for _, analyzer := range []*execstats.TraceAnalyzer{rowexecTraceAnalyzer, colexecTraceAnalyzer} {
+			rowsReadByTable, err := analyzer.GetKVRowsReadByTable()
+			require.NoError(t, err)
+			require.Equal(t, 1, len(rowsReadByTable), "expected a single table to have been read")
+			for _, stats := range rowsReadByTable {
+				require.Equal(t, "foo", stats.TableName)
+				require.Equal(t, int64(30), stats.KVRowsRead)
+			}
+		}
+	})
+}
+
+// TestTraceAnalyzerRowsWritten verifies that the TraceAnalyzer sums up the rows
+// written to each table, as recorded in the trace by mutations.
+func TestTraceAnalyzerRowsWritten(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	makeSpan := func(tableID descpb.ID, rowsWritten uint64) tracingpb.RecordedSpan {
+		stats, err := types.MarshalAny(&execstatspb.ComponentStats{
+			KV: execstatspb.KVStats{RowsWritten: execstatspb.MakeIntValue(rowsWritten)},
+		})
+		require.NoError(t, err)
+		return tracingpb.RecordedSpan{
+			Operation: "rows written",
+			Tags:      map[string]string{execinfrapb.TableIDTagKey: strconv.Itoa(int(tableID))},
+			Stats:     stats,
+		}
+	}
+
+	analyzer := execstats.NewTraceAnalyzer(nil /* flows */)
+	require.NoError(t, analyzer.AddTrace([]tracingpb.RecordedSpan{
+		makeSpan(52, 10),
+		makeSpan(53, 1),
+		makeSpan(52, 5),
+	}))
+	require.Equal(t, map[descpb.ID]int64{52: 15, 53: 1}, analyzer.GetRowsWrittenByTable())
+}
+
+// TestTraceAnalyzerProcessorOutputRows verifies that the TraceAnalyzer reports
+// the output of the processors which recorded it in the trace.
+func TestTraceAnalyzerProcessorOutputRows(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	makeSpan := func(id int, s execinfrapb.DistSQLSpanStats) tracingpb.RecordedSpan {
+		stats, err := types.MarshalAny(s)
+		require.NoError(t, err)
+		return tracingpb.RecordedSpan{
+			Operation: "processor",
+			Tags:      map[string]string{execinfrapb.ProcessorIDTagKey: strconv.Itoa(id)},
+			Stats:     stats,
+		}
+	}
+
+	flows := map[roachpb.NodeID]*execinfrapb.FlowSpec{
+		1: {Processors: []execinfrapb.ProcessorSpec{{ProcessorID: 1}, {ProcessorID: 2}, {ProcessorID: 3}}},
+		2: {Processors: []execinfrapb.ProcessorSpec{{ProcessorID: 4}}},
+	}
+	analyzer := execstats.NewTraceAnalyzer(flows)
+	require.NoError(t, analyzer.AddTrace([]tracingpb.RecordedSpan{
+		makeSpan(1, &execstatspb.ComponentStats{
+			Output: execstatspb.OutputStats{NumTuples: execstatspb.MakeIntValue(10)},
+		}),
+		makeSpan(2, &execstatspb.ComponentStats{
+			Output: execstatspb.OutputStats{NumTuples: execstatspb.MakeIntValue(0)},
+		}),
+		// A vectorized processor that did not record its output.
+		makeSpan(3, &execstatspb.ComponentStats{}),
+		// A row execution processor.
+		makeSpan(4, &rowexec.TableReaderStats{}),
+	}))
+	require.Equal(
+		t, map[execinfrapb.ProcessorID]int64{1: 10, 2: 0}, analyzer.GetProcessorOutputRows(),
+	)
+}
+
+// TestTraceAnalyzerKVRowsReadByProcessor verifies that the TraceAnalyzer
+// reports the rows read from KV by each TableReader.
+func TestTraceAnalyzerKVRowsReadByProcessor(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	makeSpan := func(id int, s execinfrapb.DistSQLSpanStats) tracingpb.RecordedSpan {
+		stats, err := types.MarshalAny(s)
+		require.NoError(t, err)
+		return tracingpb.RecordedSpan{
+			Operation: "processor",
+			Tags:      map[string]string{execinfrapb.ProcessorIDTagKey: strconv.Itoa(id)},
+			Stats:     stats,
+		}
+	}
+	reader := func(id int32) execinfrapb.ProcessorSpec {
+		return execinfrapb.ProcessorSpec{
+			ProcessorID: id,
+			Core: execinfrapb.ProcessorCoreUnion{TableReader: &execinfrapb.TableReaderSpec{
+				Table: descpb.TableDescriptor{ID: 52, Name: "foo"},
+			}},
+		}
+	}
+	flows := map[roachpb.NodeID]*execinfrapb.FlowSpec{
+		1: {Processors: []execinfrapb.ProcessorSpec{reader(1), {ProcessorID: 2}, reader(3)}},
+		2: {Processors: []execinfrapb.ProcessorSpec{reader(4)}},
+	}
+	analyzer := execstats.NewTraceAnalyzer(flows)
+	require.NoError(t, analyzer.AddTrace([]tracingpb.RecordedSpan{
+		makeSpan(1, &execstatspb.ComponentStats{
+			KV: execstatspb.KVStats{TuplesRead: execstatspb.MakeIntValue(100)},
+		}),
+		// Not a TableReader.
+		makeSpan(2, &execstatspb.ComponentStats{
+			KV: execstatspb.KVStats{TuplesRead: execstatspb.MakeIntValue(5)},
+		}),
+		// A row execution TableReader.
+		makeSpan(4, &rowexec.TableReaderStats{
+			InputStats: rowexec.InputStats{NumRows: 20},
+		}),
+	}))
+	rows, err := analyzer.GetKVRowsReadByProcessor()
+	require.NoError(t, err)
+	require.Equal(t, map[execinfrapb.ProcessorID]int64{1: 100, 4: 20}, rows)
+}
+
+// TestTraceAnalyzerExecTimeByProcessor verifies that the TraceAnalyzer reports
+// the execution time of the processors which recorded it in the trace.
+func TestTraceAnalyzerExecTimeByProcessor(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	makeSpan := func(id int, s execinfrapb.DistSQLSpanStats) tracingpb.RecordedSpan {
+		stats, err := types.MarshalAny(s)
+		require.NoError(t, err)
+		return tracingpb.RecordedSpan{
+			Operation: "processor",
+			Tags:      map[string]string{execinfrapb.ProcessorIDTagKey: strconv.Itoa(id)},
+			Stats:     stats,
+		}
+	}
+	flows := map[roachpb.NodeID]*execinfrapb.FlowSpec{
+		1: {Processors: []execinfrapb.ProcessorSpec{{ProcessorID: 1}, {ProcessorID: 2}}},
+		2: {Processors: []execinfrapb.ProcessorSpec{{ProcessorID: 3}}},
+	}
+	analyzer := execstats.NewTraceAnalyzer(flows)
+	require.NoError(t, analyzer.AddTrace([]tracingpb.RecordedSpan{
+		makeSpan(1, &execstatspb.ComponentStats{
+			Exec: execstatspb.ExecStats{ExecTime: 3 * time.Millisecond},
+		}),
+		// No execution time recorded.
+		makeSpan(2, &execstatspb.ComponentStats{}),
+		makeSpan(3, &execstatspb.ComponentStats{
+			Exec: execstatspb.ExecStats{ExecTime: time.Second},
+		}),
+	}))
+	require.Equal(t, map[execinfrapb.ProcessorID]time.Duration{
+		1: 3 * time.Millisecond, 3: time.Second,
+	}, analyzer.GetExecTimeByProcessor())
+}
+
+// TestTraceAnalyzerKVBatchesByProcessor verifies that the TraceAnalyzer
+// attributes the KV batches and RPCs in the trace to the processors that
+// issued them.
+func TestTraceAnalyzerKVBatchesByProcessor(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	var trace []tracingpb.RecordedSpan
+	addSpan := func(parent uint64, op string, tags map[string]string) uint64 {
+		id := uint64(len(trace) + 1)
+		trace = append(trace, tracingpb.RecordedSpan{
+			SpanID: id, ParentSpanID: parent, Operation: op, Tags: tags,
+		})
+		return id
+	}
+	const batch, rpc = "dist sender send", "/cockroach.roachpb.Internal/Batch"
+	root := addSpan(0, "flow", nil)
+	proc := func(id int) uint64 {
+		return addSpan(root, "processor", map[string]string{
+			execinfrapb.ProcessorIDTagKey: strconv.Itoa(id),
+		})
+	}
+
+	// Processor 1 sends two batches, one of which spans two ranges (one local
+	// and one remote RPC).
+	p1 := proc(1)
+	b := addSpan(p1, batch, nil)
+	addSpan(addSpan(b, rpc, nil), rpc, nil)
+	b = addSpan(addSpan(p1, "txn coordinator send", nil), batch, nil)
+	addSpan(b, rpc, nil)
+	addSpan(b, rpc, nil)
+	// Processor 2 doesn't send any batches.
+	proc(2)
+	// A batch that doesn't belong to a processor.
+	addSpan(addSpan(root, batch, nil), rpc, nil)
+
+	flows := map[roachpb.NodeID]*execinfrapb.FlowSpec{
+		1: {Processors: []execinfrapb.ProcessorSpec{{ProcessorID: 1}, {ProcessorID: 2}}},
+	}
+	analyzer := execstats.NewTraceAnalyzer(flows)
+	require.NoError(t, analyzer.AddTrace(trace))
+	require.Equal(
+		t,
+		map[execinfrapb.ProcessorID]execstats.KVBatchStats{1: {BatchCount: 2, RoundTrips: 3}},
+		analyzer.GetKVBatchesByProcessor(),
+	)
+}
+
+// TestTraceAnalyzerFollowerReads verifies that the TraceAnalyzer attributes the
+// follower reads in the trace, as well as the reads that fell back to the
+// leaseholder, to the processors that issued them.
+func TestTraceAnalyzerFollowerReads(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	event := func(msg string) tracingpb.LogRecord {
+		return tracingpb.LogRecord{
+			Fields: []tracingpb.LogRecord_Field{{Key: tracingpb.LogMessageField, Value: msg}},
+		}
+	}
+	var trace []tracingpb.RecordedSpan
+	addSpan := func(parent uint64, op string, tags map[string]string, msgs ...string) uint64 {
+		id := uint64(len(trace) + 1)
+		span := tracingpb.RecordedSpan{SpanID: id, ParentSpanID: parent, Operation: op, Tags: tags}
+		for _, msg := range msgs {
+			span.Logs = append(span.Logs, event(msg))
+		}
+		trace = append(trace, span)
+		return id
+	}
+	const batch, rpc = "dist sender send", "/cockroach.roachpb.Internal/Batch"
+	const (
+		served   = "serving via follower read; query timestamp below closed timestamp by 1s"
+		rejected = "can't serve follower read; closed timestamp too low by: 1s"
+	)
+	root := addSpan(0, "flow", nil)
+	proc := func(id int) uint64 {
+		return addSpan(root, "processor", map[string]string{
+			execinfrapb.ProcessorIDTagKey: strconv.Itoa(id),
+		})
+	}
+
+	// Processor 1 reads two ranges from followers.
+	b := addSpan(proc(1), batch, nil)
+	addSpan(addSpan(b, rpc, nil), rpc, nil, served)
+	addSpan(addSpan(b, rpc, nil), rpc, nil, served)
+	// Processor 2 reads one range from a follower, and falls back to the
+	// leaseholder for another one.
+	b = addSpan(proc(2), batch, nil)
+	addSpan(addSpan(b, rpc, nil), rpc, nil, served)
+	addSpan(addSpan(b, rpc, nil), rpc, nil, rejected)
+	addSpan(b, rpc, nil)
+	// Processor 3 reads from the leaseholder.
+	addSpan(addSpan(proc(3), batch, nil), rpc, nil)
+
+	flows := map[roachpb.NodeID]*execinfrapb.FlowSpec{
+		1: {Processors: []execinfrapb.ProcessorSpec{
+			{ProcessorID: 1}, {ProcessorID: 2}, {ProcessorID: 3},
+		}},
+	}
+	analyzer := execstats.NewTraceAnalyzer(flows)
+	require.NoError(t, analyzer.AddTrace(trace))
+	require.Equal(
+		t,
+		map[execinfrapb.ProcessorID]execstats.KVBatchStats{
+			1: {BatchCount: 1, RoundTrips: 2, FollowerReads: 2},
+			2: {BatchCount: 1, RoundTrips: 3, FollowerReads: 1, FollowerReadFallbacks: 1},
+			3: {BatchCount: 1, RoundTrips: 1},
+		},
+		analyzer.GetKVBatchesByProcessor(),
+	)
+}
+
+// TestTraceAnalyzerFlowTimestamps verifies that the TraceAnalyzer extracts the
+// timestamps used by each flow of its physical plan from the tags of the spans
+// of the flows.
+func TestTraceAnalyzerFlowTimestamps(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	flowID := execinfrapb.FlowID{UUID: uuid.MakeV4()}
+	otherFlowID := execinfrapb.FlowID{UUID: uuid.MakeV4()}
+	ts1 := hlc.Timestamp{WallTime: 100, Logical: 1}
+	ts2 := hlc.Timestamp{WallTime: 200}
+	flowSpan := func(
+		id execinfrapb.FlowID, nodeID int, readTS, writeTS hlc.Timestamp,
+	) tracingpb.RecordedSpan {
+		return tracingpb.RecordedSpan{Operation: "flow", Tags: map[string]string{
+			execinfrapb.FlowIDTagKey:         id.String(),
+			execinfrapb.FlowNodeIDTagKey:     strconv.Itoa(nodeID),
+			execinfrapb.ReadTimestampTagKey:  readTS.String(),
+			execinfrapb.WriteTimestampTagKey: writeTS.String(),
+		}}
+	}
+	trace := []tracingpb.RecordedSpan{
+		flowSpan(flowID, 2, ts1, ts2),
+		flowSpan(flowID, 1, ts1, ts1),
+		// A flow of another physical plan (e.g. of a subquery).
+		flowSpan(otherFlowID, 3, ts2, ts2),
+		// A processor of the flow.
+		{Operation: "processor", Tags: map[string]string{
+			execinfrapb.FlowIDTagKey:      flowID.String(),
+			execinfrapb.ProcessorIDTagKey: "1",
+		}},
+	}
+
+	flows := map[roachpb.NodeID]*execinfrapb.FlowSpec{
+		1: {FlowID: flowID, Processors: []execinfrapb.ProcessorSpec{{ProcessorID: 1}}},
+		2: {FlowID: flowID, Processors: []execinfrapb.ProcessorSpec{{ProcessorID: 2}}},
+	}
+	analyzer := execstats.NewTraceAnalyzer(flows)
+	require.NoError(t, analyzer.AddTrace(trace))
+	require.Equal(t, []execstats.FlowTimestamps{
+		{NodeID: 1, ReadTimestamp: ts1, WriteTimestamp: ts1},
+		{NodeID: 2, ReadTimestamp: ts1, WriteTimestamp: ts2},
+	}, analyzer.GetFlowTimestamps())
+}
+
+// TestTraceAnalyzerNetworkBytesSentByProcessor verifies that the TraceAnalyzer
+// attributes the bytes sent on each stream to the processor that produced them.
+func TestTraceAnalyzerNetworkBytesSentByProcessor(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	makeSpan := func(streamID int, bytes uint64) tracingpb.RecordedSpan {
+		stats, err := types.MarshalAny(&execstatspb.ComponentStats{
+			NetRx: execstatspb.NetworkRxStats{BytesReceived: execstatspb.MakeIntValue(bytes)},
+		})
+		require.NoError(t, err)
+		return tracingpb.RecordedSpan{
+			Operation: "inbox",
+			Tags:      map[string]string{execinfrapb.StreamIDTagKey: strconv.Itoa(streamID)},
+			Stats:     stats,
+		}
+	}
+	remote := func(streamIDs ...int) []execinfrapb.OutputRouterSpec {
+		var streams []execinfrapb.StreamEndpointSpec
+		for _, id := range streamIDs {
+			streams = append(streams, execinfrapb.StreamEndpointSpec{
+				Type:         execinfrapb.StreamEndpointSpec_REMOTE,
+				StreamID:     execinfrapb.StreamID(id),
+				TargetNodeID: 1,
+			})
+		}
+		return []execinfrapb.OutputRouterSpec{{Streams: streams}}
+	}
+
+	flows := map[roachpb.NodeID]*execinfrapb.FlowSpec{
+		1: {Processors: []execinfrapb.ProcessorSpec{{ProcessorID: 1}}},
+		2: {Processors: []execinfrapb.ProcessorSpec{
+			{ProcessorID: 2, Output: remote(1, 2)},
+			{ProcessorID: 3, Output: remote(3)},
+		}},
+	}
+	analyzer := execstats.NewTraceAnalyzer(flows)
+	require.NoError(t, analyzer.AddTrace([]tracingpb.RecordedSpan{
+		makeSpan(1, 10),
+		makeSpan(2, 20),
+		makeSpan(3, 5),
+	}))
+	bytesSent, err := analyzer.GetNetworkBytesSentByProcessor()
+	require.NoError(t, err)
+	require.Equal(t, map[execinfrapb.ProcessorID]int64{2: 30, 3: 5}, bytesSent)
+}
+
+// TestTraceAnalyzerPartialTrace verifies that the TraceAnalyzer still computes
+// the network bytes sent from the spans it can analyze when the trace is
+// partial, and that it reports the stats as partial.
+func TestTraceAnalyzerPartialTrace(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	makeSpan := func(streamID int, bytes uint64) tracingpb.RecordedSpan {
+		stats, err := types.MarshalAny(&execstatspb.ComponentStats{
+			NetRx: execstatspb.NetworkRxStats{BytesReceived: execstatspb.MakeIntValue(bytes)},
+		})
+		require.NoError(t, err)
+		return tracingpb.RecordedSpan{
+			Operation: "inbox",
+			Tags:      map[string]string{execinfrapb.StreamIDTagKey: strconv.Itoa(streamID)},
+			Stats:     stats,
+		}
+	}
+	flows := map[roachpb.NodeID]*execinfrapb.FlowSpec{
+		1: {Processors: []execinfrapb.ProcessorSpec{{ProcessorID: 1}}},
+		2: {Processors: []execinfrapb.ProcessorSpec{{
+			ProcessorID: 2,
+			Output: []execinfrapb.OutputRouterSpec{{Streams: []execinfrapb.StreamEndpointSpec{
+				{Type: execinfrapb.StreamEndpointSpec_REMOTE, StreamID: 1, TargetNodeID: 1},
+				{Type: execinfrapb.StreamEndpointSpec_REMOTE, StreamID: 2, TargetNodeID: 1},
+			}}},
+		}}},
+	}
+	bytesSent := func(a *execstats.TraceAnalyzer) int64 {
+		bytesByNode, err := a.GetNetworkBytesSent()
+		require.NoError(t, err)
+		return bytesByNode[2]
+	}
+
+	t.Run("complete", func(t *testing.T) {
+		analyzer := execstats.NewTraceAnalyzer(flows)
+		require.NoError(t, analyzer.AddTrace([]tracingpb.RecordedSpan{makeSpan(1, 10), makeSpan(2, 20)}))
+		require.False(t, analyzer.Partial())
+		require.Equal(t, int64(30), bytesSent(analyzer))
+	})
+
+	t.Run("unknown stream", func(t *testing.T) {
+		// The span for the unknown stream is skipped, but the other spans are
+		// still analyzed.
+		analyzer := execstats.NewTraceAnalyzer(flows)
+		require.Error(t, analyzer.AddTrace([]tracingpb.RecordedSpan{
+			makeSpan(1, 10), makeSpan(7, 100), makeSpan(2, 20),
+		}))
+		require.True(t, analyzer.Partial())
+		require.Equal(t, int64(30), bytesSent(analyzer))
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		// The trace only has stats for one of the streams.
+		analyzer := execstats.NewTraceAnalyzer(flows)
+		require.NoError(t, analyzer.AddTrace([]tracingpb.RecordedSpan{makeSpan(2, 20)}))
+		require.True(t, analyzer.Partial())
+		require.Equal(t, int64(20), bytesSent(analyzer))
+	})
+}
+
+func TestTraceAnalyzerProcessorsPerNode(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	flows := map[roachpb.NodeID]*execinfrapb.FlowSpec{
+		1: {Processors: []execinfrapb.ProcessorSpec{{ProcessorID: 1}}},
+		2: {Processors: []execinfrapb.ProcessorSpec{{ProcessorID: 2}, {ProcessorID: 3}}},
+	}
+	analyzer := execstats.NewTraceAnalyzer(flows)
+	require.Equal(t, map[roachpb.NodeID]int64{1: 1, 2: 2}, analyzer.GetProcessorsPerNode())
+}
+
+// TestTraceAnalyzerNetworkDeserializationTime verifies that the TraceAnalyzer
+// reports the time spent deserializing the data received on each stream,
+// grouped both by receiving node and by the processor that produced the data.
+func TestTraceAnalyzerNetworkDeserializationTime(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	makeSpan := func(streamID int, d time.Duration) tracingpb.RecordedSpan {
+		stats, err := types.MarshalAny(&execstatspb.ComponentStats{
+			NetRx: execstatspb.NetworkRxStats{DeserializationTime: d},
+		})
+		require.NoError(t, err)
+		return tracingpb.RecordedSpan{
+			Operation: "inbox",
+			Tags:      map[string]string{execinfrapb.StreamIDTagKey: strconv.Itoa(streamID)},
+			Stats:     stats,
+		}
+	}
+	remote := func(targetNodeID roachpb.NodeID, streamID int) []execinfrapb.OutputRouterSpec {
+		return []execinfrapb.OutputRouterSpec{{Streams: []execinfrapb.StreamEndpointSpec{{
+			Type:         execinfrapb.StreamEndpointSpec_REMOTE,
+			StreamID:     execinfrapb.StreamID(streamID),
+			TargetNodeID: targetNodeID,
+		}}}}
+	}
+
+	flows := map[roachpb.NodeID]*execinfrapb.FlowSpec{
+		1: {Processors: []execinfrapb.ProcessorSpec{{ProcessorID: 1}}},
+		2: {Processors: []execinfrapb.ProcessorSpec{
+			{ProcessorID: 2, Output: remote(1, 1)},
+			{ProcessorID: 3, Output: remote(3, 2)},
+		}},
+		3: {Processors: []execinfrapb.ProcessorSpec{{ProcessorID: 4, Output: remote(1, 3)}}},
+	}
+	analyzer := execstats.NewTraceAnalyzer(flows)
+	require.NoError(t, analyzer.AddTrace([]tracingpb.RecordedSpan{
+		makeSpan(1, 10*time.Millisecond),
+		makeSpan(2, 20*time.Millisecond),
+		makeSpan(3, 5*time.Millisecond),
+	}))
+	require.Equal(
+		t,
+		map[roachpb.NodeID]time.Duration{1: 15 * time.Millisecond, 3: 20 * time.Millisecond},
+		analyzer.GetNetworkDeserializationTime(),
+	)
+	require.Equal(
+		t,
+		map[execinfrapb.ProcessorID]time.Duration{
+			2: 10 * time.Millisecond, 3: 20 * time.Millisecond, 4: 5 * time.Millisecond,
+		},
+		analyzer.GetNetworkDeserializationTimeByProcessor(),
+	)
+}
+
+func TestGetWaitTimes(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	event := func(ms int, msg string) tracingpb.LogRecord {
+		return tracingpb.LogRecord{
+			Time:   at(ms),
+			Fields: []tracingpb.LogRecord_Field{{Key: tracingpb.LogMessageField, Value: msg}},
+		}
+	}
+	trace := []tracingpb.RecordedSpan{
+		{
+			Operation: "request",
+			StartTime: at(0),
+			Duration:  100 * time.Millisecond,
+			Logs: []tracingpb.LogRecord{
+				event(0, "sequencing request"),
+				event(1, "acquiring latches"),
+				event(11, "scanning lock table for conflicting locks"),
+				event(12, "waiting in lock wait-queues"),
+				event(42, "acquiring latches"),
+				event(44, "scanning lock table for conflicting locks"),
+			},
+		},
+		{
+			Operation: "push",
+			StartTime: at(20),
+			Duration:  20 * time.Millisecond,
+			Logs: []tracingpb.LogRecord{
+				event(20, "a1b2c3d4 pushing e5f6a7b8 (1 pending)"),
+			},
+		},
+	}
+	require.Equal(t, execstats.WaitTimes{
+		LockWait:     30 * time.Millisecond,
+		LatchWait:    12 * time.Millisecond,
+		TxnQueueWait: 20 * time.Millisecond,
+	}, execstats.GetWaitTimes(trace))
+}
+
+// TestTraceAnalyzerScannedSpans verifies that the TraceAnalyzer groups the spans
+// scanned by the TableReaders of the physical plan by table and index.
+func TestTraceAnalyzerScannedSpans(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	table := descpb.TableDescriptor{
+		ID:           52,
+		Name:         "foo",
+		PrimaryIndex: descpb.IndexDescriptor{Name: "primary"},
+		Indexes:      []descpb.IndexDescriptor{{Name: "foo_v_idx"}},
+	}
+	span := func(start, end string) execinfrapb.TableReaderSpan {
+		return execinfrapb.TableReaderSpan{
+			Span: roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)},
+		}
+	}
+	reader := func(
+		id int32, indexIdx uint32, spans ...execinfrapb.TableReaderSpan,
+	) execinfrapb.ProcessorSpec {
+		return execinfrapb.ProcessorSpec{
+			ProcessorID: id,
+			Core: execinfrapb.ProcessorCoreUnion{TableReader: &execinfrapb.TableReaderSpec{
+				Table:    table,
+				IndexIdx: indexIdx,
+				Spans:    spans,
+			}},
+		}
+	}
+	flows := map[roachpb.NodeID]*execinfrapb.FlowSpec{
+		1: {Processors: []execinfrapb.ProcessorSpec{reader(1, 1, span("a", "b"))}},
+		2: {Processors: []execinfrapb.ProcessorSpec{reader(2, 0, span("c", "d"), span("e", "f"))}},
+	}
+	analyzer := execstats.NewTraceAnalyzer(flows)
+	require.Equal(t, []execstats.IndexSpans{
+		{
+			TableName: "foo",
+			IndexName: "foo_v_idx",
+			Spans:     roachpb.Spans{{Key: roachpb.Key("a"), EndKey: roachpb.Key("b")}},
+		},
+		{
+			TableName: "foo",
+			IndexName: "primary",
+			Spans: roachpb.Spans{
+				{Key: roachpb.Key("c"), EndKey: roachpb.Key("d")},
+				{Key: roachpb.Key("e"), EndKey: roachpb.Key("f")},
+			},
+		},
+	}, analyzer.GetScannedSpansByIndex())
+}
+
+// TestTraceAnalyzerRangesScanned verifies that the TraceAnalyzer counts the
+// distinct ranges that each TableReader sent KV batches to, based on the events
+// logged by the DistSender, and groups them by index.
+func TestTraceAnalyzerRangesScanned(t *testing.T) {
+	defer log.Scope(t).Close(t)
+	defer leaktest.AfterTest(t)()
+
+	event := func(msg string) tracingpb.LogRecord {
+		return tracingpb.LogRecord{
+			Fields: []tracingpb.LogRecord_Field{{Key: tracingpb.LogMessageField, Value: msg}},
+		}
+	}
+	var trace []tracingpb.RecordedSpan
+	addSpan := func(parent uint64, op string, tags map[string]string, msgs ...string) uint64 {
+		id := uint64(len(trace) + 1)
+		span := tracingpb.RecordedSpan{SpanID: id, ParentSpanID: parent, Operation: op, Tags: tags}
+		for _, msg := range msgs {
+			span.Logs = append(span.Logs, event(msg))
+		}
+		trace = append(trace, span)
+		return id
+	}
+	const batch = "dist sender send"
+	root := addSpan(0, "flow", nil)
+	proc := func(id int) uint64 {
+		return addSpan(root, "table reader", map[string]string{
+			execinfrapb.ProcessorIDTagKey: strconv.Itoa(id),
+		})
+	}
+
+	// Processor 1 scans ranges 3 and 5, the latter twice.
+	p1 := proc(1)
+	addSpan(
+		p1, batch, nil,
+		"r5: sending batch 1 Scan to (n1,s1):1", "r3: sending batch 1 Scan to (n1,s1):1",
+	)
+	addSpan(p1, batch, nil, "r5: sending batch 1 Scan to (n1,s1):1", "trying next peer (n2,s2):2")
+	// Processor 2 scans range 7 of the same index.
+	addSpan(proc(2), batch, nil, "r7: sending batch 1 Scan to (n2,s2):2")
+	// Processor 3 scans another index.
+	addSpan(proc(3), batch, nil, "r5: sending batch 1 Scan to (n1,s1):1")
+	// A batch that doesn't belong to a processor.
+	addSpan(root, batch, nil, "r9: sending batch 1 Get to (n1,s1):1")
+
+	table := descpb.TableDescriptor{
+		ID:           52,
+		Name:         "foo",
+		PrimaryIndex: descpb.IndexDescriptor{Name: "primary"},
+		Indexes:      []descpb.IndexDescriptor{{Name: "foo_v_idx"}},
+	}
+	reader := func(id int32, indexIdx uint32) execinfrapb.ProcessorSpec {
+		return execinfrapb.ProcessorSpec{
+			ProcessorID: id,
+			Core: execinfrapb.ProcessorCoreUnion{TableReader: &execinfrapb.TableReaderSpec{
+				Table:    table,
+				IndexIdx: indexIdx,
+			}},
+		}
+	}
+	flows := map[roachpb.NodeID]*execinfrapb.FlowSpec{
+		1: {Processors: []execinfrapb.ProcessorSpec{reader(1, 0), reader(3, 1)}},
+		2: {Processors: []execinfrapb.ProcessorSpec{reader(2, 0)}},
+	}
+	analyzer := execstats.NewTraceAnalyzer(flows)
+	require.NoError(t, analyzer.AddTrace(trace))
+	require.Equal(t, map[execinfrapb.ProcessorID][]roachpb.RangeID{
+		1: {3, 5},
+		2: {7},
+		3: {5},
+	}, analyzer.GetRangeIDsByProcessor())
+	require.Equal(t, []execstats.IndexSpans{
+		{TableName: "foo", IndexName: "foo_v_idx", RangeIDs: []roachpb.RangeID{5}},
+		{TableName: "foo", IndexName: "primary", RangeIDs: []roachpb.RangeID{3, 5, 7}},
+	}, analyzer.GetScannedSpansByIndex())
 }
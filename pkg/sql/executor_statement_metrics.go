@@ -44,8 +44,24 @@ const (
 	sessionEndParse         // Parse ends.
 	plannerStartLogicalPlan // Planning starts.
 	plannerEndLogicalPlan   // Planning ends.
-	plannerStartExecStmt    // Execution starts.
-	plannerEndExecStmt      // Execution ends.
+	// Waiting on the concurrency limit starts and ends. These are only set if a
+	// ConcurrencyLimiter is configured.
+	plannerStartConcurrencyWait
+	plannerEndConcurrencyWait
+	// Waiting for admission starts and ends. These are only set if an
+	// AdmissionController is configured.
+	plannerStartAdmissionWait
+	plannerEndAdmissionWait
+	plannerStartExecStmt // Execution starts.
+	// The first row is delivered to the result. This is only set for statements
+	// that return rows, and only if they return at least one row.
+	plannerFirstRowExecStmt
+	plannerEndExecStmt // Execution ends.
+	// The auto-commit of an implicit transaction starts and ends. These are only
+	// set if the transaction was not already committed by the execution of the
+	// statement (see handleAutoCommit).
+	plannerStartAutoCommit
+	plannerEndAutoCommit
 	// Query is serviced. Note that we compute this even for empty queries or
 	// "special" statements that have no execution, like SHOW TRANSACTION STATUS.
 	sessionQueryServiced
@@ -68,6 +84,31 @@ const (
 // copy behavior.
 type phaseTimes [sessionNumPhases]time.Time
 
+// CustomPhase identifies a phase of the execution of statements which is not
+// known to the executor, e.g. a phase added by an extension (like row-level
+// security checks). Custom phases are registered with RegisterCustomPhase and
+// their durations are recorded with PlanHookState.RecordCustomPhase. They are
+// shown by EXPLAIN ANALYZE and on the statement span along with the built-in
+// phases, so that extensions don't need to add phases to phaseTimes.
+type CustomPhase int
+
+// customPhaseNames contains the names of the registered custom phases, indexed
+// by CustomPhase.
+var customPhaseNames []string
+
+// RegisterCustomPhase registers a custom phase with the given name, which is
+// shown by EXPLAIN ANALYZE as "<name> time". Should be called as part of an
+// init() function.
+func RegisterCustomPhase(name string) CustomPhase {
+	customPhaseNames = append(customPhaseNames, name)
+	return CustomPhase(len(customPhaseNames) - 1)
+}
+
+// String returns the name with which the phase was registered.
+func (c CustomPhase) String() string {
+	return customPhaseNames[c]
+}
+
 // getServiceLatency returns the time between a query being received and the end
 // of run.
 func (p *phaseTimes) getServiceLatency() time.Duration {
@@ -94,11 +135,40 @@ func (p *phaseTimes) getRunLatency() time.Duration {
 	return p[plannerEndExecStmt].Sub(p[plannerStartExecStmt])
 }
 
+// getFirstRowLatency returns the time between a query execution starting and
+// the first row being delivered to the result. It returns zero if no rows were
+// delivered.
+func (p *phaseTimes) getFirstRowLatency() time.Duration {
+	if p[plannerFirstRowExecStmt].IsZero() {
+		return 0
+	}
+	return p[plannerFirstRowExecStmt].Sub(p[plannerStartExecStmt])
+}
+
+// getAutoCommitLatency returns the time it took to commit the implicit
+// transaction of a query after its execution. It returns zero if the
+// transaction was not committed separately from the execution.
+func (p *phaseTimes) getAutoCommitLatency() time.Duration {
+	return p[plannerEndAutoCommit].Sub(p[plannerStartAutoCommit])
+}
+
 // getPlanningLatency returns the time it takes for a query to be planned.
 func (p *phaseTimes) getPlanningLatency() time.Duration {
 	return p[plannerEndLogicalPlan].Sub(p[plannerStartLogicalPlan])
 }
 
+// getAdmissionWaitLatency returns the time a query spent waiting for admission
+// before execution.
+func (p *phaseTimes) getAdmissionWaitLatency() time.Duration {
+	return p[plannerEndAdmissionWait].Sub(p[plannerStartAdmissionWait])
+}
+
+// getConcurrencyWaitLatency returns the time a query spent waiting on the
+// concurrency limit before execution.
+func (p *phaseTimes) getConcurrencyWaitLatency() time.Duration {
+	return p[plannerEndConcurrencyWait].Sub(p[plannerStartConcurrencyWait])
+}
+
 // getParsingLatency returns the time it takes for a query to be parsed.
 func (p *phaseTimes) getParsingLatency() time.Duration {
 	return p[sessionEndParse].Sub(p[sessionStartParse])
@@ -132,6 +202,12 @@ type EngineMetrics struct {
 	SQLServiceLatency     *metric.Histogram
 	SQLTxnLatency         *metric.Histogram
 
+	// SQLInstrumentationOverhead measures the time spent setting up and
+	// finishing the instrumentation of statements (tracing, building
+	// diagnostics bundles, analyzing traces). Only instrumented statements are
+	// recorded.
+	SQLInstrumentationOverhead *metric.Histogram
+
 	// TxnAbortCount counts transactions that were aborted, either due
 	// to non-retriable errors, or retriable errors when the client-side
 	// retry protocol is not in use.
@@ -153,12 +229,14 @@ func (EngineMetrics) MetricStruct() {}
 // - distSQLUsed reports whether the query was distributed.
 // - automaticRetryCount is the count of implicit txn retries
 //   so far.
+// - retryCauses describes the causes of these retries.
 // - result is the result set computed by the query/statement.
 // - err is the error encountered, if any.
 func (ex *connExecutor) recordStatementSummary(
 	ctx context.Context,
 	planner *planner,
 	automaticRetryCount int,
+	retryCauses []string,
 	rowsAffected int,
 	err error,
 	stats topLevelQueryStats,
@@ -198,9 +276,10 @@ func (ex *connExecutor) recordStatementSummary(
 	stmtID := ex.statsCollector.recordStatement(
 		stmt, planner.instrumentation.PlanForStats(ctx),
 		flags.IsDistributed(), flags.IsSet(planFlagVectorized),
-		flags.IsSet(planFlagImplicitTxn), automaticRetryCount, rowsAffected, err,
-		parseLat, planLat, runLat, svcLat, execOverhead, stats,
+		flags.IsSet(planFlagImplicitTxn), automaticRetryCount, retryCauses, rowsAffected, err,
+		parseLat, planLat, runLat, svcLat, execOverhead, stats, ex.server.cfg.NodeID.SQLInstanceID(),
 	)
+	planner.instrumentation.recordPlanGist(ctx, ex.server.cfg, ex.statsCollector.appStats, err)
 
 	// Do some transaction level accounting for the transaction this statement is
 	// a part of.
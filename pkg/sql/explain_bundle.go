@@ -14,19 +14,33 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"net/url"
+	"runtime/pprof"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/DataDog/zstd"
 	"github.com/cockroachdb/cockroach/pkg/kv"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvclient/kvcoord"
 	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
+	"github.com/cockroachdb/cockroach/pkg/sql/opt"
 	"github.com/cockroachdb/cockroach/pkg/sql/opt/cat"
 	"github.com/cockroachdb/cockroach/pkg/sql/opt/memo"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
 	"github.com/cockroachdb/cockroach/pkg/sql/stmtdiagnostics"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/limit"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing/tracingpb"
@@ -34,6 +48,99 @@ import (
 	"github.com/gogo/protobuf/jsonpb"
 )
 
+// zipMethodZstd is the zip compression method ID assigned to zstd by the zip
+// file format specification (APPNOTE 6.3.7).
+const zipMethodZstd uint16 = 93
+
+// bundleCompression controls how the files inside statement diagnostics
+// bundles are compressed.
+var bundleCompression = settings.RegisterEnumSetting(
+	"sql.stmt_diagnostics.bundle_compression",
+	"compression method for files in statement diagnostics bundles; zstd "+
+		"produces smaller bundles but requires an unzip tool with zstd support",
+	"deflate",
+	map[int64]string{
+		int64(zip.Deflate):   "deflate",
+		int64(zipMethodZstd): "zstd",
+	},
+)
+
+// separateInternalTrace controls whether the spans of the queries issued by the
+// internal executor while executing a statement are moved out of the main trace
+// files of the bundle.
+var separateInternalTrace = settings.RegisterBoolSetting(
+	"sql.stmt_diagnostics.separate_internal_trace.enabled",
+	"if enabled, the spans of queries issued by the internal executor on behalf of "+
+		"a statement are moved from trace.txt and trace.json to internal-trace.txt in "+
+		"statement diagnostics bundles",
+	false,
+)
+
+// maxTraceSize limits the size of the trace included in statement diagnostics
+// bundles (see truncateTrace).
+var maxTraceSize = settings.RegisterByteSizeSetting(
+	"sql.stmt_diagnostics.max_trace_size",
+	"maximum size of the trace included in a statement diagnostics bundle; the spans "+
+		"past this size are omitted from the bundle; if 0, the trace is never truncated",
+	64<<20, /* 64 MiB */
+)
+
+// redactedColumns is a comma-separated list of column names whose values must
+// not be stored with statement diagnostics (see redactedColumnNames). Values
+// can't be reliably traced back to their columns (they flow through joins,
+// subqueries, spans and traces), so when the setting is set, no value from the
+// statement is stored at all: the plan hides all of its constants and spans,
+// only the files listed in valueFreeFiles are kept and the statement is stored
+// as its fingerprint.
+var redactedColumns = settings.RegisterStringSetting(
+	"sql.stmt_diagnostics.redacted_columns",
+	"comma-separated list of column names (e.g. email,ssn) whose values must not be stored "+
+		"with statement diagnostics; when set, all the constants and spans are hidden from the "+
+		"plans in the bundles, the bundle files which can contain values from the statement "+
+		"(e.g. the statement, its placeholders and its trace) are omitted and the statement is "+
+		"stored as its fingerprint",
+	"",
+)
+
+// redactedColumnNames returns the column names in the
+// sql.stmt_diagnostics.redacted_columns setting.
+func redactedColumnNames(sv *settings.Values) []string {
+	var names []string
+	for _, name := range strings.Split(redactedColumns.Get(sv), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// valueFreeFiles are the names of the bundle files which can't contain any
+// value read or written by the statement (plan.txt is built with its values
+// hidden in that case). When sql.stmt_diagnostics.redacted_columns is set, the
+// contents of every other file are replaced with omittedValuesNote, so that a
+// file added to the bundle in the future is omitted unless it is listed here.
+var valueFreeFiles = map[string]bool{
+	"name.txt":          true,
+	"plan.txt":          true,
+	"env.sql":           true,
+	"schema.sql":        true,
+	"version.txt":       true,
+	"stats.txt":         true,
+	"stats-history.txt": true,
+	"overhead.txt":      true,
+	"metadata.json":     true,
+}
+
+// omittedValuesNote replaces the contents of the bundle files which aren't
+// valueFreeFiles when sql.stmt_diagnostics.redacted_columns is set.
+const omittedValuesNote = "-- omitted because sql.stmt_diagnostics.redacted_columns is set\n"
+
+// internalExecutorSpanTag is the tag set on the span of each query issued by
+// the internal executor; its value is the operation name of the query.
+// Note that it is different from the "intExec" log tag, which is inherited by
+// all the descendants of the span.
+const internalExecutorSpanTag = "internal_executor_query"
+
 // setExplainBundleResult sets the result of an EXPLAIN ANALYZE (DEBUG)
 // statement.
 //
@@ -133,6 +240,9 @@ type diagnosticsBundle struct {
 	// Tracing data, as DJson (or DNull if it is not available).
 	traceJSON tree.Datum
 
+	// traceHash is a structural hash of the trace; see traceStructuralHash.
+	traceHash string
+
 	// Stores any error in the collection, building, or insertion of the bundle.
 	collectionErr error
 
@@ -140,31 +250,153 @@ type diagnosticsBundle struct {
 	diagID stmtdiagnostics.CollectedInstanceID
 }
 
+// BundleContributor can be implemented to add custom files to statement
+// diagnostics bundles. Contributors are registered through
+// ExecutorConfig.BundleContributors and are invoked after the standard bundle
+// files have been added.
+type BundleContributor interface {
+	// BundleFiles returns the files to be added to the bundle, keyed by file
+	// name. If an error is returned, no files from this contributor are added.
+	BundleFiles(ctx context.Context, info *BundleInfo) (map[string]string, error)
+}
+
+// BundleInfo contains information about a statement for which a diagnostics
+// bundle is being built. It is passed to BundleContributors.
+type BundleInfo struct {
+	// Statement is the AST of the statement; it can be nil if we hit an error
+	// early.
+	Statement tree.Statement
+	// Plan is the EXPLAIN (VERBOSE, TYPES) output for the statement; it can be
+	// empty if no plan was built.
+	Plan string
+	// Trace is the recording of the statement execution.
+	Trace tracing.Recording
+	// Placeholders contains the placeholder values bound to the statement, if
+	// any.
+	Placeholders *tree.PlaceholderInfo
+}
+
+// bundleInputs contains the information about the planning and execution of a
+// statement, collected by the instrumentationHelper, from which its diagnostics
+// bundle is built (see buildStatementBundle).
+type bundleInputs struct {
+	// planString is the EXPLAIN (VERBOSE, TYPES) output for the statement.
+	planString string
+	// stmtRawSQL is the statement as it was received, with its comments.
+	stmtRawSQL  string
+	fingerprint string
+
+	trace tracing.Recording
+	// maxTraceSize, if positive, is the size (in bytes) to which the trace is
+	// truncated (see truncateTrace).
+	maxTraceSize int64
+	// traceFilters, separateInternal and verbosity determine which spans of the
+	// trace are included in the trace files (see stmtBundleBuilder).
+	traceFilters     []string
+	separateInternal bool
+	verbosity        stmtdiagnostics.TraceVerbosity
+
+	placeholders *tree.PlaceholderInfo
+
+	// The following are the contents of the optional files of the bundle; the
+	// files are omitted when they are empty.
+	stacks         string
+	cpuProfile     []byte
+	jobs           string
+	ranges         string
+	version        string
+	readTimestamps string
+	randomSeeds    string
+	admission      string
+	txn            string
+	stats          string
+	statsHistory   string
+
+	// stmtErr is the error with which the statement failed, if any.
+	stmtErr error
+	// tags and name are the metadata of the diagnostics request or session that
+	// triggered the collection of the bundle.
+	tags map[string]string
+	name string
+
+	contributors      []BundleContributor
+	compressionMethod uint16
+	// overhead is the time spent instrumenting the statement until the bundle
+	// started being built.
+	overhead time.Duration
+	// omitValues is set if sql.stmt_diagnostics.redacted_columns is set; only
+	// the valueFreeFiles are kept in the bundle (see memZipper.OmitValueFiles)
+	// and the contributors aren't invoked, since the BundleInfo they receive
+	// contains the values of the statement.
+	omitValues bool
+}
+
 // buildStatementBundle collects metadata related to the planning and execution
 // of the statement. It generates a bundle for storage in
 // system.statement_diagnostics.
 func buildStatementBundle(
-	ctx context.Context,
-	db *kv.DB,
-	ie *InternalExecutor,
-	plan *planTop,
-	planString string,
-	trace tracing.Recording,
-	placeholders *tree.PlaceholderInfo,
+	ctx context.Context, db *kv.DB, ie *InternalExecutor, plan *planTop, in bundleInputs,
 ) diagnosticsBundle {
+	start := timeutil.Now()
 	if plan == nil {
 		return diagnosticsBundle{collectionErr: errors.AssertionFailedf("execution terminated early")}
 	}
-	b := makeStmtBundleBuilder(db, ie, plan, trace, placeholders)
+	trace, traceTruncated := truncateTrace(in.trace, in.maxTraceSize)
+	b := makeStmtBundleBuilder(
+		db, ie, plan, in.planString, trace, in.placeholders, in.compressionMethod,
+	)
+	if traceTruncated {
+		b.traceTruncatedAt = in.maxTraceSize
+	}
+	b.traceFilters = in.traceFilters
+	b.separateInternal = in.separateInternal
+	b.verbosity = in.verbosity
+	if b.verbosity == "" {
+		b.verbosity = stmtdiagnostics.TraceVerbosityFull
+	}
+	if in.omitValues {
+		b.z.OmitValueFiles()
+	}
 
+	b.addName(in.name)
 	b.addStatement()
+	b.addRawStatement(in.stmtRawSQL, in.fingerprint)
+	b.addPlaceholders()
 	b.addOptPlans()
-	b.addExecPlan(planString)
+	b.addOptCosts()
+	b.addExecPlan()
 	// TODO(yuzefovich): consider adding some variant of EXPLAIN (VEC) output
 	// of the query to the bundle.
 	b.addDistSQLDiagrams()
+	if err := ctx.Err(); err != nil {
+		return abandonedBundle(err)
+	}
 	traceJSON := b.addTrace()
-	b.addEnv(ctx)
+	if err := ctx.Err(); err != nil {
+		return abandonedBundle(err)
+	}
+	b.addEnv(ctx, in.readTimestamps, in.randomSeeds, in.admission)
+	if err := ctx.Err(); err != nil {
+		return abandonedBundle(err)
+	}
+	b.addRepro()
+	b.addStacks(in.stacks)
+	b.addCPUProfile(in.cpuProfile)
+	b.addJobs(in.jobs)
+	b.addRanges(in.ranges)
+	b.addVersion(in.version)
+	b.addTxn(in.txn)
+	b.addStats(in.stats)
+	b.addStatsHistory(in.statsHistory)
+	b.addError(in.stmtErr)
+	b.addMetadata(in.tags)
+	if !in.omitValues {
+		b.addContributions(ctx, in.contributors)
+	}
+	if err := ctx.Err(); err != nil {
+		return abandonedBundle(err)
+	}
+	b.addOverhead(in.overhead, timeutil.Since(start))
 
 	buf, err := b.finalize()
 	if err != nil {
@@ -173,25 +405,81 @@ func buildStatementBundle(
 	return diagnosticsBundle{traceJSON: traceJSON, zip: buf.Bytes()}
 }
 
+// errBundleAbandoned is the collection error of bundles whose building was
+// abandoned because their context was canceled, for example because the client
+// disconnected.
+var errBundleAbandoned = errors.New("statement bundle abandoned")
+
+// abandonedBundle returns the bundle resulting from abandoning the building of
+// a bundle because of the given context error. Such bundles must not be
+// inserted.
+func abandonedBundle(ctxErr error) diagnosticsBundle {
+	err := errors.Wrap(ctxErr, "statement bundle abandoned")
+	return diagnosticsBundle{collectionErr: errors.Mark(err, errBundleAbandoned)}
+}
+
+// wasAbandoned returns whether the building of the bundle was abandoned (see
+// abandonedBundle).
+func (bundle *diagnosticsBundle) wasAbandoned() bool {
+	return errors.Is(bundle.collectionErr, errBundleAbandoned)
+}
+
+// WriteTo writes the zip file of the bundle to w, which allows the bundle to be
+// streamed to a destination other than the system tables (for example an HTTP
+// response or a pipe); it can be called before or instead of insert. If the
+// bundle could not be built, the collection error is returned and nothing is
+// written. WriteTo implements io.WriterTo.
+func (bundle *diagnosticsBundle) WriteTo(w io.Writer) (int64, error) {
+	if bundle.zip == nil {
+		if bundle.collectionErr != nil {
+			return 0, bundle.collectionErr
+		}
+		return 0, errors.AssertionFailedf("bundle has not been built")
+	}
+	return bytes.NewReader(bundle.zip).WriteTo(w)
+}
+
 // insert the bundle in statements diagnostics. Sets bundle.diagID and (in error
 // cases) bundle.collectionErr.
 //
 // diagRequestID should be the ID returned by ShouldCollectDiagnostics, or zero
 // if diagnostics were triggered by EXPLAIN ANALYZE (DEBUG).
+//
+// The bundle is stored through cfg.StmtDiagnosticsRecorder, which uses the
+// internal executor and the KV client of the SQL server that executed the
+// statement. On a tenant SQL server, the keys of the system tables are encoded
+// with the tenant's codec (the same codec as ih.codec) and the KV layer rejects
+// any request outside of the tenant's keyspace, so the bundle is only visible
+// to the tenant that collected it.
+//
+// If the bundle exceeds sql.stmt_diagnostics.large_bundle_threshold, it is
+// reported to cfg.LargeBundleCallback. If cfg.TestingKnobs.BundleSink is set,
+// the bundle is also added to it.
+//
+// stmt is the SQL of the statement stored with the bundle; it is the
+// fingerprint when sql.stmt_diagnostics.redacted_columns is set.
 func (bundle *diagnosticsBundle) insert(
 	ctx context.Context,
+	cfg *ExecutorConfig,
 	fingerprint string,
-	ast tree.Statement,
-	stmtDiagRecorder *stmtdiagnostics.Registry,
+	stmt string,
 	diagRequestID stmtdiagnostics.RequestID,
 ) {
+	if cb := cfg.LargeBundleCallback; cb != nil {
+		size := int64(len(bundle.zip))
+		if threshold := largeBundleThreshold.Get(&cfg.Settings.SV); threshold > 0 && size > threshold {
+			cb(ctx, fingerprint, size)
+		}
+	}
+
 	var err error
-	bundle.diagID, err = stmtDiagRecorder.InsertStatementDiagnostics(
+	bundle.diagID, err = cfg.StmtDiagnosticsRecorder.InsertStatementDiagnostics(
 		ctx,
 		diagRequestID,
 		fingerprint,
-		tree.AsString(ast),
+		stmt,
 		bundle.traceJSON,
+		bundle.traceHash,
 		bundle.zip,
 		bundle.collectionErr,
 	)
@@ -201,6 +489,200 @@ func (bundle *diagnosticsBundle) insert(
 			bundle.collectionErr = err
 		}
 	}
+	if sink := cfg.TestingKnobs.BundleSink; sink != nil && bundle.zip != nil {
+		sink.add(TestingBundle{
+			RequestID: diagRequestID,
+			DiagID:    bundle.diagID,
+			Zip:       bundle.zip,
+		})
+	}
+}
+
+// LargeBundleCallback is invoked when a statement diagnostics bundle larger
+// than sql.stmt_diagnostics.large_bundle_threshold is collected, with the
+// fingerprint of the statement and the size of the bundle (in bytes).
+type LargeBundleCallback func(ctx context.Context, fingerprint string, size int64)
+
+// bundleStatsHistoryEnabled controls whether bundles include the statement
+// statistics of the fingerprint of the statement (see statsHistoryForBundle).
+var bundleStatsHistoryEnabled = settings.RegisterBoolSetting(
+	"sql.stmt_diagnostics.stats_history.enabled",
+	"if enabled, statement diagnostics bundles include the statement statistics collected "+
+		"on the gateway node for the fingerprint of the statement (stats-history.txt)",
+	true,
+)
+
+// largeBundleThreshold is the size above which statement diagnostics bundles
+// are reported to ExecutorConfig.LargeBundleCallback.
+var largeBundleThreshold = settings.RegisterByteSizeSetting(
+	"sql.stmt_diagnostics.large_bundle_threshold",
+	"size of a statement diagnostics bundle above which it is reported as "+
+		"unusually large; if 0, bundles are never reported",
+	16<<20, /* 16 MiB */
+)
+
+// maxConcurrentBundles limits the number of statement diagnostics bundles
+// which are built concurrently on a node (see BundleCollectionLimiter).
+var maxConcurrentBundles = settings.RegisterPositiveIntSetting(
+	"sql.stmt_diagnostics.max_concurrent_bundles",
+	"maximum number of statement diagnostics bundles built concurrently on a node",
+	4,
+)
+
+// bundleQueueTimeout is the maximum time that a statement waits for another
+// bundle to be built when sql.stmt_diagnostics.max_concurrent_bundles is
+// reached.
+var bundleQueueTimeout = settings.RegisterNonNegativeDurationSetting(
+	"sql.stmt_diagnostics.bundle_queue_timeout",
+	"maximum time a statement waits for other statement diagnostics bundles to be built "+
+		"when sql.stmt_diagnostics.max_concurrent_bundles is reached, after which its own "+
+		"bundle is not collected; if 0, the bundle is not collected without waiting",
+	time.Second,
+)
+
+// BundleCollectionLimiter limits the number of statement diagnostics bundles
+// which are built concurrently, so that many diagnostics requests matching at
+// the same time can't overload the node. A nil BundleCollectionLimiter doesn't
+// limit the collection of bundles.
+type BundleCollectionLimiter struct {
+	st      *cluster.Settings
+	limiter limit.ConcurrentRequestLimiter
+}
+
+// NewBundleCollectionLimiter creates a BundleCollectionLimiter which is
+// configured by sql.stmt_diagnostics.max_concurrent_bundles.
+func NewBundleCollectionLimiter(st *cluster.Settings) *BundleCollectionLimiter {
+	l := &BundleCollectionLimiter{
+		st: st,
+		limiter: limit.MakeConcurrentRequestLimiter(
+			"bundleCollectionLimiter", int(maxConcurrentBundles.Get(&st.SV)),
+		),
+	}
+	maxConcurrentBundles.SetOnChange(&st.SV, func() {
+		l.limiter.SetLimit(int(maxConcurrentBundles.Get(&st.SV)))
+	})
+	return l
+}
+
+// begin reserves a spot for building a bundle. If the limit is reached, it
+// waits for sql.stmt_diagnostics.bundle_queue_timeout or, if wait is set,
+// until the context is canceled. It returns an error if no spot could be
+// reserved, in which case the bundle must not be built; otherwise finish must
+// be called once the bundle is built.
+func (l *BundleCollectionLimiter) begin(ctx context.Context, wait bool) error {
+	if l == nil || l.limiter.TryBegin() {
+		return nil
+	}
+	if !wait {
+		timeout := bundleQueueTimeout.Get(&l.st.SV)
+		if timeout == 0 {
+			return errors.Newf(
+				"%d bundles are already being built", maxConcurrentBundles.Get(&l.st.SV),
+			)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := l.limiter.Begin(ctx); err != nil {
+		return errors.Wrapf(
+			err, "waiting for %d bundles to be built", maxConcurrentBundles.Get(&l.st.SV),
+		)
+	}
+	return nil
+}
+
+// finish releases the spot reserved by begin.
+func (l *BundleCollectionLimiter) finish() {
+	if l != nil {
+		l.limiter.Finish()
+	}
+}
+
+// TestingBundle is a statement diagnostics bundle recorded by a
+// TestingBundleSink.
+type TestingBundle struct {
+	// RequestID is the ID of the diagnostics request for which the bundle was
+	// collected; it is zero for bundles collected through EXPLAIN ANALYZE
+	// (DEBUG).
+	RequestID stmtdiagnostics.RequestID
+	// DiagID is the ID of the bundle in system.statement_diagnostics; it is zero
+	// if the bundle could not be inserted.
+	DiagID stmtdiagnostics.CollectedInstanceID
+	// Zip contains the raw bytes of the bundle.
+	Zip []byte
+}
+
+// TestingBundleSink is an in-memory ring buffer of the most recently collected
+// statement diagnostics bundles. It can be set through
+// ExecutorTestingKnobs.BundleSink, allowing tests to inspect bundles without
+// polling the system tables (to which bundles are written asynchronously with
+// respect to the client).
+type TestingBundleSink struct {
+	mu struct {
+		syncutil.Mutex
+		// bundles is a ring buffer; next is the position of the next bundle to
+		// be added and full is set once the buffer has wrapped around.
+		bundles []TestingBundle
+		next    int
+		full    bool
+	}
+}
+
+// NewTestingBundleSink creates a TestingBundleSink which retains the last
+// capacity bundles.
+func NewTestingBundleSink(capacity int) *TestingBundleSink {
+	if capacity <= 0 {
+		panic(errors.AssertionFailedf("invalid capacity %d", capacity))
+	}
+	s := &TestingBundleSink{}
+	s.mu.bundles = make([]TestingBundle, capacity)
+	return s
+}
+
+func (s *TestingBundleSink) add(b TestingBundle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.bundles[s.mu.next] = b
+	s.mu.next++
+	if s.mu.next == len(s.mu.bundles) {
+		s.mu.next = 0
+		s.mu.full = true
+	}
+}
+
+// Bundles returns the retained bundles, from oldest to newest.
+func (s *TestingBundleSink) Bundles() []TestingBundle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []TestingBundle
+	if s.mu.full {
+		res = append(res, s.mu.bundles[s.mu.next:]...)
+	}
+	return append(res, s.mu.bundles[:s.mu.next]...)
+}
+
+// Last returns the most recently collected bundle, if any.
+func (s *TestingBundleSink) Last() (_ TestingBundle, ok bool) {
+	bundles := s.Bundles()
+	if len(bundles) == 0 {
+		return TestingBundle{}, false
+	}
+	return bundles[len(bundles)-1], true
+}
+
+// ForRequest returns the most recently collected bundle for the given
+// diagnostics request, if any is retained.
+func (s *TestingBundleSink) ForRequest(
+	requestID stmtdiagnostics.RequestID,
+) (_ TestingBundle, ok bool) {
+	bundles := s.Bundles()
+	for i := len(bundles) - 1; i >= 0; i-- {
+		if bundles[i].RequestID == requestID {
+			return bundles[i], true
+		}
+	}
+	return TestingBundle{}, false
 }
 
 // stmtBundleBuilder is a helper for building a statement bundle.
@@ -209,9 +691,35 @@ type stmtBundleBuilder struct {
 	ie *InternalExecutor
 
 	plan         *planTop
+	planString   string
 	trace        tracing.Recording
 	placeholders *tree.PlaceholderInfo
 
+	// traceFilters, if set, restricts the trace files to the spans with an
+	// operation name that starts with one of these prefixes (see filterTrace).
+	traceFilters []string
+	// separateInternal, if set, moves the spans of the queries issued by the
+	// internal executor out of the trace files and into internal-trace.txt (see
+	// splitInternalTrace).
+	separateInternal bool
+	// verbosity is the verbosity with which the trace was recorded; the trace
+	// files are restricted accordingly (see traceWithVerbosity).
+	verbosity stmtdiagnostics.TraceVerbosity
+	// traceTruncatedAt, if set, is the size (in bytes) at which the trace was
+	// truncated (see truncateTrace).
+	traceTruncatedAt int64
+
+	// repro contains the parts of repro.sql that are collected by addEnv.
+	repro struct {
+		// database is the current database of the session.
+		database string
+		// databases contains the (quoted) names of the databases of the data
+		// sources used by the statement.
+		databases []string
+		env       string
+		schema    string
+	}
+
 	z memZipper
 }
 
@@ -219,14 +727,27 @@ func makeStmtBundleBuilder(
 	db *kv.DB,
 	ie *InternalExecutor,
 	plan *planTop,
+	planString string,
 	trace tracing.Recording,
 	placeholders *tree.PlaceholderInfo,
+	compressionMethod uint16,
 ) stmtBundleBuilder {
-	b := stmtBundleBuilder{db: db, ie: ie, plan: plan, trace: trace, placeholders: placeholders}
-	b.z.Init()
+	b := stmtBundleBuilder{
+		db: db, ie: ie, plan: plan, planString: planString, trace: trace, placeholders: placeholders,
+	}
+	b.z.Init(compressionMethod)
 	return b
 }
 
+// addName adds the name of the bundle as file name.txt, if the bundle has a
+// name.
+func (b *stmtBundleBuilder) addName(name string) {
+	if name == "" {
+		return
+	}
+	b.z.AddFile("name.txt", name)
+}
+
 // addStatement adds the pretty-printed statement as file statement.txt.
 func (b *stmtBundleBuilder) addStatement() {
 	cfg := tree.DefaultPrettyCfg()
@@ -260,6 +781,50 @@ func (b *stmtBundleBuilder) addStatement() {
 	b.z.AddFile("statement.txt", output)
 }
 
+// addRawStatement adds the statement as it was received from the client
+// (including any comments, which can be used to correlate the statement with
+// the application request that issued it) as file statement.sql, preceded by
+// the statement fingerprint.
+func (b *stmtBundleBuilder) addRawStatement(stmtRawSQL string, fingerprint string) {
+	if stmtRawSQL == "" {
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString("-- Statement fingerprint:\n")
+	for _, line := range strings.Split(fingerprint, "\n") {
+		fmt.Fprintf(&buf, "--   %s\n", line)
+	}
+	buf.WriteString("\n")
+	buf.WriteString(stmtRawSQL)
+	buf.WriteString("\n")
+	b.z.AddFile("statement.sql", buf.String())
+}
+
+// addPlaceholders adds the placeholder values bound to the statement, along
+// with their types, as file placeholders.txt. The values are formatted as SQL
+// literals so that the statement can be replayed with the same arguments.
+func (b *stmtBundleBuilder) addPlaceholders() {
+	if b.placeholders == nil || len(b.placeholders.Values) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	for i, v := range b.placeholders.Values {
+		idx := tree.PlaceholderIdx(i)
+		if v == nil {
+			fmt.Fprintf(&buf, "%s: NULL\n", idx)
+			continue
+		}
+		typ := v.ResolvedType()
+		if i < len(b.placeholders.Types) && b.placeholders.Types[i] != nil {
+			typ = b.placeholders.Types[i]
+		}
+		fmt.Fprintf(
+			&buf, "%s: %s -- %s\n", idx, tree.AsStringWithFlags(v, tree.FmtParsable), typ.SQLString(),
+		)
+	}
+	b.z.AddFile("placeholders.txt", buf.String())
+}
+
 // addOptPlans adds the EXPLAIN (OPT) variants as files opt.txt, opt-v.txt,
 // opt-vv.txt.
 func (b *stmtBundleBuilder) addOptPlans() {
@@ -275,39 +840,208 @@ func (b *stmtBundleBuilder) addOptPlans() {
 	b.z.AddFile("opt-vv.txt", b.plan.formatOptPlan(memo.ExprFmtHideQualifications))
 }
 
+// addOptCosts adds the estimated cost of each join in the optimizer plan, along
+// with the alternatives for the join that were explored by the optimizer, as
+// file opt-costs.txt. The file is only added if the plan contains joins.
+func (b *stmtBundleBuilder) addOptCosts() {
+	if b.plan.mem == nil {
+		// No optimizer plans; an error must have occurred during planning.
+		return
+	}
+	if costs := formatJoinCosts(b.plan.mem); costs != "" {
+		b.z.AddFile("opt-costs.txt", costs)
+	}
+}
+
+// formatJoinCosts returns a description of the estimated cost and row count of
+// each join in the optimized plan and of its inputs, in the order in which the
+// joins appear in the plan (nested joins are indented). For each join, the
+// operators of the expressions in its memo group (i.e. the alternatives
+// explored by the optimizer) are listed too; the memo only retains the costs of
+// the expressions in the final plan. It returns the empty string if the plan
+// has no joins.
+func formatJoinCosts(mem *memo.Memo) string {
+	md := mem.Metadata()
+	// describe returns the operator of a relational expression, followed by the
+	// aliases of the tables it reads from.
+	describe := func(e memo.RelExpr) string {
+		var tables []string
+		var collect func(e opt.Expr)
+		collect = func(e opt.Expr) {
+			switch t := e.(type) {
+			case *memo.ScanExpr:
+				tables = append(tables, string(md.TableMeta(t.Table).Alias.ObjectName))
+			case *memo.LookupJoinExpr:
+				tables = append(tables, string(md.TableMeta(t.Table).Alias.ObjectName))
+			case *memo.InvertedJoinExpr:
+				tables = append(tables, string(md.TableMeta(t.Table).Alias.ObjectName))
+			case *memo.ZigzagJoinExpr:
+				tables = append(tables, string(md.TableMeta(t.LeftTable).Alias.ObjectName))
+			}
+			for i, n := 0, e.ChildCount(); i < n; i++ {
+				if c, ok := e.Child(i).(memo.RelExpr); ok {
+					collect(c)
+				}
+			}
+		}
+		collect(e)
+		if len(tables) == 0 {
+			return e.Op().String()
+		}
+		return fmt.Sprintf("%s [%s]", e.Op(), strings.Join(tables, ", "))
+	}
+
+	var buf bytes.Buffer
+	var walk func(e opt.Expr, depth int)
+	walk = func(e opt.Expr, depth int) {
+		rel, ok := e.(memo.RelExpr)
+		if !ok {
+			return
+		}
+		if isJoinExpr(rel) {
+			indent := strings.Repeat("  ", depth)
+			fmt.Fprintf(&buf, "%s%s\n", indent, describe(rel))
+			fmt.Fprintf(
+				&buf, "%s  cost: %.2f, rows: %.2f\n", indent, rel.Cost(), rel.Relational().Stats.RowCount,
+			)
+			for i, n := 0, rel.ChildCount(); i < n; i++ {
+				if input, ok := rel.Child(i).(memo.RelExpr); ok {
+					fmt.Fprintf(
+						&buf, "%s  input: %s, cost: %.2f, rows: %.2f\n",
+						indent, describe(input), input.Cost(), input.Relational().Stats.RowCount,
+					)
+				}
+			}
+			var ops []string
+			counts := make(map[string]int)
+			for alt := rel.FirstExpr(); alt != nil; alt = alt.NextExpr() {
+				op := alt.Op().String()
+				if counts[op] == 0 {
+					ops = append(ops, op)
+				}
+				counts[op]++
+			}
+			fmt.Fprintf(&buf, "%s  alternatives considered:", indent)
+			for i, op := range ops {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				fmt.Fprintf(&buf, " %s (%d)", op, counts[op])
+			}
+			buf.WriteString("\n\n")
+			depth++
+		}
+		for i, n := 0, rel.ChildCount(); i < n; i++ {
+			walk(rel.Child(i), depth)
+		}
+	}
+	walk(mem.RootExpr(), 0 /* depth */)
+	if buf.Len() == 0 {
+		return ""
+	}
+	root := mem.RootExpr().(memo.RelExpr)
+	return fmt.Sprintf("total cost: %.2f\n\n%s", root.Cost(), buf.String())
+}
+
+// isJoinExpr returns whether the given expression joins its inputs, including
+// the lookup, inverted, merge and zigzag joins built during exploration.
+func isJoinExpr(e memo.RelExpr) bool {
+	switch e.Op() {
+	case opt.LookupJoinOp, opt.InvertedJoinOp, opt.MergeJoinOp, opt.ZigzagJoinOp:
+		return true
+	}
+	return opt.IsJoinOp(e)
+}
+
 // addExecPlan adds the EXPLAIN (VERBOSE) plan as file plan.txt.
-func (b *stmtBundleBuilder) addExecPlan(plan string) {
-	if plan != "" {
-		b.z.AddFile("plan.txt", plan)
+func (b *stmtBundleBuilder) addExecPlan() {
+	if b.planString != "" {
+		b.z.AddFile("plan.txt", b.planString)
 	}
 }
 
+// addDistSQLDiagrams adds the physical plan diagram of each flow to the
+// bundle, both as the JSON consumed by the DistSQL visualizer and as an html
+// file which redirects to the visualizer. It also adds distsql.txt and vec.txt
+// with the EXPLAIN (DISTSQL) and EXPLAIN (VEC) output of all the flows; both are
+// generated from the flows which were executed.
 func (b *stmtBundleBuilder) addDistSQLDiagrams() {
+	if len(b.plan.distSQLFlowInfos) == 0 {
+		return
+	}
+	var distSQLText, vecText bytes.Buffer
 	for i, d := range b.plan.distSQLFlowInfos {
 		d.diagram.AddSpans(b.trace)
-		_, url, err := d.diagram.ToURL()
-
-		var contents string
+		diagramJSON, diagramURL, err := d.diagram.ToURL()
+
+		name := "distsql"
+		if len(b.plan.distSQLFlowInfos) > 1 {
+			name = fmt.Sprintf("distsql-%d-%s", i+1, d.typ)
+			if i > 0 {
+				distSQLText.WriteByte('\n')
+				vecText.WriteByte('\n')
+			}
+			fmt.Fprintf(&distSQLText, "%d-%s:\n", i+1, d.typ)
+			fmt.Fprintf(&vecText, "%d-%s:\n", i+1, d.typ)
+		}
 		if err != nil {
-			contents = err.Error()
+			b.z.AddFile(name+".json", err.Error())
+			b.z.AddFile(name+".html", err.Error())
+			fmt.Fprintf(&distSQLText, "%s\n", err)
 		} else {
-			contents = fmt.Sprintf(`<meta http-equiv="Refresh" content="0; url=%s">`, url.String())
+			b.z.AddFile(name+".json", diagramJSON)
+			b.z.AddFile(name+".html", distSQLDiagramLink(diagramURL))
+			fmt.Fprintf(&distSQLText, "%s\n", diagramURL.String())
 		}
-
-		var filename string
-		if len(b.plan.distSQLFlowInfos) == 1 {
-			filename = "distsql.html"
-		} else {
-			filename = fmt.Sprintf("distsql-%d-%s.html", i+1, d.typ)
+		for _, line := range d.explainVec {
+			fmt.Fprintf(&vecText, "%s\n", line)
 		}
-		b.z.AddFile(filename, contents)
 	}
+	b.z.AddFile("distsql.txt", distSQLText.String())
+	b.z.AddFile("vec.txt", vecText.String())
+}
+
+// distSQLDiagramLink returns the contents of an html file which redirects to
+// the given DistSQL visualizer URL.
+func distSQLDiagramLink(diagramURL url.URL) string {
+	return fmt.Sprintf(`<meta http-equiv="Refresh" content="0; url=%s">`, diagramURL.String())
 }
 
 // addTrace adds two files to the bundle: one is a json representation of the
-// trace, the other one is a human-readable representation.
+// trace, the other one is a human-readable representation. Nothing is added
+// for bundles collected with stmtdiagnostics.TraceVerbosityPlanOnly, which
+// don't have a trace.
 func (b *stmtBundleBuilder) addTrace() tree.Datum {
-	traceJSON, traceJSONStr, err := traceToJSON(b.trace)
+	if b.verbosity == stmtdiagnostics.TraceVerbosityPlanOnly {
+		return tree.DNull
+	}
+	if b.z.omitValueFiles {
+		// The trace can contain the values of redacted columns; don't store it,
+		// not even on its own.
+		b.z.AddFile("trace.txt", omittedValuesNote)
+		return tree.DNull
+	}
+	trace := traceWithVerbosity(b.trace, b.verbosity)
+	if len(b.traceFilters) > 0 {
+		// Keep the full trace in a separate file.
+		b.z.AddFile("trace-unfiltered.txt", trace.String())
+		trace = filterTrace(trace, b.traceFilters)
+	}
+	if b.separateInternal {
+		var internal []tracing.Recording
+		trace, internal = splitInternalTrace(trace)
+		if len(internal) > 0 {
+			var buf bytes.Buffer
+			for _, r := range internal {
+				fmt.Fprintf(&buf, "internal query: %s\n\n", r[0].Tags[internalExecutorSpanTag])
+				buf.WriteString(r.String())
+				buf.WriteString("\n\n")
+			}
+			b.z.AddFile("internal-trace.txt", buf.String())
+		}
+	}
+
+	traceJSON, traceJSONStr, err := traceToJSON(trace)
 	if err != nil {
 		b.z.AddFile("trace.json", err.Error())
 	} else {
@@ -324,11 +1058,17 @@ func (b *stmtBundleBuilder) addTrace() tree.Datum {
 	stmt := cfg.Pretty(b.plan.stmt.AST)
 
 	// The JSON is not very human-readable, so we include another format too.
-	b.z.AddFile("trace.txt", fmt.Sprintf("%s\n\n\n\n%s", stmt, b.trace.String()))
+	var truncated string
+	if b.traceTruncatedAt > 0 {
+		truncated = fmt.Sprintf("\n-- TRACE TRUNCATED at %d bytes", b.traceTruncatedAt)
+	}
+	b.z.AddFile("trace.txt", fmt.Sprintf(
+		"%s\n\n-- trace verbosity: %s%s\n\n\n\n%s", stmt, b.verbosity, truncated, trace.String(),
+	))
 
 	// Note that we're going to include the non-anonymized statement in the trace.
 	// But then again, nothing in the trace is anonymized.
-	jaegerJSON, err := b.trace.ToJaegerJSON(stmt)
+	jaegerJSON, err := trace.ToJaegerJSON(stmt)
 	if err != nil {
 		b.z.AddFile("trace-jaeger.txt", err.Error())
 	} else {
@@ -338,7 +1078,202 @@ func (b *stmtBundleBuilder) addTrace() tree.Datum {
 	return traceJSON
 }
 
-func (b *stmtBundleBuilder) addEnv(ctx context.Context) {
+// truncateTrace returns the longest prefix of the trace whose spans take at
+// most maxSize bytes (as measured by their encoded size), and whether any spans
+// were omitted. The root span is always kept, so that the truncated trace is
+// still a valid recording. If maxSize is 0, the trace is returned unchanged.
+func truncateTrace(trace tracing.Recording, maxSize int64) (_ tracing.Recording, truncated bool) {
+	if maxSize <= 0 || len(trace) == 0 {
+		return trace, false
+	}
+	size := int64(trace[0].Size())
+	for i := 1; i < len(trace); i++ {
+		size += int64(trace[i].Size())
+		if size > maxSize {
+			return trace[:i], true
+		}
+	}
+	return trace, false
+}
+
+// traceStructuralHash returns a hash of the shape of the trace: the operation
+// names of the spans and how they are nested. Timings, tags and log messages
+// don't affect the hash, so executions of a statement that do the same work
+// produce the same hash. The children of each span are ordered by their
+// operation names, since the order in which concurrent spans are recorded is
+// not deterministic. Returns an empty string if the trace is empty.
+func traceStructuralHash(trace tracing.Recording) string {
+	if len(trace) == 0 {
+		return ""
+	}
+	children := make(map[uint64][]int, len(trace))
+	for i := 1; i < len(trace); i++ {
+		children[trace[i].ParentSpanID] = append(children[trace[i].ParentSpanID], i)
+	}
+	var shape func(i int) string
+	shape = func(i int) string {
+		kids := make([]string, len(children[trace[i].SpanID]))
+		for j, c := range children[trace[i].SpanID] {
+			kids[j] = shape(c)
+		}
+		sort.Strings(kids)
+		return fmt.Sprintf("%q(%s)", trace[i].Operation, strings.Join(kids, ","))
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(shape(0)))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// kvSpanOperations are the operation names of the spans created by the KV
+// client for KV requests; these spans and their descendants are excluded from
+// traces recorded with stmtdiagnostics.TraceVerbositySQL.
+var kvSpanOperations = []string{kvcoord.OpTxnCoordSender, "dist sender send"}
+
+// traceWithVerbosity returns the part of the trace that corresponds to the
+// given verbosity:
+//  - TraceVerbosityFull: the entire trace.
+//  - TraceVerbositySQL: the trace without the spans of KV requests.
+//  - TraceVerbositySummary: the spans of the trace without their log messages.
+func traceWithVerbosity(
+	trace tracing.Recording, verbosity stmtdiagnostics.TraceVerbosity,
+) tracing.Recording {
+	switch verbosity {
+	case stmtdiagnostics.TraceVerbositySQL:
+		ops := make(map[uint64]string, len(trace))
+		parents := make(map[uint64]uint64, len(trace))
+		for i := range trace {
+			ops[trace[i].SpanID] = trace[i].Operation
+			parents[trace[i].SpanID] = trace[i].ParentSpanID
+		}
+		isKV := func(id uint64) bool {
+			for ; id != 0; id = parents[id] {
+				for _, op := range kvSpanOperations {
+					if ops[id] == op {
+						return true
+					}
+				}
+			}
+			return false
+		}
+		res := make(tracing.Recording, 0, len(trace))
+		for i := range trace {
+			// The root span is always kept.
+			if i == 0 || !isKV(trace[i].SpanID) {
+				res = append(res, trace[i])
+			}
+		}
+		return res
+
+	case stmtdiagnostics.TraceVerbositySummary:
+		res := make(tracing.Recording, len(trace))
+		for i := range trace {
+			res[i] = trace[i]
+			res[i].Logs = nil
+		}
+		return res
+
+	default:
+		return trace
+	}
+}
+
+// filterTrace returns the spans of the trace with an operation name that starts
+// with one of the given prefixes, along with the root span. Each returned span
+// is reparented to its closest ancestor that is also returned, so the result is
+// still a single tree.
+func filterTrace(trace tracing.Recording, prefixes []string) tracing.Recording {
+	if len(trace) == 0 {
+		return trace
+	}
+	parents := make(map[uint64]uint64, len(trace))
+	kept := make(map[uint64]bool)
+	for i := range trace {
+		parents[trace[i].SpanID] = trace[i].ParentSpanID
+		if i == 0 {
+			kept[trace[i].SpanID] = true
+			continue
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(trace[i].Operation, prefix) {
+				kept[trace[i].SpanID] = true
+				break
+			}
+		}
+	}
+	res := make(tracing.Recording, 0, len(kept))
+	res = append(res, trace[0])
+	for i := 1; i < len(trace); i++ {
+		if !kept[trace[i].SpanID] {
+			continue
+		}
+		s := trace[i]
+		for s.ParentSpanID != 0 && !kept[s.ParentSpanID] {
+			s.ParentSpanID = parents[s.ParentSpanID]
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+// splitInternalTrace separates the spans of the queries issued by the internal
+// executor (which are tagged with internalExecutorSpanTag), along with all their
+// descendants, from the rest of the trace. It returns the rest of the trace and
+// one recording for each internal query, with the query's span first.
+func splitInternalTrace(trace tracing.Recording) (tracing.Recording, []tracing.Recording) {
+	if len(trace) == 0 {
+		return trace, nil
+	}
+	// The spans in a recording don't necessarily come after their parents, so
+	// we resolve the internal query of each span by walking up the tree.
+	parents := make(map[uint64]uint64, len(trace))
+	roots := make(map[uint64]int)
+	for i := range trace {
+		parents[trace[i].SpanID] = trace[i].ParentSpanID
+		if _, ok := trace[i].Tags[internalExecutorSpanTag]; ok && i != 0 {
+			roots[trace[i].SpanID] = -1
+		}
+	}
+	if len(roots) == 0 {
+		return trace, nil
+	}
+	// internalRoot returns the outermost internal query span among the given
+	// span and its ancestors; the spans of internal queries issued by other
+	// internal queries are grouped with the outer query.
+	internalRoot := func(id uint64) (root uint64, ok bool) {
+		// The root span of the trace is never considered internal.
+		for ; id != 0 && id != trace[0].SpanID; id = parents[id] {
+			if _, isRoot := roots[id]; isRoot {
+				root, ok = id, true
+			}
+		}
+		return root, ok
+	}
+	res := make(tracing.Recording, 0, len(trace))
+	var internal []tracing.Recording
+	for i := range trace {
+		root, ok := internalRoot(trace[i].SpanID)
+		if !ok {
+			res = append(res, trace[i])
+			continue
+		}
+		if roots[root] == -1 {
+			roots[root] = len(internal)
+			internal = append(internal, tracing.Recording{})
+		}
+		r := &internal[roots[root]]
+		if trace[i].SpanID == root {
+			// Keep the span of the internal query first.
+			*r = append(tracing.Recording{trace[i]}, *r...)
+		} else {
+			*r = append(*r, trace[i])
+		}
+	}
+	return res, internal
+}
+
+func (b *stmtBundleBuilder) addEnv(
+	ctx context.Context, readTimestamps, randomSeeds, admission string,
+) {
 	c := makeStmtEnvCollector(ctx, b.ie)
 
 	var buf bytes.Buffer
@@ -347,12 +1282,37 @@ func (b *stmtBundleBuilder) addEnv(ctx context.Context) {
 	}
 	fmt.Fprintf(&buf, "\n")
 
+	// The timestamps at which the statement read are needed to reproduce the
+	// results (and plans) of historical queries.
+	if readTimestamps != "" {
+		buf.WriteString(readTimestamps)
+		fmt.Fprintf(&buf, "\n")
+	}
+
+	// The random seeds used by the statement are needed to reproduce its
+	// results; they are applied when env.sql is replayed.
+	if randomSeeds != "" {
+		buf.WriteString(randomSeeds)
+		fmt.Fprintf(&buf, "\n")
+	}
+
+	// The admission priority of the statement helps explaining latency caused
+	// by overload.
+	if admission != "" {
+		buf.WriteString(admission)
+		fmt.Fprintf(&buf, "\n")
+	}
+
 	// Show the values of any non-default session variables that can impact
 	// planning decisions.
 	if err := c.PrintSettings(&buf); err != nil {
 		fmt.Fprintf(&buf, "-- error getting settings: %v\n", err)
 	}
 	b.z.AddFile("env.sql", buf.String())
+	b.repro.env = buf.String()
+	if database, err := c.query("SHOW database"); err == nil {
+		b.repro.database = database
+	}
 
 	mem := b.plan.mem
 	if mem == nil {
@@ -406,6 +1366,24 @@ func (b *stmtBundleBuilder) addEnv(ctx context.Context) {
 		}
 	}
 	b.z.AddFile("schema.sql", buf.String())
+	b.repro.schema = buf.String()
+	addDatabase := func(tn *tree.TableName) {
+		if !tn.ExplicitCatalog {
+			return
+		}
+		name := tn.CatalogName.String()
+		for _, db := range b.repro.databases {
+			if db == name {
+				return
+			}
+		}
+		b.repro.databases = append(b.repro.databases, name)
+	}
+	for _, names := range [][]tree.TableName{sequences, tables, views} {
+		for i := range names {
+			addDatabase(&names[i])
+		}
+	}
 	for i := range tables {
 		buf.Reset()
 		if err := c.PrintTableStats(&buf, &tables[i], false /* hideHistograms */); err != nil {
@@ -415,30 +1393,330 @@ func (b *stmtBundleBuilder) addEnv(ctx context.Context) {
 	}
 }
 
+// addRepro adds a script that reproduces the statement as file repro.sql. The
+// script creates the schema (see schema.sql), applies the session settings
+// (see env.sql) and executes the statement with the placeholder values (see
+// placeholders.txt), so that it can be piped into a fresh SQL session.
+func (b *stmtBundleBuilder) addRepro() {
+	if b.plan.stmt == nil || b.plan.stmt.AST == nil {
+		b.z.AddFile("repro.sql", "-- No statement.\n")
+		return
+	}
+	stmt := b.plan.stmt.AST
+	if e, ok := stmt.(*tree.ExplainAnalyze); ok && e.Mode == tree.ExplainDebug {
+		// Reproduce the statement that was explained rather than collecting
+		// another bundle.
+		stmt = e.Statement
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("-- Reproduction of the statement; it can be run in a fresh cluster with:\n")
+	buf.WriteString("--   cockroach sql < repro.sql\n\n")
+	for _, db := range b.repro.databases {
+		fmt.Fprintf(&buf, "CREATE DATABASE IF NOT EXISTS %s;\n", db)
+	}
+	if len(b.repro.databases) > 0 {
+		buf.WriteString("\n")
+	}
+	if b.repro.schema != "" {
+		buf.WriteString(b.repro.schema)
+		buf.WriteString("\n")
+	}
+	if b.repro.database != "" {
+		fmt.Fprintf(&buf, "SET database = %s;\n\n", tree.NameString(b.repro.database))
+	}
+	if b.repro.env != "" {
+		buf.WriteString(b.repro.env)
+		buf.WriteString("\n")
+	}
+
+	if b.placeholders == nil || len(b.placeholders.Values) == 0 {
+		fmt.Fprintf(&buf, "%s;\n", tree.AsString(stmt))
+	} else {
+		// The placeholder types are only specified if they are all known;
+		// otherwise they are inferred from the statement.
+		typs := make([]string, 0, len(b.placeholders.Values))
+		values := make([]string, len(b.placeholders.Values))
+		for i, v := range b.placeholders.Values {
+			var typ *types.T
+			if i < len(b.placeholders.Types) && b.placeholders.Types[i] != nil {
+				typ = b.placeholders.Types[i]
+			} else if v != nil {
+				typ = v.ResolvedType()
+			}
+			if typ != nil && typs != nil {
+				typs = append(typs, typ.SQLString())
+			} else {
+				typs = nil
+			}
+			values[i] = "NULL"
+			if v != nil {
+				values[i] = tree.AsStringWithFlags(v, tree.FmtParsable)
+			}
+		}
+		typesStr := ""
+		if len(typs) > 0 {
+			typesStr = fmt.Sprintf(" (%s)", strings.Join(typs, ", "))
+		}
+		fmt.Fprintf(&buf, "PREPARE repro%s AS %s;\n", typesStr, tree.AsString(stmt))
+		fmt.Fprintf(&buf, "EXECUTE repro (%s);\n", strings.Join(values, ", "))
+	}
+	b.z.AddFile("repro.sql", buf.String())
+}
+
+// addContributions adds the files provided by any registered
+// BundleContributors. Files are added in file name order for each contributor.
+func (b *stmtBundleBuilder) addContributions(
+	ctx context.Context, contributors []BundleContributor,
+) {
+	if len(contributors) == 0 {
+		return
+	}
+	info := &BundleInfo{
+		Plan:         b.planString,
+		Trace:        b.trace,
+		Placeholders: b.placeholders,
+	}
+	if b.plan.stmt != nil {
+		info.Statement = b.plan.stmt.AST
+	}
+	for _, c := range contributors {
+		files, err := c.BundleFiles(ctx, info)
+		if err != nil {
+			log.Warningf(ctx, "error collecting bundle files from %T: %v", c, err)
+			continue
+		}
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			b.z.AddFile(name, files[name])
+		}
+	}
+}
+
+// addStacks adds the goroutine stacks captured when the statement was canceled
+// as file stacks.txt, if there are any.
+func (b *stmtBundleBuilder) addStacks(stacks string) {
+	if stacks == "" {
+		return
+	}
+	b.z.AddFile("stacks.txt", stacks)
+}
+
+// addCPUProfile adds the CPU profile recorded during the execution of the
+// statement as file cpu.pprof, if there is one.
+func (b *stmtBundleBuilder) addCPUProfile(cpuProfile []byte) {
+	if len(cpuProfile) == 0 {
+		return
+	}
+	b.z.AddFile("cpu.pprof", string(cpuProfile))
+}
+
+// addJobs adds the description of the jobs created by the statement as file
+// job.txt, if there are any.
+func (b *stmtBundleBuilder) addJobs(jobs string) {
+	if jobs == "" {
+		return
+	}
+	b.z.AddFile("job.txt", jobs)
+}
+
+// addRanges adds the description of the ranges scanned by the statement, along
+// with their leaseholders, as file ranges.txt, if there are any.
+func (b *stmtBundleBuilder) addRanges(ranges string) {
+	if ranges == "" {
+		return
+	}
+	b.z.AddFile("ranges.txt", ranges)
+}
+
+// addVersion adds the cluster version and build information as file
+// version.txt.
+func (b *stmtBundleBuilder) addVersion(version string) {
+	if version == "" {
+		return
+	}
+	b.z.AddFile("version.txt", version)
+}
+
+// addTxn adds the description of the transaction of the statement and of the
+// timestamps used by its flows (see txnForBundle) as file txn.txt.
+func (b *stmtBundleBuilder) addTxn(txn string) {
+	if txn == "" {
+		return
+	}
+	b.z.AddFile("txn.txt", txn)
+}
+
+// addOverhead adds the time spent instrumenting the statement as file
+// overhead.txt. instrumentation is the time spent setting up and finishing the
+// instrumentation before the bundle was built, and bundle is the time spent
+// building the bundle.
+func (b *stmtBundleBuilder) addOverhead(instrumentation, bundle time.Duration) {
+	b.z.AddFile("overhead.txt", fmt.Sprintf(
+		"instrumentation: %s\nbundle building: %s\ntotal: %s\n",
+		instrumentation, bundle, instrumentation+bundle,
+	))
+}
+
+// addStats adds the description of the table statistics used to plan the
+// statement (see statsForBundle) as file stats.txt, if there are any.
+func (b *stmtBundleBuilder) addStats(stats string) {
+	if stats == "" {
+		return
+	}
+	b.z.AddFile("stats.txt", stats)
+}
+
+// addStatsHistory adds the statement statistics of the fingerprint of the
+// statement as file stats-history.txt.
+func (b *stmtBundleBuilder) addStatsHistory(statsHistory string) {
+	if statsHistory == "" {
+		return
+	}
+	b.z.AddFile("stats-history.txt", statsHistory)
+}
+
+// addError adds error.txt, which describes the error returned by the statement
+// (if any): its pgwire error code, message, detail and hint, followed by the
+// entire error chain.
+func (b *stmtBundleBuilder) addError(stmtErr error) {
+	if stmtErr == nil {
+		return
+	}
+	pgErr := pgerror.Flatten(stmtErr)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "code: %s\n", pgErr.Code)
+	fmt.Fprintf(&buf, "message: %s\n", pgErr.Message)
+	if pgErr.Detail != "" {
+		fmt.Fprintf(&buf, "detail: %s\n", pgErr.Detail)
+	}
+	if pgErr.Hint != "" {
+		fmt.Fprintf(&buf, "hint: %s\n", pgErr.Hint)
+	}
+	fmt.Fprintf(&buf, "\nerror chain:\n%+v\n", stmtErr)
+	b.z.AddFile("error.txt", buf.String())
+}
+
+// bundleMetadata is the contents of the metadata.json file of a bundle.
+type bundleMetadata struct {
+	// Tags are the tags attached to the diagnostics request.
+	Tags map[string]string `json:"tags"`
+}
+
+// addMetadata adds metadata.json, which contains the tags attached to the
+// diagnostics request, if there are any.
+func (b *stmtBundleBuilder) addMetadata(tags map[string]string) {
+	if len(tags) == 0 {
+		return
+	}
+	buf, err := json.MarshalIndent(bundleMetadata{Tags: tags}, "", "  ")
+	if err != nil {
+		b.z.AddFile("metadata.json", fmt.Sprintf("-- error encoding metadata: %v\n", err))
+		return
+	}
+	b.z.AddFile("metadata.json", string(buf))
+}
+
+// goroutineStacksWithLabel returns the stacks of the goroutines that have the
+// given pprof label, in the format of the debug=1 goroutine profile.
+func goroutineStacksWithLabel(key, value string) string {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1 /* debug */); err != nil {
+		return fmt.Sprintf("error capturing goroutine stacks: %v", err)
+	}
+	return filterGoroutineProfile(buf.String(), key, value)
+}
+
+// filterGoroutineProfile filters a debug=1 goroutine profile (where goroutines
+// with the same stack and labels are grouped in blank-line separated entries)
+// down to the entries of goroutines with the given label.
+func filterGoroutineProfile(profile string, key, value string) string {
+	label := fmt.Sprintf("%q:%q", key, value)
+	var buf strings.Builder
+	for _, entry := range strings.Split(profile, "\n\n") {
+		for _, line := range strings.Split(entry, "\n") {
+			if strings.HasPrefix(line, "# labels: ") && strings.Contains(line, label) {
+				buf.WriteString(entry)
+				buf.WriteString("\n\n")
+				break
+			}
+		}
+	}
+	return buf.String()
+}
+
 // finalize generates the zipped bundle and returns it as a buffer.
 func (b *stmtBundleBuilder) finalize() (*bytes.Buffer, error) {
 	return b.z.Finalize()
 }
 
-// memZipper builds a zip file into an in-memory buffer.
+// memZipper builds a zip file into an in-memory buffer. A manifest.txt file
+// listing the uncompressed size of every other file is added when the zip file
+// is finalized.
 type memZipper struct {
-	buf *bytes.Buffer
-	z   *zip.Writer
-	err error
+	buf    *bytes.Buffer
+	z      *zip.Writer
+	method uint16
+	err    error
+
+	// omitValueFiles is set by OmitValueFiles.
+	omitValueFiles bool
+
+	manifest bytes.Buffer
 }
 
-func (z *memZipper) Init() {
+// Init prepares the zipper; method is the zip compression method used for all
+// files (zip.Deflate or zipMethodZstd).
+func (z *memZipper) Init(method uint16) {
 	z.buf = &bytes.Buffer{}
 	z.z = zip.NewWriter(z.buf)
+	z.method = method
+	if method == zipMethodZstd {
+		z.z.RegisterCompressor(zipMethodZstd, func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w), nil
+		})
+	}
+}
+
+// OpenBundle returns a zip.Reader for the statement diagnostics bundle of the
+// given size read from r. The files of the bundle can be read regardless of the
+// sql.stmt_diagnostics.bundle_compression setting they were written with.
+func OpenBundle(r io.ReaderAt, size int64) (*zip.Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	zr.RegisterDecompressor(zipMethodZstd, func(r io.Reader) io.ReadCloser {
+		return zstd.NewReader(r)
+	})
+	return zr, nil
+}
+
+// OmitValueFiles causes the contents of the files added afterwards which aren't
+// valueFreeFiles to be replaced with omittedValuesNote.
+func (z *memZipper) OmitValueFiles() {
+	z.omitValueFiles = true
 }
 
 func (z *memZipper) AddFile(name string, contents string) {
+	if z.omitValueFiles && !valueFreeFiles[name] {
+		contents = omittedValuesNote
+	}
+	z.addFile(name, contents)
+	fmt.Fprintf(&z.manifest, "%s\t%d\n", name, len(contents))
+}
+
+func (z *memZipper) addFile(name string, contents string) {
 	if z.err != nil {
 		return
 	}
 	w, err := z.z.CreateHeader(&zip.FileHeader{
 		Name:     name,
-		Method:   zip.Deflate,
+		Method:   z.method,
 		Modified: timeutil.Now(),
 	})
 	if err != nil {
@@ -449,6 +1727,7 @@ func (z *memZipper) AddFile(name string, contents string) {
 }
 
 func (z *memZipper) Finalize() (*bytes.Buffer, error) {
+	z.addFile("manifest.txt", z.manifest.String())
 	if z.err != nil {
 		return nil, z.err
 	}
@@ -13,22 +13,35 @@ package sql
 import (
 	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
+	gosql "database/sql"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"regexp"
+	"runtime/pprof"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/stmtdiagnostics"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
 	"github.com/cockroachdb/cockroach/pkg/util/httputil"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing/tracingpb"
 	"github.com/cockroachdb/errors"
 	"github.com/lib/pq"
 )
@@ -43,8 +56,11 @@ func TestExplainAnalyzeDebug(t *testing.T) {
 	r := sqlutils.MakeSQLRunner(godb)
 	r.Exec(t, "CREATE TABLE abc (a INT PRIMARY KEY, b INT, c INT UNIQUE)")
 
-	base := "statement.txt trace.json trace.txt trace-jaeger.json env.sql"
-	plans := "schema.sql opt.txt opt-v.txt opt-vv.txt plan.txt"
+	base := "statement.txt statement.sql trace.json trace.txt trace-jaeger.json env.sql version.txt " +
+		"txn.txt overhead.txt repro.sql manifest.txt"
+	// The statement statistics are only recorded for statements which were
+	// planned successfully.
+	plans := "schema.sql opt.txt opt-v.txt opt-vv.txt plan.txt stats.txt stats-history.txt"
 
 	// Set a small chunk size to test splitting into chunks. The bundle files are
 	// on the order of 10KB.
@@ -57,16 +73,66 @@ func TestExplainAnalyzeDebug(t *testing.T) {
 		rows := r.QueryStr(t, "EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc WHERE c=1")
 		checkBundle(
 			t, fmt.Sprint(rows),
-			base, plans, "stats-defaultdb.public.abc.sql", "distsql.html",
+			base, plans, "stats-defaultdb.public.abc.sql", "ranges.txt",
+			"distsql.json distsql.html distsql.txt vec.txt",
 		)
 	})
 
+	// Check that the overhead of instrumenting the statement is recorded.
+	t.Run("overhead", func(t *testing.T) {
+		overhead := srv.SQLServer().(*Server).Metrics.EngineMetrics.SQLInstrumentationOverhead
+		before := overhead.TotalCount()
+		r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc WHERE c=1")
+		if after := overhead.TotalCount(); after <= before {
+			t.Errorf("expected the instrumentation overhead to be recorded")
+		}
+	})
+
+	// Check that placeholder values are included in the bundle.
+	t.Run("placeholders", func(t *testing.T) {
+		rows := r.QueryStr(t, "EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc WHERE c=$1", 1)
+		zipBytes := checkBundle(
+			t, fmt.Sprint(rows),
+			base, plans, "placeholders.txt", "stats-defaultdb.public.abc.sql", "ranges.txt",
+			"distsql.json distsql.html distsql.txt vec.txt",
+		)
+		placeholders := readBundleFile(t, zipBytes, "placeholders.txt")
+		if !strings.Contains(placeholders, "$1: 1 -- INT8") {
+			t.Errorf("unexpected placeholders.txt:\n%s", placeholders)
+		}
+	})
+
+	// Check that a trace hash is recorded for each bundle and that the
+	// distinct hashes can be listed by fingerprint.
+	t.Run("trace hash", func(t *testing.T) {
+		const fingerprint = "EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc WHERE a = _"
+		for i := 0; i < 3; i++ {
+			r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc WHERE a = 1")
+		}
+		r.CheckQueryResults(t, fmt.Sprintf(`
+SELECT count(*), count(trace_hash) FROM system.statement_diagnostics
+WHERE statement_fingerprint = '%s'`, fingerprint),
+			[][]string{{"3", "3"}},
+		)
+		exp := r.QueryStr(t, fmt.Sprintf(`
+SELECT DISTINCT trace_hash FROM system.statement_diagnostics
+WHERE statement_fingerprint = '%s' ORDER BY trace_hash`, fingerprint))
+		act := r.QueryStr(t,
+			"SELECT unnest(crdb_internal.statement_diagnostics_trace_hashes($1))", fingerprint,
+		)
+		if fmt.Sprint(exp) != fmt.Sprint(act) {
+			t.Errorf("expected trace hashes %v, got %v", exp, act)
+		}
+	})
+
 	// Check that we get separate diagrams for subqueries.
 	t.Run("subqueries", func(t *testing.T) {
 		rows := r.QueryStr(t, "EXPLAIN ANALYZE (DEBUG) SELECT EXISTS (SELECT * FROM abc WHERE c=1)")
 		checkBundle(
 			t, fmt.Sprint(rows),
-			base, plans, "stats-defaultdb.public.abc.sql", "distsql-2-main-query.html distsql-1-subquery.html",
+			base, plans, "stats-defaultdb.public.abc.sql", "ranges.txt",
+			"distsql-2-main-query.json distsql-2-main-query.html",
+			"distsql-1-subquery.json distsql-1-subquery.html distsql.txt vec.txt",
 		)
 	})
 
@@ -105,16 +171,1216 @@ func TestExplainAnalyzeDebug(t *testing.T) {
 		}
 		checkBundle(
 			t, rowsBuf.String(),
-			base, plans, "stats-defaultdb.public.abc.sql", "distsql.html",
+			base, plans, "stats-defaultdb.public.abc.sql", "ranges.txt",
+			"distsql.json distsql.html distsql.txt vec.txt",
+		)
+	})
+
+	// Check that no value from the statement is stored with the bundle when
+	// columns are redacted: the plan hides all of its constants, only the
+	// value-free files are kept and the statement is stored as its fingerprint.
+	t.Run("redacted columns", func(t *testing.T) {
+		r.Exec(t, "SET CLUSTER SETTING sql.stmt_diagnostics.redacted_columns = 'c'")
+		defer r.Exec(t, "RESET CLUSTER SETTING sql.stmt_diagnostics.redacted_columns")
+		rows := r.QueryStr(
+			t, "EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc WHERE c=$1 AND b > 54321", 12345,
+		)
+		zipBytes := checkBundle(
+			t, fmt.Sprint(rows),
+			"statement.txt statement.sql trace.txt env.sql version.txt txn.txt overhead.txt",
+			"repro.sql manifest.txt", plans, "placeholders.txt", "stats-defaultdb.public.abc.sql",
+			"ranges.txt", "distsql.json distsql.html distsql.txt vec.txt",
+		)
+		if plan := readBundleFile(t, zipBytes, "plan.txt"); !strings.Contains(plan, "spans: 1 span") {
+			t.Errorf("expected the spans to be hidden from plan.txt:\n%s", plan)
+		}
+		unzip, err := OpenBundle(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range unzip.File {
+			contents := readBundleFile(t, zipBytes, f.Name)
+			if !valueFreeFiles[f.Name] && f.Name != "manifest.txt" && contents != omittedValuesNote {
+				t.Errorf("expected %s to be omitted, got:\n%s", f.Name, contents)
+			}
+			for _, value := range []string{"12345", "54321"} {
+				if strings.Contains(contents, value) {
+					t.Errorf("unexpected value %s in %s:\n%s", value, f.Name, contents)
+				}
+			}
+		}
+
+		id := regexp.MustCompile("/_admin/v1/stmtbundle/([0-9]+)").FindStringSubmatch(fmt.Sprint(rows))
+		var stmt, fingerprint string
+		var trace gosql.NullString
+		r.QueryRow(t, `
+SELECT statement, statement_fingerprint, trace::STRING
+  FROM system.statement_diagnostics WHERE id = $1`, id[1],
+		).Scan(&stmt, &fingerprint, &trace)
+		if stmt != fingerprint || strings.Contains(stmt, "54321") {
+			t.Errorf("expected the statement to be stored as %q, got %q", fingerprint, stmt)
+		}
+		if trace.Valid {
+			t.Errorf("expected the trace not to be stored, got %s", trace.String)
+		}
+	})
+
+	// Check that bundles can be compressed with zstd.
+	t.Run("zstd", func(t *testing.T) {
+		r.Exec(t, "SET CLUSTER SETTING sql.stmt_diagnostics.bundle_compression = 'zstd'")
+		defer r.Exec(t, "RESET CLUSTER SETTING sql.stmt_diagnostics.bundle_compression")
+		rows := r.QueryStr(t, "EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc WHERE c=1")
+		checkBundle(
+			t, fmt.Sprint(rows),
+			base, plans, "stats-defaultdb.public.abc.sql", "ranges.txt",
+			"distsql.json distsql.html distsql.txt vec.txt",
 		)
 	})
+
+	// Check that the download is gzip-compressed on the wire only if the client
+	// accepts it.
+	t.Run("gzip download", func(t *testing.T) {
+		rows := r.QueryStr(t, "EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc WHERE c=1")
+		url := regexp.MustCompile("http://[a-zA-Z0-9.:]*/_admin/v1/stmtbundle/[0-9]*").
+			FindString(fmt.Sprint(rows))
+		if url == "" {
+			t.Fatalf("couldn't find URL in response '%s'", rows)
+		}
+		download := func(acceptEncoding string) (contentEncoding string, body []byte) {
+			req, err := http.NewRequestWithContext(ctx, "GET", url, nil /* body */)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Setting the header explicitly disables the transparent decompression
+			// of the client.
+			req.Header.Set(httputil.AcceptEncodingHeader, acceptEncoding)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err = ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return resp.Header.Get(httputil.ContentEncodingHeader), body
+		}
+
+		encoding, compressed := download(httputil.GzipEncoding)
+		if encoding != httputil.GzipEncoding {
+			t.Fatalf("expected gzip encoding, got %q", encoding)
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zipBytes, err := ioutil.ReadAll(gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if readBundleFile(t, zipBytes, "statement.txt") == "" {
+			t.Fatal("statement.txt missing from gzipped bundle")
+		}
+
+		encoding, uncompressed := download("identity")
+		if encoding != "" {
+			t.Fatalf("expected no encoding, got %q", encoding)
+		}
+		if !bytes.Equal(zipBytes, uncompressed) {
+			t.Fatal("gzipped and uncompressed downloads differ")
+		}
+	})
+}
+
+func TestCollectAllStatementBundles(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{Insecure: true})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+	r.Exec(t, "CREATE TABLE t (k INT PRIMARY KEY)")
+	r.Exec(t, "SET CLUSTER SETTING sql.stmt_diagnostics.collect_all.max_bundles = 2")
+
+	numBundles := func() int {
+		var n int
+		r.QueryRow(t, "SELECT count(*) FROM system.statement_diagnostics").Scan(&n)
+		return n
+	}
+	before := numBundles()
+
+	// Use a single connection so that the session variable applies to all the
+	// statements below.
+	conn, err := godb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET collect_all_statement_bundles = true"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := conn.ExecContext(ctx, "INSERT INTO t VALUES ($1)", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := conn.ExecContext(ctx, "SET collect_all_statement_bundles = false"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The number of bundles is capped by the cluster setting.
+	if n := numBundles() - before; n != 2 {
+		t.Errorf("expected 2 bundles, got %d", n)
+	}
+}
+
+type testBundleContributor struct {
+	files map[string]string
+	err   error
+}
+
+func (c *testBundleContributor) BundleFiles(
+	ctx context.Context, info *BundleInfo,
+) (map[string]string, error) {
+	return c.files, c.err
+}
+
+func TestBundleContributors(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	contributors := []BundleContributor{
+		&testBundleContributor{files: map[string]string{"b.txt": "b", "a.txt": "a"}},
+		&testBundleContributor{err: errors.New("boom")},
+		&testBundleContributor{files: map[string]string{"c.txt": "c"}},
+	}
+	b := makeStmtBundleBuilder(
+		nil /* db */, nil /* ie */, &planTop{}, "" /* planString */, nil /* trace */, nil, /* placeholders */
+		zip.Deflate,
+	)
+	b.addContributions(context.Background(), contributors)
+	buf, err := b.finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unzip, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, f := range unzip.File {
+		files = append(files, f.Name)
+	}
+	if exp := "[a.txt b.txt c.txt manifest.txt]"; fmt.Sprint(files) != exp {
+		t.Errorf("expected files %s, got %v", exp, files)
+	}
+}
+
+func TestTestingBundleSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	t.Run("ring buffer", func(t *testing.T) {
+		sink := NewTestingBundleSink(2)
+		if _, ok := sink.Last(); ok {
+			t.Fatal("expected no bundles")
+		}
+		for i := 1; i <= 3; i++ {
+			sink.add(TestingBundle{RequestID: stmtdiagnostics.RequestID(i)})
+		}
+		var ids []stmtdiagnostics.RequestID
+		for _, b := range sink.Bundles() {
+			ids = append(ids, b.RequestID)
+		}
+		if exp := "[2 3]"; fmt.Sprint(ids) != exp {
+			t.Errorf("expected bundles for requests %s, got %v", exp, ids)
+		}
+		if _, ok := sink.ForRequest(1); ok {
+			t.Error("expected bundle for request 1 to be evicted")
+		}
+		if b, ok := sink.ForRequest(2); !ok || b.RequestID != 2 {
+			t.Errorf("expected bundle for request 2, got %v", b)
+		}
+	})
+
+	t.Run("server", func(t *testing.T) {
+		ctx := context.Background()
+		sink := NewTestingBundleSink(4)
+		srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+			Knobs: base.TestingKnobs{
+				SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+			},
+		})
+		defer srv.Stopper().Stop(ctx)
+		r := sqlutils.MakeSQLRunner(godb)
+		r.Exec(t, "CREATE TABLE abc (a INT PRIMARY KEY, b INT, c INT UNIQUE)")
+		r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc WHERE c=1")
+
+		// The bundle is available as soon as the statement returns.
+		b, ok := sink.Last()
+		if !ok {
+			t.Fatal("expected a bundle")
+		}
+		if b.RequestID != 0 || b.DiagID == 0 {
+			t.Errorf("unexpected bundle IDs: request %d, diagnostics %d", b.RequestID, b.DiagID)
+		}
+		unzip, err := zip.NewReader(bytes.NewReader(b.Zip), int64(len(b.Zip)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, f := range unzip.File {
+			if f.Name == "statement.txt" {
+				found = true
+			}
+		}
+		if !found {
+			t.Error("expected statement.txt in the bundle")
+		}
+	})
+}
+
+// TestStatementBundlesTable verifies that collected bundles are listed by
+// crdb_internal.statement_bundles, and that their download URL is returned by
+// crdb_internal.statement_bundle_url.
+func TestStatementBundlesTable(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+	r.Exec(t, "CREATE TABLE abc (a INT PRIMARY KEY, b INT, c INT UNIQUE)")
+	r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc WHERE c=1")
+	b, ok := sink.Last()
+	if !ok {
+		t.Fatal("expected a bundle")
+	}
+
+	var fingerprint, url string
+	var size int
+	r.QueryRow(t, `
+SELECT statement_fingerprint, size, crdb_internal.statement_bundle_url(id)
+FROM crdb_internal.statement_bundles WHERE id = $1`, b.DiagID,
+	).Scan(&fingerprint, &size, &url)
+	if exp := "SELECT * FROM abc WHERE c = _"; fingerprint != exp {
+		t.Errorf("expected fingerprint %q, got %q", exp, fingerprint)
+	}
+	if size != len(b.Zip) {
+		t.Errorf("expected size %d, got %d", len(b.Zip), size)
+	}
+	if suffix := fmt.Sprintf("/_admin/v1/stmtbundle/%d", b.DiagID); !strings.HasSuffix(url, suffix) {
+		t.Errorf("expected URL ending in %s, got %s", suffix, url)
+	}
+}
+
+func TestBundleJobs(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+	r.Exec(t, "CREATE TABLE abc (a INT PRIMARY KEY, b INT, c INT UNIQUE)")
+
+	readJobFile := func(t *testing.T) string {
+		b, ok := sink.Last()
+		if !ok {
+			t.Fatal("expected a bundle")
+		}
+		return readBundleFile(t, b.Zip, "job.txt")
+	}
+
+	// A schema change includes its job in the bundle.
+	r.Exec(t, "EXPLAIN ANALYZE (DEBUG) CREATE INDEX idx ON abc (b)")
+	jobFile := readJobFile(t)
+	for _, exp := range []string{"type: SCHEMA CHANGE", "CREATE INDEX idx", "payload:", "progress:"} {
+		if !strings.Contains(jobFile, exp) {
+			t.Errorf("expected %q in job.txt:\n%s", exp, jobFile)
+		}
+	}
+
+	// Other statements don't have a job.txt file.
+	r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc")
+	if jobFile := readJobFile(t); jobFile != "" {
+		t.Errorf("unexpected job.txt:\n%s", jobFile)
+	}
+}
+
+func TestBundleRawStatement(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+	r.Exec(t, "CREATE TABLE abc (a INT PRIMARY KEY, b INT, c INT UNIQUE)")
+
+	// The raw statement, including comments, is in statement.sql.
+	r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT /* app:checkout,trace-id:1234 */ * FROM abc WHERE c = 1")
+	b, ok := sink.Last()
+	if !ok {
+		t.Fatal("expected a bundle")
+	}
+	stmtFile := readBundleFile(t, b.Zip, "statement.sql")
+	for _, exp := range []string{
+		"-- Statement fingerprint:\n--   EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc WHERE c = _\n",
+		"SELECT /* app:checkout,trace-id:1234 */ * FROM abc WHERE c = 1\n",
+	} {
+		if !strings.Contains(stmtFile, exp) {
+			t.Errorf("expected %q in statement.sql:\n%s", exp, stmtFile)
+		}
+	}
+}
+
+func TestBundleRepro(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+	r.Exec(t, "CREATE TABLE abc (a INT PRIMARY KEY, b INT, c INT UNIQUE)")
+
+	// The repro contains the schema, followed by the statement prepared with the
+	// placeholder types and executed with the placeholder values.
+	r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc WHERE c = $1", 1)
+	b, ok := sink.Last()
+	if !ok {
+		t.Fatal("expected a bundle")
+	}
+	repro := readBundleFile(t, b.Zip, "repro.sql")
+	last := -1
+	for _, exp := range []string{
+		"CREATE DATABASE IF NOT EXISTS defaultdb;\n",
+		"CREATE TABLE ",
+		"SET database = defaultdb;\n",
+		"PREPARE repro (INT8) AS SELECT * FROM abc WHERE c = $1;\n",
+		"EXECUTE repro (1:::INT8);\n",
+	} {
+		idx := strings.Index(repro, exp)
+		if idx == -1 {
+			t.Fatalf("expected %q in repro.sql:\n%s", exp, repro)
+		}
+		if idx < last {
+			t.Fatalf("expected %q later in repro.sql:\n%s", exp, repro)
+		}
+		last = idx
+	}
+}
+
+func TestBundleStats(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+	r.Exec(t, "SET CLUSTER SETTING sql.stats.automatic_collection.enabled = false")
+	r.Exec(t, "CREATE TABLE abc (a INT PRIMARY KEY, b INT, c INT UNIQUE)")
+	r.Exec(t, "INSERT INTO abc VALUES (1, 1, 1), (2, 2, 2)")
+	r.Exec(t, "CREATE STATISTICS s1 ON a FROM abc")
+
+	// The statistics used by the optimizer are listed in stats.txt; there are no
+	// automatic statistics, so a refresh is overdue.
+	testutils.SucceedsSoon(t, func() error {
+		r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc WHERE a = 1")
+		b, ok := sink.Last()
+		if !ok {
+			return errors.New("expected a bundle")
+		}
+		statsFile := readBundleFile(t, b.Zip, "stats.txt")
+		for _, exp := range []string{
+			"abc:\n",
+			"  s1 on (a): created at ",
+			"row count: 2\n",
+			"  automatic refresh overdue: true (automatic statistics collection is disabled)\n",
+		} {
+			if !strings.Contains(statsFile, exp) {
+				return errors.Errorf("expected %q in stats.txt:\n%s", exp, statsFile)
+			}
+		}
+		return nil
+	})
+}
+
+// TestBundleStatsHistory verifies that bundles include the statement
+// statistics of the fingerprint of the statement.
+func TestBundleStatsHistory(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+	r.Exec(t, "CREATE TABLE abc (a INT PRIMARY KEY, b INT, c INT UNIQUE)")
+	for i := 0; i < 3; i++ {
+		r.Exec(t, "SELECT * FROM abc WHERE c = 1")
+	}
+
+	registry := srv.ExecutorConfig().(ExecutorConfig).StmtDiagnosticsRecorder
+	if err := registry.InsertRequest(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT * FROM abc WHERE c = _",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r.Exec(t, "SELECT * FROM abc WHERE c = 1")
+	b, ok := sink.Last()
+	if !ok {
+		t.Fatal("expected a bundle")
+	}
+	history := readBundleFile(t, b.Zip, "stats-history.txt")
+	for _, exp := range []string{
+		"executions: 4\n",
+		"run latency: mean ",
+		", this execution ",
+		"rows: mean 0.0, stddev 0.0\n",
+	} {
+		if !strings.Contains(history, exp) {
+			t.Errorf("expected %q in stats-history.txt:\n%s", exp, history)
+		}
+	}
+
+	// The statement statistics can be excluded from bundles.
+	r.Exec(t, "SET CLUSTER SETTING sql.stmt_diagnostics.stats_history.enabled = false")
+	if err := registry.InsertRequest(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT * FROM abc WHERE c = _",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r.Exec(t, "SELECT * FROM abc WHERE c = 1")
+	b2, ok := sink.Last()
+	if !ok || b2.RequestID == b.RequestID {
+		t.Fatal("expected a second bundle")
+	}
+	if history := readBundleFile(t, b2.Zip, "stats-history.txt"); history != "" {
+		t.Errorf("unexpected stats-history.txt in bundle:\n%s", history)
+	}
+}
+
+// TestBundleOptCosts verifies that the bundle of a statement with joins
+// includes the estimated costs of the joins and the alternatives explored by
+// the optimizer.
+func TestBundleOptCosts(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+	r.Exec(t, "CREATE TABLE abc (a INT PRIMARY KEY, b INT, c INT UNIQUE)")
+
+	registry := srv.ExecutorConfig().(ExecutorConfig).StmtDiagnosticsRecorder
+	const query = "SELECT * FROM abc AS x JOIN abc AS y ON x.b = y.c"
+	if err := registry.InsertRequest(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: query,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r.Exec(t, query)
+	b, ok := sink.Last()
+	if !ok {
+		t.Fatal("expected a bundle")
+	}
+	costs := readBundleFile(t, b.Zip, "opt-costs.txt")
+	for _, exp := range []string{
+		"total cost: ",
+		"  cost: ",
+		"  input: scan [",
+		"  alternatives considered: inner-join (",
+	} {
+		if !strings.Contains(costs, exp) {
+			t.Errorf("expected %q in opt-costs.txt:\n%s", exp, costs)
+		}
+	}
+
+	// Statements without joins don't get the file.
+	if err := registry.InsertRequest(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT * FROM abc WHERE c = _",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r.Exec(t, "SELECT * FROM abc WHERE c = 1")
+	b2, ok := sink.Last()
+	if !ok || b2.RequestID == b.RequestID {
+		t.Fatal("expected a second bundle")
+	}
+	if costs := readBundleFile(t, b2.Zip, "opt-costs.txt"); costs != "" {
+		t.Errorf("unexpected opt-costs.txt in bundle:\n%s", costs)
+	}
+}
+
+func TestBundlePlanOnly(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+	r.Exec(t, "CREATE TABLE abc (a INT PRIMARY KEY, b INT, c INT)")
+
+	registry := srv.ExecutorConfig().(ExecutorConfig).StmtDiagnosticsRecorder
+	if err := registry.InsertRequest(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT * FROM abc WHERE b = _",
+		Verbosity:   stmtdiagnostics.TraceVerbosityPlanOnly,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r.Exec(t, "SELECT * FROM abc WHERE b = 1")
+	b, ok := sink.Last()
+	if !ok {
+		t.Fatal("expected a bundle")
+	}
+	for _, name := range []string{"statement.txt", "opt.txt", "plan.txt", "schema.sql", "env.sql"} {
+		if readBundleFile(t, b.Zip, name) == "" {
+			t.Errorf("expected %s in the bundle", name)
+		}
+	}
+	for _, name := range []string{"trace.json", "trace.txt", "trace-jaeger.json"} {
+		if contents := readBundleFile(t, b.Zip, name); contents != "" {
+			t.Errorf("unexpected %s in the bundle:\n%s", name, contents)
+		}
+	}
+}
+
+// TestBundleError verifies that the error returned by the statement is
+// described in error.txt.
+func TestBundleError(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+
+	registry := srv.ExecutorConfig().(ExecutorConfig).StmtDiagnosticsRecorder
+	if err := registry.InsertRequest(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT crdb_internal.force_error(_, _)",
+		Verbosity:   stmtdiagnostics.TraceVerbosityFull,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r.ExpectErr(t, "boom", "SELECT crdb_internal.force_error('22012', 'boom')")
+	b, ok := sink.Last()
+	if !ok {
+		t.Fatal("expected a bundle")
+	}
+	contents := readBundleFile(t, b.Zip, "error.txt")
+	for _, expected := range []string{"code: 22012\n", "message: boom\n", "error chain:\n"} {
+		if !strings.Contains(contents, expected) {
+			t.Errorf("expected %q in error.txt:\n%s", expected, contents)
+		}
+	}
+
+	// Successful statements don't have an error.txt.
+	r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT 1")
+	if b, ok = sink.Last(); !ok {
+		t.Fatal("expected a bundle")
+	}
+	if contents := readBundleFile(t, b.Zip, "error.txt"); contents != "" {
+		t.Errorf("unexpected error.txt:\n%s", contents)
+	}
+}
+
+// TestBundleMetadata verifies that the tags of a diagnostics request are
+// included in the metadata.json file of the bundle.
+func TestBundleMetadata(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+
+	registry := srv.ExecutorConfig().(ExecutorConfig).StmtDiagnosticsRecorder
+	tags := map[string]string{"ticket": "12345", "engineer": "alice"}
+	if err := registry.InsertRequest(
+		ctx, stmtdiagnostics.RequestOptions{Fingerprint: "SELECT _", Tags: tags},
+	); err != nil {
+		t.Fatal(err)
+	}
+	r.Exec(t, "SELECT 1")
+	b, ok := sink.Last()
+	if !ok {
+		t.Fatal("expected a bundle")
+	}
+	var metadata bundleMetadata
+	contents := readBundleFile(t, b.Zip, "metadata.json")
+	if err := json.Unmarshal([]byte(contents), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(metadata.Tags) != fmt.Sprint(tags) {
+		t.Errorf("expected tags %v, got %v", tags, metadata.Tags)
+	}
+
+	// Bundles without tags don't have a metadata.json.
+	r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT 1")
+	if b, ok = sink.Last(); !ok {
+		t.Fatal("expected a bundle")
+	}
+	if contents := readBundleFile(t, b.Zip, "metadata.json"); contents != "" {
+		t.Errorf("unexpected metadata.json:\n%s", contents)
+	}
+}
+
+// TestBundleRandomSeed verifies that the seed of the random() builtin is
+// recorded in env.sql, and that replaying it reproduces the results of the
+// statement.
+func TestBundleRandomSeed(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+
+	r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT random()")
+	b, ok := sink.Last()
+	if !ok {
+		t.Fatal("expected a bundle")
+	}
+	env := readBundleFile(t, b.Zip, "env.sql")
+	m := regexp.MustCompile(`SET random_seed = (-?[0-9]+);`).FindStringSubmatch(env)
+	if m == nil {
+		t.Fatalf("expected a random seed in env.sql:\n%s", env)
+	}
+
+	// Replaying the seed makes random() return the same values.
+	r.Exec(t, "SET random_seed = "+m[1])
+	var first, second float64
+	r.QueryRow(t, "SELECT random()").Scan(&first)
+	r.QueryRow(t, "SELECT random()").Scan(&second)
+	if first != second {
+		t.Errorf("expected the same value with a fixed seed, got %f and %f", first, second)
+	}
+
+	// Statements which don't use random() don't record a seed.
+	r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT 1")
+	b, ok = sink.Last()
+	if !ok {
+		t.Fatal("expected a bundle")
+	}
+	if env := readBundleFile(t, b.Zip, "env.sql"); strings.Contains(env, "random_seed") {
+		t.Errorf("unexpected random seed in env.sql:\n%s", env)
+	}
+}
+
+// TestBundleCPUProfile verifies that a CPU profile of the statement is included
+// in bundles when sql.stmt_diagnostics.cpu_profile.enabled is set, unless
+// another CPU profile is being recorded.
+func TestBundleCPUProfile(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+
+	cpuProfile := func() string {
+		r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT count(*) FROM generate_series(1, 10000)")
+		b, ok := sink.Last()
+		if !ok {
+			t.Fatal("expected a bundle")
+		}
+		return readBundleFile(t, b.Zip, "cpu.pprof")
+	}
+
+	if cpuProfile() != "" {
+		t.Error("unexpected cpu.pprof with the setting disabled")
+	}
+
+	r.Exec(t, "SET CLUSTER SETTING sql.stmt_diagnostics.cpu_profile.enabled = true")
+	if cpuProfile() == "" {
+		t.Error("expected cpu.pprof in the bundle")
+	}
+	// The profile is stopped when the statement finishes.
+	st := srv.ClusterSettings()
+	if typ := st.CPUProfileType(); typ != cluster.CPUProfileNone {
+		t.Errorf("expected no CPU profile in progress, got %d", typ)
+	}
+
+	// No profile is recorded while another one is in progress.
+	if err := st.SetCPUProfiling(cluster.CPUProfileDefault); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = st.SetCPUProfiling(cluster.CPUProfileNone) }()
+	if cpuProfile() != "" {
+		t.Error("unexpected cpu.pprof while another CPU profile is in progress")
+	}
+}
+
+// TestBundleReadTimestamp verifies that the read timestamp of the statement and
+// the resolved AS OF SYSTEM TIME timestamp are recorded in env.sql.
+func TestBundleReadTimestamp(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+	r.Exec(t, "CREATE TABLE abc (a INT PRIMARY KEY, b INT, c INT)")
+
+	var ts string
+	r.QueryRow(t, "SELECT cluster_logical_timestamp()").Scan(&ts)
+	r.Exec(t, fmt.Sprintf("EXPLAIN ANALYZE (DEBUG) SELECT * FROM abc AS OF SYSTEM TIME %s", ts))
+	b, ok := sink.Last()
+	if !ok {
+		t.Fatal("expected a bundle")
+	}
+	env := readBundleFile(t, b.Zip, "env.sql")
+	for _, expected := range []string{
+		fmt.Sprintf("-- read timestamp: %s\n", ts),
+		fmt.Sprintf("-- AS OF SYSTEM TIME: %s\n", ts),
+	} {
+		if !strings.Contains(env, expected) {
+			t.Errorf("expected %q in env.sql:\n%s", expected, env)
+		}
+	}
+}
+
+func TestFilterTrace(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	span := func(id, parent uint64, op string) tracingpb.RecordedSpan {
+		return tracingpb.RecordedSpan{SpanID: id, ParentSpanID: parent, Operation: op}
+	}
+	trace := tracing.Recording{
+		span(1, 0, "traced statement"),
+		span(2, 1, "flow"),
+		span(3, 2, "txn coordinator send"),
+		span(4, 3, "colbatchscan"),
+		span(5, 1, "dist sender send"),
+	}
+	var res []string
+	for _, s := range filterTrace(trace, []string{"colbatch", "flow"}) {
+		res = append(res, fmt.Sprintf("%d:%d:%s", s.SpanID, s.ParentSpanID, s.Operation))
+	}
+	// The root span is always kept, and the colbatchscan span is reparented to
+	// the flow span.
+	exp := "[1:0:traced statement 2:1:flow 4:2:colbatchscan]"
+	if fmt.Sprint(res) != exp {
+		t.Errorf("expected %s, got %v", exp, res)
+	}
+}
+
+func TestTraceWithVerbosity(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	span := func(id, parent uint64, op string) tracingpb.RecordedSpan {
+		return tracingpb.RecordedSpan{
+			SpanID: id, ParentSpanID: parent, Operation: op,
+			Logs: []tracingpb.LogRecord{{Fields: []tracingpb.LogRecord_Field{{Key: "event", Value: op}}}},
+		}
+	}
+	trace := tracing.Recording{
+		span(1, 0, "traced statement"),
+		span(2, 1, "flow"),
+		span(3, 2, "txn coordinator send"),
+		span(4, 3, "dist sender send"),
+		span(5, 4, "/cockroach.roachpb.Internal/Batch"),
+		span(6, 2, "colbatchscan"),
+	}
+	format := func(r tracing.Recording) string {
+		var res []string
+		for _, s := range r {
+			res = append(res, fmt.Sprintf("%d:%s:%d", s.SpanID, s.Operation, len(s.Logs)))
+		}
+		return fmt.Sprint(res)
+	}
+	for _, tc := range []struct {
+		verbosity stmtdiagnostics.TraceVerbosity
+		exp       string
+	}{
+		{
+			verbosity: stmtdiagnostics.TraceVerbosityFull,
+			exp: "[1:traced statement:1 2:flow:1 3:txn coordinator send:1 4:dist sender send:1 " +
+				"5:/cockroach.roachpb.Internal/Batch:1 6:colbatchscan:1]",
+		},
+		{
+			verbosity: stmtdiagnostics.TraceVerbositySQL,
+			exp:       "[1:traced statement:1 2:flow:1 6:colbatchscan:1]",
+		},
+		{
+			verbosity: stmtdiagnostics.TraceVerbositySummary,
+			exp: "[1:traced statement:0 2:flow:0 3:txn coordinator send:0 4:dist sender send:0 " +
+				"5:/cockroach.roachpb.Internal/Batch:0 6:colbatchscan:0]",
+		},
+	} {
+		if res := format(traceWithVerbosity(trace, tc.verbosity)); res != tc.exp {
+			t.Errorf("%s: expected %s, got %s", tc.verbosity, tc.exp, res)
+		}
+	}
+	// The original trace is not modified.
+	if len(trace[0].Logs) != 1 {
+		t.Errorf("trace was modified")
+	}
+}
+
+func TestSplitInternalTrace(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	span := func(id, parent uint64, op string, internal bool) tracingpb.RecordedSpan {
+		s := tracingpb.RecordedSpan{SpanID: id, ParentSpanID: parent, Operation: op}
+		if internal {
+			s.Tags = map[string]string{internalExecutorSpanTag: op}
+		}
+		return s
+	}
+	trace := tracing.Recording{
+		span(1, 0, "traced statement", false),
+		span(3, 2, "exec stmt", false),
+		span(2, 1, "get-stats", true),
+		span(4, 3, "get-zone", true),
+		span(5, 1, "flow", false),
+		span(6, 5, "get-desc", true),
+	}
+	format := func(r tracing.Recording) string {
+		var res []string
+		for _, s := range r {
+			res = append(res, fmt.Sprintf("%d:%s", s.SpanID, s.Operation))
+		}
+		return fmt.Sprint(res)
+	}
+	rest, internal := splitInternalTrace(trace)
+	if exp := "[1:traced statement 5:flow]"; format(rest) != exp {
+		t.Errorf("expected %s, got %s", exp, format(rest))
+	}
+	// Nested internal queries are grouped with the outer query, and the span of
+	// each internal query comes first.
+	var res []string
+	for _, r := range internal {
+		res = append(res, format(r))
+	}
+	exp := "[[2:get-stats 3:exec stmt 4:get-zone] [6:get-desc]]"
+	if fmt.Sprint(res) != exp {
+		t.Errorf("expected %s, got %v", exp, res)
+	}
+}
+
+func TestTruncateTrace(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	var trace tracing.Recording
+	for i := 1; i <= 5; i++ {
+		trace = append(trace, tracingpb.RecordedSpan{SpanID: uint64(i), Operation: "op"})
+	}
+	spanSize := int64(trace[1].Size())
+	testCases := []struct {
+		maxSize   int64
+		expSpans  int
+		truncated bool
+	}{
+		{maxSize: 0, expSpans: 5},
+		{maxSize: 5 * spanSize, expSpans: 5},
+		{maxSize: 3*spanSize + 1, expSpans: 3, truncated: true},
+		// The root span is always kept.
+		{maxSize: 1, expSpans: 1, truncated: true},
+	}
+	for _, tc := range testCases {
+		res, truncated := truncateTrace(trace, tc.maxSize)
+		if len(res) != tc.expSpans || truncated != tc.truncated {
+			t.Errorf(
+				"max size %d: expected %d spans (truncated: %t), got %d spans (truncated: %t)",
+				tc.maxSize, tc.expSpans, tc.truncated, len(res), truncated,
+			)
+		}
+	}
+
+	// The truncation is reported in trace.txt.
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+	r.Exec(t, "SET CLUSTER SETTING sql.stmt_diagnostics.max_trace_size = '1B'")
+	r.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT 1")
+	b, ok := sink.Last()
+	if !ok {
+		t.Fatal("expected a bundle")
+	}
+	traceFile := readBundleFile(t, b.Zip, "trace.txt")
+	if exp := "-- TRACE TRUNCATED at 1 bytes"; !strings.Contains(traceFile, exp) {
+		t.Errorf("expected %q in trace.txt:\n%s", exp, traceFile)
+	}
+}
+
+func TestTraceStructuralHash(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	span := func(id, parent uint64, op string, d time.Duration) tracingpb.RecordedSpan {
+		return tracingpb.RecordedSpan{SpanID: id, ParentSpanID: parent, Operation: op, Duration: d}
+	}
+	a := traceStructuralHash(tracing.Recording{
+		span(1, 0, "traced statement", time.Second),
+		span(2, 1, "flow", time.Millisecond),
+		span(3, 1, "dist sender send", time.Millisecond),
+		span(4, 2, "colbatchscan", time.Microsecond),
+	})
+	// Span IDs, timings and the order of sibling spans don't affect the hash.
+	b := traceStructuralHash(tracing.Recording{
+		span(10, 0, "traced statement", 2*time.Second),
+		span(12, 10, "dist sender send", time.Microsecond),
+		span(11, 10, "flow", time.Second),
+		span(13, 11, "colbatchscan", time.Millisecond),
+	})
+	if a != b {
+		t.Errorf("expected equal hashes, got %s and %s", a, b)
+	}
+	// The nesting of the spans does.
+	c := traceStructuralHash(tracing.Recording{
+		span(1, 0, "traced statement", time.Second),
+		span(2, 1, "flow", time.Millisecond),
+		span(3, 2, "dist sender send", time.Millisecond),
+		span(4, 2, "colbatchscan", time.Microsecond),
+	})
+	if a == c {
+		t.Errorf("expected different hashes, got %s", a)
+	}
+	if h := traceStructuralHash(nil); h != "" {
+		t.Errorf("expected empty hash for empty trace, got %s", h)
+	}
+}
+
+func TestBundleWriteTo(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	bundle := diagnosticsBundle{zip: []byte("zip contents")}
+	var buf bytes.Buffer
+	n, err := bundle.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(bundle.zip)) || buf.String() != "zip contents" {
+		t.Errorf("unexpected output %q (%d bytes)", buf.String(), n)
+	}
+
+	// Bundles that failed to build return their error.
+	bundle = diagnosticsBundle{collectionErr: errors.New("boom")}
+	buf.Reset()
+	if _, err := bundle.WriteTo(&buf); !testutils.IsError(err, "boom") {
+		t.Errorf("expected error, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestStatementExemplarCallback(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	var exemplars []StatementExemplar
+	cfg := ExecutorConfig{
+		StatementExemplarCallback: func(ctx context.Context, exemplar StatementExemplar) {
+			exemplars = append(exemplars, exemplar)
+		},
+	}
+	ih := instrumentationHelper{fingerprint: "SELECT _", origCtx: context.Background()}
+
+	// Nothing is reported if no bundle was collected.
+	ih.reportExemplar(&cfg, "sql.service.latency", time.Second)
+	if len(exemplars) != 0 {
+		t.Fatalf("unexpected exemplars %v", exemplars)
+	}
+
+	ih.bundleID = 5
+	ih.traceID = 7
+	ih.reportExemplar(&cfg, "sql.service.latency", time.Second)
+	if exp := "[{sql.service.latency SELECT _ 5 7 1s}]"; fmt.Sprint(exemplars) != exp {
+		t.Errorf("expected exemplars %s, got %v", exp, exemplars)
+	}
+}
+
+func TestLargeBundleCallback(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+
+	type report struct {
+		fingerprint string
+		size        int64
+	}
+	var reports []report
+	cfg := srv.ExecutorConfig().(ExecutorConfig)
+	cfg.LargeBundleCallback = func(ctx context.Context, fingerprint string, size int64) {
+		reports = append(reports, report{fingerprint: fingerprint, size: size})
+	}
+	insert := func(size int) {
+		bundle := diagnosticsBundle{zip: make([]byte, size), traceJSON: tree.DNull}
+		bundle.insert(ctx, &cfg, "SELECT _", "SELECT 1", 0 /* diagRequestID */)
+	}
+
+	r.Exec(t, "SET CLUSTER SETTING sql.stmt_diagnostics.large_bundle_threshold = '100B'")
+	insert(50)
+	insert(150)
+	if exp := "[{SELECT _ 150}]"; fmt.Sprint(reports) != exp {
+		t.Errorf("expected reports %s, got %v", exp, reports)
+	}
+
+	// A zero threshold disables the callback.
+	reports = nil
+	r.Exec(t, "SET CLUSTER SETTING sql.stmt_diagnostics.large_bundle_threshold = '0B'")
+	insert(150)
+	if len(reports) != 0 {
+		t.Errorf("unexpected reports %v", reports)
+	}
+}
+
+func TestGoroutineStacksWithLabel(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	stop := make(chan struct{})
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	pprof.Do(context.Background(), pprof.Labels("test.label", "foo"), func(context.Context) {
+		go func() {
+			defer wg.Done()
+			close(started)
+			<-stop
+		}()
+	})
+	<-started
+	defer wg.Wait()
+	defer close(stop)
+
+	stacks := goroutineStacksWithLabel("test.label", "foo")
+	if !strings.Contains(stacks, "TestGoroutineStacksWithLabel") {
+		t.Errorf("expected labeled goroutine in stacks:\n%s", stacks)
+	}
+	if strings.Contains(stacks, "goroutineStacksWithLabel") {
+		t.Errorf("unexpected unlabeled goroutine in stacks:\n%s", stacks)
+	}
+	if stacks := goroutineStacksWithLabel("test.label", "bar"); stacks != "" {
+		t.Errorf("expected no stacks, got:\n%s", stacks)
+	}
+}
+
+// readBundleFile returns the contents of the given file in the zipped bundle,
+// or the empty string if the bundle doesn't contain the file.
+func readBundleFile(t *testing.T, zipBytes []byte, name string) string {
+	t.Helper()
+	unzip, err := OpenBundle(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range unzip.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		contents, err := ioutil.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(contents)
+	}
+	return ""
 }
 
 // checkBundle searches text strings for a bundle URL and then verifies that the
 // bundle contains the expected files. The expected files are passed as an
 // arbitrary number of strings; each string contains one or more filenames
-// separated by a space.
-func checkBundle(t *testing.T, text string, expectedFiles ...string) {
+// separated by a space. The downloaded bundle is returned.
+func checkBundle(t *testing.T, text string, expectedFiles ...string) []byte {
 	t.Helper()
 	reg := regexp.MustCompile("http://[a-zA-Z0-9.:]*/_admin/v1/stmtbundle/[0-9]*")
 	url := reg.FindString(text)
@@ -130,7 +1396,7 @@ func checkBundle(t *testing.T, text string, expectedFiles ...string) {
 	var buf bytes.Buffer
 	_, _ = io.Copy(&buf, resp.Body)
 
-	unzip, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	unzip, err := OpenBundle(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
 	if err != nil {
 		t.Errorf("%q\n", buf.String())
 		t.Fatal(err)
@@ -154,4 +1420,78 @@ func checkBundle(t *testing.T, text string, expectedFiles ...string) {
 	if fmt.Sprint(files) != fmt.Sprint(expList) {
 		t.Errorf("unexpected list of files:\n  %v\nexpected:\n  %v", files, expList)
 	}
+	return buf.Bytes()
+}
+
+// TestBundleCollectionLimiter verifies that a diagnostics request is left
+// pending, without failing the statement, when too many bundles are being
+// built, and that it is serviced by a later execution.
+func TestBundleCollectionLimiter(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sink := NewTestingBundleSink(1)
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{BundleSink: sink},
+		},
+	})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+	r.Exec(t, "CREATE TABLE abc (a INT PRIMARY KEY, b INT, c INT UNIQUE)")
+	r.Exec(t, "SET CLUSTER SETTING sql.stmt_diagnostics.max_concurrent_bundles = 1")
+	r.Exec(t, "SET CLUSTER SETTING sql.stmt_diagnostics.bundle_queue_timeout = '0s'")
+
+	cfg := srv.ExecutorConfig().(ExecutorConfig)
+	registry := cfg.StmtDiagnosticsRecorder
+	if err := registry.InsertRequest(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT * FROM abc WHERE c = _",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Take the only spot, as if another bundle was being built.
+	if err := cfg.BundleCollectionLimiter.begin(ctx, false /* wait */); err != nil {
+		t.Fatal(err)
+	}
+	r.Exec(t, "SELECT * FROM abc WHERE c = 1")
+	if _, ok := sink.Last(); ok {
+		t.Fatal("unexpected bundle collected while the limit was reached")
+	}
+	if !registry.HasPendingRequests() {
+		t.Fatal("expected the request to still be pending")
+	}
+
+	cfg.BundleCollectionLimiter.finish()
+	r.Exec(t, "SELECT * FROM abc WHERE c = 1")
+	if b, ok := sink.Last(); !ok || b.RequestID == 0 {
+		t.Fatalf("expected a bundle for the request, got %v", b)
+	}
+}
+
+// TestBuildStatementBundleCanceled verifies that the building of a bundle is
+// abandoned when its context is canceled.
+func TestBuildStatementBundleCanceled(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bundle := buildStatementBundle(
+		ctx, nil /* db */, nil /* ie */, &planTop{}, bundleInputs{
+			stmtRawSQL:  "SELECT 1",
+			fingerprint: "SELECT _",
+			verbosity:   stmtdiagnostics.TraceVerbosityFull,
+		},
+	)
+	if !bundle.wasAbandoned() {
+		t.Fatalf("expected the bundle to be abandoned, got error %v", bundle.collectionErr)
+	}
+	if !errors.Is(bundle.collectionErr, context.Canceled) {
+		t.Fatalf("expected a context canceled error, got %v", bundle.collectionErr)
+	}
+	if bundle.zip != nil {
+		t.Fatal("expected no zip for an abandoned bundle")
+	}
 }
@@ -23,9 +23,13 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/opt/cat"
 	"github.com/cockroachdb/cockroach/pkg/sql/opt/exec"
 	"github.com/cockroachdb/cockroach/pkg/sql/opt/exec/explain"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/sql/physicalplan"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sessiondatapb"
+	"github.com/cockroachdb/errors"
 )
 
 // explainPlanNode implements EXPLAIN (PLAN); it produces the output of
@@ -47,7 +51,10 @@ func (e *explainPlanNode) startExec(params runParams) error {
 	distribution, willVectorize := explainGetDistributedAndVectorized(params, realPlan)
 
 	ob := explain.NewOutputBuilder(e.flags)
-	if err := emitExplain(ob, params.EvalContext(), params.p.ExecCfg().Codec, e.plan, distribution, willVectorize); err != nil {
+	if err := emitExplain(
+		ob, params.EvalContext(), params.p.ExecCfg().Codec, e.plan, distribution,
+		"" /* notDistributedReason */, willVectorize,
+	); err != nil {
 		return err
 	}
 	v := params.p.newContainerValuesNode(colinfo.ExplainPlanColumns, 0)
@@ -70,9 +77,14 @@ func emitExplain(
 	codec keys.SQLCodec,
 	explainPlan *explain.Plan,
 	distribution physicalplan.PlanDistribution,
+	notDistributedReason string,
 	vectorized bool,
 ) error {
-	ob.AddField("distribution", distribution.String())
+	if notDistributedReason != "" {
+		ob.AddField("distribution", fmt.Sprintf("%s (reason: %s)", distribution, notDistributedReason))
+	} else {
+		ob.AddField("distribution", distribution.String())
+	}
 	ob.AddField("vectorized", fmt.Sprintf("%t", vectorized))
 	spanFormatFn := func(table cat.Table, index cat.Index, scanParams exec.ScanParams) string {
 		var tabDesc *tabledesc.Immutable
@@ -122,6 +134,52 @@ func (e *explainPlanNode) Close(ctx context.Context) {
 	}
 }
 
+// PlanInfo implements the tree.EvalPlanner interface. The statement is planned
+// as EXPLAIN would, by a separate planner that uses the transaction, the
+// current database and the search path of the session.
+func (p *planner) PlanInfo(
+	ctx context.Context, sql string,
+) (gist, distribution string, vectorized bool, _ error) {
+	stmt, err := parser.ParseOne(sql)
+	if err != nil {
+		return "", "", false, err
+	}
+	if typ := stmt.AST.StatementType(); typ != tree.Rows && typ != tree.RowsAffected {
+		return "", "", false, pgerror.Newf(pgcode.InvalidParameterValue,
+			"cannot plan %s statement; only queries and data modification statements are supported",
+			stmt.AST.StatementTag())
+	}
+
+	ip, cleanup := newInternalPlanner(
+		"plan-info", p.txn, p.User(), &MemoryMetrics{}, p.ExecCfg(), p.SessionData().SessionData,
+	)
+	defer cleanup()
+	ip.SessionData().Database = p.SessionData().Database
+	ip.SessionData().SearchPath = p.SessionData().SearchPath
+	ip.semaCtx.SearchPath = p.SessionData().SearchPath
+
+	stmt.AST = &tree.Explain{
+		ExplainOptions: tree.ExplainOptions{Mode: tree.ExplainPlan},
+		Statement:      stmt.AST,
+	}
+	ip.stmt = makeStatement(stmt, ClusterWideID{} /* queryID */)
+	ip.optPlanningCtx.init(ip)
+	if err := ip.makeOptimizerPlan(ctx); err != nil {
+		return "", "", false, err
+	}
+	defer ip.curPlan.close(ctx)
+
+	n, ok := ip.curPlan.main.planNode.(*explainPlanNode)
+	if !ok {
+		return "", "", false, errors.AssertionFailedf("unexpected plan node %T", ip.curPlan.main.planNode)
+	}
+	params := runParams{ctx: ctx, extendedEvalCtx: &ip.extendedEvalCtx, p: ip}
+	dist, willVectorize := explainGetDistributedAndVectorized(
+		params, n.plan.WrappedPlan.(*planComponents),
+	)
+	return explain.PlanGist(n.plan), dist.String(), willVectorize, nil
+}
+
 // explainGetDistributedAndVectorized determines the "distributed" and
 // "vectorized" properties for EXPLAIN.
 func explainGetDistributedAndVectorized(
@@ -145,7 +203,7 @@ func explainGetDistributedAndVectorized(
 		// cause an error or panic, so swallow the error. See #40677 for example.
 		distSQLPlanner.FinalizePlan(planCtx, physicalPlan)
 		flows := physicalPlan.GenerateFlowSpecs()
-		flowCtx := newFlowCtxForExplainPurposes(planCtx, params)
+		flowCtx := newFlowCtxForExplainPurposes(planCtx, params.p)
 		flowCtx.Cfg.ClusterID = &distSQLPlanner.rpcCtx.ClusterID
 
 		ctxSessionData := flowCtx.EvalCtx.SessionData
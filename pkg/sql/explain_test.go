@@ -12,13 +12,24 @@ package sql_test
 
 import (
 	"context"
+	gosql "database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/tests"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	yaml "gopkg.in/yaml.v2"
 )
 
 func TestStatementReuses(t *testing.T) {
@@ -199,3 +210,497 @@ func TestStatementReuses(t *testing.T) {
 		}
 	})
 }
+
+func TestExplainAnalyzeRetries(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	params, _ := tests.CreateTestServerParams()
+	s, db, _ := serverutils.StartServer(t, params)
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(db)
+	r.Exec(t, "CREATE SEQUENCE s")
+
+	// The first attempt of the statement forces a retry; the sequence is not
+	// transactional, so the second attempt succeeds.
+	rows := r.QueryStr(t, `EXPLAIN ANALYZE (PLAN)
+SELECT CASE nextval('s') WHEN 1 THEN crdb_internal.force_retry('1h') ELSE 1 END`)
+	exp := "retries: 1 (causes: forced by crdb_internal.force_retry())"
+	for _, row := range rows {
+		if row[0] == exp {
+			return
+		}
+	}
+	t.Fatalf("expected %q in output:\n%v", exp, rows)
+}
+
+func TestExplainAnalyzeTimeToFirstRow(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	params, _ := tests.CreateTestServerParams()
+	s, db, _ := serverutils.StartServer(t, params)
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(db)
+	r.Exec(t, "CREATE TABLE t (x INT PRIMARY KEY)")
+
+	hasFirstRow := func(query string) bool {
+		for _, row := range r.QueryStr(t, query) {
+			if strings.HasPrefix(row[0], "time to first row: ") {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Statements that don't produce any rows don't have a first row.
+	if hasFirstRow("EXPLAIN ANALYZE (PLAN) SELECT * FROM t") {
+		t.Error("unexpected time to first row for a query without results")
+	}
+	if hasFirstRow("EXPLAIN ANALYZE (PLAN) INSERT INTO t VALUES (1), (2)") {
+		t.Error("unexpected time to first row for a statement without RETURNING")
+	}
+	if !hasFirstRow("EXPLAIN ANALYZE (PLAN) SELECT * FROM t") {
+		t.Error("expected time to first row for a query with results")
+	}
+}
+
+func TestExplainAnalyzeCommitTime(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	params, _ := tests.CreateTestServerParams()
+	s, db, _ := serverutils.StartServer(t, params)
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(db)
+	r.Exec(t, "CREATE TABLE t (x INT PRIMARY KEY)")
+
+	commitTime := func(query string) string {
+		for _, row := range r.QueryStr(t, query) {
+			if strings.HasPrefix(row[0], "commit time: ") {
+				return strings.TrimPrefix(row[0], "commit time: ")
+			}
+		}
+		return ""
+	}
+
+	// The implicit transaction of a read-only statement is committed after the
+	// execution.
+	if c := commitTime("EXPLAIN ANALYZE (PLAN) SELECT * FROM t"); c == "" {
+		t.Error("expected commit time for an implicit transaction")
+	}
+	// Inserts can be committed as part of their execution.
+	const onePhaseCommit = "included in execution time (one-phase commit)"
+	if c := commitTime("EXPLAIN ANALYZE (PLAN) INSERT INTO t VALUES (1)"); c != onePhaseCommit {
+		t.Errorf("expected %q, got %q", onePhaseCommit, c)
+	}
+	// There is no auto-commit in explicit transactions.
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := tx.Query("EXPLAIN ANALYZE (PLAN) SELECT * FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var row string
+		if err := rows.Scan(&row); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(row, "commit time: ") {
+			t.Errorf("unexpected %q in an explicit transaction", row)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExplainAnalyzeRepeat(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	params, _ := tests.CreateTestServerParams()
+	s, db, _ := serverutils.StartServer(t, params)
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(db)
+	r.Exec(t, "CREATE TABLE t (x INT PRIMARY KEY)")
+	r.Exec(t, "INSERT INTO t VALUES (0)")
+	r.Exec(t, "SET CLUSTER SETTING sql.explain_analyze.repeat_count = 5")
+
+	fields := func(query string) map[string]string {
+		res := make(map[string]string)
+		for _, row := range r.QueryStr(t, query) {
+			if i := strings.Index(row[0], ": "); i > 0 {
+				res[row[0][:i]] = row[0][i+2:]
+			}
+		}
+		return res
+	}
+
+	f := fields("EXPLAIN ANALYZE (PLAN, REPEAT) SELECT * FROM t WHERE x > 1")
+	if f["executions"] != "5" {
+		t.Errorf("expected 5 executions, got %q", f["executions"])
+	}
+	for _, field := range []string{
+		"execution time p50", "execution time p90", "execution time p99", "execution time variance",
+	} {
+		if _, ok := f[field]; !ok {
+			t.Errorf("expected field %q", field)
+		}
+	}
+
+	// The count given with REPEAT overrides the cluster setting.
+	f = fields("EXPLAIN ANALYZE (PLAN, REPEAT 3) SELECT * FROM t WHERE x > 1")
+	if f["executions"] != "3" {
+		t.Errorf("expected 3 executions, got %q", f["executions"])
+	}
+
+	// The statement is planned again for each run, so plans which can only be
+	// executed once (because of their subqueries, or because they contain
+	// planNodes which are drained by their execution) return the same results
+	// in every run; each query below fails if one of its runs returns a
+	// different count.
+	for _, query := range []string{
+		"SELECT count(*) AS c FROM t WHERE x <= (SELECT max(x) FROM t)",
+		"SELECT count(*) AS c FROM (VALUES (random()), (random()), (random()))",
+		"SELECT count(*) AS c FROM crdb_internal.tables WHERE name = 't'",
+	} {
+		var expected string
+		r.QueryRow(t, query).Scan(&expected)
+		f = fields(fmt.Sprintf(
+			"EXPLAIN ANALYZE (PLAN, REPEAT) SELECT crdb_internal.force_error('XX000', 'wrong count') "+
+				"FROM (%s) WHERE c != %s", query, expected,
+		))
+		if f["executions"] != "5" {
+			t.Errorf("expected 5 executions of %q, got %q", query, f["executions"])
+		}
+	}
+
+	// Statements that can modify data are only executed once.
+	f = fields("EXPLAIN ANALYZE (PLAN, REPEAT) INSERT INTO t VALUES (1)")
+	if _, ok := f["executions"]; ok {
+		t.Errorf("unexpected executions field for a mutation: %q", f["executions"])
+	}
+	r.CheckQueryResults(t, "SELECT count(*) FROM t", [][]string{{"2"}})
+}
+
+func TestExplainAnalyzeLogOutput(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	params, _ := tests.CreateTestServerParams()
+	s, db, _ := serverutils.StartServer(t, params)
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(db)
+	r.Exec(t, "CREATE TABLE t (x INT PRIMARY KEY)")
+	r.Exec(t, "SET CLUSTER SETTING sql.explain_analyze.log_output.enabled = true")
+	r.Exec(t, "EXPLAIN ANALYZE (PLAN) SELECT * FROM t WHERE x > 1")
+
+	log.Flush()
+	entries, err := log.FetchEntriesFromFiles(
+		0, math.MaxInt64, 10000, regexp.MustCompile(`EXPLAIN ANALYZE output`),
+		log.WithFlattenedSensitiveData,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Message, `fingerprint="SELECT * FROM t WHERE x > _"`) &&
+			strings.Contains(e.Message, "• scan") {
+			return
+		}
+	}
+	t.Fatalf("EXPLAIN ANALYZE output not found in log entries: %v", entries)
+}
+
+func TestExplainAnalyzeEncodings(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	params, _ := tests.CreateTestServerParams()
+	s, db, _ := serverutils.StartServer(t, params)
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(db)
+	r.Exec(t, "CREATE TABLE t (x INT PRIMARY KEY)")
+
+	for _, tc := range []struct {
+		encoding  string
+		unmarshal func([]byte, interface{}) error
+	}{
+		{encoding: "JSON", unmarshal: json.Unmarshal},
+		{encoding: "YAML", unmarshal: yaml.Unmarshal},
+	} {
+		t.Run(tc.encoding, func(t *testing.T) {
+			rows := r.QueryStr(t, fmt.Sprintf("EXPLAIN ANALYZE (PLAN, %s) SELECT * FROM t", tc.encoding))
+			if len(rows) != 1 {
+				t.Fatalf("expected a single row, got %v", rows)
+			}
+			var res struct {
+				Fields []struct {
+					Key   string `json:"key" yaml:"key"`
+					Value string `json:"value" yaml:"value"`
+				} `json:"fields" yaml:"fields"`
+				Plan struct {
+					Name string `json:"name" yaml:"name"`
+				} `json:"plan" yaml:"plan"`
+				Warnings []string `json:"warnings" yaml:"warnings"`
+			}
+			if err := tc.unmarshal([]byte(rows[0][0]), &res); err != nil {
+				t.Fatalf("error decoding %s: %v", rows[0][0], err)
+			}
+			if len(res.Fields) == 0 || res.Fields[0].Key != "planning time" {
+				t.Errorf("expected planning time field, got %+v", res.Fields)
+			}
+			if res.Plan.Name != "scan" {
+				t.Errorf("expected scan, got %q", res.Plan.Name)
+			}
+			if len(res.Warnings) == 0 {
+				t.Errorf("expected experimental warning")
+			}
+		})
+	}
+}
+
+// TestExplainAnalyzeTypes verifies that the TYPES option of EXPLAIN ANALYZE
+// (PLAN) shows the types of the columns of each node, with both the text and
+// the structured encodings.
+func TestExplainAnalyzeTypes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	params, _ := tests.CreateTestServerParams()
+	s, db, _ := serverutils.StartServer(t, params)
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(db)
+	r.Exec(t, "CREATE TABLE t (x INT PRIMARY KEY, y STRING)")
+
+	const exp = "columns: (x int, y string)"
+	rows := r.QueryStr(t, "EXPLAIN ANALYZE (PLAN, TYPES) SELECT * FROM t")
+	found := false
+	for _, row := range rows {
+		found = found || strings.TrimSpace(row[0]) == exp
+	}
+	if !found {
+		t.Errorf("expected %q in output:\n%v", exp, rows)
+	}
+
+	rows = r.QueryStr(t, "EXPLAIN ANALYZE (PLAN, TYPES, JSON) SELECT * FROM t")
+	var res struct {
+		Plan struct {
+			Attrs []struct {
+				Key   string `json:"key"`
+				Value string `json:"value"`
+			} `json:"attrs"`
+		} `json:"plan"`
+	}
+	if err := json.Unmarshal([]byte(rows[0][0]), &res); err != nil {
+		t.Fatalf("error decoding %s: %v", rows[0][0], err)
+	}
+	if len(res.Plan.Attrs) == 0 || res.Plan.Attrs[0].Key+": "+res.Plan.Attrs[0].Value != exp {
+		t.Errorf("expected %q as the first attribute, got %+v", exp, res.Plan.Attrs)
+	}
+}
+
+// TestExplainAnalyzeSQL verifies that EXPLAIN ANALYZE (PLAN, SQL) shows the SQL
+// of the statement at the root of the plan, and the SQL of each common table
+// expression at the root of its subquery.
+func TestExplainAnalyzeSQL(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	params, _ := tests.CreateTestServerParams()
+	s, db, _ := serverutils.StartServer(t, params)
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(db)
+	r.Exec(t, "CREATE TABLE t (x INT PRIMARY KEY, y STRING)")
+
+	rows := r.QueryStr(t, `EXPLAIN ANALYZE (PLAN, SQL)
+WITH w AS MATERIALIZED (SELECT x FROM t WHERE y = 'a') SELECT count(*) FROM w`)
+	var out []string
+	for _, row := range rows {
+		out = append(out, strings.TrimSpace(row[0]))
+	}
+	for _, exp := range []string{
+		"sql: SELECT count(*) FROM w",
+		"original sql: SELECT x FROM t WHERE y = 'a'",
+	} {
+		found := false
+		for _, line := range out {
+			found = found || strings.HasSuffix(line, exp)
+		}
+		if !found {
+			t.Errorf("expected %q in output:\n%s", exp, strings.Join(out, "\n"))
+		}
+	}
+
+	// Without the SQL flag, the SQL of the statement is not shown.
+	rows = r.QueryStr(t, "EXPLAIN ANALYZE (PLAN) SELECT count(*) FROM t")
+	for _, row := range rows {
+		if strings.Contains(row[0], "sql: SELECT count(*) FROM t") {
+			t.Errorf("unexpected SQL in output: %s", row[0])
+		}
+	}
+}
+
+// TestExplainAnalyzeHints verifies that EXPLAIN ANALYZE shows the optimizer
+// hints which are in effect in the plan.
+func TestExplainAnalyzeHints(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	params, _ := tests.CreateTestServerParams()
+	s, db, _ := serverutils.StartServer(t, params)
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(db)
+	r.Exec(t, "CREATE TABLE t (x INT PRIMARY KEY, y INT, INDEX y_idx (y))")
+	r.Exec(t, "CREATE TABLE u (x INT PRIMARY KEY)")
+
+	hintsRow := func(query string) string {
+		rows := r.QueryStr(t, query)
+		for _, row := range rows {
+			if line := strings.TrimSpace(row[0]); strings.HasPrefix(line, "hints: ") {
+				return line
+			}
+		}
+		return ""
+	}
+
+	if row := hintsRow(
+		"EXPLAIN ANALYZE (PLAN) SELECT * FROM t@{FORCE_INDEX=y_idx,DESC} INNER HASH JOIN u ON t.x = u.x",
+	); row != "hints: force hash join (store right side); force-index=t@y_idx,rev" {
+		t.Errorf("unexpected hints row: %q", row)
+	}
+
+	// Without hints, no hints are shown.
+	if row := hintsRow("EXPLAIN ANALYZE (PLAN) SELECT * FROM t"); row != "" {
+		t.Errorf("unexpected hints row: %q", row)
+	}
+}
+
+// TestExplainAnalyzePrecise verifies that EXPLAIN ANALYZE (PLAN, PRECISE) shows
+// the durations with nanosecond precision, whereas they are rounded to the
+// microsecond by default.
+func TestExplainAnalyzePrecise(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	params, _ := tests.CreateTestServerParams()
+	s, db, _ := serverutils.StartServer(t, params)
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(db)
+	r.Exec(t, "CREATE TABLE t (x INT PRIMARY KEY)")
+
+	// planningTime returns the planning time shown by the given EXPLAIN ANALYZE
+	// statement.
+	planningTime := func(stmt string) time.Duration {
+		for _, row := range r.QueryStr(t, stmt) {
+			line := strings.TrimSpace(row[0])
+			if strings.HasPrefix(line, "planning time: ") {
+				d, err := time.ParseDuration(strings.TrimPrefix(line, "planning time: "))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return d
+			}
+		}
+		t.Fatalf("no planning time in the output of %s", stmt)
+		return 0
+	}
+
+	// The planning time is rounded to the microsecond by default. With the
+	// PRECISE flag it isn't (except if it happens to be a whole number of
+	// microseconds, so try a few times).
+	for i := 0; i < 5; i++ {
+		if d := planningTime("EXPLAIN ANALYZE (PLAN) SELECT * FROM t"); d%time.Microsecond != 0 {
+			t.Errorf("expected planning time rounded to the microsecond, got %s", d)
+		}
+	}
+	testutils.SucceedsSoon(t, func() error {
+		if d := planningTime("EXPLAIN ANALYZE (PLAN, PRECISE) SELECT * FROM t"); d%time.Microsecond == 0 {
+			return fmt.Errorf("expected planning time with nanosecond precision, got %s", d)
+		}
+		return nil
+	})
+}
+
+// TestPlanGistBuiltin verifies that crdb_internal.plan_gist and
+// crdb_internal.plan_info plan a statement without executing it.
+func TestPlanGistBuiltin(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	params, _ := tests.CreateTestServerParams()
+	s, db, _ := serverutils.StartServer(t, params)
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(db)
+	r.Exec(t, "CREATE TABLE t (a INT PRIMARY KEY, b INT, INDEX (b))")
+
+	gist := func(stmt string) string {
+		var g string
+		r.QueryRow(t, "SELECT crdb_internal.plan_gist($1)", stmt).Scan(&g)
+		return g
+	}
+	// Constants don't affect the gist, but the index used by the plan does.
+	a := gist("SELECT * FROM t WHERE b = 1")
+	if b := gist("SELECT * FROM t WHERE b = 2"); a != b {
+		t.Errorf("expected the same gist for both statements, got %s and %s", a, b)
+	}
+	if c := gist("SELECT * FROM t WHERE a = 1"); a == c {
+		t.Errorf("expected different gists, got %s", a)
+	}
+
+	var info string
+	r.QueryRow(t, "SELECT crdb_internal.plan_info('SELECT * FROM t WHERE b = 1')").Scan(&info)
+	var decoded struct {
+		Gist         string `json:"gist"`
+		Distribution string `json:"distribution"`
+		Vectorized   bool   `json:"vectorized"`
+	}
+	if err := json.Unmarshal([]byte(info), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Gist != a || decoded.Distribution == "" {
+		t.Errorf("unexpected plan info %s", info)
+	}
+
+	// The statement is not executed.
+	gist("INSERT INTO t VALUES (1, 1)")
+	r.CheckQueryResults(t, "SELECT count(*) FROM t", [][]string{{"0"}})
+
+	r.ExpectErr(t, "cannot plan CREATE TABLE statement",
+		"SELECT crdb_internal.plan_gist('CREATE TABLE u (x INT)')")
+}
+
+// TestExplainAnalyzeDebugTenant verifies that the bundles collected by a tenant
+// are stored in the system tables of that tenant, and that they are not
+// visible to the system tenant or to other tenants.
+func TestExplainAnalyzeDebugTenant(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	params, _ := tests.CreateTestServerParams()
+	s, db, _ := serverutils.StartServer(t, params)
+	defer s.Stopper().Stop(ctx)
+
+	startTenant := func(id uint64) *gosql.DB {
+		return serverutils.StartTenant(t, s, base.TestTenantArgs{TenantID: roachpb.MakeTenantID(id)})
+	}
+	tenantDB := startTenant(10)
+	defer tenantDB.Close()
+	otherTenantDB := startTenant(11)
+	defer otherTenantDB.Close()
+
+	tenant := sqlutils.MakeSQLRunner(tenantDB)
+	tenant.Exec(t, "EXPLAIN ANALYZE (DEBUG) SELECT 1")
+
+	const query = "SELECT count(*) FROM system.statement_diagnostics WHERE statement = 'SELECT 1'"
+	tenant.CheckQueryResults(t, query, [][]string{{"1"}})
+	sqlutils.MakeSQLRunner(db).CheckQueryResults(t, query, [][]string{{"0"}})
+	sqlutils.MakeSQLRunner(otherTenantDB).CheckQueryResults(t, query, [][]string{{"0"}})
+}
@@ -74,7 +74,7 @@ func (n *explainVecNode) startExec(params runParams) error {
 
 	distSQLPlanner.FinalizePlan(planCtx, physPlan)
 	flows := physPlan.GenerateFlowSpecs()
-	flowCtx := newFlowCtxForExplainPurposes(planCtx, params)
+	flowCtx := newFlowCtxForExplainPurposes(planCtx, params.p)
 	flowCtx.Cfg.ClusterID = &distSQLPlanner.rpcCtx.ClusterID
 
 	// We want to get the vectorized plan which would be executed with the
@@ -87,6 +87,20 @@ func (n *explainVecNode) startExec(params runParams) error {
 		return errors.New("vectorize is set to 'off'")
 	}
 
+	verbose := n.options.Flags[tree.ExplainFlagVerbose]
+	n.run.lines, err = explainVec(params.ctx, flowCtx, flows, !willDistribute, verbose)
+	return err
+}
+
+// explainVec returns the lines of the EXPLAIN (VEC) output for the given flows,
+// which are converted to trees of vectorized operators.
+func explainVec(
+	ctx context.Context,
+	flowCtx *execinfra.FlowCtx,
+	flows map[roachpb.NodeID]*execinfrapb.FlowSpec,
+	isPlanLocal bool,
+	verbose bool,
+) ([]string, error) {
 	sortedFlows := make([]flowWithNode, 0, len(flows))
 	for nodeID, flow := range flows {
 		sortedFlows = append(sortedFlows, flowWithNode{nodeID: nodeID, flow: flow})
@@ -95,13 +109,12 @@ func (n *explainVecNode) startExec(params runParams) error {
 	sort.Slice(sortedFlows, func(i, j int) bool { return sortedFlows[i].nodeID < sortedFlows[j].nodeID })
 	tp := treeprinter.NewWithStyle(treeprinter.CompactStyle)
 	root := tp.Child("│")
-	verbose := n.options.Flags[tree.ExplainFlagVerbose]
 	for _, flow := range sortedFlows {
 		node := root.Childf("Node %d", flow.nodeID)
-		opChains, cleanup, err := colflow.ConvertToVecTree(params.ctx, flowCtx, flow.flow, !willDistribute)
+		opChains, cleanup, err := colflow.ConvertToVecTree(ctx, flowCtx, flow.flow, isPlanLocal)
 		defer cleanup()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		// It is possible that when iterating over execinfra.OpNodes we will hit
 		// a panic (an input that doesn't implement OpNode interface), so we're
@@ -111,24 +124,23 @@ func (n *explainVecNode) startExec(params runParams) error {
 				formatOpChain(op, node, verbose)
 			}
 		}); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	n.run.lines = tp.FormattedRows()
-	return nil
+	return tp.FormattedRows(), nil
 }
 
-func newFlowCtxForExplainPurposes(planCtx *PlanningCtx, params runParams) *execinfra.FlowCtx {
+func newFlowCtxForExplainPurposes(planCtx *PlanningCtx, p *planner) *execinfra.FlowCtx {
 	return &execinfra.FlowCtx{
 		NodeID:  planCtx.EvalContext().NodeID,
 		EvalCtx: planCtx.EvalContext(),
 		Cfg: &execinfra.ServerConfig{
-			Settings:       params.p.execCfg.Settings,
+			Settings:       p.execCfg.Settings,
 			DiskMonitor:    &mon.BytesMonitor{},
-			VecFDSemaphore: params.p.execCfg.DistSQLSrv.VecFDSemaphore,
+			VecFDSemaphore: p.execCfg.DistSQLSrv.VecFDSemaphore,
 		},
 		TypeResolverFactory: &descs.DistSQLTypeResolverFactory{
-			Descriptors: params.p.Descriptors(),
+			Descriptors: p.Descriptors(),
 		},
 	}
 }
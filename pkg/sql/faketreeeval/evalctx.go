@@ -22,6 +22,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/types"
 	"github.com/cockroachdb/cockroach/pkg/util/errorutil/unimplemented"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
 	"github.com/cockroachdb/errors"
 	"github.com/lib/pq/oid"
 )
@@ -121,6 +122,35 @@ func (ep *DummyEvalPlanner) EvalSubquery(expr *tree.Subquery) (tree.Datum, error
 	return nil, errors.WithStack(errEvalPlanner)
 }
 
+// RequestStmtDiagnostics is part of the tree.EvalPlanner interface.
+func (ep *DummyEvalPlanner) RequestStmtDiagnostics(ctx context.Context, fingerprint string) error {
+	return errors.WithStack(errEvalPlanner)
+}
+
+// CancelStmtDiagnosticsRequest is part of the tree.EvalPlanner interface.
+func (ep *DummyEvalPlanner) CancelStmtDiagnosticsRequest(
+	ctx context.Context, requestID int64,
+) error {
+	return errors.WithStack(errEvalPlanner)
+}
+
+// StatementDiagnosticsHistory is part of the tree.EvalPlanner interface.
+func (ep *DummyEvalPlanner) StatementDiagnosticsHistory() (json.JSON, error) {
+	return nil, errors.WithStack(errEvalPlanner)
+}
+
+// StatementBundleURL is part of the tree.EvalPlanner interface.
+func (ep *DummyEvalPlanner) StatementBundleURL(bundleID int64) (string, error) {
+	return "", errors.WithStack(errEvalPlanner)
+}
+
+// PlanInfo is part of the tree.EvalPlanner interface.
+func (ep *DummyEvalPlanner) PlanInfo(
+	ctx context.Context, sql string,
+) (gist, distribution string, vectorized bool, _ error) {
+	return "", "", false, errors.WithStack(errEvalPlanner)
+}
+
 // ResolveTypeByOID implements the tree.TypeReferenceResolver interface.
 func (ep *DummyEvalPlanner) ResolveTypeByOID(_ context.Context, _ oid.Oid) (*types.T, error) {
 	return nil, errors.WithStack(errEvalPlanner)
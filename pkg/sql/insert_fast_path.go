@@ -314,6 +314,7 @@ func (n *insertFastPathNode) BatchedNext(params runParams) (bool, error) {
 
 	// Possibly initiate a run of CREATE STATISTICS.
 	params.ExecCfg().StatsRefresher.NotifyMutation(n.run.ti.ri.Helper.TableDesc.GetID(), len(n.input))
+	recordRowsWritten(params.ctx, n.run.ti.ri.Helper.TableDesc.GetID(), len(n.input))
 
 	return true, nil
 }
@@ -11,23 +11,121 @@
 package sql
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/build"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
 	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/kv"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/server/telemetry"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/colexec/colbuilder"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
+	"github.com/cockroachdb/cockroach/pkg/sql/execstats"
+	"github.com/cockroachdb/cockroach/pkg/sql/opt/cat"
+	"github.com/cockroachdb/cockroach/pkg/sql/opt/exec"
 	"github.com/cockroachdb/cockroach/pkg/sql/opt/exec/explain"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgnotice"
 	"github.com/cockroachdb/cockroach/pkg/sql/physicalplan"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sqltelemetry"
+	"github.com/cockroachdb/cockroach/pkg/sql/stats"
 	"github.com/cockroachdb/cockroach/pkg/sql/stmtdiagnostics"
+	"github.com/cockroachdb/cockroach/pkg/util/cancelchecker"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+	"github.com/cockroachdb/errors"
+	"github.com/gogo/protobuf/jsonpb"
+	yaml "gopkg.in/yaml.v2"
 )
 
+// fullScanWarningFraction is the fraction of a table's rows (according to the
+// latest table statistics) that a statement has to read from KV in order for
+// the read to be reported as a full scan.
+var fullScanWarningFraction = settings.RegisterPositiveFloatSetting(
+	"sql.explain_analyze.full_scan_warning_fraction",
+	"fraction of the rows of a table that a statement has to read for EXPLAIN ANALYZE "+
+		"to warn about a full scan of the table",
+	0.9,
+)
+
+// explainAnalyzeLogOutput causes the output of EXPLAIN ANALYZE (PLAN) to also be
+// written to the log, for tooling that can scrape logs but can't consume result
+// rows.
+var explainAnalyzeLogOutput = settings.RegisterBoolSetting(
+	"sql.explain_analyze.log_output.enabled",
+	"if set, the output of EXPLAIN ANALYZE (PLAN) statements is also written to the log, "+
+		"along with the statement fingerprint",
+	false,
+)
+
+// explainAnalyzeExperimentalWarning controls whether the output of EXPLAIN
+// ANALYZE (PLAN) ends with experimentalWarning.
+var explainAnalyzeExperimentalWarning = settings.RegisterBoolSetting(
+	"sql.explain_analyze.experimental_warning.enabled",
+	"if set, the output of EXPLAIN ANALYZE (PLAN) statements includes a warning that "+
+		"the statement is experimental",
+	true,
+)
+
+// experimentalWarning is the warning included in the output of EXPLAIN ANALYZE
+// (PLAN) when sql.explain_analyze.experimental_warning.enabled is set.
+const experimentalWarning = "WARNING: this statement is experimental!"
+
+// explainAnalyzeRepeatCount is the number of times a statement is executed by
+// EXPLAIN ANALYZE (PLAN, REPEAT) when no count is given.
+var explainAnalyzeRepeatCount = settings.RegisterPositiveIntSetting(
+	"sql.explain_analyze.repeat_count",
+	"number of times the statement is executed by EXPLAIN ANALYZE (PLAN, REPEAT) "+
+		"when no count is given with REPEAT",
+	10,
+)
+
+// collectAllStatementBundlesMaxBundles and collectAllStatementBundlesMaxBytes
+// limit the bundles collected in a session because of the
+// collect_all_statement_bundles session variable.
+var collectAllStatementBundlesMaxBundles = settings.RegisterPositiveIntSetting(
+	"sql.stmt_diagnostics.collect_all.max_bundles",
+	"maximum number of bundles collected in a session because of the "+
+		"collect_all_statement_bundles session variable",
+	100,
+)
+
+var collectAllStatementBundlesMaxBytes = settings.RegisterByteSizeSetting(
+	"sql.stmt_diagnostics.collect_all.max_bytes",
+	"maximum total size of the bundles collected in a session because of the "+
+		"collect_all_statement_bundles session variable",
+	64<<20, /* 64 MiB */
+)
+
+// sessionBundleState tracks the bundles collected in a session because of the
+// collect_all_statement_bundles session variable.
+type sessionBundleState struct {
+	// stmtIndex is the index of the last statement executed in the session
+	// while collect_all_statement_bundles was set.
+	stmtIndex int
+	// numBundles and numBytes are the number and total size of the bundles
+	// collected so far.
+	numBundles int
+	numBytes   int64
+}
+
 // instrumentationHelper encapsulates the logic around extracting information
 // about the execution of a statement, like bundles and traces. Typical usage:
 //
@@ -38,14 +136,41 @@ import (
 //
 //  - SetDiscardRows(), ShouldDiscardRows(), ShouldCollectBundle(),
 //    ShouldBuildExplainPlan(), RecordExplainPlan(), RecordPlanInfo(),
-//    PlanForStats() can be called at any point during execution.
+//    PlanForStats(), BuildPlanTree() can be called at any point during
+//    execution.
 //
 //  - Finish() is called after query execution.
 //
 type instrumentationHelper struct {
 	outputMode outputMode
-	// explainFlags is used when outputMode is explainAnalyzePlanOutput.
-	explainFlags explain.Flags
+	// outputEncoding and explainFlags are used when outputMode is
+	// explainAnalyzePlanOutput.
+	outputEncoding outputEncoding
+	explainFlags   explain.Flags
+
+	// retryCount is the number of automatic retries of the transaction that
+	// preceded this execution of the statement, and retryCauses describes
+	// their causes. See SetRetries.
+	retryCount  int
+	retryCauses []string
+
+	// committedByExecution is set if the implicit transaction of the statement
+	// was committed by the execution of the statement itself (one-phase
+	// commit), rather than by a separate auto-commit. See
+	// SetCommittedByExecution.
+	committedByExecution bool
+
+	// admissionPriority is the priority (or queue) under which the statement
+	// was admitted by the AdmissionController, if any. See
+	// SetAdmissionPriority.
+	admissionPriority string
+
+	// customPhases contains the time spent in each custom phase in which the
+	// statement spent time (see RecordCustomPhase).
+	customPhases struct {
+		syncutil.Mutex
+		durations map[CustomPhase]time.Duration
+	}
 
 	// Query fingerprint (anonymized statement).
 	fingerprint string
@@ -68,17 +193,129 @@ type instrumentationHelper struct {
 	finishCollectionDiagnostics func()
 	withStatementTrace          func(trace tracing.Recording, stmt string)
 
+	// spanFilters, if set, restricts the trace in the bundle to the spans with
+	// an operation name that starts with one of these prefixes. It is set by
+	// the diagnostics request.
+	spanFilters []string
+	// verbosity determines how much of the execution is recorded in the trace.
+	// It is set by the diagnostics request; the zero value corresponds to
+	// stmtdiagnostics.TraceVerbosityFull.
+	verbosity stmtdiagnostics.TraceVerbosity
+	// logVerbosity, if positive, is the verbosity level up to which the messages
+	// logged with log.VInfof during the execution of the statement are recorded
+	// in the trace. It is set by the diagnostics request.
+	logVerbosity int
+	// tags are the tags attached to the diagnostics request, if any; they are
+	// included in the metadata.json file of the bundle.
+	tags map[string]string
+	// planOnly is set if the only reason for instrumenting the statement is a
+	// bundle requested with stmtdiagnostics.TraceVerbosityPlanOnly; the
+	// statement is not traced and sp remains nil.
+	planOnly bool
+
+	// bundleID and traceID identify the diagnostics bundle collected by
+	// Finish, if any, and the trace it contains. They are reported to
+	// ExecutorConfig.StatementExemplarCallback by reportExemplar.
+	bundleID stmtdiagnostics.CollectedInstanceID
+	traceID  uint64
+
 	sp      *tracing.Span
 	origCtx context.Context
 	evalCtx *tree.EvalContext
 
+	// stacksLabel is the value of the stacksLabelKey pprof label which is set
+	// on the goroutines executing the statement when we are collecting a
+	// bundle. It allows the stacks of these goroutines to be found if the
+	// statement is canceled.
+	stacksLabel string
+	// sessionBundles is set if the bundle is collected because of the
+	// collect_all_statement_bundles session variable; sessionStmtIndex is the
+	// index of the statement in the session.
+	sessionBundles   *sessionBundleState
+	sessionStmtIndex int
+	// stmtHistory is set if lightweight diagnostics for the statement are
+	// retained because of the statement_diagnostics_history_size session
+	// variable.
+	stmtHistory *stmtDiagnosticsHistory
+
+	// overhead is the time spent in Setup() and Finish(); it is only set if
+	// the statement is instrumented (i.e. Setup() returned needFinish=true).
+	overhead time.Duration
+
+	// cpuProfile contains the CPU profile recorded during the execution of the
+	// statement, if bundleCPUProfileEnabled is set. See startCPUProfile.
+	cpuProfile struct {
+		settings *cluster.Settings
+		buf      *bytes.Buffer
+		data     []byte
+	}
+
+	// stacks contains the goroutine stacks captured by CaptureStacks.
+	stacks struct {
+		syncutil.Mutex
+		captured string
+	}
+
+	// jobsBefore is the number of jobs queued by the transaction before the
+	// statement started; the jobs queued after that were created by the
+	// statement.
+	jobsBefore int
+	// stmtJobs contains the jobs created by the statement which are not queued
+	// by the transaction (see RecordStatementJob).
+	stmtJobs struct {
+		syncutil.Mutex
+		jobs []stmtJob
+	}
+
 	// If savePlanForStats is true, the explainPlan will be collected and returned
 	// via PlanForStats().
 	savePlanForStats bool
 
-	explainPlan  *explain.Plan
+	explainPlan *explain.Plan
+	// hints are the descriptions of the optimizer hints in effect in
+	// explainPlan (see RecordHints).
+	hints        []string
 	distribution physicalplan.PlanDistribution
-	vectorized   bool
+	// notDistributedReason is the reason for which the plan was not
+	// distributed, if that is the case.
+	notDistributedReason string
+	vectorized           bool
+
+	// repeatLatencies contains the execution latency of each run of the
+	// statement, if it was executed multiple times because of
+	// EXPLAIN ANALYZE (PLAN, REPEAT). See RecordRepeatedExecutions.
+	repeatLatencies []time.Duration
+	// repeating is set while the statement is planned and executed again
+	// because of EXPLAIN ANALYZE (PLAN, REPEAT). See SetRepeating.
+	repeating bool
+
+	// waitTimes is the breakdown of the time spent waiting on conflicting
+	// requests and transactions, shown by EXPLAIN ANALYZE (PLAN).
+	waitTimes execstats.WaitTimes
+	// networkBytesSent is the number of bytes sent over the network by the
+	// flows of the statement, shown by EXPLAIN ANALYZE (PLAN, JSON_SUMMARY).
+	// networkBytesSentPartial is set if it was computed from a partial trace.
+	networkBytesSent        int64
+	networkBytesSentPartial bool
+
+	// phaseTimeSource is the PhaseTimeSource testing knob. See
+	// OverridePhaseTimes.
+	phaseTimeSource func(fingerprint string) *PhaseDurations
+
+	// fingerprintAnonymizer is the FingerprintAnonymizer of the ExecutorConfig.
+	// See exportedFingerprint.
+	fingerprintAnonymizer FingerprintAnonymizer
+
+	// wrappedNodes contains the planNodes which were executed by vectorized
+	// flows; the value is true if at least one of the processors of the node
+	// was executed by wrapping a row execution processor. It is populated by
+	// RecordWrappedProcessors.
+	wrappedNodes map[planNode]bool
+	// rowConversions is the number of conversions from columnar batches to rows
+	// in the vectorized flows of the statement, i.e. the number of inputs of
+	// wrapped row execution processors. It is populated by
+	// RecordWrappedProcessors.
+	rowConversions int
 }
 
 // outputMode indicates how the statement output needs to be populated (for
@@ -91,15 +328,62 @@ const (
 	explainAnalyzePlanOutput
 )
 
+// outputEncoding indicates how the output of EXPLAIN ANALYZE (PLAN) is encoded.
+type outputEncoding int8
+
+const (
+	// textEncoding is the human-readable tree of the plan, with one row per
+	// line.
+	textEncoding outputEncoding = iota
+	// jsonEncoding is a single row with a JSON representation of the plan.
+	jsonEncoding
+	// yamlEncoding is a single row with a YAML representation of the plan.
+	yamlEncoding
+)
+
 // SetOutputMode can be called before Setup, if we are running an EXPLAIN
 // ANALYZE variant.
-func (ih *instrumentationHelper) SetOutputMode(outputMode outputMode, explainFlags explain.Flags) {
+func (ih *instrumentationHelper) SetOutputMode(
+	outputMode outputMode, outputEncoding outputEncoding, explainFlags explain.Flags,
+) {
 	ih.outputMode = outputMode
+	ih.outputEncoding = outputEncoding
 	ih.explainFlags = explainFlags
 }
 
+// SetRetries can be called before Setup to record the number of automatic
+// retries that preceded this execution of the statement, along with their
+// causes.
+func (ih *instrumentationHelper) SetRetries(count int, causes []string) {
+	ih.retryCount = count
+	ih.retryCauses = causes
+}
+
+// SetAdmissionPriority records the priority under which the statement was
+// admitted by the AdmissionController. It must be called before Finish.
+func (ih *instrumentationHelper) SetAdmissionPriority(priority string) {
+	ih.admissionPriority = priority
+}
+
+// SetCommittedByExecution records that the implicit transaction of the
+// statement was committed by the execution of the statement, so that no time
+// was spent committing it afterwards. It must be called before Finish.
+func (ih *instrumentationHelper) SetCommittedByExecution() {
+	ih.committedByExecution = true
+}
+
+// retriesDescription returns a description of the automatic retries that
+// preceded this execution of the statement, of the form "N (causes: ...)".
+func (ih *instrumentationHelper) retriesDescription() string {
+	if len(ih.retryCauses) == 0 {
+		return strconv.Itoa(ih.retryCount)
+	}
+	return fmt.Sprintf("%d (causes: %s)", ih.retryCount, strings.Join(ih.retryCauses, "; "))
+}
+
 // Setup potentially enables snowball tracing for the statement, depending on
-// output mode or statement diagnostic activation requests. Finish() must be
+// output mode or statement diagnostic activation requests. preparedName is the
+// name under which the statement was prepared, if any. Finish() must be
 // called after the statement finishes execution (unless needFinish=false, in
 // which case Finish() is a no-op).
 func (ih *instrumentationHelper) Setup(
@@ -108,12 +392,30 @@ func (ih *instrumentationHelper) Setup(
 	appStats *appStats,
 	p *planner,
 	stmtDiagnosticsRecorder *stmtdiagnostics.Registry,
+	sessionBundles *sessionBundleState,
+	stmtHistory *stmtDiagnosticsHistory,
 	fingerprint string,
+	preparedName string,
 	implicitTxn bool,
 ) (newCtx context.Context, needFinish bool) {
 	ih.fingerprint = fingerprint
 	ih.implicitTxn = implicitTxn
 	ih.codec = cfg.Codec
+	ih.phaseTimeSource = cfg.TestingKnobs.PhaseTimeSource
+	ih.fingerprintAnonymizer = cfg.FingerprintAnonymizer
+
+	if ih.outputMode == unmodifiedOutput && !stmtDiagnosticsRecorder.HasPendingRequests() &&
+		!p.SessionData().CollectAllStatementBundles &&
+		p.SessionData().StatementDiagnosticsHistorySize == 0 &&
+		cfg.TestingKnobs.WithStatementTrace == nil {
+		// Fast path for the common case where nothing is being collected for the
+		// statement; this avoids the locking in ShouldCollectDiagnostics. The
+		// only remaining question is whether the plan should be sampled for the
+		// statement statistics, which is gated by a cluster setting.
+		ih.savePlanForStats = appStats.shouldSaveLogicalPlanDescription(fingerprint, implicitTxn)
+		return ctx, false
+	}
+	start := timeutil.Now()
 
 	switch ih.outputMode {
 	case explainAnalyzeDebugOutput:
@@ -129,23 +431,559 @@ func (ih *instrumentationHelper) Setup(
 
 	default:
 		ih.collectBundle, ih.diagRequestID, ih.finishCollectionDiagnostics =
-			stmtDiagnosticsRecorder.ShouldCollectDiagnostics(ctx, fingerprint)
+			stmtDiagnosticsRecorder.ShouldCollectDiagnostics(
+				ctx, fingerprint, preparedName, p.SessionData().User(), p.SessionData().Database,
+			)
+		if ih.diagRequestID != 0 {
+			ih.spanFilters = stmtDiagnosticsRecorder.SpanFilters(ih.diagRequestID)
+			ih.verbosity = stmtDiagnosticsRecorder.Verbosity(ih.diagRequestID)
+			ih.logVerbosity = stmtDiagnosticsRecorder.LogVerbosity(ih.diagRequestID)
+			ih.tags = stmtDiagnosticsRecorder.Tags(ih.diagRequestID)
+		}
+		if p.SessionData().CollectAllStatementBundles {
+			sessionBundles.stmtIndex++
+			sv := &cfg.Settings.SV
+			if !ih.collectBundle &&
+				int64(sessionBundles.numBundles) < collectAllStatementBundlesMaxBundles.Get(sv) &&
+				sessionBundles.numBytes < collectAllStatementBundlesMaxBytes.Get(sv) {
+				ih.collectBundle = true
+				ih.sessionBundles = sessionBundles
+				ih.sessionStmtIndex = sessionBundles.stmtIndex
+			}
+		}
 	}
 
 	ih.withStatementTrace = cfg.TestingKnobs.WithStatementTrace
+	if p.SessionData().StatementDiagnosticsHistorySize > 0 {
+		ih.stmtHistory = stmtHistory
+	}
 
 	ih.savePlanForStats = appStats.shouldSaveLogicalPlanDescription(fingerprint, implicitTxn)
 
-	if !ih.collectBundle && ih.withStatementTrace == nil && ih.outputMode == unmodifiedOutput {
+	if !ih.collectBundle && ih.withStatementTrace == nil && ih.stmtHistory == nil &&
+		ih.outputMode == unmodifiedOutput {
 		return ctx, false
 	}
 
 	ih.origCtx = ctx
 	ih.evalCtx = p.EvalContext()
-	newCtx, ih.sp = tracing.StartSnowballTrace(ctx, cfg.AmbientCtx.Tracer, "traced statement")
+	ih.planOnly = ih.collectBundle && ih.verbosity == stmtdiagnostics.TraceVerbosityPlanOnly &&
+		ih.withStatementTrace == nil && ih.stmtHistory == nil && ih.outputMode == unmodifiedOutput
+	if ih.planOnly {
+		// The bundle is built from the plan alone; skip the overhead of tracing
+		// the execution.
+		ih.overhead = timeutil.Since(start)
+		return ctx, true
+	}
+	recType := tracing.SnowballRecording
+	if ih.verbosity == stmtdiagnostics.TraceVerbositySummary ||
+		(!ih.collectBundle && ih.withStatementTrace == nil && ih.outputMode == unmodifiedOutput) {
+		// Only record the spans on the gateway node. This is also sufficient for
+		// the lightweight diagnostics of the statement history.
+		recType = tracing.SingleNodeRecording
+	}
+	newCtx, ih.sp = tracing.StartRecordingTrace(
+		ctx, cfg.AmbientCtx.Tracer, "traced statement", recType,
+	)
+	if ih.logVerbosity > 0 {
+		// Record the messages logged at the requested verbosity in the trace,
+		// for this statement only.
+		newCtx = log.WithTraceVerbosity(newCtx, log.Level(ih.logVerbosity))
+	}
+	if ih.retryCount > 0 {
+		log.Eventf(newCtx, "previous attempts of the statement were retried: %s", ih.retriesDescription())
+	}
+	if ih.collectBundle {
+		// Label the goroutines executing the statement (including the goroutines
+		// of local flows, which inherit the label) so that their stacks can be
+		// included in the bundle if the statement is canceled.
+		ih.stacksLabel = strconv.FormatInt(atomic.AddInt64(&stacksLabelCounter, 1), 10)
+		newCtx = pprof.WithLabels(newCtx, pprof.Labels(stacksLabelKey, ih.stacksLabel))
+		pprof.SetGoroutineLabels(newCtx)
+		if bundleCPUProfileEnabled.Get(&cfg.Settings.SV) {
+			ih.startCPUProfile(ctx, cfg.Settings)
+		}
+		if queued := p.extendedEvalCtx.Jobs; queued != nil {
+			ih.jobsBefore = len(*queued)
+		}
+	}
+	ih.overhead = timeutil.Since(start)
 	return newCtx, true
 }
 
+// sessionBundleName returns the name of a bundle collected because of the
+// collect_all_statement_bundles session variable, or the empty string if the
+// bundle is collected for a different reason.
+func (ih *instrumentationHelper) sessionBundleName() string {
+	if ih.sessionBundles == nil {
+		return ""
+	}
+	return fmt.Sprintf("stmt-%d", ih.sessionStmtIndex)
+}
+
+// stacksLabelKey is the pprof label used to find the goroutines executing a
+// statement for which a bundle is collected.
+const stacksLabelKey = "stmt.diag"
+
+// stacksLabelCounter is used to generate unique values for the stacksLabelKey
+// label.
+var stacksLabelCounter int64
+
+// bundleCPUProfileEnabled controls whether bundles include a CPU profile
+// covering the execution of the statement. See startCPUProfile.
+var bundleCPUProfileEnabled = settings.RegisterBoolSetting(
+	"sql.stmt_diagnostics.cpu_profile.enabled",
+	"if enabled, statement diagnostics bundles include a CPU profile recorded during "+
+		"the execution of the statement (cpu.pprof); only one statement is profiled "+
+		"at a time on each node",
+	false,
+)
+
+// startCPUProfile starts recording a CPU profile for the statement, which is
+// stopped by stopCPUProfile and included in the bundle. CPU profiles are
+// process-wide, so the profile also contains samples of other goroutines; the
+// samples of the goroutines executing the statement are those with the
+// stacksLabelKey pprof label set to stacksLabel (e.g. pprof -tagfocus). If a
+// CPU profile is already being recorded (for another statement, or through the
+// debug pages), no profile is recorded for the statement.
+func (ih *instrumentationHelper) startCPUProfile(ctx context.Context, st *cluster.Settings) {
+	if err := st.SetCPUProfiling(cluster.CPUProfileWithLabels); err != nil {
+		log.VEventf(ctx, 1, "not recording a CPU profile for the statement: %v", err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		log.VEventf(ctx, 1, "not recording a CPU profile for the statement: %v", err)
+		_ = st.SetCPUProfiling(cluster.CPUProfileNone)
+		return
+	}
+	ih.cpuProfile.settings = st
+	ih.cpuProfile.buf = &buf
+}
+
+// stopCPUProfile stops the CPU profile started by startCPUProfile, if any.
+func (ih *instrumentationHelper) stopCPUProfile() {
+	if ih.cpuProfile.buf == nil {
+		return
+	}
+	pprof.StopCPUProfile()
+	_ = ih.cpuProfile.settings.SetCPUProfiling(cluster.CPUProfileNone)
+	ih.cpuProfile.data = ih.cpuProfile.buf.Bytes()
+	ih.cpuProfile.buf = nil
+}
+
+// CaptureStacks captures the stacks of the goroutines executing the statement,
+// for inclusion in the bundle. It is a no-op if we are not collecting a bundle.
+// It is called when the statement times out, before the statement is canceled;
+// it can be called concurrently with the execution of the statement.
+func (ih *instrumentationHelper) CaptureStacks() {
+	if ih.stacksLabel == "" {
+		return
+	}
+	stacks := goroutineStacksWithLabel(stacksLabelKey, ih.stacksLabel)
+	ih.stacks.Lock()
+	defer ih.stacks.Unlock()
+	ih.stacks.captured = stacks
+}
+
+// stacksForBundle returns the goroutine stacks to include in the bundle, if the
+// statement was canceled or timed out.
+func (ih *instrumentationHelper) stacksForBundle(res RestrictedCommandResult) string {
+	ih.stacks.Lock()
+	defer ih.stacks.Unlock()
+	if ih.stacks.captured != "" {
+		return ih.stacks.captured
+	}
+	if err := res.Err(); err != nil && ih.stacksLabel != "" &&
+		(ih.origCtx.Err() != nil || errors.Is(err, cancelchecker.QueryCanceledError)) {
+		// The statement was canceled without CaptureStacks being called. The
+		// goroutines of the statement have likely exited by now, but any
+		// goroutines that are stuck are still of interest.
+		return goroutineStacksWithLabel(stacksLabelKey, ih.stacksLabel)
+	}
+	return ""
+}
+
+// stmtJob identifies a job created by a statement.
+type stmtJob struct {
+	jobID int64
+	// inStmtTxn is set if the job was created in the transaction of the
+	// statement (in which case it is not yet committed when the bundle is
+	// built).
+	inStmtTxn bool
+}
+
+// RecordStatementJob records that the statement created the given job, so
+// that the job can be described in the bundle. It only needs to be called for
+// jobs that are not queued through extendedEvalContext.QueueJob. It can be
+// called concurrently with the execution of the statement.
+func (ih *instrumentationHelper) RecordStatementJob(jobID int64, inStmtTxn bool) {
+	if !ih.collectBundle {
+		return
+	}
+	ih.stmtJobs.Lock()
+	defer ih.stmtJobs.Unlock()
+	ih.stmtJobs.jobs = append(ih.stmtJobs.jobs, stmtJob{jobID: jobID, inStmtTxn: inStmtTxn})
+}
+
+// RecordCustomPhase records that the statement spent the given duration in the
+// given custom phase. A phase can be recorded multiple times, in which case
+// the durations add up. It can be called concurrently with the execution of
+// the statement.
+func (ih *instrumentationHelper) RecordCustomPhase(phase CustomPhase, d time.Duration) {
+	ih.customPhases.Lock()
+	defer ih.customPhases.Unlock()
+	if ih.customPhases.durations == nil {
+		ih.customPhases.durations = make(map[CustomPhase]time.Duration)
+	}
+	ih.customPhases.durations[phase] += d
+}
+
+// forEachCustomPhase calls fn for each custom phase recorded for the statement,
+// in the order in which the phases were registered.
+func (ih *instrumentationHelper) forEachCustomPhase(fn func(phase CustomPhase, d time.Duration)) {
+	ih.customPhases.Lock()
+	defer ih.customPhases.Unlock()
+	if len(ih.customPhases.durations) == 0 {
+		return
+	}
+	for i := range customPhaseNames {
+		if d, ok := ih.customPhases.durations[CustomPhase(i)]; ok {
+			fn(CustomPhase(i), d)
+		}
+	}
+}
+
+// createsJobs returns whether the given statement is of a kind that can create
+// long-running jobs.
+func createsJobs(ast tree.Statement) bool {
+	switch ast.(type) {
+	case *tree.Backup, *tree.Restore, *tree.Import:
+		return true
+	}
+	return tree.CanModifySchema(ast)
+}
+
+// jobsForBundle returns a description of the jobs created by the statement
+// (including their payload and progress), for inclusion in the bundle. It
+// returns the empty string if the statement did not create any jobs.
+func (ih *instrumentationHelper) jobsForBundle(
+	ctx context.Context, cfg *ExecutorConfig, p *planner, ast tree.Statement,
+) string {
+	if ast == nil || !createsJobs(ast) {
+		return ""
+	}
+	var stmtJobs []stmtJob
+	if queued := p.extendedEvalCtx.Jobs; queued != nil && len(*queued) > ih.jobsBefore {
+		for _, jobID := range (*queued)[ih.jobsBefore:] {
+			stmtJobs = append(stmtJobs, stmtJob{jobID: jobID, inStmtTxn: true})
+		}
+	}
+	ih.stmtJobs.Lock()
+	stmtJobs = append(stmtJobs, ih.stmtJobs.jobs...)
+	ih.stmtJobs.Unlock()
+
+	var buf bytes.Buffer
+	for _, j := range stmtJobs {
+		var txn *kv.Txn
+		if j.inStmtTxn {
+			txn = p.txn
+		}
+		writeJobInfo(ctx, &buf, cfg.JobRegistry, j.jobID, txn)
+	}
+	return buf.String()
+}
+
+// writeJobInfo writes a description of the given job, read using the given
+// transaction (if not nil).
+func writeJobInfo(
+	ctx context.Context, buf *bytes.Buffer, registry *jobs.Registry, jobID int64, txn *kv.Txn,
+) {
+	fmt.Fprintf(buf, "job %d\n", jobID)
+	job, err := registry.LoadJobWithTxn(ctx, jobID, txn)
+	if err != nil {
+		fmt.Fprintf(buf, "error loading job: %v\n\n", err)
+		return
+	}
+	payload := job.Payload()
+	progress := job.Progress()
+	fmt.Fprintf(buf, "type: %s\n", payload.Type())
+	fmt.Fprintf(buf, "description: %s\n", payload.Description)
+	if status, err := job.WithTxn(txn).CurrentStatus(ctx); err != nil {
+		fmt.Fprintf(buf, "status: error: %v\n", err)
+	} else {
+		fmt.Fprintf(buf, "status: %s\n", status)
+	}
+	fmt.Fprintf(buf, "fraction completed: %.2f\n", job.FractionCompleted())
+	marshaller := jsonpb.Marshaler{Indent: "  "}
+	if str, err := marshaller.MarshalToString(&payload); err != nil {
+		fmt.Fprintf(buf, "payload: error: %v\n", err)
+	} else {
+		fmt.Fprintf(buf, "payload:\n%s\n", str)
+	}
+	if str, err := marshaller.MarshalToString(&progress); err != nil {
+		fmt.Fprintf(buf, "progress: error: %v\n", err)
+	} else {
+		fmt.Fprintf(buf, "progress:\n%s\n", str)
+	}
+	buf.WriteString("\n")
+}
+
+// rangesForBundle returns a description of the ranges scanned by the
+// statement, grouped by table and index, along with the node holding the lease
+// for each range, for inclusion in the bundle. The ranges are looked up in the
+// range descriptor cache of the gateway, so they reflect what the gateway knew
+// when the statement finished; ranges that are not in the cache are omitted.
+// It returns the empty string if the statement did not scan any tables.
+func (ih *instrumentationHelper) rangesForBundle(
+	ctx context.Context, cfg *ExecutorConfig, p *planner,
+) string {
+	if cfg.RangeDescriptorCache == nil {
+		return ""
+	}
+	var indexes []execstats.IndexSpans
+	for _, flowInfo := range p.curPlan.distSQLFlowInfos {
+		indexes = append(indexes, flowInfo.analyzer.GetScannedSpansByIndex()...)
+	}
+	if len(indexes) == 0 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	for _, idx := range indexes {
+		fmt.Fprintf(&buf, "%s@%s:\n", idx.TableName, idx.IndexName)
+		var rangeIDs []roachpb.RangeID
+		spansPerRange := make(map[roachpb.RangeID]int)
+		leaseholders := make(map[roachpb.RangeID]*roachpb.ReplicaDescriptor)
+		descs := make(map[roachpb.RangeID]*roachpb.RangeDescriptor)
+		for _, span := range idx.Spans {
+			rSpan, err := keys.SpanAddr(span)
+			if err != nil {
+				fmt.Fprintf(&buf, "  error resolving span %s: %v\n", span, err)
+				continue
+			}
+			for _, entry := range cfg.RangeDescriptorCache.GetCachedOverlapping(ctx, rSpan) {
+				desc := entry.Desc()
+				if _, ok := descs[desc.RangeID]; !ok {
+					rangeIDs = append(rangeIDs, desc.RangeID)
+					descs[desc.RangeID] = desc
+					leaseholders[desc.RangeID] = entry.Leaseholder()
+				}
+				spansPerRange[desc.RangeID]++
+			}
+		}
+		if len(rangeIDs) == 0 {
+			buf.WriteString("  no cached ranges\n")
+		}
+		for _, rangeID := range rangeIDs {
+			leaseholder := "unknown"
+			if l := leaseholders[rangeID]; l != nil {
+				leaseholder = l.NodeID.String()
+			}
+			fmt.Fprintf(
+				&buf, "  r%d %s leaseholder: %s (%d spans)\n",
+				rangeID, descs[rangeID].RSpan(), leaseholder, spansPerRange[rangeID],
+			)
+		}
+	}
+	return buf.String()
+}
+
+// statsForBundle returns a description of the table statistics that were used
+// by the optimizer to plan the statement, for inclusion in the bundle: for each
+// table, the creation time and row count of each statistic (most recent first)
+// and whether an automatic refresh of the statistics was overdue. Stale
+// statistics are a common cause of misestimates.
+func (ih *instrumentationHelper) statsForBundle(cfg *ExecutorConfig, p *planner) string {
+	mem := p.curPlan.mem
+	if mem == nil {
+		return ""
+	}
+	now := timeutil.Now()
+	var buf bytes.Buffer
+	seen := make(map[cat.StableID]struct{})
+	for _, tm := range mem.Metadata().AllTables() {
+		tab := tm.Table
+		if _, ok := seen[tab.ID()]; ok || tab.IsVirtualTable() {
+			continue
+		}
+		seen[tab.ID()] = struct{}{}
+		fmt.Fprintf(&buf, "%s:\n", tab.Name())
+		if tab.StatisticCount() == 0 {
+			buf.WriteString("  no statistics\n")
+		}
+		tableStats := make([]*stats.TableStatistic, 0, tab.StatisticCount())
+		for i := 0; i < tab.StatisticCount(); i++ {
+			stat := tab.Statistic(i)
+			name := "<unnamed>"
+			if s, ok := stat.(*optTableStat); ok {
+				tableStats = append(tableStats, s.stat)
+				if s.stat.Name != "" {
+					name = s.stat.Name
+				}
+			}
+			cols := make([]string, stat.ColumnCount())
+			for j := range cols {
+				cols[j] = string(tab.Column(stat.ColumnOrdinal(j)).ColName())
+			}
+			fmt.Fprintf(
+				&buf, "  %s on (%s): created at %s (%s ago), row count: %d\n",
+				name, strings.Join(cols, ", "), stat.CreatedAt().UTC().Format(time.RFC3339),
+				now.Sub(stat.CreatedAt()).Round(time.Second), stat.RowCount(),
+			)
+		}
+		fmt.Fprintf(&buf, "  automatic refresh overdue: %t", stats.AutomaticStatsOverdue(tableStats, now))
+		if !stats.AutomaticStatisticsClusterMode.Get(&cfg.Settings.SV) {
+			buf.WriteString(" (automatic statistics collection is disabled)")
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// statsHistoryForBundle returns the statement statistics collected on this
+// node for the fingerprint of the statement, along with the latencies of this
+// execution, for inclusion in the bundle. This allows determining whether the
+// execution captured by the bundle is an outlier.
+func (ih *instrumentationHelper) statsHistoryForBundle(
+	cfg *ExecutorConfig, appStats *appStats, phaseTimes *phaseTimes, err error,
+) string {
+	if !bundleStatsHistoryEnabled.Get(&cfg.Settings.SV) || appStats == nil {
+		return ""
+	}
+	s, _ := appStats.getStatsForStmt(
+		ih.fingerprint, ih.implicitTxn, err, false, /* createIfNonexistent */
+	)
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	data := s.mu.data
+	s.mu.Unlock()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "executions: %d\n", data.Count)
+	fmt.Fprintf(&buf, "first attempts: %d\n", data.FirstAttemptCount)
+	fmt.Fprintf(&buf, "max retries: %d\n", data.MaxRetries)
+	stddev := func(stat roachpb.NumericStat) float64 {
+		if data.Count < 2 {
+			return 0
+		}
+		return math.Sqrt(stat.GetVariance(data.Count))
+	}
+	latency := func(name string, stat roachpb.NumericStat, this time.Duration) {
+		seconds := func(f float64) time.Duration {
+			return time.Duration(f * float64(time.Second)).Round(time.Microsecond)
+		}
+		fmt.Fprintf(
+			&buf, "%s: mean %s, stddev %s, this execution %s\n",
+			name, seconds(stat.Mean), seconds(stddev(stat)), this.Round(time.Microsecond),
+		)
+	}
+	latency("parse latency", data.ParseLat, phaseTimes.getParsingLatency())
+	latency("plan latency", data.PlanLat, phaseTimes.getPlanningLatency())
+	latency("run latency", data.RunLat, phaseTimes.getRunLatency())
+	count := func(name string, stat roachpb.NumericStat) {
+		fmt.Fprintf(&buf, "%s: mean %.1f, stddev %.1f\n", name, stat.Mean, stddev(stat))
+	}
+	count("rows", data.NumRows)
+	count("rows read", data.RowsRead)
+	count("bytes read", data.BytesRead)
+	return buf.String()
+}
+
+// versionForBundle returns a description of the cluster version, the build of
+// the gateway node and the tenant of the statement, for inclusion in the
+// bundle. A bundle that is reproduced on a different version can result in a
+// different plan.
+func (ih *instrumentationHelper) versionForBundle(ctx context.Context, cfg *ExecutorConfig) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "cluster version: %s\n", cfg.Settings.Version.ActiveVersion(ctx))
+	info := build.GetInfo()
+	fmt.Fprintf(&buf, "build tag: %s\n", info.Tag)
+	fmt.Fprintf(&buf, "build revision: %s\n", info.Revision)
+	fmt.Fprintf(&buf, "build: %s\n", info.Short())
+	if nodeID, ok := cfg.NodeID.OptionalNodeID(); ok {
+		fmt.Fprintf(&buf, "gateway node: %s\n", nodeID)
+	}
+	fmt.Fprintf(&buf, "gateway SQL instance: %s\n", cfg.NodeID.SQLInstanceID())
+	if _, tenID, err := keys.DecodeTenantPrefix(ih.codec.TenantPrefix()); err != nil {
+		fmt.Fprintf(&buf, "tenant: error: %v\n", err)
+	} else {
+		fmt.Fprintf(&buf, "tenant: %s\n", tenID)
+	}
+	return buf.String()
+}
+
+// readTimestampsForBundle returns the read timestamp of the statement and, for
+// historical queries, the resolved AS OF SYSTEM TIME timestamp, formatted as
+// SQL comments for env.sql.
+func (ih *instrumentationHelper) readTimestampsForBundle(p *planner) string {
+	var buf bytes.Buffer
+	if p.txn != nil {
+		fmt.Fprintf(&buf, "-- read timestamp: %s\n", p.txn.ReadTimestamp().AsOfSystemTime())
+	}
+	if asOf := p.semaCtx.AsOfTimestamp; asOf != nil {
+		fmt.Fprintf(&buf, "-- AS OF SYSTEM TIME: %s\n", asOf.AsOfSystemTime())
+	}
+	return buf.String()
+}
+
+// txnForBundle returns the description of the transaction of the statement and
+// of the read and write timestamps used by each of its distributed flows, for
+// txn.txt. All the flows of a statement are expected to read at the same
+// timestamp; different read timestamps indicate a bug, so they are called out.
+func (ih *instrumentationHelper) txnForBundle(
+	p *planner, flowTimestamps []execstats.FlowTimestamps,
+) string {
+	if p.txn == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "txn: %s\n", p.txn.ID())
+	fmt.Fprintf(&buf, "read timestamp: %s\n", p.txn.ReadTimestamp())
+	fmt.Fprintf(&buf, "write timestamp: %s\n", p.txn.ProvisionalCommitTimestamp())
+	if len(flowTimestamps) == 0 {
+		return buf.String()
+	}
+	buf.WriteString("\nflows:\n")
+	consistent := true
+	for _, ts := range flowTimestamps {
+		fmt.Fprintf(
+			&buf, "  n%d: read timestamp: %s, write timestamp: %s\n",
+			ts.NodeID, ts.ReadTimestamp, ts.WriteTimestamp,
+		)
+		if ts.ReadTimestamp != flowTimestamps[0].ReadTimestamp {
+			consistent = false
+		}
+	}
+	if !consistent {
+		buf.WriteString("\nWARNING: the flows used different read timestamps\n")
+	}
+	return buf.String()
+}
+
+// randomSeedsForBundle returns the statements which set the random seeds used
+// by the statement (see tree.EvalContext.RandSeed), for env.sql. Replaying them
+// makes the results of the statement reproducible.
+func (ih *instrumentationHelper) randomSeedsForBundle(p *planner) string {
+	seed, ok := p.EvalContext().RandSeed()
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("SET random_seed = %d;  -- used by random()\n", seed)
+}
+
+// admissionForBundle returns the admission priority of the statement formatted
+// as a SQL comment for env.sql, or an empty string if the statement wasn't
+// admitted under a specific priority.
+func (ih *instrumentationHelper) admissionForBundle() string {
+	if ih.admissionPriority == "" {
+		return ""
+	}
+	return fmt.Sprintf("-- admission priority: %s\n", ih.admissionPriority)
+}
+
 func (ih *instrumentationHelper) Finish(
 	cfg *ExecutorConfig,
 	appStats *appStats,
@@ -156,27 +994,129 @@ func (ih *instrumentationHelper) Finish(
 	res RestrictedCommandResult,
 	retErr error,
 ) error {
-	if ih.sp == nil {
+	if ih.sp == nil && !ih.planOnly {
 		return retErr
 	}
+	start := timeutil.Now()
+	defer func() {
+		ih.overhead += timeutil.Since(start)
+	}()
+
+	if ih.stacksLabel != "" {
+		pprof.SetGoroutineLabels(ih.origCtx)
+	}
+	ih.stopCPUProfile()
 
 	// Record the statement information that we've collected.
 	// Note that in case of implicit transactions, the trace contains the auto-commit too.
-	ih.sp.Finish()
+	var trace tracing.Recording
+	if ih.sp != nil {
+		ih.sp.SetTag("fingerprint", ih.exportedFingerprint())
+		ih.setPhaseTimesTags(&statsCollector.phaseTimes)
+		ih.sp.Finish()
+		trace = ih.sp.GetRecording()
+	}
 	ctx := ih.origCtx
 
-	trace := ih.sp.GetRecording()
 	ie := p.extendedEvalCtx.InternalExecutor.(*InternalExecutor)
 	placeholders := p.extendedEvalCtx.Placeholders
+	traceStats := analyzeTrace(ctx, cfg, p, ast, trace)
+	if ih.diagRequestID != 0 {
+		if cfg.StmtDiagnosticsRecorder.WasCanceled(ih.diagRequestID) {
+			// The request was canceled while the statement was executing; drop the
+			// trace.
+			ih.collectBundle = false
+		} else if !cfg.StmtDiagnosticsRecorder.ShouldFinishCollection(
+			ih.diagRequestID, ih.PlanGist(), traceStats.rowsProcessed(int64(res.RowsAffected())),
+		) {
+			// The request targets a different plan, or more rows than the
+			// statement processed; leave it for a later execution.
+			ih.collectBundle = false
+		}
+	}
+	ih.annotateExecutionEngine()
 	if ih.collectBundle {
-		bundle := buildStatementBundle(
-			ih.origCtx, cfg.DB, ie, &p.curPlan, ih.planStringForBundle(), trace, placeholders,
-		)
-		bundle.insert(ctx, ih.fingerprint, ast, cfg.StmtDiagnosticsRecorder, ih.diagRequestID)
-		if ih.finishCollectionDiagnostics != nil {
-			ih.finishCollectionDiagnostics()
-			telemetry.Inc(sqltelemetry.StatementDiagnosticsCollectedCounter)
+		wait := ih.outputMode == explainAnalyzeDebugOutput
+		if err := cfg.BundleCollectionLimiter.begin(ctx, wait); err != nil {
+			// Too many bundles are being built; leave the request for a later
+			// execution. The statement itself completes normally.
+			log.Infof(ctx, "not collecting diagnostics bundle for %s: %v", ih.fingerprint, err)
+			if ih.diagRequestID != 0 {
+				cfg.StmtDiagnosticsRecorder.PostponeCollection(ih.diagRequestID)
+			}
+			ih.collectBundle = false
+		}
+	}
+	if ih.collectBundle {
+		omitValues := len(redactedColumnNames(&cfg.Settings.SV)) > 0
+		planString := ih.planStringForBundle(omitValues)
+		statsHistory := ih.statsHistoryForBundle(cfg, appStats, &statsCollector.phaseTimes, retErr)
+		bundle := buildStatementBundle(ih.origCtx, cfg.DB, ie, &p.curPlan, bundleInputs{
+			planString:        planString,
+			stmtRawSQL:        stmtRawSQL,
+			fingerprint:       ih.fingerprint,
+			trace:             trace,
+			maxTraceSize:      maxTraceSize.Get(&cfg.Settings.SV),
+			traceFilters:      ih.spanFilters,
+			separateInternal:  separateInternalTrace.Get(&cfg.Settings.SV),
+			verbosity:         ih.verbosity,
+			placeholders:      placeholders,
+			stacks:            ih.stacksForBundle(res),
+			cpuProfile:        ih.cpuProfile.data,
+			jobs:              ih.jobsForBundle(ctx, cfg, p, ast),
+			ranges:            ih.rangesForBundle(ctx, cfg, p),
+			version:           ih.versionForBundle(ctx, cfg),
+			readTimestamps:    ih.readTimestampsForBundle(p),
+			randomSeeds:       ih.randomSeedsForBundle(p),
+			admission:         ih.admissionForBundle(),
+			txn:               ih.txnForBundle(p, traceStats.flowTimestamps),
+			stats:             ih.statsForBundle(cfg, p),
+			statsHistory:      statsHistory,
+			stmtErr:           retErr,
+			tags:              ih.tags,
+			name:              ih.sessionBundleName(),
+			contributors:      cfg.BundleContributors,
+			compressionMethod: uint16(bundleCompression.Get(&cfg.Settings.SV)),
+			overhead:          ih.overhead + timeutil.Since(start),
+			omitValues:        omitValues,
+		})
+		if bundle.wasAbandoned() {
+			// The context was canceled while the bundle was being built, e.g.
+			// because the client disconnected; nobody will read the bundle, so
+			// leave the request for a later execution.
+			log.Infof(ctx, "%v for %s", bundle.collectionErr, ih.fingerprint)
+			if ih.diagRequestID != 0 {
+				cfg.StmtDiagnosticsRecorder.PostponeCollection(ih.diagRequestID)
+			}
+		} else {
+			bundle.traceHash = traceStructuralHash(trace)
+			stmt := tree.AsString(ast)
+			if omitValues {
+				stmt = ih.fingerprint
+			}
+			bundle.insert(ctx, cfg, ih.fingerprint, stmt, ih.diagRequestID)
+			if bundle.collectionErr == nil {
+				ih.bundleID = bundle.diagID
+				if len(trace) > 0 {
+					ih.traceID = trace[0].TraceID
+				}
+			}
+			if ih.sessionBundles != nil {
+				ih.sessionBundles.numBundles++
+				ih.sessionBundles.numBytes += int64(len(bundle.zip))
+				if bundle.collectionErr == nil {
+					p.BufferClientNotice(ctx, pgnotice.Newf(
+						"statement %d: diagnostics bundle %s generated (%s/_admin/v1/stmtbundle/%d)",
+						ih.sessionStmtIndex, ih.sessionBundleName(), cfg.AdminURL(), bundle.diagID,
+					))
+				}
+			}
+			if ih.finishCollectionDiagnostics != nil {
+				ih.finishCollectionDiagnostics()
+				telemetry.Inc(sqltelemetry.StatementDiagnosticsCollectedCounter)
+			}
 		}
+		cfg.BundleCollectionLimiter.finish()
 
 		// Handle EXPLAIN ANALYZE (DEBUG). If there was a communication error
 		// already, no point in setting any results.
@@ -189,45 +1129,472 @@ func (ih *instrumentationHelper) Finish(
 		ih.withStatementTrace(trace, stmtRawSQL)
 	}
 
+	// The statement may have changed statement_diagnostics_history_size.
+	if size := p.SessionData().StatementDiagnosticsHistorySize; ih.stmtHistory != nil && size > 0 {
+		e := stmtDiagnosticsHistoryEntry{
+			fingerprint:     ih.fingerprint,
+			planGist:        ih.PlanGist(),
+			planningLatency: statsCollector.phaseTimes.getPlanningLatency(),
+			runLatency:      statsCollector.phaseTimes.getRunLatency(),
+		}
+		for _, i := range topSpansByDuration(trace, stmtDiagnosticsHistoryMaxSpans) {
+			e.topSpans = append(e.topSpans, stmtDiagnosticsHistorySpan{
+				operation: trace[i].Operation,
+				duration:  trace[i].Duration,
+			})
+		}
+		if retErr != nil {
+			e.err = retErr.Error()
+		}
+		ih.stmtHistory.add(size, e)
+	}
+
 	if ih.outputMode == explainAnalyzePlanOutput && retErr == nil {
 		phaseTimes := &statsCollector.phaseTimes
-		if cfg.TestingKnobs.DeterministicExplainAnalyze {
-			phaseTimes = &deterministicPhaseTimes
+		if !cfg.TestingKnobs.DeterministicExplainAnalyze {
+			ih.waitTimes = traceStats.waitTimes
+			ih.networkBytesSent = traceStats.networkBytesSent
+			ih.networkBytesSentPartial = traceStats.partial
+		} else {
+			traceStats.networkBytesSentByNode = nil
+			traceStats.deserializationTimeByNode = nil
+			traceStats.kvBatchesByNode = nil
+			traceStats.rangesByNode = nil
+			traceStats.execTimeByNode = nil
+		}
+		ih.annotateExecutionStats(&traceStats)
+		if ih.explainFlags.ShowSQL {
+			ih.annotateSourceSQL(ast)
+		}
+		if explainAnalyzeLogOutput.Get(&cfg.Settings.SV) {
+			ih.logExplainAnalyzePlan(ctx, phaseTimes)
+		}
+		var traceSummary []string
+		if p.SessionData().ExplainAnalyzeTraceSummary {
+			traceSummary = traceSummaryRows(trace, traceSummaryMaxSpans)
 		}
-		retErr = ih.setExplainAnalyzePlanResult(ctx, res, phaseTimes)
+		// The plan is truncated after it was logged, since the logged plan is
+		// not subject to the limits of the client.
+		ih.explainFlags.MaxRows = p.SessionData().ExplainAnalyzeMaxRows
+		warnings := traceStats.fullScanWarnings
+		if explainAnalyzeExperimentalWarning.Get(&cfg.Settings.SV) {
+			warnings = append(warnings, experimentalWarning)
+		}
+		retErr = ih.setExplainAnalyzePlanResult(ctx, res, phaseTimes, warnings, traceSummary)
+	}
+
+	if ih.planOnly {
+		// There is no trace to derive statistics from.
+		return retErr
 	}
 
+	// The trace-related statistics are buffered by appStats, which records them
+	// in batches instead of acquiring the lock of the statistics of the
+	// statement here.
 	// TODO(radu): this should be unified with other stmt stats accesses.
-	stmtStats, _ := appStats.getStatsForStmt(ih.fingerprint, ih.implicitTxn, retErr, false)
-	if stmtStats != nil {
-		networkBytesSent := int64(0)
-		for _, flowInfo := range p.curPlan.distSQLFlowInfos {
-			analyzer := flowInfo.analyzer
-			if err := analyzer.AddTrace(trace); err != nil {
-				log.VInfof(ctx, 1, "error analyzing trace statistics for stmt %s: %v", ast, err)
-				continue
+	appStats.recordTraceStats(ih.fingerprint, ih.implicitTxn, retErr, traceStatsUpdate{
+		networkBytesSent:  traceStats.networkBytesSent,
+		fullScan:          len(traceStats.fullScanWarnings) > 0,
+		rowsWritten:       traceStats.rowsWritten(),
+		fullyVectorized:   ih.fullyVectorized(),
+		indexes:           ih.indexesRead(),
+		waitTimes:         traceStats.waitTimes,
+		processorsPerNode: traceStats.processorsPerNode,
+		rowConversions:    int64(ih.rowConversions),
+		rangesScanned:     traceStats.rangesScanned,
+	})
+
+	return retErr
+}
+
+// traceStatsUpdate contains the statistics derived from the trace of one
+// execution of a statement which are recorded in the statistics of the
+// statement.
+type traceStatsUpdate struct {
+	networkBytesSent  int64
+	fullScan          bool
+	rowsWritten       int64
+	fullyVectorized   bool
+	indexes           []string
+	waitTimes         execstats.WaitTimes
+	processorsPerNode map[roachpb.NodeID]int64
+	rowConversions    int64
+	rangesScanned     int64
+}
+
+// apply records the update in the given statistics, whose lock must be held.
+func (u *traceStatsUpdate) apply(data *roachpb.StatementStatistics) {
+	// A count of 1 is passed given that these statistics are only recorded
+	// when statement diagnostics are enabled.
+	// TODO(asubiotto): NumericStat properties will be properly calculated
+	//  once this statistic is always collected.
+	data.BytesSentOverNetwork.Record(1 /* count */, float64(u.networkBytesSent))
+	if u.fullScan {
+		data.FullScan = true
+	}
+	data.RowsWritten.Record(1 /* count */, float64(u.rowsWritten))
+	if u.fullyVectorized {
+		data.FullyVectorized = true
+	}
+	data.AddIndexes(u.indexes)
+	data.LockWaitLat.Record(1 /* count */, u.waitTimes.LockWait.Seconds())
+	data.LatchWaitLat.Record(1 /* count */, u.waitTimes.LatchWait.Seconds())
+	data.TxnQueueWaitLat.Record(1 /* count */, u.waitTimes.TxnQueueWait.Seconds())
+	data.RecordProcessorsPerNode(u.processorsPerNode)
+	data.VectorizedRowConversions.Record(1 /* count */, float64(u.rowConversions))
+	data.RangesScanned.Record(1 /* count */, float64(u.rangesScanned))
+}
+
+// OverridePhaseTimes replaces the measured phase times of the statement with the
+// durations returned by the PhaseTimeSource testing knob, if it is set. The
+// phases are laid out back-to-back starting when the query was received; the
+// concurrency wait, admission wait and the first row times are cleared. The
+// durations of the recorded custom phases are replaced as well. It must be
+// called after the execution of the statement has ended, before the phase
+// times are used, and again after the auto-commit of the transaction (whose
+// phase, if set, follows the execution).
+func (ih *instrumentationHelper) OverridePhaseTimes(phaseTimes *phaseTimes) {
+	if ih.phaseTimeSource == nil {
+		return
+	}
+	d := ih.phaseTimeSource(ih.fingerprint)
+	if d == nil {
+		return
+	}
+	start := phaseTimes[sessionQueryReceived]
+	phaseTimes[sessionStartParse] = start
+	phaseTimes[sessionEndParse] = start.Add(d.Parse)
+	phaseTimes[plannerStartLogicalPlan] = phaseTimes[sessionEndParse]
+	phaseTimes[plannerEndLogicalPlan] = phaseTimes[plannerStartLogicalPlan].Add(d.Plan)
+	phaseTimes[plannerStartConcurrencyWait] = time.Time{}
+	phaseTimes[plannerEndConcurrencyWait] = time.Time{}
+	phaseTimes[plannerStartAdmissionWait] = time.Time{}
+	phaseTimes[plannerEndAdmissionWait] = time.Time{}
+	phaseTimes[plannerStartExecStmt] = phaseTimes[plannerEndLogicalPlan]
+	phaseTimes[plannerFirstRowExecStmt] = time.Time{}
+	phaseTimes[plannerEndExecStmt] = phaseTimes[plannerStartExecStmt].Add(d.Run)
+	if !phaseTimes[plannerStartAutoCommit].IsZero() {
+		phaseTimes[plannerStartAutoCommit] = phaseTimes[plannerEndExecStmt]
+		phaseTimes[plannerEndAutoCommit] = phaseTimes[plannerStartAutoCommit].Add(d.Commit)
+	}
+	ih.customPhases.Lock()
+	defer ih.customPhases.Unlock()
+	for phase := range ih.customPhases.durations {
+		ih.customPhases.durations[phase] = d.Custom[phase.String()]
+	}
+}
+
+// setPhaseTimesTags attaches the durations of the phases of the execution of
+// the statement as tags on the statement span, so that they are available to
+// tracing backends that consume the recording.
+func (ih *instrumentationHelper) setPhaseTimesTags(phaseTimes *phaseTimes) {
+	ih.sp.SetTag("phase.parsing", phaseTimes.getParsingLatency())
+	ih.sp.SetTag("phase.planning", phaseTimes.getPlanningLatency())
+	if wait := phaseTimes.getConcurrencyWaitLatency(); wait > 0 {
+		ih.sp.SetTag("phase.concurrency_wait", wait)
+	}
+	if wait := phaseTimes.getAdmissionWaitLatency(); wait > 0 {
+		ih.sp.SetTag("phase.admission_wait", wait)
+	}
+	ih.sp.SetTag("phase.execution", phaseTimes.getRunLatency())
+	if firstRow := phaseTimes.getFirstRowLatency(); firstRow > 0 {
+		ih.sp.SetTag("phase.first_row", firstRow)
+	}
+	if commit := phaseTimes.getAutoCommitLatency(); commit > 0 {
+		ih.sp.SetTag("phase.commit", commit)
+	}
+	ih.forEachCustomPhase(func(phase CustomPhase, d time.Duration) {
+		ih.sp.SetTag("phase."+phase.String(), d)
+	})
+}
+
+// traceStats contains statistics derived from the trace of a statement.
+type traceStats struct {
+	networkBytesSent int64
+	// partial is set if the trace could only be partially analyzed (e.g.
+	// because it was truncated), in which case the statistics only account for
+	// the parts of the trace which were analyzed.
+	partial bool
+	// fullScanWarnings contains a warning for each table of which the statement
+	// read at least sql.explain_analyze.full_scan_warning_fraction of the rows.
+	fullScanWarnings []string
+	// rowsWrittenByTable contains the number of rows written to each table by
+	// mutations.
+	rowsWrittenByTable map[descpb.ID]int64
+	// rowCountByNode contains the number of rows produced by each planNode whose
+	// output was recorded during execution.
+	rowCountByNode map[planNode]int64
+	// networkBytesSentByNode contains the number of bytes of the output of each
+	// planNode that were sent over the network to the next stage of the plan.
+	// Traffic which can't be attributed to a planNode is only included in
+	// networkBytesSent.
+	networkBytesSentByNode map[planNode]int64
+	// deserializationTimeByNode contains the time spent deserializing the
+	// output of each planNode that was sent over the network.
+	deserializationTimeByNode map[planNode]time.Duration
+	// kvBatchesByNode contains the number of KV batches sent by each planNode
+	// and the number of RPCs they required.
+	kvBatchesByNode map[planNode]execstats.KVBatchStats
+	// kvRowsReadByNode contains the number of rows read from KV by each
+	// planNode which is executed by TableReaders.
+	kvRowsReadByNode map[planNode]int64
+	// rangesByNode contains the distinct ranges that the KV batches of each
+	// planNode were sent to.
+	rangesByNode map[planNode]map[roachpb.RangeID]struct{}
+	// rangesScanned is the number of distinct ranges that the KV batches of
+	// the statement were sent to.
+	rangesScanned int64
+	// kvRowsRead is the number of rows read from KV by the TableReaders of the
+	// statement.
+	kvRowsRead int64
+	// execTimeByNode contains the time spent executing each planNode, for the
+	// planNodes executed by processors which record their execution time.
+	execTimeByNode map[planNode]time.Duration
+	// waitTimes is the breakdown of the time spent waiting on locks, latches
+	// and in the txn wait queue.
+	waitTimes execstats.WaitTimes
+	// processorsPerNode contains the number of processors of the physical plans
+	// of the statement assigned to each node.
+	processorsPerNode map[roachpb.NodeID]int64
+	// flowTimestamps contains the timestamps used by each flow of the physical
+	// plans of the statement, in the order of the plans.
+	flowTimestamps []execstats.FlowTimestamps
+}
+
+// rowsWritten returns the total number of rows written by mutations.
+func (s *traceStats) rowsWritten() int64 {
+	var res int64
+	for _, n := range s.rowsWrittenByTable {
+		res += n
+	}
+	return res
+}
+
+// rowsProcessed returns the number of rows processed by the statement, given
+// the number of rows it returned (or affected): the largest of the number of
+// rows it read from KV, wrote and returned.
+func (s *traceStats) rowsProcessed(rowsReturned int64) int64 {
+	res := rowsReturned
+	if s.kvRowsRead > res {
+		res = s.kvRowsRead
+	}
+	if n := s.rowsWritten(); n > res {
+		res = n
+	}
+	return res
+}
+
+// forEachOutputProcessor calls fn for each processor of the flow that produces
+// the output of a planNode, along with that planNode. It is used to attribute
+// the statistics of the processors to the nodes of the explain plan.
+func (f *flowInfo) forEachOutputProcessor(fn func(node planNode, id execinfrapb.ProcessorID)) {
+	for node, procs := range f.outputProcessors {
+		for _, id := range procs {
+			fn(node, id)
+		}
+	}
+}
+
+// analyzeTrace extracts statistics from the trace of a statement, using the
+// flows that were saved during its execution.
+func analyzeTrace(
+	ctx context.Context,
+	cfg *ExecutorConfig,
+	p *planner,
+	ast tree.Statement,
+	trace tracing.Recording,
+) traceStats {
+	res := traceStats{waitTimes: execstats.GetWaitTimes(trace)}
+	rowsReadByTable := make(map[descpb.ID]*execstats.TableReadStats)
+	rangesScanned := make(map[roachpb.RangeID]struct{})
+	for i, flowInfo := range p.curPlan.distSQLFlowInfos {
+		analyzer := flowInfo.analyzer
+		for nodeID, n := range analyzer.GetProcessorsPerNode() {
+			if res.processorsPerNode == nil {
+				res.processorsPerNode = make(map[roachpb.NodeID]int64)
 			}
+			res.processorsPerNode[nodeID] += n
+		}
+		if err := analyzer.AddTrace(trace); err != nil {
+			// The spans which could be analyzed are still taken into account, so
+			// that the statistics are populated on a best-effort basis.
+			log.VInfof(ctx, 1, "error analyzing trace statistics for stmt %s: %v", ast, err)
+		}
+		if analyzer.Partial() {
+			res.partial = true
+		}
+		res.flowTimestamps = append(res.flowTimestamps, analyzer.GetFlowTimestamps()...)
+		if i == 0 {
+			// Writes are not associated with a flow; each analyzer is given the
+			// entire trace, so we only need to look at the first one.
+			res.rowsWrittenByTable = analyzer.GetRowsWrittenByTable()
+		}
 
-			networkBytesSentGroupedByNode, err := analyzer.GetNetworkBytesSent()
-			if err != nil {
-				log.VInfof(ctx, 1, "error calculating network bytes sent for stmt %s: %v", ast, err)
-				continue
+		if len(flowInfo.outputProcessors) > 0 {
+			outputRows := analyzer.GetProcessorOutputRows()
+		nodes:
+			for node, procs := range flowInfo.outputProcessors {
+				var rows int64
+				for _, id := range procs {
+					n, ok := outputRows[id]
+					if !ok {
+						// Don't report a partial count.
+						continue nodes
+					}
+					rows += n
+				}
+				if res.rowCountByNode == nil {
+					res.rowCountByNode = make(map[planNode]int64)
+				}
+				res.rowCountByNode[node] += rows
+			}
+		}
+
+		kvBatchesByProcessor := analyzer.GetKVBatchesByProcessor()
+		flowInfo.forEachOutputProcessor(func(node planNode, id execinfrapb.ProcessorID) {
+			if b, ok := kvBatchesByProcessor[id]; ok {
+				if res.kvBatchesByNode == nil {
+					res.kvBatchesByNode = make(map[planNode]execstats.KVBatchStats)
+				}
+				nodeBatches := res.kvBatchesByNode[node]
+				nodeBatches.BatchCount += b.BatchCount
+				nodeBatches.RoundTrips += b.RoundTrips
+				nodeBatches.FollowerReads += b.FollowerReads
+				nodeBatches.FollowerReadFallbacks += b.FollowerReadFallbacks
+				res.kvBatchesByNode[node] = nodeBatches
+			}
+		})
+
+		rangeIDsByProcessor := analyzer.GetRangeIDsByProcessor()
+		for _, rangeIDs := range rangeIDsByProcessor {
+			for _, rangeID := range rangeIDs {
+				rangesScanned[rangeID] = struct{}{}
 			}
+		}
+		flowInfo.forEachOutputProcessor(func(node planNode, id execinfrapb.ProcessorID) {
+			for _, rangeID := range rangeIDsByProcessor[id] {
+				if res.rangesByNode == nil {
+					res.rangesByNode = make(map[planNode]map[roachpb.RangeID]struct{})
+				}
+				if res.rangesByNode[node] == nil {
+					res.rangesByNode[node] = make(map[roachpb.RangeID]struct{})
+				}
+				res.rangesByNode[node][rangeID] = struct{}{}
+			}
+		})
+
+		if kvRowsReadByProcessor, err := analyzer.GetKVRowsReadByProcessor(); err != nil {
+			log.VInfof(ctx, 1, "error calculating KV rows read for stmt %s: %v", ast, err)
+		} else {
+			flowInfo.forEachOutputProcessor(func(node planNode, id execinfrapb.ProcessorID) {
+				if n, ok := kvRowsReadByProcessor[id]; ok {
+					if res.kvRowsReadByNode == nil {
+						res.kvRowsReadByNode = make(map[planNode]int64)
+					}
+					res.kvRowsReadByNode[node] += n
+				}
+			})
+		}
+
+		execTimeByProcessor := analyzer.GetExecTimeByProcessor()
+		flowInfo.forEachOutputProcessor(func(node planNode, id execinfrapb.ProcessorID) {
+			if d, ok := execTimeByProcessor[id]; ok {
+				if res.execTimeByNode == nil {
+					res.execTimeByNode = make(map[planNode]time.Duration)
+				}
+				res.execTimeByNode[node] += d
+			}
+		})
+
+		if networkBytesSentGroupedByNode, err := analyzer.GetNetworkBytesSent(); err != nil {
+			log.VInfof(ctx, 1, "error calculating network bytes sent for stmt %s: %v", ast, err)
+		} else {
 			for _, bytesSentByNode := range networkBytesSentGroupedByNode {
-				networkBytesSent += bytesSentByNode
+				res.networkBytesSent += bytesSentByNode
 			}
 		}
+		if bytesSentByProcessor, err := analyzer.GetNetworkBytesSentByProcessor(); err != nil {
+			log.VInfof(ctx, 1, "error calculating network bytes sent for stmt %s: %v", ast, err)
+		} else {
+			flowInfo.forEachOutputProcessor(func(node planNode, id execinfrapb.ProcessorID) {
+				if n, ok := bytesSentByProcessor[id]; ok {
+					if res.networkBytesSentByNode == nil {
+						res.networkBytesSentByNode = make(map[planNode]int64)
+					}
+					res.networkBytesSentByNode[node] += n
+				}
+			})
+		}
+
+		deserializationTimeByProcessor := analyzer.GetNetworkDeserializationTimeByProcessor()
+		flowInfo.forEachOutputProcessor(func(node planNode, id execinfrapb.ProcessorID) {
+			if d, ok := deserializationTimeByProcessor[id]; ok {
+				if res.deserializationTimeByNode == nil {
+					res.deserializationTimeByNode = make(map[planNode]time.Duration)
+				}
+				res.deserializationTimeByNode[node] += d
+			}
+		})
 
-		stmtStats.mu.Lock()
-		// Record trace-related statistics. A count of 1 is passed given that this
-		// statistic is only recorded when statement diagnostics are enabled.
-		// TODO(asubiotto): NumericStat properties will be properly calculated
-		//  once this statistic is always collected.
-		stmtStats.mu.data.BytesSentOverNetwork.Record(1 /* count */, float64(networkBytesSent))
-		stmtStats.mu.Unlock()
+		flowRowsReadByTable, err := analyzer.GetKVRowsReadByTable()
+		if err != nil {
+			log.VInfof(ctx, 1, "error calculating KV rows read for stmt %s: %v", ast, err)
+			continue
+		}
+		for tableID, flowStats := range flowRowsReadByTable {
+			if stats, ok := rowsReadByTable[tableID]; ok {
+				stats.KVRowsRead += flowStats.KVRowsRead
+			} else {
+				rowsReadByTable[tableID] = flowStats
+			}
+		}
+	}
+	res.fullScanWarnings = getFullScanWarnings(ctx, cfg, rowsReadByTable)
+	res.rangesScanned = int64(len(rangesScanned))
+	for _, stats := range rowsReadByTable {
+		res.kvRowsRead += stats.KVRowsRead
 	}
+	return res
+}
 
-	return retErr
+// getFullScanWarnings returns a warning for each table of which at least
+// sql.explain_analyze.full_scan_warning_fraction of the rows were read. The
+// number of rows in a table is taken from its most recent statistics; tables
+// without statistics are never reported.
+func getFullScanWarnings(
+	ctx context.Context,
+	cfg *ExecutorConfig,
+	rowsReadByTable map[descpb.ID]*execstats.TableReadStats,
+) []string {
+	if cfg.TableStatsCache == nil || len(rowsReadByTable) == 0 {
+		return nil
+	}
+	tableIDs := make([]descpb.ID, 0, len(rowsReadByTable))
+	for tableID := range rowsReadByTable {
+		tableIDs = append(tableIDs, tableID)
+	}
+	sort.Slice(tableIDs, func(i, j int) bool { return tableIDs[i] < tableIDs[j] })
+
+	fraction := fullScanWarningFraction.Get(&cfg.Settings.SV)
+	var warnings []string
+	for _, tableID := range tableIDs {
+		tableStats, err := cfg.TableStatsCache.GetTableStats(ctx, tableID)
+		if err != nil {
+			log.VInfof(ctx, 1, "error retrieving statistics for table %d: %v", tableID, err)
+			continue
+		}
+		if len(tableStats) == 0 || tableStats[0].RowCount == 0 {
+			continue
+		}
+		readStats := rowsReadByTable[tableID]
+		if float64(readStats.KVRowsRead) < fraction*float64(tableStats[0].RowCount) {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf(
+			"WARNING: full scan on table %s read %d rows", readStats.TableName, readStats.KVRowsRead,
+		))
+	}
+	return warnings
 }
 
 // SetDiscardRows should be called when we want to discard rows for a
@@ -247,10 +1614,53 @@ func (ih *instrumentationHelper) ShouldCollectBundle() bool {
 	return ih.collectBundle
 }
 
+// ShouldSaveFlows is true if we should save the flows of the physical plans
+// (and their diagrams), so that the trace of the statement can be analyzed.
+func (ih *instrumentationHelper) ShouldSaveFlows() bool {
+	if ih.repeating {
+		return false
+	}
+	return ih.collectBundle || ih.outputMode == explainAnalyzePlanOutput
+}
+
+// ShouldRepeatExecution returns true if the statement should be executed
+// multiple times, as requested by EXPLAIN ANALYZE (PLAN, REPEAT).
+func (ih *instrumentationHelper) ShouldRepeatExecution() bool {
+	return ih.outputMode == explainAnalyzePlanOutput && ih.explainFlags.Repeat
+}
+
+// RepeatCount returns the number of times the statement should be executed
+// (see ShouldRepeatExecution): the count given with REPEAT, if any, or
+// sql.explain_analyze.repeat_count.
+func (ih *instrumentationHelper) RepeatCount(sv *settings.Values) int {
+	if n := ih.explainFlags.RepeatCount; n > 0 {
+		return n
+	}
+	return int(explainAnalyzeRepeatCount.Get(sv))
+}
+
+// SetRepeating is called with true before the statement is planned and
+// executed again because of EXPLAIN ANALYZE (PLAN, REPEAT), and with false
+// once it has been repeated. The repeated runs are not instrumented, so that
+// the plan and the flows shown are those of the first run.
+func (ih *instrumentationHelper) SetRepeating(repeating bool) {
+	ih.repeating = repeating
+}
+
+// RecordRepeatedExecutions records the execution latency of each run of a
+// statement that was executed multiple times (see ShouldRepeatExecution).
+func (ih *instrumentationHelper) RecordRepeatedExecutions(latencies []time.Duration) {
+	ih.repeatLatencies = latencies
+}
+
 // ShouldBuildExplainPlan returns true if we should build an explain plan and
 // call RecordExplainPlan.
 func (ih *instrumentationHelper) ShouldBuildExplainPlan() bool {
-	return ih.collectBundle || ih.savePlanForStats || ih.outputMode == explainAnalyzePlanOutput
+	if ih.repeating {
+		return false
+	}
+	return ih.collectBundle || ih.savePlanForStats || ih.stmtHistory != nil ||
+		ih.outputMode == explainAnalyzePlanOutput
 }
 
 // RecordExplainPlan records the explain.Plan for this query.
@@ -258,47 +1668,374 @@ func (ih *instrumentationHelper) RecordExplainPlan(explainPlan *explain.Plan) {
 	ih.explainPlan = explainPlan
 }
 
+// RecordHints records the descriptions of the optimizer hints which are in
+// effect in the plan; they are shown by EXPLAIN ANALYZE and in the plan of
+// statement bundles.
+func (ih *instrumentationHelper) RecordHints(hints []string) {
+	ih.hints = hints
+}
+
+// addHintsField adds the recorded optimizer hints, if any, as a top-level
+// field.
+func (ih *instrumentationHelper) addHintsField(ob *explain.OutputBuilder) {
+	if len(ih.hints) > 0 {
+		ob.AddField("hints", strings.Join(ih.hints, "; "))
+	}
+}
+
+// PlanGist returns the gist of the plan recorded with RecordExplainPlan, or the
+// empty string if no plan was recorded.
+func (ih *instrumentationHelper) PlanGist() string {
+	if ih.explainPlan == nil {
+		return ""
+	}
+	return explain.PlanGist(ih.explainPlan)
+}
+
+// PlanChangeCallback is invoked when the plan sampled for the statement
+// statistics of a fingerprint has a different gist (see explain.PlanGist) than
+// the previously sampled plan of the same fingerprint. This allows plan
+// regressions to be detected as they happen.
+type PlanChangeCallback func(ctx context.Context, fingerprint string, oldGist, newGist string)
+
+// StatementExemplar identifies an execution of a statement for which a
+// diagnostics bundle was collected. It allows the bundle and its trace to be
+// attached as an exemplar to the observation of the statement latency metric
+// made for that execution.
+type StatementExemplar struct {
+	// Metric is the name of the statement latency metric.
+	Metric string
+	// Fingerprint is the fingerprint of the statement.
+	Fingerprint string
+	// BundleID is the ID of the bundle in system.statement_diagnostics.
+	BundleID stmtdiagnostics.CollectedInstanceID
+	// TraceID is the ID of the trace included in the bundle; it is zero if the
+	// statement was not traced.
+	TraceID uint64
+	// ServiceLatency is the latency recorded in the metric.
+	ServiceLatency time.Duration
+}
+
+// StatementExemplarCallback is invoked after the execution of a statement for
+// which a diagnostics bundle was collected.
+type StatementExemplarCallback func(ctx context.Context, exemplar StatementExemplar)
+
+// reportExemplar reports the bundle collected by Finish, if any, to
+// cfg.StatementExemplarCallback. metric is the name of the latency metric in
+// which the service latency of the statement was recorded.
+func (ih *instrumentationHelper) reportExemplar(
+	cfg *ExecutorConfig, metric string, serviceLatency time.Duration,
+) {
+	cb := cfg.StatementExemplarCallback
+	if cb == nil || ih.bundleID == 0 {
+		return
+	}
+	cb(ih.origCtx, StatementExemplar{
+		Metric:         metric,
+		Fingerprint:    ih.fingerprint,
+		BundleID:       ih.bundleID,
+		TraceID:        ih.traceID,
+		ServiceLatency: serviceLatency,
+	})
+}
+
+// FingerprintAnonymizer transforms a statement fingerprint before it is
+// exported outside of the SQL layer, for example to an external telemetry
+// system. Even though fingerprints don't contain any constants, the names of
+// the tables and columns they refer to can be sensitive; an anonymizer would
+// typically hash or map these identifiers. It must map a given fingerprint
+// consistently to the same result, so that exported data can still be
+// aggregated by fingerprint.
+type FingerprintAnonymizer func(fingerprint string) string
+
+// exportedFingerprint returns the fingerprint of the statement as it should be
+// exported outside of the SQL layer, i.e. transformed by the configured
+// FingerprintAnonymizer, if any.
+func (ih *instrumentationHelper) exportedFingerprint() string {
+	if ih.fingerprintAnonymizer == nil {
+		return ih.fingerprint
+	}
+	return ih.fingerprintAnonymizer(ih.fingerprint)
+}
+
+// recordPlanGist records the gist of the plan of the statement, if it was
+// sampled, in the plan history of the fingerprint. If the gist differs from the
+// gist of the previously sampled plan of the fingerprint, cfg.PlanChangeCallback
+// is invoked. It should be called after the statement statistics were recorded.
+func (ih *instrumentationHelper) recordPlanGist(
+	ctx context.Context, cfg *ExecutorConfig, appStats *appStats, err error,
+) {
+	newGist := ih.PlanGist()
+	if newGist == "" {
+		return
+	}
+	oldGist := appStats.recordPlanGist(ih.fingerprint, ih.implicitTxn, err, newGist, timeutil.Now())
+	if cfg.PlanChangeCallback != nil && oldGist != "" && oldGist != newGist {
+		cfg.PlanChangeCallback(ctx, ih.exportedFingerprint(), oldGist, newGist)
+	}
+}
+
 // RecordPlanInfo records top-level information about the plan.
 func (ih *instrumentationHelper) RecordPlanInfo(
-	distribution physicalplan.PlanDistribution, vectorized bool,
+	distribution physicalplan.PlanDistribution, notDistributedReason string, vectorized bool,
 ) {
 	ih.distribution = distribution
+	ih.notDistributedReason = notDistributedReason
 	ih.vectorized = vectorized
 }
 
+// RecordWrappedProcessors records, for each planNode that owns processors in
+// the given vectorized flows, whether any of these processors is executed by
+// wrapping a row execution processor (as opposed to a native columnar
+// operator), along with the number of inputs of wrapped processors, each of
+// which requires the conversion of columnar batches to rows. It can be called
+// multiple times (e.g. for subqueries).
+func (ih *instrumentationHelper) RecordWrappedProcessors(
+	processorOwners map[interface{}]planNode, flows map[roachpb.NodeID]*execinfrapb.FlowSpec,
+) {
+	if ih.wrappedNodes == nil {
+		ih.wrappedNodes = make(map[planNode]bool)
+	}
+	for _, flow := range flows {
+		for i := range flow.Processors {
+			proc := &flow.Processors[i]
+			wrapped := colbuilder.IsWrapped(proc)
+			if wrapped {
+				ih.rowConversions += len(proc.Input)
+			}
+			owner, ok := processorOwners[proc.Core.GetValue()]
+			if !ok {
+				// Processors added during the finalization of the plan (like
+				// synchronizers) are not owned by any node.
+				continue
+			}
+			ih.wrappedNodes[owner] = ih.wrappedNodes[owner] || wrapped
+		}
+	}
+}
+
+// fullyVectorized returns true if the plan was executed by the vectorized
+// engine without wrapping any row execution processors.
+func (ih *instrumentationHelper) fullyVectorized() bool {
+	if !ih.vectorized {
+		return false
+	}
+	for _, wrapped := range ih.wrappedNodes {
+		if wrapped {
+			return false
+		}
+	}
+	return true
+}
+
 // PlanForStats returns the plan as an ExplainTreePlanNode tree, if it was
 // collected (nil otherwise). It should be called after RecordExplainPlan() and
 // RecordPlanInfo().
 func (ih *instrumentationHelper) PlanForStats(ctx context.Context) *roachpb.ExplainTreePlanNode {
-	if ih.explainPlan == nil {
+	planTree, err := ih.BuildPlanTree(explain.Flags{HideValues: true})
+	if err != nil {
+		log.Warningf(ctx, "unable to emit explain plan tree: %v", err)
 		return nil
 	}
+	return planTree
+}
 
-	ob := explain.NewOutputBuilder(explain.Flags{
-		HideValues: true,
-	})
-	if err := emitExplain(ob, ih.evalCtx, ih.codec, ih.explainPlan, ih.distribution, ih.vectorized); err != nil {
-		log.Warningf(ctx, "unable to emit explain plan tree: %v", err)
-		return nil
+// BuildPlanTree returns the plan as an ExplainTreePlanNode tree, emitted with
+// the given flags. It returns nil if no plan was collected. It should be
+// called after RecordExplainPlan() and RecordPlanInfo().
+func (ih *instrumentationHelper) BuildPlanTree(
+	flags explain.Flags,
+) (*roachpb.ExplainTreePlanNode, error) {
+	if ih.explainPlan == nil {
+		return nil, nil
 	}
-	return ob.BuildProtoTree()
+
+	ob := explain.NewOutputBuilder(flags)
+	if err := emitExplain(
+		ob, ih.evalCtx, ih.codec, ih.explainPlan, ih.distribution, ih.notDistributedReason, ih.vectorized,
+	); err != nil {
+		return nil, err
+	}
+	return ob.BuildProtoTree(), nil
 }
 
 // planStringForBundle generates the plan tree as a string; used internally for bundles.
-func (ih *instrumentationHelper) planStringForBundle() string {
+func (ih *instrumentationHelper) planStringForBundle(hideValues bool) string {
 	if ih.explainPlan == nil {
 		return ""
 	}
 	ob := explain.NewOutputBuilder(explain.Flags{
-		Verbose:   true,
-		ShowTypes: true,
+		Verbose:    true,
+		ShowTypes:  true,
+		HideValues: hideValues,
 	})
-	if err := emitExplain(ob, ih.evalCtx, ih.codec, ih.explainPlan, ih.distribution, ih.vectorized); err != nil {
+	ih.addHintsField(ob)
+	if err := emitExplain(
+		ob, ih.evalCtx, ih.codec, ih.explainPlan, ih.distribution, ih.notDistributedReason, ih.vectorized,
+	); err != nil {
 		return fmt.Sprintf("error emitting plan: %v", err)
 	}
 	return ob.BuildString()
 }
 
+// annotateExecutionStats annotates the nodes in the explain plan with the
+// statistics gathered from the execution of the statement, so that they are
+// shown by EXPLAIN ANALYZE: the number of rows produced by each node (when it
+// was recorded), the number of bytes of its output sent over the network and
+// the time spent deserializing them, the number of KV batches it sent and rows
+// it read, the time spent executing it, and the number of rows written by each
+// mutation node.
+func (ih *instrumentationHelper) annotateExecutionStats(stats *traceStats) {
+	if ih.explainPlan == nil {
+		return
+	}
+	var walk func(n *explain.Node)
+	walk = func(n *explain.Node) {
+		var s exec.ExecutionStats
+		if pn, isPlanNode := n.WrappedNode().(planNode); isPlanNode {
+			s.RowCount, s.RowCountValid = stats.rowCountByNode[pn]
+			s.NetworkBytesSent, s.NetworkBytesSentValid = stats.networkBytesSentByNode[pn]
+			s.NetworkDeserializationTime, s.NetworkDeserializationTimeValid =
+				stats.deserializationTimeByNode[pn]
+			var b execstats.KVBatchStats
+			b, s.KVBatchCountValid = stats.kvBatchesByNode[pn]
+			s.KVBatchCount, s.KVRoundTrips = b.BatchCount, b.RoundTrips
+			s.KVFollowerReads, s.KVFollowerReadFallbacks = b.FollowerReads, b.FollowerReadFallbacks
+			s.KVRowsRead, s.KVRowsReadValid = stats.kvRowsReadByNode[pn]
+			if ranges, ok := stats.rangesByNode[pn]; ok {
+				s.RangesScanned, s.RangesScannedValid = int64(len(ranges)), true
+			}
+			s.ExecTime, s.ExecTimeValid = stats.execTimeByNode[pn]
+		}
+		if table := n.MutatedTable(); table != nil {
+			s.RowsWritten = stats.rowsWrittenByTable[descpb.ID(table.ID())]
+			s.RowsWrittenValid = true
+		}
+		if s.RowCountValid || s.RowsWrittenValid || s.NetworkBytesSentValid ||
+			s.NetworkDeserializationTimeValid || s.KVBatchCountValid || s.KVRowsReadValid ||
+			s.RangesScannedValid || s.ExecTimeValid {
+			n.Annotate(exec.ExecutionStatsID, &s)
+		}
+		for i := 0; i < n.ChildCount(); i++ {
+			walk(n.Child(i))
+		}
+	}
+	walk(ih.explainPlan.Root)
+	for i := range ih.explainPlan.Subqueries {
+		walk(ih.explainPlan.Subqueries[i].Root.(*explain.Node))
+	}
+	for _, n := range ih.explainPlan.Checks {
+		walk(n)
+	}
+}
+
+// indexesRead returns the indexes read by the executed plan, in the form
+// tableID@indexID.
+func (ih *instrumentationHelper) indexesRead() []string {
+	if ih.explainPlan == nil {
+		return nil
+	}
+	var indexes []string
+	var walk func(n *explain.Node)
+	walk = func(n *explain.Node) {
+		n.IndexesRead(func(table cat.Table, index cat.Index) {
+			indexes = append(indexes, fmt.Sprintf("%d@%d", table.ID(), index.ID()))
+		})
+		for i := 0; i < n.ChildCount(); i++ {
+			walk(n.Child(i))
+		}
+	}
+	walk(ih.explainPlan.Root)
+	for i := range ih.explainPlan.Subqueries {
+		walk(ih.explainPlan.Subqueries[i].Root.(*explain.Node))
+	}
+	for _, n := range ih.explainPlan.Checks {
+		walk(n)
+	}
+	return indexes
+}
+
+// annotateSourceSQL annotates the root of the explain plan with the SQL of the
+// statement, for EXPLAIN ANALYZE (PLAN, SQL). The WITH clause of the statement
+// is omitted, since each common table expression is planned as a separate
+// subquery which already shows its original SQL.
+func (ih *instrumentationHelper) annotateSourceSQL(ast tree.Statement) {
+	if ih.explainPlan == nil {
+		return
+	}
+	switch t := ast.(type) {
+	case *tree.Select:
+		if t.With != nil {
+			stmt := *t
+			stmt.With = nil
+			ast = &stmt
+		}
+	case *tree.Insert:
+		if t.With != nil {
+			stmt := *t
+			stmt.With = nil
+			ast = &stmt
+		}
+	case *tree.Update:
+		if t.With != nil {
+			stmt := *t
+			stmt.With = nil
+			ast = &stmt
+		}
+	case *tree.Delete:
+		if t.With != nil {
+			stmt := *t
+			stmt.With = nil
+			ast = &stmt
+		}
+	}
+	ih.explainPlan.Root.Annotate(exec.SourceSQLID, &exec.SourceSQL{
+		SQL: tree.AsStringWithFlags(ast, tree.FmtSimple),
+	})
+}
+
+// annotateExecutionEngine annotates the nodes in the explain plan with the
+// engine that executed them, so that it is shown by EXPLAIN ANALYZE and in
+// bundles. This is only done for vectorized plans which wrap some row
+// execution processors; in other cases the engine of all nodes is already
+// evident from the "vectorized" field of the plan.
+//
+// Nodes which don't own any processors (for example, filters which are merged
+// into the post-processing stage of their input) are considered to be executed
+// by the same engine as their first child which does; if there is no such
+// child, they inherit the engine of their parent.
+func (ih *instrumentationHelper) annotateExecutionEngine() {
+	if ih.explainPlan == nil || ih.fullyVectorized() || len(ih.wrappedNodes) == 0 {
+		return
+	}
+	// walk annotates the subtree rooted at n and returns whether n was wrapped;
+	// ok is false if the engine of n could not be determined from its subtree.
+	var walk func(n *explain.Node, parentWrapped bool) (wrapped bool, ok bool)
+	walk = func(n *explain.Node, parentWrapped bool) (wrapped bool, ok bool) {
+		if pn, isPlanNode := n.WrappedNode().(planNode); isPlanNode {
+			wrapped, ok = ih.wrappedNodes[pn]
+		}
+		if !ok {
+			wrapped = parentWrapped
+		}
+		for i := 0; i < n.ChildCount(); i++ {
+			childWrapped, childOk := walk(n.Child(i), wrapped)
+			if !ok && childOk {
+				wrapped, ok = childWrapped, true
+			}
+		}
+		n.Annotate(exec.ExecutionEngineID, &exec.ExecutionEngine{Wrapped: wrapped})
+		return wrapped, ok
+	}
+	walk(ih.explainPlan.Root, false /* parentWrapped */)
+	for i := range ih.explainPlan.Subqueries {
+		walk(ih.explainPlan.Subqueries[i].Root.(*explain.Node), false /* parentWrapped */)
+	}
+	for _, n := range ih.explainPlan.Checks {
+		walk(n, false /* parentWrapped */)
+	}
+}
+
 // planRowsForExplainAnalyze generates the plan tree as a list of strings (one
 // for each line).
 // Used in explainAnalyzePlanOutput mode.
@@ -306,20 +2043,184 @@ func (ih *instrumentationHelper) planRowsForExplainAnalyze(phaseTimes *phaseTime
 	if ih.explainPlan == nil {
 		return nil
 	}
-	ob := explain.NewOutputBuilder(ih.explainFlags)
-	ob.AddField("planning time", phaseTimes.getPlanningLatency().Round(time.Microsecond).String())
-	ob.AddField("execution time", phaseTimes.getRunLatency().Round(time.Microsecond).String())
-	if err := emitExplain(ob, ih.evalCtx, ih.codec, ih.explainPlan, ih.distribution, ih.vectorized); err != nil {
+	ob, err := ih.outputBuilderForExplainAnalyze(phaseTimes)
+	if err != nil {
 		return []string{fmt.Sprintf("error emitting plan: %v", err)}
 	}
 	return ob.BuildStringRows()
 }
 
+// encodedPlanForExplainAnalyze returns the plan along with the given warnings,
+// encoded according to the output encoding (JSON or YAML).
+// Used in explainAnalyzePlanOutput mode.
+func (ih *instrumentationHelper) encodedPlanForExplainAnalyze(
+	phaseTimes *phaseTimes, warnings []string,
+) string {
+	s := &explain.Structured{}
+	if ih.explainPlan != nil {
+		ob, err := ih.outputBuilderForExplainAnalyze(phaseTimes)
+		if err != nil {
+			s.Warnings = append(s.Warnings, fmt.Sprintf("error emitting plan: %v", err))
+		} else {
+			s = ob.BuildStructured()
+		}
+	}
+	s.Warnings = append(s.Warnings, warnings...)
+
+	var encoded []byte
+	var err error
+	if ih.outputEncoding == yamlEncoding {
+		encoded, err = yaml.Marshal(s)
+	} else {
+		encoded, err = json.MarshalIndent(s, "", "  ")
+	}
+	if err != nil {
+		return fmt.Sprintf("error encoding plan: %v", err)
+	}
+	return string(encoded)
+}
+
+// explainAnalyzeSummary contains the top-level statistics of an execution,
+// emitted as the last row of EXPLAIN ANALYZE (PLAN, JSON_SUMMARY). The field
+// names are part of the output format and must not change.
+type explainAnalyzeSummary struct {
+	PlanningTimeNanos  int64  `json:"planning_time_ns"`
+	ExecutionTimeNanos int64  `json:"execution_time_ns"`
+	Distribution       string `json:"distribution"`
+	Vectorized         bool   `json:"vectorized"`
+	NetworkBytesSent   int64  `json:"network_bytes_sent"`
+	// NetworkBytesSentPartial is set if NetworkBytesSent was computed from a
+	// partial trace, in which case it is a lower bound.
+	NetworkBytesSentPartial bool `json:"network_bytes_sent_partial,omitempty"`
+}
+
+// jsonSummaryForExplainAnalyze returns the JSON encoding of the
+// explainAnalyzeSummary of the execution.
+// Used in explainAnalyzePlanOutput mode.
+func (ih *instrumentationHelper) jsonSummaryForExplainAnalyze(phaseTimes *phaseTimes) string {
+	round := ih.explainFlags.RoundDuration
+	encoded, err := json.Marshal(explainAnalyzeSummary{
+		PlanningTimeNanos:       round(phaseTimes.getPlanningLatency()).Nanoseconds(),
+		ExecutionTimeNanos:      round(phaseTimes.getRunLatency()).Nanoseconds(),
+		Distribution:            ih.distribution.String(),
+		Vectorized:              ih.vectorized,
+		NetworkBytesSent:        ih.networkBytesSent,
+		NetworkBytesSentPartial: ih.networkBytesSentPartial,
+	})
+	if err != nil {
+		return fmt.Sprintf("error encoding summary: %v", err)
+	}
+	return string(encoded)
+}
+
+// outputBuilderForExplainAnalyze emits the plan, along with the top-level
+// fields shown by EXPLAIN ANALYZE, into a new OutputBuilder.
+func (ih *instrumentationHelper) outputBuilderForExplainAnalyze(
+	phaseTimes *phaseTimes,
+) (*explain.OutputBuilder, error) {
+	ob := explain.NewOutputBuilder(ih.explainFlags)
+	round := ih.explainFlags.RoundDuration
+	ob.AddField("planning time", round(phaseTimes.getPlanningLatency()).String())
+	if wait := phaseTimes.getConcurrencyWaitLatency(); wait > 0 {
+		ob.AddField("concurrency wait time", round(wait).String())
+	}
+	if wait := phaseTimes.getAdmissionWaitLatency(); wait > 0 {
+		ob.AddField("admission wait time", round(wait).String())
+	}
+	if ih.admissionPriority != "" {
+		ob.AddField("admission priority", ih.admissionPriority)
+	}
+	ob.AddField("execution time", round(phaseTimes.getRunLatency()).String())
+	if firstRow := phaseTimes.getFirstRowLatency(); firstRow > 0 {
+		ob.AddField("time to first row", round(firstRow).String())
+	}
+	if ih.committedByExecution {
+		ob.AddField("commit time", "included in execution time (one-phase commit)")
+	} else if commit := phaseTimes.getAutoCommitLatency(); commit > 0 {
+		ob.AddField("commit time", round(commit).String())
+	}
+	ih.forEachCustomPhase(func(phase CustomPhase, d time.Duration) {
+		ob.AddField(phase.String()+" time", round(d).String())
+	})
+	if ih.retryCount > 0 {
+		ob.AddField("retries", ih.retriesDescription())
+	}
+	if w := ih.waitTimes.LockWait; w > 0 {
+		ob.AddField("lock wait time", round(w).String())
+	}
+	if w := ih.waitTimes.LatchWait; w > 0 {
+		ob.AddField("latch wait time", round(w).String())
+	}
+	if w := ih.waitTimes.TxnQueueWait; w > 0 {
+		ob.AddField("txn queue wait time", round(w).String())
+	}
+	if ih.rowConversions > 0 {
+		ob.AddField("vectorized→row conversions", strconv.Itoa(ih.rowConversions))
+	}
+	if len(ih.repeatLatencies) > 0 {
+		p50, p90, p99, variance := latencyDistribution(ih.repeatLatencies)
+		ob.AddField("executions", strconv.Itoa(len(ih.repeatLatencies)))
+		ob.AddField("execution time p50", round(p50).String())
+		ob.AddField("execution time p90", round(p90).String())
+		ob.AddField("execution time p99", round(p99).String())
+		ob.AddField("execution time variance", fmt.Sprintf("%.3fms²", variance))
+	}
+	ih.addHintsField(ob)
+	if err := emitExplain(
+		ob, ih.evalCtx, ih.codec, ih.explainPlan, ih.distribution, ih.notDistributedReason, ih.vectorized,
+	); err != nil {
+		return nil, err
+	}
+	return ob, nil
+}
+
+// latencyDistribution returns the 50th, 90th and 99th percentiles (using the
+// nearest-rank method) and the variance (in squared milliseconds) of the given
+// latencies, which must not be empty.
+func latencyDistribution(
+	latencies []time.Duration,
+) (p50, p90, p99 time.Duration, variance float64) {
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) time.Duration {
+		idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		return sorted[idx]
+	}
+
+	var sum float64
+	for _, l := range sorted {
+		sum += float64(l) / float64(time.Millisecond)
+	}
+	mean := sum / float64(len(sorted))
+	for _, l := range sorted {
+		d := float64(l)/float64(time.Millisecond) - mean
+		variance += d * d
+	}
+	variance /= float64(len(sorted))
+	return percentile(0.5), percentile(0.9), percentile(0.99), variance
+}
+
+// logExplainAnalyzePlan writes the output of EXPLAIN ANALYZE (PLAN) to the log as
+// a single entry which includes the statement fingerprint.
+func (ih *instrumentationHelper) logExplainAnalyzePlan(ctx context.Context, phaseTimes *phaseTimes) {
+	rows := ih.planRowsForExplainAnalyze(phaseTimes)
+	log.Infof(ctx, "EXPLAIN ANALYZE output: fingerprint=%q plan=\n%s",
+		ih.exportedFingerprint(), strings.Join(rows, "\n"))
+}
+
 // setExplainAnalyzePlanResult sets the result for an EXPLAIN ANALYZE (PLAN)
-// statement. It returns an error only if there was an error adding rows to the
-// result.
+// statement, followed by the given warnings and, for the text encoding, the
+// given trace summary. It returns an error only if there was an error adding
+// rows to the result.
 func (ih *instrumentationHelper) setExplainAnalyzePlanResult(
-	ctx context.Context, res RestrictedCommandResult, phaseTimes *phaseTimes,
+	ctx context.Context,
+	res RestrictedCommandResult,
+	phaseTimes *phaseTimes,
+	warnings []string,
+	traceSummary []string,
 ) (commErr error) {
 	res.ResetStmtType(&tree.ExplainAnalyze{})
 	res.SetColumns(ctx, colinfo.ExplainPlanColumns)
@@ -329,9 +2230,23 @@ func (ih *instrumentationHelper) setExplainAnalyzePlanResult(
 		return nil //nolint:returnerrcheck
 	}
 
-	rows := ih.planRowsForExplainAnalyze(phaseTimes)
-	rows = append(rows, "")
-	rows = append(rows, "WARNING: this statement is experimental!")
+	var rows []string
+	if ih.outputEncoding == textEncoding {
+		rows = ih.planRowsForExplainAnalyze(phaseTimes)
+		if len(warnings) > 0 {
+			rows = append(rows, "")
+			rows = append(rows, warnings...)
+		}
+		if ih.explainFlags.JSONSummary {
+			rows = append(rows, ih.jsonSummaryForExplainAnalyze(phaseTimes))
+		}
+		if len(traceSummary) > 0 {
+			rows = append(rows, "")
+			rows = append(rows, traceSummary...)
+		}
+	} else {
+		rows = []string{ih.encodedPlanForExplainAnalyze(phaseTimes, warnings)}
+	}
 	for _, row := range rows {
 		if err := res.AddRow(ctx, tree.Datums{tree.NewDString(row)}); err != nil {
 			return err
@@ -340,12 +2255,42 @@ func (ih *instrumentationHelper) setExplainAnalyzePlanResult(
 	return nil
 }
 
-var deterministicPhaseTimes = phaseTimes{
-	sessionQueryReceived:    time.Time{},
-	sessionStartParse:       time.Time{},
-	sessionEndParse:         time.Time{}.Add(1 * time.Microsecond),
-	plannerStartLogicalPlan: time.Time{}.Add(1 * time.Microsecond),
-	plannerEndLogicalPlan:   time.Time{}.Add(11 * time.Microsecond),
-	plannerStartExecStmt:    time.Time{}.Add(11 * time.Microsecond),
-	plannerEndExecStmt:      time.Time{}.Add(111 * time.Microsecond),
+// traceSummaryMaxSpans is the maximum number of spans listed in the trace
+// summary shown by EXPLAIN ANALYZE (PLAN) when the
+// explain_analyze_trace_summary session variable is set.
+const traceSummaryMaxSpans = 10
+
+// topSpansByDuration returns the indexes of the (at most) maxSpans spans of
+// the given trace with the longest duration, from the longest to the shortest.
+func topSpansByDuration(trace tracing.Recording, maxSpans int) []int {
+	spans := make([]int, len(trace))
+	for i := range spans {
+		spans[i] = i
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		return trace[spans[i]].Duration > trace[spans[j]].Duration
+	})
+	if len(spans) > maxSpans {
+		spans = spans[:maxSpans]
+	}
+	return spans
+}
+
+// traceSummaryRows returns a condensed summary of the given trace, which lists
+// the (at most) maxSpans spans with the longest duration.
+func traceSummaryRows(trace tracing.Recording, maxSpans int) []string {
+	if len(trace) == 0 {
+		return nil
+	}
+	spans := topSpansByDuration(trace, maxSpans)
+	rows := make([]string, 0, len(spans)+1)
+	rows = append(rows, fmt.Sprintf(
+		"trace summary (top %d of %d spans by duration):", len(spans), len(trace),
+	))
+	for _, i := range spans {
+		rows = append(rows, fmt.Sprintf(
+			"  %s  %s", trace[i].Duration.Round(time.Microsecond), trace[i].Operation,
+		))
+	}
+	return rows
 }
@@ -0,0 +1,413 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package sql
+
+import (
+	"context"
+	gosql "database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyDistribution(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	p50, p90, p99, variance := latencyDistribution(latencies)
+	require.Equal(t, 50*time.Millisecond, p50)
+	require.Equal(t, 90*time.Millisecond, p90)
+	require.Equal(t, 99*time.Millisecond, p99)
+	require.InDelta(t, 833.25, variance, 1e-6)
+	// The input is not modified.
+	require.Equal(t, 100*time.Millisecond, latencies[0])
+
+	p50, p90, p99, variance = latencyDistribution([]time.Duration{time.Second})
+	require.Equal(t, time.Second, p50)
+	require.Equal(t, time.Second, p90)
+	require.Equal(t, time.Second, p99)
+	require.Equal(t, 0.0, variance)
+}
+
+// TestPhaseTimeSource verifies that the phase durations provided by the
+// PhaseTimeSource testing knob are used both by the statement statistics and by
+// EXPLAIN ANALYZE.
+func TestPhaseTimeSource(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	durations := PhaseDurations{
+		Parse: 1 * time.Millisecond,
+		Plan:  10 * time.Millisecond,
+		Run:   100 * time.Millisecond,
+	}
+	params := base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &ExecutorTestingKnobs{
+				PhaseTimeSource: func(fingerprint string) *PhaseDurations {
+					if !strings.HasSuffix(fingerprint, "SELECT _ + _") {
+						return nil
+					}
+					return &durations
+				},
+			},
+		},
+	}
+	s, sqlDB, _ := serverutils.StartServer(t, params)
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(sqlDB)
+
+	r.Exec(t, "SELECT 1 + 2")
+	var parseLat, planLat, runLat, serviceLat float64
+	r.QueryRow(t, `
+SELECT parse_lat_avg, plan_lat_avg, run_lat_avg, service_lat_avg
+FROM crdb_internal.node_statement_statistics WHERE key = 'SELECT _ + _'`,
+	).Scan(&parseLat, &planLat, &runLat, &serviceLat)
+	require.Equal(t, durations.Parse.Seconds(), parseLat)
+	require.Equal(t, durations.Plan.Seconds(), planLat)
+	require.Equal(t, durations.Run.Seconds(), runLat)
+	require.InDelta(t, (durations.Parse + durations.Plan + durations.Run).Seconds(), serviceLat, 1e-9)
+
+	rows := r.QueryStr(t, "EXPLAIN ANALYZE (PLAN) SELECT 1 + 2")
+	var plan strings.Builder
+	for _, row := range rows {
+		plan.WriteString(row[0])
+		plan.WriteByte('\n')
+	}
+	require.Contains(t, plan.String(), "planning time: 10ms")
+	require.Contains(t, plan.String(), "execution time: 100ms")
+}
+
+// TestPlanChangeCallback verifies that the PlanChangeCallback is invoked when
+// the sampled plan of a fingerprint changes, and only then.
+func TestPlanChangeCallback(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	s, sqlDB, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(sqlDB)
+
+	type change struct {
+		oldGist, newGist string
+	}
+	var mu syncutil.Mutex
+	var changes []change
+	s.SQLServer().(*Server).cfg.PlanChangeCallback = func(
+		ctx context.Context, fingerprint string, oldGist, newGist string,
+	) {
+		if !strings.Contains(fingerprint, "FROM t WHERE b = _") {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, change{oldGist: oldGist, newGist: newGist})
+	}
+	numChanges := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(changes)
+	}
+
+	// Sample the plan of every execution.
+	r.Exec(t, "SET CLUSTER SETTING sql.metrics.statement_details.plan_collection.period = '0s'")
+	r.Exec(t, "CREATE TABLE t (a INT PRIMARY KEY, b INT)")
+	r.Exec(t, "SELECT a FROM t WHERE b = 1")
+	r.Exec(t, "SELECT a FROM t WHERE b = 2")
+	require.Equal(t, 0, numChanges())
+
+	// An index on b changes the plan.
+	r.Exec(t, "CREATE INDEX b_idx ON t (b)")
+	r.Exec(t, "SELECT a FROM t WHERE b = 3")
+	require.Equal(t, 1, numChanges())
+	require.NotEqual(t, changes[0].oldGist, changes[0].newGist)
+	r.Exec(t, "SELECT a FROM t WHERE b = 4")
+	require.Equal(t, 1, numChanges())
+}
+
+// TestStatementPlanHistory verifies that crdb_internal.node_statement_plan_history
+// contains the distinct plans sampled for a fingerprint.
+func TestStatementPlanHistory(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	s, sqlDB, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(sqlDB)
+
+	// Sample the plan of every execution.
+	r.Exec(t, "SET CLUSTER SETTING sql.metrics.statement_details.plan_collection.period = '0s'")
+	r.Exec(t, "CREATE TABLE t (a INT PRIMARY KEY, b INT)")
+	r.Exec(t, "SELECT a FROM t WHERE b = 1")
+	r.Exec(t, "SELECT a FROM t WHERE b = 2")
+	// An index on b changes the plan.
+	r.Exec(t, "CREATE INDEX b_idx ON t (b)")
+	r.Exec(t, "SELECT a FROM t WHERE b = 3")
+
+	rows := r.QueryStr(t, `
+SELECT plan_gist, count, first_seen <= last_seen
+  FROM crdb_internal.node_statement_plan_history
+ WHERE key LIKE '%FROM t WHERE b = _'
+ ORDER BY first_seen`)
+	require.Len(t, rows, 2)
+	require.NotEqual(t, rows[0][0], rows[1][0])
+	require.Equal(t, []string{"2", "true"}, rows[0][1:])
+	require.Equal(t, []string{"1", "true"}, rows[1][1:])
+}
+
+// TestFingerprintAnonymizer verifies that the FingerprintAnonymizer is applied
+// to the fingerprints passed to the PlanChangeCallback, but not to the ones
+// used by the statement statistics.
+func TestFingerprintAnonymizer(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	s, sqlDB, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(sqlDB)
+
+	var mu syncutil.Mutex
+	var exported []string
+	cfg := s.SQLServer().(*Server).cfg
+	cfg.FingerprintAnonymizer = func(fingerprint string) string {
+		return strings.ReplaceAll(fingerprint, "secret", "t1")
+	}
+	cfg.PlanChangeCallback = func(ctx context.Context, fingerprint string, oldGist, newGist string) {
+		mu.Lock()
+		defer mu.Unlock()
+		exported = append(exported, fingerprint)
+	}
+
+	r.Exec(t, "SET CLUSTER SETTING sql.metrics.statement_details.plan_collection.period = '0s'")
+	r.Exec(t, "CREATE TABLE secret (a INT PRIMARY KEY, b INT)")
+	r.Exec(t, "SELECT a FROM secret WHERE b = 1")
+	r.Exec(t, "CREATE INDEX b_idx ON secret (b)")
+	r.Exec(t, "SELECT a FROM secret WHERE b = 2")
+
+	mu.Lock()
+	require.Contains(t, exported, "SELECT a FROM t1 WHERE b = _")
+	for _, f := range exported {
+		require.NotContains(t, f, "secret")
+	}
+	mu.Unlock()
+
+	// The statement statistics still use the original fingerprint.
+	r.CheckQueryResults(t, `
+SELECT count(*) FROM crdb_internal.node_statement_statistics
+WHERE key = 'SELECT a FROM secret WHERE b = _'`, [][]string{{"1"}})
+}
+
+func TestTraceSummaryRows(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	trace := tracing.Recording{
+		{Operation: "a", Duration: 2 * time.Millisecond},
+		{Operation: "b", Duration: 5 * time.Millisecond},
+		{Operation: "c", Duration: time.Millisecond},
+	}
+	require.Equal(t, []string{
+		"trace summary (top 2 of 3 spans by duration):",
+		"  5ms  b",
+		"  2ms  a",
+	}, traceSummaryRows(trace, 2 /* maxSpans */))
+	require.Nil(t, traceSummaryRows(nil /* trace */, 2 /* maxSpans */))
+}
+
+// TestExplainAnalyzeTraceSummary verifies that EXPLAIN ANALYZE (PLAN) includes
+// a trace summary only if the explain_analyze_trace_summary session variable
+// is set.
+func TestExplainAnalyzeTraceSummary(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, sqlDB, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(sqlDB)
+
+	explain := func() string {
+		var out strings.Builder
+		for _, row := range r.QueryStr(t, "EXPLAIN ANALYZE (PLAN) SELECT 1") {
+			out.WriteString(row[0])
+			out.WriteByte('\n')
+		}
+		return out.String()
+	}
+	require.NotContains(t, explain(), "trace summary")
+	r.Exec(t, "SET explain_analyze_trace_summary = on")
+	require.Contains(t, explain(), "trace summary (top ")
+}
+
+// TestExplainAnalyzeMaxRows verifies that the plan shown by EXPLAIN ANALYZE
+// (PLAN) is truncated according to the explain_analyze_max_rows session
+// variable.
+func TestExplainAnalyzeMaxRows(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, sqlDB, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(sqlDB)
+	r.Exec(t, "CREATE TABLE t (k INT PRIMARY KEY, v INT)")
+	r.Exec(t, "INSERT INTO t VALUES (1, 1), (2, 2)")
+
+	explain := func() string {
+		var out strings.Builder
+		rows := r.QueryStr(t, "EXPLAIN ANALYZE (PLAN) SELECT * FROM t AS a JOIN t AS b ON a.v = b.v")
+		for _, row := range rows {
+			out.WriteString(row[0])
+			out.WriteByte('\n')
+		}
+		return out.String()
+	}
+	require.NotContains(t, explain(), "omitted)")
+	r.Exec(t, "SET explain_analyze_max_rows = 5")
+	require.Contains(t, explain(), "nodes omitted)")
+	r.ExpectErr(t, "negative value", "SET explain_analyze_max_rows = -1")
+}
+
+// TestExplainAnalyzeRangesScanned verifies that EXPLAIN ANALYZE shows the
+// number of distinct ranges scanned by each scan.
+func TestExplainAnalyzeRangesScanned(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, sqlDB, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.Background())
+	r := sqlutils.MakeSQLRunner(sqlDB)
+	r.Exec(t, "CREATE TABLE t (k INT PRIMARY KEY, v INT)")
+	r.Exec(t, "INSERT INTO t SELECT i, i FROM generate_series(1, 30) AS g(i)")
+	r.Exec(t, "ALTER TABLE t SPLIT AT VALUES (10), (20)")
+
+	explain := func(query string) string {
+		var out strings.Builder
+		for _, row := range r.QueryStr(t, "EXPLAIN ANALYZE (PLAN) "+query) {
+			out.WriteString(row[0])
+			out.WriteByte('\n')
+		}
+		return out.String()
+	}
+	require.Contains(t, explain("SELECT * FROM t"), "ranges scanned: 3\n")
+	require.Contains(t, explain("SELECT * FROM t WHERE k < 5"), "ranges scanned: 1\n")
+}
+
+// TestCustomPhases verifies that the durations of the custom phases recorded
+// for a statement add up, are reported in registration order and are replaced
+// by the PhaseTimeSource testing knob.
+func TestCustomPhases(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	first := RegisterCustomPhase("test first")
+	second := RegisterCustomPhase("test second")
+	unused := RegisterCustomPhase("test unused")
+
+	collect := func(ih *instrumentationHelper) map[string]time.Duration {
+		res := make(map[string]time.Duration)
+		var names []string
+		ih.forEachCustomPhase(func(phase CustomPhase, d time.Duration) {
+			require.NotEqual(t, unused, phase)
+			names = append(names, phase.String())
+			res[phase.String()] = d
+		})
+		require.Equal(t, []string{"test first", "test second"}, names)
+		return res
+	}
+
+	var ih instrumentationHelper
+	ih.RecordCustomPhase(second, time.Millisecond)
+	ih.RecordCustomPhase(first, 10*time.Millisecond)
+	ih.RecordCustomPhase(second, 2*time.Millisecond)
+	require.Equal(t, map[string]time.Duration{
+		"test first":  10 * time.Millisecond,
+		"test second": 3 * time.Millisecond,
+	}, collect(&ih))
+
+	ih.phaseTimeSource = func(string) *PhaseDurations {
+		return &PhaseDurations{Custom: map[string]time.Duration{
+			"test second": 5 * time.Millisecond,
+			"test unused": time.Second,
+		}}
+	}
+	var pt phaseTimes
+	ih.OverridePhaseTimes(&pt)
+	require.Equal(t, map[string]time.Duration{
+		"test first":  0,
+		"test second": 5 * time.Millisecond,
+	}, collect(&ih))
+}
+
+// TestFlushTraceStats verifies that the trace statistics buffered by appStats
+// are only recorded in the statistics of the statements once they are read,
+// reset or once enough of them are pending.
+func TestFlushTraceStats(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	st := cluster.MakeTestingClusterSettings()
+	stats := sqlStats{st: st, apps: make(map[string]*appStats)}
+	reported := sqlStats{st: st, apps: make(map[string]*appStats)}
+	app := stats.getStatsForApplication("app")
+	a, _ := app.getStatsForStmt("SELECT a", true /* implicitTxn */, nil /* err */, true)
+	b, _ := app.getStatsForStmt("SELECT b", true /* implicitTxn */, nil /* err */, true)
+
+	app.recordTraceStats("SELECT a", true, nil, traceStatsUpdate{fullScan: true, rowsWritten: 3})
+	app.recordTraceStats("SELECT b", true, nil, traceStatsUpdate{indexes: []string{"1@1"}})
+	app.recordTraceStats("SELECT a", true, nil, traceStatsUpdate{rowsWritten: 5})
+	// The statistics of statements which aren't recorded are dropped.
+	app.recordTraceStats("SELECT c", true, nil, traceStatsUpdate{fullScan: true})
+	require.False(t, a.mu.data.FullScan)
+	require.Empty(t, b.mu.data.Indexes)
+
+	// Reading the statistics records the pending trace statistics.
+	for _, s := range stats.getUnscrubbedStmtStats(nil /* vt */) {
+		switch s.Key.Query {
+		case "SELECT a":
+			require.True(t, s.Stats.FullScan)
+			require.Equal(t, float64(5), s.Stats.RowsWritten.Mean)
+		case "SELECT b":
+			require.Equal(t, []string{"1@1"}, s.Stats.Indexes)
+			require.False(t, s.Stats.FullScan)
+		default:
+			t.Fatalf("unexpected statement %q", s.Key.Query)
+		}
+	}
+
+	// Resetting the statistics records the pending trace statistics before they
+	// are reported.
+	app.recordTraceStats("SELECT b", true, nil, traceStatsUpdate{fullScan: true})
+	stats.resetAndMaybeDumpStats(context.Background(), &reported)
+	require.True(t, b.mu.data.FullScan)
+	r, _ := reported.getStatsForApplication("app").getStatsForStmt("SELECT b", true, nil, false)
+	require.True(t, r.mu.data.FullScan)
+
+	// Enough pending statistics are recorded even if the statistics aren't read.
+	a, _ = app.getStatsForStmt("SELECT a", true /* implicitTxn */, nil /* err */, true)
+	for i := 0; i < traceStatsFlushThreshold; i++ {
+		app.recordTraceStats("SELECT a", true, nil, traceStatsUpdate{rowsWritten: int64(i)})
+	}
+	require.Zero(t, app.pendingTraceStats.count)
+	require.Equal(t, float64(traceStatsFlushThreshold-1), a.mu.data.RowsWritten.Mean)
+}
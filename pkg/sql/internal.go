@@ -411,6 +411,7 @@ func (ie *InternalExecutor) execInternal(
 
 	ctx, sp := tracing.EnsureChildSpan(ctx, ie.s.cfg.AmbientCtx.Tracer, opName)
 	defer sp.Finish()
+	sp.SetTag(internalExecutorSpanTag, opName)
 
 	timeReceived := timeutil.Now()
 	parseStart := timeReceived
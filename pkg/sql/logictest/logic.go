@@ -1280,6 +1280,13 @@ func (t *logicTest) setup(cfg testClusterConfig, serverArgs TestServerArgs) {
 				},
 				SQLExecutor: &sql.ExecutorTestingKnobs{
 					DeterministicExplainAnalyze: true,
+					PhaseTimeSource: func(string) *sql.PhaseDurations {
+						return &sql.PhaseDurations{
+							Parse: 1 * time.Microsecond,
+							Plan:  10 * time.Microsecond,
+							Run:   100 * time.Microsecond,
+						}
+					},
 				},
 			},
 			ClusterName: "testclustername",
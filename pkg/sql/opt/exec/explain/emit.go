@@ -46,8 +46,15 @@ func Emit(plan *Plan, ob *OutputBuilder, spanFormatFn SpanFormatFn) error {
 			return err
 		}
 		ob.EnterNode(name, columns, ordering)
-		if err := e.emitNodeAttributes(n); err != nil {
-			return err
+		if stats, ok := n.annotations[exec.ExecutionStatsID]; ok {
+			if s := stats.(*exec.ExecutionStats); s.ExecTimeValid {
+				ob.setExecTime(s.ExecTime)
+			}
+		}
+		if !ob.flags.OnlySummary {
+			if err := e.emitNodeAttributes(n); err != nil {
+				return err
+			}
 		}
 		for _, c := range n.children {
 			if err := walk(c); err != nil {
@@ -72,7 +79,11 @@ func Emit(plan *Plan, ob *OutputBuilder, spanFormatFn SpanFormatFn) error {
 		// This field contains the original subquery (which could have been modified
 		// by optimizer transformations).
 		if s.ExprNode != nil {
-			ob.Attr("original sql", tree.AsStringWithFlags(s.ExprNode, tree.FmtSimple))
+			fmtFlags := tree.FmtSimple
+			if ob.flags.HideValues {
+				fmtFlags = tree.FmtHideConstants
+			}
+			ob.Attr("original sql", tree.AsStringWithFlags(s.ExprNode, fmtFlags))
 		}
 		var mode string
 		switch s.Mode {
@@ -326,6 +337,15 @@ func (e *emitter) joinNodeName(algo string, joinType descpb.JoinType) string {
 }
 
 func (e *emitter) emitNodeAttributes(n *Node) error {
+	if e.ob.flags.ShowSQL && !e.ob.flags.HideValues {
+		if src, ok := n.annotations[exec.SourceSQLID]; ok {
+			e.ob.Attr("sql", src.(*exec.SourceSQL).SQL)
+		}
+	}
+
+	// estimatedRowCount is set if the estimated row count is shown for this
+	// node, in which case it is compared to the actual row count below.
+	var estimatedRowCount *float64
 	if stats, ok := n.annotations[exec.EstimatedStatsID]; ok {
 		s := stats.(*exec.EstimatedStats)
 
@@ -337,10 +357,12 @@ func (e *emitter) emitNodeAttributes(n *Node) error {
 			count := int(math.Round(s.RowCount))
 			if s.TableStatsAvailable {
 				e.ob.Attr("estimated row count", count)
+				estimatedRowCount = &s.RowCount
 			} else {
 				// No stats available.
 				if e.ob.flags.Verbose {
 					e.ob.Attrf("estimated row count", "%d (missing stats)", count)
+					estimatedRowCount = &s.RowCount
 				} else {
 					// In non-verbose mode, don't show the row count (which is not based
 					// on reality); only show a "missing stats" field. Don't show it for
@@ -354,6 +376,58 @@ func (e *emitter) emitNodeAttributes(n *Node) error {
 		// TODO(radu): we may want to emit estimated cost in Verbose mode.
 	}
 
+	if stats, ok := n.annotations[exec.ExecutionStatsID]; ok {
+		s := stats.(*exec.ExecutionStats)
+		if s.RowCountValid {
+			e.ob.Attr("actual row count", s.RowCount)
+			if estimatedRowCount != nil {
+				e.emitEstimateRatio(*estimatedRowCount, s.RowCount)
+			}
+		}
+		if s.RowsWrittenValid {
+			e.ob.Attr("rows written", s.RowsWritten)
+		}
+		if s.NetworkBytesSentValid {
+			e.ob.Attr("network bytes sent", e.ob.flags.FormatBytes(s.NetworkBytesSent))
+		}
+		if s.NetworkDeserializationTimeValid {
+			e.ob.Attr(
+				"network deserialization time",
+				e.ob.flags.RoundDuration(s.NetworkDeserializationTime).String(),
+			)
+		}
+		if s.KVBatchCountValid {
+			e.ob.Attr("KV batches", s.KVBatchCount)
+			e.ob.Attr("KV round trips", s.KVRoundTrips)
+			// Follower reads are only shown if they were attempted, e.g. by
+			// statements using AS OF SYSTEM TIME follower_read_timestamp().
+			if s.KVFollowerReads > 0 || s.KVFollowerReadFallbacks > 0 {
+				e.ob.Attr("KV follower reads", s.KVFollowerReads)
+				e.ob.Attr("KV follower read fallbacks", s.KVFollowerReadFallbacks)
+			}
+		}
+		if s.KVRowsReadValid {
+			e.ob.Attr("KV rows read", s.KVRowsRead)
+		}
+		if s.RangesScannedValid {
+			e.ob.Attr("ranges scanned", s.RangesScanned)
+		}
+		if rowsRead, ok := e.rowsRead(n, s); ok && s.RowCountValid {
+			// Scans which output every row they read are not interesting.
+			if n.op == filterOp || s.RowCount < rowsRead {
+				e.emitSelectivity(rowsRead, s.RowCount)
+			}
+		}
+	}
+
+	if engine, ok := n.annotations[exec.ExecutionEngineID]; ok {
+		if engine.(*exec.ExecutionEngine).Wrapped {
+			e.ob.Attr("execution engine", "row-by-row (wrapped)")
+		} else {
+			e.ob.Attr("execution engine", "vectorized")
+		}
+	}
+
 	ob := e.ob
 	switch n.op {
 	case scanOp:
@@ -686,6 +760,54 @@ func (e *emitter) emitNodeAttributes(n *Node) error {
 	return nil
 }
 
+// misestimateRatio is the factor by which the actual row count of a node must
+// differ from its estimated row count for the node to be flagged.
+const misestimateRatio = 10
+
+// emitEstimateRatio emits the ratio between the actual and the estimated row
+// count of a node, flagging the node if the estimate was off by at least
+// misestimateRatio. Both counts are treated as at least 1, so that tiny
+// estimates (like a fraction of a row) don't result in huge ratios.
+func (e *emitter) emitEstimateRatio(estimated float64, actual int64) {
+	ratio := math.Max(float64(actual), 1) / math.Max(estimated, 1)
+	switch {
+	case ratio >= misestimateRatio:
+		e.ob.Attrf("estimate ratio", "%.2f (underestimate)", ratio)
+	case ratio <= 1.0/misestimateRatio:
+		e.ob.Attrf("estimate ratio", "%.2f (overestimate)", ratio)
+	default:
+		e.ob.Attrf("estimate ratio", "%.2f", ratio)
+	}
+}
+
+// rowsRead returns the number of rows read by a node during execution: for
+// filters, the number of rows produced by their input; for other nodes, the
+// number of rows they read from KV (if any).
+func (e *emitter) rowsRead(n *Node, s *exec.ExecutionStats) (_ int64, ok bool) {
+	if n.op == filterOp {
+		if stats, ok := n.children[0].annotations[exec.ExecutionStatsID]; ok {
+			if input := stats.(*exec.ExecutionStats); input.RowCountValid {
+				return input.RowCount, true
+			}
+		}
+	}
+	return s.KVRowsRead, s.KVRowsReadValid
+}
+
+// emitSelectivity emits the number of rows which were read by a node but not
+// output by it, along with the fraction of the rows read that were output.
+// Unselective predicates (which filter out most of the rows read) are good
+// candidates to be served by an index.
+func (e *emitter) emitSelectivity(rowsRead, rowsOutput int64) {
+	if rowsRead <= 0 || rowsOutput > rowsRead {
+		return
+	}
+	e.ob.Attrf(
+		"rows filtered", "%d (selectivity: %.2f%%)",
+		rowsRead-rowsOutput, 100*float64(rowsOutput)/float64(rowsRead),
+	)
+}
+
 func (e *emitter) emitTableAndIndex(field string, table cat.Table, index cat.Index) {
 	partial := ""
 	if _, isPartial := index.Predicate(); isPartial {
@@ -708,6 +830,15 @@ func (e *emitter) spansStr(table cat.Table, index cat.Index, scanParams exec.Sca
 		return "FULL SCAN"
 	}
 
+	// If we must hide values, only show the count (even in verbose mode).
+	if e.ob.flags.HideValues {
+		n := len(scanParams.InvertedConstraint)
+		if scanParams.IndexConstraint != nil {
+			n = scanParams.IndexConstraint.Spans.Count()
+		}
+		return fmt.Sprintf("%d span%s", n, util.Pluralize(int64(n)))
+	}
+
 	// In verbose mode show the physical spans.
 	if e.ob.flags.Verbose {
 		return e.spanFormatFn(table, index, scanParams)
@@ -720,12 +851,6 @@ func (e *emitter) spansStr(table cat.Table, index cat.Index, scanParams exec.Sca
 		return fmt.Sprintf("%d span%s", n, util.Pluralize(int64(n)))
 	}
 
-	// If we must hide values, only show the count.
-	if e.ob.flags.HideValues {
-		n := scanParams.IndexConstraint.Spans.Count()
-		return fmt.Sprintf("%d span%s", n, util.Pluralize(int64(n)))
-	}
-
 	sp := &scanParams.IndexConstraint.Spans
 	// Show up to 4 logical spans.
 	if maxSpans := 4; sp.Count() > maxSpans {
@@ -0,0 +1,192 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package explain
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
+	"github.com/cockroachdb/cockroach/pkg/sql/opt/exec"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEmitRowCounts verifies that the actual row count of a node is shown next
+// to its estimated row count, and that large misestimates are flagged.
+func TestEmitRowCounts(t *testing.T) {
+	testCases := []struct {
+		estimated float64
+		actual    int64
+		expected  string
+	}{
+		{estimated: 10, actual: 12, expected: "estimate ratio: 1.20\n"},
+		{estimated: 10, actual: 100, expected: "estimate ratio: 10.00 (underestimate)\n"},
+		{estimated: 500, actual: 5, expected: "estimate ratio: 0.01 (overestimate)\n"},
+		// Counts under one row are not considered misestimates.
+		{estimated: 0.01, actual: 1, expected: "estimate ratio: 1.00\n"},
+		{estimated: 1, actual: 0, expected: "estimate ratio: 1.00\n"},
+	}
+	for _, tc := range testCases {
+		f := NewFactory(exec.StubFactory{})
+		n, err := f.ConstructValues(
+			[][]tree.TypedExpr{{tree.NewDInt(1)}},
+			colinfo.ResultColumns{{Name: "x", Typ: types.Int}},
+		)
+		require.NoError(t, err)
+		n, err = f.ConstructFilter(n, tree.DBoolTrue, nil /* reqOrdering */)
+		require.NoError(t, err)
+		f.AnnotateNode(n, exec.EstimatedStatsID, &exec.EstimatedStats{
+			TableStatsAvailable: true,
+			RowCount:            tc.estimated,
+		})
+		n.(*Node).Annotate(exec.ExecutionStatsID, &exec.ExecutionStats{
+			RowCount:      tc.actual,
+			RowCountValid: true,
+		})
+		plan, err := f.ConstructPlan(n, nil /* subqueries */, nil /* cascades */, nil /* checks */)
+		require.NoError(t, err)
+
+		ob := NewOutputBuilder(Flags{Verbose: true})
+		require.NoError(t, Emit(plan.(*Plan), ob, nil /* spanFormatFn */))
+		out := ob.BuildString()
+		require.Contains(t, out, "actual row count: ")
+		require.Contains(t, out, tc.expected)
+	}
+}
+
+// TestEmitRowsFiltered verifies that the number of rows filtered by a filter
+// node and its selectivity are shown, based on the actual row count of its
+// input.
+func TestEmitRowsFiltered(t *testing.T) {
+	f := NewFactory(exec.StubFactory{})
+	input, err := f.ConstructValues(
+		[][]tree.TypedExpr{{tree.NewDInt(1)}},
+		colinfo.ResultColumns{{Name: "x", Typ: types.Int}},
+	)
+	require.NoError(t, err)
+	n, err := f.ConstructFilter(input, tree.DBoolTrue, nil /* reqOrdering */)
+	require.NoError(t, err)
+	input.(*Node).Annotate(exec.ExecutionStatsID, &exec.ExecutionStats{
+		RowCount:      200,
+		RowCountValid: true,
+	})
+	n.(*Node).Annotate(exec.ExecutionStatsID, &exec.ExecutionStats{
+		RowCount:      50,
+		RowCountValid: true,
+	})
+	plan, err := f.ConstructPlan(n, nil /* subqueries */, nil /* cascades */, nil /* checks */)
+	require.NoError(t, err)
+
+	ob := NewOutputBuilder(Flags{Verbose: true})
+	require.NoError(t, Emit(plan.(*Plan), ob, nil /* spanFormatFn */))
+	require.Contains(t, ob.BuildString(), "rows filtered: 150 (selectivity: 25.00%)\n")
+}
+
+// TestEmitMaxRows verifies that, when the number of rows of the plan is
+// limited, the subtrees which took the least time to execute are collapsed.
+func TestEmitMaxRows(t *testing.T) {
+	f := NewFactory(exec.StubFactory{})
+	branch := func(filterTime, valuesTime time.Duration) exec.Node {
+		values, err := f.ConstructValues(
+			[][]tree.TypedExpr{{tree.NewDInt(1)}},
+			colinfo.ResultColumns{{Name: "x", Typ: types.Int}},
+		)
+		require.NoError(t, err)
+		values.(*Node).Annotate(exec.ExecutionStatsID, &exec.ExecutionStats{
+			ExecTime:      valuesTime,
+			ExecTimeValid: true,
+		})
+		filter, err := f.ConstructFilter(values, tree.DBoolTrue, nil /* reqOrdering */)
+		require.NoError(t, err)
+		filter.(*Node).Annotate(exec.ExecutionStatsID, &exec.ExecutionStats{
+			ExecTime:      filterTime,
+			ExecTimeValid: true,
+		})
+		return filter
+	}
+	hot := branch(time.Second, 500*time.Millisecond)
+	cold := branch(time.Millisecond, time.Millisecond)
+	n, err := f.ConstructSetOp(tree.UnionOp, true /* all */, hot, cold)
+	require.NoError(t, err)
+	plan, err := f.ConstructPlan(n, nil /* subqueries */, nil /* cascades */, nil /* checks */)
+	require.NoError(t, err)
+
+	emit := func(maxRows int) []string {
+		ob := NewOutputBuilder(Flags{OnlySummary: true, MaxRows: maxRows})
+		require.NoError(t, Emit(plan.(*Plan), ob, nil /* spanFormatFn */))
+		return ob.BuildStringRows()
+	}
+	// Each of the five nodes takes one row, preceded by an empty row for the
+	// children.
+	require.Len(t, emit(0 /* maxRows */), 9)
+	require.Equal(t, emit(0 /* maxRows */), emit(9 /* maxRows */))
+
+	// The cold branch is collapsed first.
+	rows := emit(7 /* maxRows */)
+	require.Len(t, rows, 7)
+	require.Equal(t, "└── • ... (2 nodes omitted)", rows[6])
+	out := strings.Join(rows, "\n")
+	require.Equal(t, 1, strings.Count(out, "• filter"))
+	require.Equal(t, 1, strings.Count(out, "• values"))
+
+	// If the plan doesn't fit otherwise, all the children of the root are
+	// collapsed.
+	rows = emit(1 /* maxRows */)
+	require.Len(t, rows, 3)
+	require.Equal(t, "└── • ... (4 nodes omitted)", rows[2])
+}
+
+// TestEmitPrettyValues verifies that JSON and array values are pretty-printed
+// over multiple lines when the PrettyValues flag is set, and shown on a single
+// line otherwise.
+func TestEmitPrettyValues(t *testing.T) {
+	j, err := tree.ParseDJSON(`{"a": [1, 2], "b": "c"}`)
+	require.NoError(t, err)
+	arr := tree.NewDArray(types.Int)
+	require.NoError(t, arr.Append(tree.NewDInt(1)))
+	require.NoError(t, arr.Append(tree.NewDInt(2)))
+
+	f := NewFactory(exec.StubFactory{})
+	n, err := f.ConstructValues(
+		[][]tree.TypedExpr{{j.(tree.TypedExpr), arr}},
+		colinfo.ResultColumns{{Name: "j", Typ: types.Jsonb}, {Name: "a", Typ: types.IntArray}},
+	)
+	require.NoError(t, err)
+	plan, err := f.ConstructPlan(n, nil /* subqueries */, nil /* cascades */, nil /* checks */)
+	require.NoError(t, err)
+
+	emit := func(flags Flags) string {
+		ob := NewOutputBuilder(flags)
+		require.NoError(t, Emit(plan.(*Plan), ob, nil /* spanFormatFn */))
+		return ob.BuildString()
+	}
+	out := emit(Flags{Verbose: true})
+	require.Contains(t, out, `row 0, expr 0: '{"a": [1, 2], "b": "c"}'`)
+	require.Contains(t, out, "row 0, expr 1: ARRAY[1,2]\n")
+
+	out = emit(Flags{Verbose: true, PrettyValues: true})
+	require.Contains(t, out, strings.Join([]string{
+		`  row 0, expr 0: '{`,
+		`      "a": [`,
+		`          1,`,
+		`          2`,
+		`      ],`,
+		`      "b": "c"`,
+		`  }'`,
+		`  row 0, expr 1: ARRAY[`,
+		`      1,`,
+		`      2`,
+		`  ]`,
+	}, "\n"))
+}
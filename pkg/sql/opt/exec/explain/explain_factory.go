@@ -12,6 +12,7 @@ package explain
 
 import (
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
+	"github.com/cockroachdb/cockroach/pkg/sql/opt/cat"
 	"github.com/cockroachdb/cockroach/pkg/sql/opt/exec"
 )
 
@@ -72,6 +73,51 @@ func (n *Node) WrappedNode() exec.Node {
 	return n.wrappedNode
 }
 
+// MutatedTable returns the table written to by the node, if it is an insert,
+// update, upsert or delete; otherwise it returns nil.
+func (n *Node) MutatedTable() cat.Table {
+	switch a := n.args.(type) {
+	case *insertArgs:
+		return a.Table
+	case *insertFastPathArgs:
+		return a.Table
+	case *updateArgs:
+		return a.Table
+	case *upsertArgs:
+		return a.Table
+	case *deleteArgs:
+		return a.Table
+	case *deleteRangeArgs:
+		return a.Table
+	}
+	return nil
+}
+
+// IndexesRead calls fn for each index read by the node, if it is a scan, an
+// index join or a join which reads from an index.
+func (n *Node) IndexesRead(fn func(table cat.Table, index cat.Index)) {
+	switch a := n.args.(type) {
+	case *scanArgs:
+		fn(a.Table, a.Index)
+	case *indexJoinArgs:
+		fn(a.Table, a.Table.Index(cat.PrimaryIndex))
+	case *lookupJoinArgs:
+		fn(a.Table, a.Index)
+	case *invertedJoinArgs:
+		fn(a.Table, a.Index)
+	case *zigzagJoinArgs:
+		fn(a.LeftTable, a.LeftIndex)
+		fn(a.RightTable, a.RightIndex)
+	}
+}
+
+// Annotate annotates the node with extra information, in the same way as
+// Factory.AnnotateNode. It can be used to add information after the plan was
+// constructed (e.g. statistics collected during execution).
+func (n *Node) Annotate(id exec.ExplainAnnotationID, value interface{}) {
+	n.f.AnnotateNode(n, id, value)
+}
+
 func (f *Factory) newNode(
 	op execOperator, args interface{}, ordering exec.OutputOrdering, children ...*Node,
 ) (*Node, error) {
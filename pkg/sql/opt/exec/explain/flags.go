@@ -10,7 +10,13 @@
 
 package explain
 
-import "github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+import (
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+)
 
 // Flags are modifiers for EXPLAIN (PLAN).
 type Flags struct {
@@ -21,9 +27,45 @@ type Flags struct {
 	// If ShowTypes is true, then Verbose is also true.
 	ShowTypes bool
 	// If HideValues is true, we hide fields that may contain values from the
-	// query (e.g. spans). Used internally for the plan visible in the UI.
-	// If HideValues is true, then Verbose must be false.
+	// query (e.g. spans, constants and the SQL of subqueries). Used internally
+	// for the plan visible in the UI, and for the plan in statement bundles
+	// when sql.stmt_diagnostics.redacted_columns is set.
 	HideValues bool
+	// If OnlySummary is true, only the top-level fields and the operators of
+	// the plan are shown; the attributes and statistics of each node are
+	// omitted. Used for EXPLAIN ANALYZE (PLAN, SUMMARY).
+	OnlySummary bool
+	// If Repeat is true, the statement is executed multiple times (planning it
+	// again for each execution) and the distribution of the execution latency
+	// is shown. Used for EXPLAIN ANALYZE (PLAN, REPEAT [<count>]).
+	Repeat bool
+	// RepeatCount is the number of executions if Repeat is true; if zero, the
+	// sql.explain_analyze.repeat_count cluster setting is used.
+	RepeatCount int
+	// If JSONSummary is true, a JSON object with the top-level statistics of
+	// the execution is appended as the last row of the output, so that tools
+	// can parse them without scraping the text. Used for EXPLAIN ANALYZE (PLAN,
+	// JSON_SUMMARY).
+	JSONSummary bool
+	// If ShowSQL is true, nodes which are annotated with the SQL text they
+	// originate from (see exec.SourceSQL) show it; subqueries always show their
+	// original SQL. Used for EXPLAIN ANALYZE (PLAN, SQL).
+	ShowSQL bool
+	// If Precise is true, durations are shown with nanosecond precision rather
+	// than rounded to the microsecond, and byte counts are shown as exact
+	// numbers rather than humanized. Used for EXPLAIN ANALYZE (PLAN, PRECISE),
+	// for benchmarks.
+	Precise bool
+	// If PrettyValues is true, the JSON and array values in expressions are
+	// pretty-printed over multiple lines rather than shown as single-line
+	// blobs. Used for EXPLAIN ANALYZE (PLAN, PRETTY).
+	PrettyValues bool
+	// If MaxRows is positive, the text representation of the plan is limited
+	// to (at most) this many rows: the subtrees which took the least time to
+	// execute are collapsed into "... (N nodes omitted)" nodes. Used for EXPLAIN
+	// ANALYZE (PLAN) of very large plans (see the explain_analyze_max_rows
+	// session variable).
+	MaxRows int
 }
 
 // MakeFlags crates Flags from ExplainOptions.
@@ -36,5 +78,42 @@ func MakeFlags(options *tree.ExplainOptions) Flags {
 		f.Verbose = true
 		f.ShowTypes = true
 	}
+	if options.Flags[tree.ExplainFlagSummary] {
+		f.OnlySummary = true
+	}
+	if options.Flags[tree.ExplainFlagRepeat] {
+		f.Repeat = true
+		f.RepeatCount = int(options.RepeatCount)
+	}
+	if options.Flags[tree.ExplainFlagJSONSummary] {
+		f.JSONSummary = true
+	}
+	if options.Flags[tree.ExplainFlagSQL] {
+		f.ShowSQL = true
+	}
+	if options.Flags[tree.ExplainFlagPrecise] {
+		f.Precise = true
+	}
+	if options.Flags[tree.ExplainFlagPretty] {
+		f.PrettyValues = true
+	}
 	return f
 }
+
+// RoundDuration rounds a duration shown by EXPLAIN ANALYZE to the microsecond,
+// unless Precise is set.
+func (f Flags) RoundDuration(d time.Duration) time.Duration {
+	if f.Precise {
+		return d
+	}
+	return d.Round(time.Microsecond)
+}
+
+// FormatBytes formats a byte count shown by EXPLAIN ANALYZE in a human-readable
+// form, unless Precise is set.
+func (f Flags) FormatBytes(n int64) string {
+	if f.Precise {
+		return strconv.FormatInt(n, 10)
+	}
+	return humanizeutil.IBytes(n)
+}
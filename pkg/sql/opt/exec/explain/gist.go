@@ -0,0 +1,71 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package explain
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/opt/cat"
+)
+
+// PlanGist returns a short identifier of the shape of the given plan. The gist
+// only depends on the tree of operators and on the tables and indexes they
+// access; it does not depend on any values, expressions or estimates. Two
+// executions of the same statement fingerprint that use the same plan have the
+// same gist, whereas a change in (for example) join order, join algorithm or
+// index selection results in a different gist.
+func PlanGist(plan *Plan) string {
+	g := gistBuilder{
+		e: makeEmitter(NewOutputBuilder(Flags{Verbose: true}), nil /* spanFormatFn */),
+	}
+	g.walk(plan.Root)
+	for i := range plan.Subqueries {
+		g.buf.WriteString("subquery")
+		g.walk(plan.Subqueries[i].Root.(*Node))
+	}
+	for i := range plan.Cascades {
+		fmt.Fprintf(&g.buf, "fk-cascade(%s)", plan.Cascades[i].FKName)
+	}
+	for _, n := range plan.Checks {
+		g.buf.WriteString("fk-check")
+		g.walk(n)
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write(g.buf.Bytes())
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+type gistBuilder struct {
+	e   emitter
+	buf bytes.Buffer
+}
+
+func (g *gistBuilder) walk(n *Node) {
+	name, err := g.e.nodeName(n)
+	if err != nil {
+		// Unknown operators are still part of the shape of the plan.
+		name = fmt.Sprintf("op%d", n.op)
+	}
+	g.buf.WriteString(name)
+	n.IndexesRead(g.writeTableAndIndex)
+	g.buf.WriteByte('(')
+	for _, c := range n.children {
+		g.walk(c)
+	}
+	g.buf.WriteByte(')')
+}
+
+func (g *gistBuilder) writeTableAndIndex(table cat.Table, index cat.Index) {
+	fmt.Fprintf(&g.buf, "[%d@%d]", table.ID(), index.ID())
+}
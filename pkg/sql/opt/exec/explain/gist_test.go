@@ -0,0 +1,52 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package explain
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
+	"github.com/cockroachdb/cockroach/pkg/sql/opt/exec"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanGist(t *testing.T) {
+	buildPlan := func(t *testing.T, val int, filter tree.TypedExpr) *Plan {
+		f := NewFactory(exec.StubFactory{})
+		n, err := f.ConstructValues(
+			[][]tree.TypedExpr{
+				{tree.NewDInt(tree.DInt(val))},
+				{tree.NewDInt(tree.DInt(val + 1))},
+			},
+			colinfo.ResultColumns{{Name: "x", Typ: types.Int}},
+		)
+		require.NoError(t, err)
+		f.AnnotateNode(n, exec.EstimatedStatsID, &exec.EstimatedStats{RowCount: float64(val)})
+		if filter != nil {
+			n, err = f.ConstructFilter(n, filter, nil /* reqOrdering */)
+			require.NoError(t, err)
+		}
+		plan, err := f.ConstructPlan(n, nil /* subqueries */, nil /* cascades */, nil /* checks */)
+		require.NoError(t, err)
+		return plan.(*Plan)
+	}
+
+	// Values, expressions and estimates don't affect the gist.
+	a := PlanGist(buildPlan(t, 1, tree.DBoolTrue))
+	b := PlanGist(buildPlan(t, 10, tree.DBoolFalse))
+	require.Equal(t, a, b)
+
+	// The shape of the plan does.
+	c := PlanGist(buildPlan(t, 1, nil /* filter */))
+	require.NotEqual(t, a, c)
+}
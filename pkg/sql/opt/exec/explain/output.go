@@ -13,10 +13,13 @@ package explain
 import (
 	"bytes"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
 	"github.com/cockroachdb/cockroach/pkg/util/treeprinter"
 )
 
@@ -48,6 +51,10 @@ type entry struct {
 
 	field    string
 	fieldVal string
+
+	// execTime is the time spent executing the node, if it is known; it is only
+	// used to decide which nodes to omit when flags.MaxRows is set.
+	execTime time.Duration
 }
 
 func (e *entry) isNode() bool {
@@ -92,6 +99,16 @@ func (ob *OutputBuilder) enterNode(name, columns, ordering string) {
 	})
 }
 
+// setExecTime records the time spent executing the current node.
+func (ob *OutputBuilder) setExecTime(d time.Duration) {
+	for i := len(ob.entries) - 1; i >= 0; i-- {
+		if ob.entries[i].isNode() {
+			ob.entries[i].execTime = d
+			return
+		}
+	}
+}
+
 // LeaveNode moves the current node back up the tree by one level.
 func (ob *OutputBuilder) LeaveNode() {
 	ob.level--
@@ -134,6 +151,9 @@ func (ob *OutputBuilder) Expr(key string, expr tree.TypedExpr, varColumns colinf
 	if ob.flags.HideValues {
 		flags |= tree.FmtHideConstants
 	}
+	if ob.flags.PrettyValues && !ob.flags.HideValues {
+		expr = prettyExpr(expr)
+	}
 	f := tree.NewFmtCtx(flags)
 	f.SetIndexedVarFormat(func(ctx *tree.FmtCtx, idx int) {
 		// Ensure proper quoting.
@@ -144,6 +164,67 @@ func (ob *OutputBuilder) Expr(key string, expr tree.TypedExpr, varColumns colinf
 	ob.AddField(key, f.CloseAndGetString())
 }
 
+// prettyExpr returns a copy of the expression in which the JSON and array
+// values are pretty-printed.
+func prettyExpr(expr tree.TypedExpr) tree.TypedExpr {
+	pretty := func(e tree.Expr) (recurse bool, newExpr tree.Expr, err error) {
+		switch d := e.(type) {
+		case *tree.DJSON, *tree.DArray:
+			return false, prettyValue{TypedExpr: d.(tree.TypedExpr)}, nil
+		}
+		return true, e, nil
+	}
+	newExpr, err := tree.SimpleVisit(expr, pretty)
+	if err != nil {
+		return expr
+	}
+	return newExpr.(tree.TypedExpr)
+}
+
+// prettyValueIndent is the indentation of the nested lines of a pretty-printed
+// value.
+const prettyValueIndent = "    "
+
+// prettyValue wraps a JSON or array value which is formatted over multiple
+// lines, with the nested lines indented by depth+1 levels.
+type prettyValue struct {
+	tree.TypedExpr
+	depth int
+}
+
+// Format implements the NodeFormatter interface.
+func (v prettyValue) Format(ctx *tree.FmtCtx) {
+	indent := strings.Repeat(prettyValueIndent, v.depth)
+	switch d := v.TypedExpr.(type) {
+	case *tree.DJSON:
+		s, err := json.Pretty(d.JSON)
+		if err != nil {
+			ctx.FormatNode(d)
+			return
+		}
+		s = strings.ReplaceAll(s, "'", "''")
+		ctx.WriteByte('\'')
+		ctx.WriteString(strings.ReplaceAll(s, "\n", "\n"+indent))
+		ctx.WriteByte('\'')
+	case *tree.DArray:
+		if len(d.Array) == 0 {
+			ctx.FormatNode(d)
+			return
+		}
+		ctx.WriteString("ARRAY[")
+		for i, e := range d.Array {
+			if i > 0 {
+				ctx.WriteByte(',')
+			}
+			ctx.WriteString("\n" + indent + prettyValueIndent)
+			ctx.FormatNode(prettyValue{TypedExpr: e, depth: v.depth + 1})
+		}
+		ctx.WriteString("\n" + indent + "]")
+	default:
+		ctx.FormatNode(d)
+	}
+}
+
 // VExpr is a verbose-only variant of Expr.
 func (ob *OutputBuilder) VExpr(key string, expr tree.TypedExpr, varColumns colinfo.ResultColumns) {
 	if ob.flags.Verbose {
@@ -218,7 +299,7 @@ func (ob *OutputBuilder) BuildStringRows() []string {
 	var result []string
 	tp := treeprinter.NewWithStyle(treeprinter.BulletStyle)
 	stack := []treeprinter.Node{tp}
-	entries := ob.entries
+	entries := ob.truncatedEntries()
 
 	pop := func() *entry {
 		e := &entries[0]
@@ -236,7 +317,7 @@ func (ob *OutputBuilder) BuildStringRows() []string {
 	// There may be some top-level non-node entries (like "distributed"). Print
 	// them separately, as they can't be part of the tree.
 	for e := popField(); e != nil; e = popField() {
-		result = append(result, e.fieldStr())
+		result = append(result, strings.Split(e.fieldStr(), "\n")...)
 	}
 	if len(result) > 0 {
 		result = append(result, "")
@@ -254,13 +335,142 @@ func (ob *OutputBuilder) BuildStringRows() []string {
 		}
 		// Add any fields for the node.
 		for entry = popField(); entry != nil; entry = popField() {
-			child.AddLine(entry.fieldStr())
+			// Fields with pretty-printed values span multiple lines.
+			for _, line := range strings.Split(entry.fieldStr(), "\n") {
+				child.AddLine(line)
+			}
 		}
 	}
 	result = append(result, tp.FormattedRows()...)
 	return result
 }
 
+// truncatedEntries returns the entries to be shown by BuildStringRows. If
+// flags.MaxRows is set and the plan has more rows than that, subtrees are
+// collapsed until the plan fits, starting with the subtrees that took the least
+// time to execute, so that the hottest nodes are kept. The collapsed children of
+// a node are replaced by a single "... (N nodes omitted)" node.
+func (ob *OutputBuilder) truncatedEntries() []entry {
+	if ob.flags.MaxRows <= 0 {
+		return ob.entries
+	}
+
+	// planNode describes a node entry along with its fields.
+	type planNode struct {
+		entryIdx int
+		// parent is the index of the parent in nodes, or -1 for the root.
+		parent int
+		// rows is the number of rows shown for the node itself, including the
+		// blank line that precedes it when it has a parent.
+		rows int
+		// execTime is the time spent executing the subtree rooted at the node.
+		execTime time.Duration
+		// children is the number of children which are not collapsed.
+		children int
+		// omitted is the number of nodes in the collapsed subtrees of the
+		// children.
+		omitted   int
+		collapsed bool
+	}
+	var nodes []planNode
+	totalRows := 0
+	stack := []int{-1}
+	for i := range ob.entries {
+		e := &ob.entries[i]
+		if !e.isNode() {
+			if len(nodes) > 0 {
+				nodes[len(nodes)-1].rows++
+			} else {
+				totalRows++
+			}
+			continue
+		}
+		n := planNode{entryIdx: i, parent: stack[e.level-1], rows: 1, execTime: e.execTime}
+		if n.parent != -1 {
+			n.rows++
+			nodes[n.parent].children++
+		}
+		if e.columns != "" {
+			n.rows++
+		}
+		if e.ordering != "" {
+			n.rows++
+		}
+		stack = append(stack[:e.level], len(nodes))
+		nodes = append(nodes, n)
+	}
+	if totalRows > 0 {
+		// The top-level fields are separated from the tree by an empty row.
+		totalRows++
+	}
+	// Nodes are in pre-order, so the children of a node always follow it.
+	for i := len(nodes) - 1; i >= 0; i-- {
+		totalRows += nodes[i].rows
+		if p := nodes[i].parent; p != -1 {
+			nodes[p].execTime += nodes[i].execTime
+		}
+	}
+
+	// Collapse the leaves of the remaining tree with the lowest execution time
+	// one by one. Collapsing a node removes its rows (and those of its own
+	// "omitted" node), but adds an "omitted" node (two rows) to its parent if
+	// it doesn't have one yet.
+	for totalRows > ob.flags.MaxRows {
+		leaf := -1
+		for i := range nodes {
+			n := &nodes[i]
+			if n.collapsed || n.parent == -1 || n.children > 0 {
+				continue
+			}
+			if leaf == -1 || n.execTime <= nodes[leaf].execTime {
+				leaf = i
+			}
+		}
+		if leaf == -1 {
+			break
+		}
+		n := &nodes[leaf]
+		p := &nodes[n.parent]
+		n.collapsed = true
+		totalRows -= n.rows
+		if n.omitted > 0 {
+			totalRows -= 2
+		}
+		if p.omitted == 0 {
+			totalRows += 2
+		}
+		p.omitted += n.omitted + 1
+		p.children--
+	}
+
+	res := make([]entry, 0, len(ob.entries))
+	// hidden[i] is set if node i is part of a collapsed subtree.
+	hidden := make([]bool, len(nodes))
+	// omittedShown[i] is set once the "omitted" node of node i was added.
+	omittedShown := make([]bool, len(nodes))
+	nodeIdx := -1
+	for i := range ob.entries {
+		e := ob.entries[i]
+		if e.isNode() {
+			nodeIdx++
+			n := &nodes[nodeIdx]
+			hidden[nodeIdx] = n.collapsed || (n.parent != -1 && hidden[n.parent])
+			if n.collapsed && !hidden[n.parent] && !omittedShown[n.parent] {
+				omittedShown[n.parent] = true
+				omitted := fmt.Sprintf("... (%d nodes omitted)", nodes[n.parent].omitted)
+				if nodes[n.parent].omitted == 1 {
+					omitted = "... (1 node omitted)"
+				}
+				res = append(res, entry{level: e.level, node: omitted})
+			}
+		}
+		if nodeIdx == -1 || !hidden[nodeIdx] {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
 // BuildString creates a string representation of the plan information.
 // The output string always ends in a newline.
 func (ob *OutputBuilder) BuildString() string {
@@ -276,6 +486,58 @@ func (ob *OutputBuilder) BuildString() string {
 // BuildProtoTree creates a representation of the plan as a tree of
 // roachpb.ExplainTreePlanNodes.
 func (ob *OutputBuilder) BuildProtoTree() *roachpb.ExplainTreePlanNode {
+	return ob.buildProtoTree(false /* withColumns */).Children[0]
+}
+
+// PlanTreeString renders a plan that was previously built with BuildProtoTree
+// (for example, the sampled plan stored in the statement statistics) using the
+// same formatting as BuildString. The tree does not contain the columns and
+// orderings of the nodes, nor the top-level fields, so those are not shown.
+func PlanTreeString(plan *roachpb.ExplainTreePlanNode) string {
+	ob := NewOutputBuilder(Flags{})
+	var walk func(n *roachpb.ExplainTreePlanNode)
+	walk = func(n *roachpb.ExplainTreePlanNode) {
+		ob.EnterMetaNode(n.Name)
+		for _, attr := range n.Attrs {
+			ob.AddField(attr.Key, attr.Value)
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+		ob.LeaveNode()
+	}
+	walk(plan)
+	return ob.BuildString()
+}
+
+// Structured is a representation of the plan information which can be encoded
+// as JSON or YAML.
+type Structured struct {
+	// Fields contains the top-level fields (like "distribution"), which are not
+	// part of the tree.
+	Fields []*roachpb.ExplainTreePlanNode_Attr `json:"fields,omitempty" yaml:"fields,omitempty"`
+	Plan   *roachpb.ExplainTreePlanNode        `json:"plan,omitempty" yaml:"plan,omitempty"`
+	// Warnings can be populated by the caller.
+	Warnings []string `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+}
+
+// BuildStructured creates a Structured representation of the plan information.
+// Unlike BuildProtoTree, the columns (along with their types, if the ShowTypes
+// flag is set) and the ordering of each node are included as attributes of the
+// node, when they are shown.
+func (ob *OutputBuilder) BuildStructured() *Structured {
+	sentinel := ob.buildProtoTree(true /* withColumns */)
+	s := &Structured{Fields: sentinel.Attrs}
+	if len(sentinel.Children) > 0 {
+		s.Plan = sentinel.Children[0]
+	}
+	return s
+}
+
+// buildProtoTree returns a sentinel node which contains the top-level fields as
+// attributes and the root of the plan as its only child. If withColumns is set,
+// the columns and ordering of each node are added as its first attributes.
+func (ob *OutputBuilder) buildProtoTree(withColumns bool) *roachpb.ExplainTreePlanNode {
 	// We reconstruct the hierarchy using the levels.
 	// stack keeps track of the current node on each level. We use a sentinel node
 	// for level 0.
@@ -286,6 +548,18 @@ func (ob *OutputBuilder) BuildProtoTree() *roachpb.ExplainTreePlanNode {
 		if entry.isNode() {
 			parent := stack[entry.level-1]
 			child := &roachpb.ExplainTreePlanNode{Name: entry.node}
+			if withColumns && entry.columns != "" {
+				child.Attrs = append(child.Attrs, &roachpb.ExplainTreePlanNode_Attr{
+					Key:   "columns",
+					Value: entry.columns,
+				})
+			}
+			if withColumns && entry.ordering != "" {
+				child.Attrs = append(child.Attrs, &roachpb.ExplainTreePlanNode_Attr{
+					Key:   "ordering",
+					Value: entry.ordering,
+				})
+			}
 			parent.Children = append(parent.Children, child)
 			stack = append(stack[:entry.level], child)
 		} else {
@@ -297,5 +571,5 @@ func (ob *OutputBuilder) BuildProtoTree() *roachpb.ExplainTreePlanNode {
 		}
 	}
 
-	return sentinel.Children[0]
+	return sentinel
 }
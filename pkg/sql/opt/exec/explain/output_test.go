@@ -83,6 +83,9 @@ func TestOutputBuilder(t *testing.T) {
 		case "string":
 			return ob.BuildString()
 
+		case "tree-string":
+			return explain.PlanTreeString(ob.BuildProtoTree())
+
 		case "tree":
 			treeYaml, err := yaml.Marshal(ob.BuildProtoTree())
 			if err != nil {
@@ -90,6 +93,13 @@ func TestOutputBuilder(t *testing.T) {
 			}
 			return string(treeYaml)
 
+		case "structured":
+			structuredYaml, err := yaml.Marshal(ob.BuildStructured())
+			if err != nil {
+				panic(err)
+			}
+			return string(structuredYaml)
+
 		case "datums":
 			rows := ob.BuildExplainRows()
 
@@ -12,6 +12,7 @@ package exec
 
 import (
 	"context"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
 	"github.com/cockroachdb/cockroach/pkg/sql/opt/cat"
@@ -273,6 +274,15 @@ type ExplainAnnotationID int
 const (
 	// EstimatedStatsID is an annotation with a *EstimatedStats value.
 	EstimatedStatsID ExplainAnnotationID = iota
+
+	// ExecutionStatsID is an annotation with a *ExecutionStats value.
+	ExecutionStatsID
+
+	// ExecutionEngineID is an annotation with a *ExecutionEngine value.
+	ExecutionEngineID
+
+	// SourceSQLID is an annotation with a *SourceSQL value.
+	SourceSQLID
 )
 
 // EstimatedStats  contains estimated statistics about a given operator.
@@ -287,6 +297,70 @@ type EstimatedStats struct {
 	Cost float64
 }
 
+// ExecutionStats contains statistics about a given operator gathered from the
+// execution of the query.
+type ExecutionStats struct {
+	// RowCount is the number of rows produced by the operator. It is only
+	// valid if RowCountValid is set, since the output of some operators is not
+	// recorded during execution.
+	RowCount      int64
+	RowCountValid bool
+	// RowsWritten is the number of rows written by a mutation operator. It is
+	// only valid if RowsWrittenValid is set.
+	RowsWritten      int64
+	RowsWrittenValid bool
+	// NetworkBytesSent is the number of bytes of the output of the operator
+	// that were sent over the network to the next stage of the plan (e.g. when
+	// the output is shuffled or gathered on the gateway). It is only valid if
+	// NetworkBytesSentValid is set.
+	NetworkBytesSent      int64
+	NetworkBytesSentValid bool
+	// NetworkDeserializationTime is the time spent by the receiving nodes
+	// deserializing the output of the operator that was sent over the network.
+	// It is only valid if NetworkDeserializationTimeValid is set.
+	NetworkDeserializationTime      time.Duration
+	NetworkDeserializationTimeValid bool
+	// KVBatchCount is the number of KV batches sent by the operator and
+	// KVRoundTrips is the number of RPCs that were needed to serve them (a
+	// batch that spans several ranges requires several RPCs). They are only
+	// valid if KVBatchCountValid is set.
+	KVBatchCount      int64
+	KVRoundTrips      int64
+	KVBatchCountValid bool
+	// KVFollowerReads is the number of the KVRoundTrips that were served by
+	// follower replicas, and KVFollowerReadFallbacks is the number of times
+	// that a follower replica could not serve a read, which then fell back to
+	// the leaseholder. They are only valid if KVBatchCountValid is set.
+	KVFollowerReads         int64
+	KVFollowerReadFallbacks int64
+	// KVRowsRead is the number of rows read from KV by the operator (which is
+	// typically a scan). It is only valid if KVRowsReadValid is set.
+	KVRowsRead      int64
+	KVRowsReadValid bool
+	// RangesScanned is the number of distinct ranges that the KV batches of the
+	// operator were sent to. It is only valid if RangesScannedValid is set.
+	RangesScanned      int64
+	RangesScannedValid bool
+	// ExecTime is the time spent executing the operator. It is only valid if
+	// ExecTimeValid is set.
+	ExecTime      time.Duration
+	ExecTimeValid bool
+}
+
+// SourceSQL contains the SQL text which a given operator (typically the root
+// of a plan or subplan) originates from.
+type SourceSQL struct {
+	SQL string
+}
+
+// ExecutionEngine describes how a given operator was executed by a vectorized
+// flow.
+type ExecutionEngine struct {
+	// Wrapped is true if the operator was executed by wrapping a row execution
+	// processor rather than by a native columnar operator.
+	Wrapped bool
+}
+
 // BuildPlanForExplainFn builds an execution plan against the given
 // ExplainFactory.
 type BuildPlanForExplainFn func(ef ExplainFactory) (Plan, error)
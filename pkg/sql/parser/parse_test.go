@@ -549,6 +549,15 @@ func TestParse(t *testing.T) {
 		{`EXPLAIN ANALYZE (DISTSQL) SELECT 1`},
 		{`EXPLAIN ANALYZE (DEBUG) SELECT 1`},
 		{`EXPLAIN ANALYZE (PLAN) SELECT 1`},
+		{`EXPLAIN ANALYZE (PLAN, SUMMARY) SELECT 1`},
+		{`EXPLAIN ANALYZE (PLAN, JSON) SELECT 1`},
+		{`EXPLAIN ANALYZE (PLAN, YAML) SELECT 1`},
+		{`EXPLAIN ANALYZE (PLAN, REPEAT) SELECT 1`},
+		{`EXPLAIN ANALYZE (PLAN, REPEAT 5) SELECT 1`},
+		{`EXPLAIN ANALYZE (PLAN, JSON_SUMMARY) SELECT 1`},
+		{`EXPLAIN ANALYZE (PLAN, SQL) SELECT 1`},
+		{`EXPLAIN ANALYZE (PLAN, PRECISE) SELECT 1`},
+		{`EXPLAIN ANALYZE (PLAN, PRETTY) SELECT 1`},
 		{`SELECT * FROM [EXPLAIN SELECT 1]`},
 		{`SELECT * FROM [SHOW TRANSACTION STATUS]`},
 
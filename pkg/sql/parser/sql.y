@@ -1109,7 +1109,7 @@ func (u *sqlSymUnion) objectNamePrefixList() tree.ObjectNamePrefixList {
 %type <tree.AsOfClause> as_of_clause opt_as_of_clause
 %type <tree.Expr> opt_changefeed_sink
 
-%type <str> explain_option_name
+%type <str> explain_option explain_option_name
 %type <[]string> explain_option_list opt_enum_val_list enum_val_list
 
 %type <tree.ResolvableTypeReference> typename simple_typename cast_target
@@ -3581,6 +3581,12 @@ analyze_target:
 //
 // Plan options:
 //     TYPES, VERBOSE, OPT
+//     SUMMARY (only with ANALYZE)
+//     JSON, YAML (only with ANALYZE (PLAN))
+//     REPEAT [<count>] (only with ANALYZE (PLAN))
+//     JSON_SUMMARY (only with ANALYZE (PLAN))
+//     PRECISE (only with ANALYZE (PLAN))
+//     PRETTY (only with ANALYZE (PLAN))
 //
 // %SeeAlso: WEBDOCS/explain.html
 explain_stmt:
@@ -3680,15 +3686,22 @@ row_source_extension_stmt:
 | upsert_stmt       // EXTEND WITH HELP: UPSERT
 
 explain_option_list:
-  explain_option_name
+  explain_option
   {
     $$.val = []string{$1}
   }
-| explain_option_list ',' explain_option_name
+| explain_option_list ',' explain_option
   {
     $$.val = append($1.strs(), $3)
   }
 
+explain_option:
+  explain_option_name
+| explain_option_name ICONST
+  {
+    $$ = $1 + " " + $2.numVal().OrigString()
+  }
+
 // %Help: PREPARE - prepare a statement for later execution
 // %Category: Misc
 // %Text: PREPARE <name> [ ( <types...> ) ] AS <query>
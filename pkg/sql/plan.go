@@ -270,6 +270,13 @@ type flowInfo struct {
 	// corresponding flow. Users of this field will want to add a corresponding
 	// trace in order to calculate statistics.
 	analyzer *execstats.TraceAnalyzer
+	// explainVec contains the lines of the EXPLAIN (VEC) output for the flow.
+	// It is only populated when collecting a statement bundle.
+	explainVec []string
+	// outputProcessors maps each planNode which owns processors in the flow to
+	// the processors that produce its output (see getOutputProcessors). It is
+	// only populated when the explain plan is built.
+	outputProcessors map[planNode][]execinfrapb.ProcessorID
 }
 
 // planTop is the struct that collects the properties
@@ -296,6 +303,10 @@ type planTop struct {
 	// flags is populated during planning and execution.
 	flags planFlags
 
+	// notDistributedReason is the reason for which the plan was not
+	// distributed, if that is the case.
+	notDistributedReason string
+
 	// execErr retains the last execution error, if any.
 	execErr error
 
@@ -447,6 +458,16 @@ func (p *planTop) init(stmt *Statement, instrumentation *instrumentationHelper)
 	}
 }
 
+// canMutate returns true if the statement can modify data (or if we can't tell
+// because the memo is not available).
+func (p *planTop) canMutate() bool {
+	if p.mem == nil {
+		return true
+	}
+	rel, ok := p.mem.RootExpr().(memo.RelExpr)
+	return !ok || rel.Relational().CanMutate
+}
+
 // close ensures that the plan's resources have been deallocated.
 func (p *planTop) close(ctx context.Context) {
 	if p.flags.IsSet(planFlagExecDone) {
@@ -464,7 +485,11 @@ func (p *planTop) savePlanInfo(ctx context.Context) {
 	} else if p.flags.IsSet(planFlagPartiallyDistributed) {
 		distribution = physicalplan.PartiallyDistributedPlan
 	}
-	p.instrumentation.RecordPlanInfo(distribution, vectorized)
+	var notDistributedReason string
+	if distribution == physicalplan.LocalPlan {
+		notDistributedReason = p.notDistributedReason
+	}
+	p.instrumentation.RecordPlanInfo(distribution, notDistributedReason, vectorized)
 }
 
 // formatOptPlan returns a visual representation of the optimizer plan that was
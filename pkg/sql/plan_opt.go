@@ -12,6 +12,7 @@ package sql
 
 import (
 	"context"
+	"fmt"
 	"strings"
 
 	"github.com/cockroachdb/cockroach/pkg/settings"
@@ -575,6 +576,7 @@ func (opc *optPlanningCtx) runExecBuilder(
 		containsFullIndexScan = bld.ContainsFullIndexScan
 
 		planTop.instrumentation.RecordExplainPlan(explainPlan)
+		planTop.instrumentation.RecordHints(appliedHints(mem))
 	}
 
 	if stmt.ExpectedTypes != nil {
@@ -600,3 +602,64 @@ func (opc *optPlanningCtx) runExecBuilder(
 	}
 	return nil
 }
+
+// appliedHints returns a description of each index and join hint which is in
+// effect in the optimized plan, in the order in which the hinted expressions
+// appear in the plan. Duplicate descriptions are only listed once.
+func appliedHints(mem *memo.Memo) []string {
+	var hints []string
+	seen := make(map[string]struct{})
+	add := func(hint string) {
+		if _, ok := seen[hint]; !ok {
+			seen[hint] = struct{}{}
+			hints = append(hints, hint)
+		}
+	}
+	md := mem.Metadata()
+	var walk func(e opt.Expr)
+	walk = func(e opt.Expr) {
+		switch t := e.(type) {
+		case *memo.ScanExpr:
+			tab := md.Table(t.Table)
+			if t.Flags.NoIndexJoin {
+				add(fmt.Sprintf("no-index-join=%s", tab.Name()))
+			} else if t.Flags.ForceIndex {
+				dir := ""
+				switch t.Flags.Direction {
+				case tree.Ascending:
+					dir = ",fwd"
+				case tree.Descending:
+					dir = ",rev"
+				}
+				add(fmt.Sprintf("force-index=%s@%s%s", tab.Name(), tab.Index(t.Flags.Index).Name(), dir))
+			}
+
+		case *memo.LookupJoinExpr:
+			if !t.Flags.Empty() {
+				add(t.Flags.String())
+			}
+
+		case *memo.InvertedJoinExpr:
+			if !t.Flags.Empty() {
+				add(t.Flags.String())
+			}
+
+		case *memo.MergeJoinExpr:
+			if !t.Flags.Empty() {
+				add(t.Flags.String())
+			}
+
+		default:
+			if opt.IsJoinOp(t) {
+				if p := t.Private().(*memo.JoinPrivate); !p.Flags.Empty() {
+					add(p.Flags.String())
+				}
+			}
+		}
+		for i, n := 0, e.ChildCount(); i < n; i++ {
+			walk(e.Child(i))
+		}
+	}
+	walk(mem.RootExpr())
+	return hints
+}
@@ -12,6 +12,7 @@ package sql
 
 import (
 	"context"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/security"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
@@ -101,6 +102,14 @@ type PlanHookState interface {
 	ShowCreate(
 		ctx context.Context, dbPrefix string, allDescs []descpb.Descriptor, desc *tabledesc.Immutable, displayOptions ShowCreateDisplayOptions,
 	) (string, error)
+	// RecordStatementJob records that the current statement created the given
+	// job, so that the job can be described in statement diagnostics bundles.
+	// inStmtTxn should be set if the job was created in the transaction of the
+	// statement (as opposed to a separate transaction).
+	RecordStatementJob(jobID int64, inStmtTxn bool)
+	// RecordCustomPhase records that the current statement spent the given
+	// duration in the given custom phase (see RegisterCustomPhase).
+	RecordCustomPhase(phase CustomPhase, d time.Duration)
 }
 
 // AddPlanHook adds a hook used to short-circuit creating a planNode from a
@@ -28,16 +28,19 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
 	"github.com/cockroachdb/cockroach/pkg/sql/opt/exec"
 	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgnotice"
 	"github.com/cockroachdb/cockroach/pkg/sql/querycache"
 	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/transform"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
 	"github.com/cockroachdb/cockroach/pkg/sql/sessiondatapb"
+	"github.com/cockroachdb/cockroach/pkg/sql/stmtdiagnostics"
 	"github.com/cockroachdb/cockroach/pkg/sql/types"
 	"github.com/cockroachdb/cockroach/pkg/util/cancelchecker"
 	"github.com/cockroachdb/cockroach/pkg/util/envutil"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
 	"github.com/cockroachdb/cockroach/pkg/util/mon"
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/logtags"
@@ -92,6 +95,10 @@ type extendedEvalContext struct {
 	schemaAccessors *schemaInterface
 
 	sqlStatsCollector *sqlStatsCollector
+
+	// stmtHistory refers to the lightweight diagnostics retained for the last
+	// statements of the session (see stmtDiagnosticsHistory).
+	stmtHistory *stmtDiagnosticsHistory
 }
 
 // copy returns a deep copy of ctx.
@@ -421,6 +428,16 @@ func (p *planner) ExtendedEvalContextCopy() *extendedEvalContext {
 	return p.extendedEvalCtx.copy()
 }
 
+// RecordStatementJob is part of the PlanHookState interface.
+func (p *planner) RecordStatementJob(jobID int64, inStmtTxn bool) {
+	p.instrumentation.RecordStatementJob(jobID, inStmtTxn)
+}
+
+// RecordCustomPhase is part of the PlanHookState interface.
+func (p *planner) RecordCustomPhase(phase CustomPhase, d time.Duration) {
+	p.instrumentation.RecordCustomPhase(phase, d)
+}
+
 // CurrentDatabase is part of the resolver.SchemaResolver interface.
 func (p *planner) CurrentDatabase() string {
 	return p.SessionData().Database
@@ -476,6 +493,41 @@ func (p *planner) ParseType(sql string) (*types.T, error) {
 	return tree.ResolveType(context.TODO(), ref, p.semaCtx.GetTypeResolver())
 }
 
+// RequestStmtDiagnostics implements the tree.EvalPlanner interface.
+func (p *planner) RequestStmtDiagnostics(ctx context.Context, fingerprint string) error {
+	warning, err := p.execCfg.StmtDiagnosticsRecorder.Validate(ctx, fingerprint)
+	if err != nil {
+		return err
+	}
+	if warning != "" {
+		p.BufferClientNotice(ctx, pgnotice.NewWithSeverityf("WARNING", "%s", warning))
+	}
+	return p.execCfg.StmtDiagnosticsRecorder.InsertRequest(
+		ctx, stmtdiagnostics.RequestOptions{Fingerprint: fingerprint},
+	)
+}
+
+// CancelStmtDiagnosticsRequest implements the tree.EvalPlanner interface.
+func (p *planner) CancelStmtDiagnosticsRequest(ctx context.Context, requestID int64) error {
+	return p.execCfg.StmtDiagnosticsRecorder.Cancel(ctx, stmtdiagnostics.RequestID(requestID))
+}
+
+// StatementDiagnosticsHistory implements the tree.EvalPlanner interface.
+func (p *planner) StatementDiagnosticsHistory() (json.JSON, error) {
+	if p.extendedEvalCtx.stmtHistory == nil {
+		return nil, errors.AssertionFailedf("statement diagnostics history not available")
+	}
+	return p.extendedEvalCtx.stmtHistory.toJSON(), nil
+}
+
+// StatementBundleURL implements the tree.EvalPlanner interface.
+func (p *planner) StatementBundleURL(bundleID int64) (string, error) {
+	if p.execCfg.AdminURL == nil {
+		return "", errors.AssertionFailedf("admin URL not available")
+	}
+	return fmt.Sprintf("%s/_admin/v1/stmtbundle/%d", p.execCfg.AdminURL(), bundleID), nil
+}
+
 // ParseQualifiedTableName implements the tree.EvalDatabase interface.
 // This exists to get around a circular dependency between sql/sem/tree and
 // sql/parser. sql/parser depends on tree to make objects, so tree cannot import
@@ -65,6 +65,10 @@ type PreparedStatement struct {
 	// origin is the protocol in which this prepare statement was created.
 	// Used for reporting on `pg_prepared_statements`.
 	origin PreparedStatementOrigin
+	// name is the name under which this statement was prepared; it is empty for
+	// the unnamed prepared statement. Used for matching statement diagnostics
+	// requests.
+	name string
 }
 
 // MemoryEstimate returns a rough estimate of the PreparedStatement's memory
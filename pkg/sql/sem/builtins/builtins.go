@@ -22,7 +22,6 @@ import (
 	"hash/crc32"
 	"hash/fnv"
 	"math"
-	"math/rand"
 	"net"
 	"regexp/syntax"
 	"strconv"
@@ -44,6 +43,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/lex"
 	"github.com/cockroachdb/cockroach/pkg/sql/lexbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/opt/exec/explain"
 	"github.com/cockroachdb/cockroach/pkg/sql/parser"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
@@ -62,6 +62,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/ipaddr"
 	"github.com/cockroachdb/cockroach/pkg/util/json"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeofday"
 	"github.com/cockroachdb/cockroach/pkg/util/timetz"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
@@ -1796,10 +1797,11 @@ var builtins = map[string]builtinDefinition{
 		tree.Overload{
 			Types:      tree.ArgTypes{},
 			ReturnType: tree.FixedReturnType(types.Float),
-			Fn: func(_ *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
-				return tree.NewDFloat(tree.DFloat(rand.Float64())), nil
+			Fn: func(ctx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				return tree.NewDFloat(tree.DFloat(ctx.RandFloat64())), nil
 			},
-			Info:       "Returns a random float between 0 and 1.",
+			Info: "Returns a random float between 0 and 1. The results are reproducible " +
+				"if the random_seed session variable is set.",
 			Volatility: tree.VolatilityVolatile,
 		},
 	),
@@ -3104,6 +3106,42 @@ may increase either contention or retry errors, or both.`,
 			Volatility: tree.VolatilityImmutable,
 		}),
 
+	"crdb_internal.explain_tree_to_text": makeBuiltin(
+		tree.FunctionProperties{Category: categorySystemInfo},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"plan", types.Bytes}},
+			ReturnType: tree.FixedReturnType(types.String),
+			Fn: func(evalCtx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				var plan roachpb.ExplainTreePlanNode
+				if err := protoutil.Unmarshal([]byte(tree.MustBeDBytes(args[0])), &plan); err != nil {
+					return nil, errors.Wrap(err, "decoding plan")
+				}
+				return tree.NewDString(explain.PlanTreeString(&plan)), nil
+			},
+			Info: "Renders a plan stored in the statement statistics, encoded as a " +
+				"cockroach.sql.ExplainTreePlanNode protocol message, as text using the " +
+				"formatting of EXPLAIN.",
+			Volatility: tree.VolatilityImmutable,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"plan", types.Jsonb}},
+			ReturnType: tree.FixedReturnType(types.String),
+			Fn: func(evalCtx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				var plan roachpb.ExplainTreePlanNode
+				if _, err := protoreflect.JSONBMarshalToMessage(
+					tree.MustBeDJSON(args[0]).JSON, &plan,
+				); err != nil {
+					return nil, err
+				}
+				return tree.NewDString(explain.PlanTreeString(&plan)), nil
+			},
+			Info: "Renders a plan stored in the statement statistics, in the JSON " +
+				"representation of the cockroach.sql.ExplainTreePlanNode protocol message, " +
+				"as text using the formatting of EXPLAIN.",
+			Volatility: tree.VolatilityImmutable,
+		},
+	),
+
 	// Enum functions.
 	"enum_first": makeBuiltin(
 		tree.FunctionProperties{NullableArgs: true, Category: categoryEnum},
@@ -4011,6 +4049,194 @@ may increase either contention or retry errors, or both.`,
 			Volatility: tree.VolatilityVolatile,
 		},
 	),
+
+	// Returns the distinct structural hashes of the traces collected in
+	// statement diagnostics bundles for a statement fingerprint, which allows
+	// tooling to avoid downloading bundles with traces of the same shape.
+	"crdb_internal.statement_diagnostics_trace_hashes": makeBuiltin(
+		tree.FunctionProperties{
+			Category:         categorySystemInfo,
+			DistsqlBlocklist: true,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"fingerprint", types.String}},
+			ReturnType: tree.FixedReturnType(types.StringArray),
+			Fn: func(ctx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				if err := checkPrivilegedUser(ctx); err != nil {
+					return nil, err
+				}
+				rows, err := ctx.InternalExecutor.Query(
+					ctx.Ctx(), "stmt-diag-trace-hashes", ctx.Txn,
+					`SELECT DISTINCT trace_hash FROM system.statement_diagnostics
+WHERE statement_fingerprint = $1 AND trace_hash IS NOT NULL
+ORDER BY trace_hash`,
+					args[0],
+				)
+				if err != nil {
+					return nil, err
+				}
+				ret := tree.NewDArray(types.String)
+				for _, row := range rows {
+					if err := ret.Append(row[0]); err != nil {
+						return nil, err
+					}
+				}
+				return ret, nil
+			},
+			Info: "Returns the distinct trace hashes of the statement diagnostics bundles " +
+				"collected for the given statement fingerprint. Bundles with the same trace " +
+				"hash have traces with the same shape.",
+			Volatility: tree.VolatilityStable,
+		},
+	),
+
+	"crdb_internal.request_statement_diagnostics": makeBuiltin(
+		tree.FunctionProperties{
+			Category:         categorySystemInfo,
+			DistsqlBlocklist: true,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"stmt_fingerprint", types.String}},
+			ReturnType: tree.FixedReturnType(types.Bool),
+			Fn: func(ctx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				if err := checkPrivilegedUser(ctx); err != nil {
+					return nil, err
+				}
+				fingerprint := string(tree.MustBeDString(args[0]))
+				if err := ctx.Planner.RequestStmtDiagnostics(ctx.Ctx(), fingerprint); err != nil {
+					return nil, err
+				}
+				return tree.DBoolTrue, nil
+			},
+			Info: "Requests the collection of a statement diagnostics bundle for the next " +
+				"execution of a statement with the given fingerprint. A warning is returned if no " +
+				"statement with that fingerprint was executed recently, since the request may then " +
+				"never be fulfilled.",
+			Volatility: tree.VolatilityVolatile,
+		},
+	),
+
+	"crdb_internal.cancel_statement_diagnostics_request": makeBuiltin(
+		tree.FunctionProperties{
+			Category:         categorySystemInfo,
+			DistsqlBlocklist: true,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"request_id", types.Int}},
+			ReturnType: tree.FixedReturnType(types.Bool),
+			Fn: func(ctx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				if err := checkPrivilegedUser(ctx); err != nil {
+					return nil, err
+				}
+				requestID := int64(tree.MustBeDInt(args[0]))
+				if err := ctx.Planner.CancelStmtDiagnosticsRequest(ctx.Ctx(), requestID); err != nil {
+					return nil, err
+				}
+				return tree.DBoolTrue, nil
+			},
+			Info: "Cancels the pending statement diagnostics request with the given ID. " +
+				"Diagnostics that are being collected for the request are discarded.",
+			Volatility: tree.VolatilityVolatile,
+		},
+	),
+
+	"crdb_internal.statement_bundle_url": makeBuiltin(
+		tree.FunctionProperties{
+			Category:         categorySystemInfo,
+			DistsqlBlocklist: true,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"bundle_id", types.Int}},
+			ReturnType: tree.FixedReturnType(types.String),
+			Fn: func(ctx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				if err := checkPrivilegedUser(ctx); err != nil {
+					return nil, err
+				}
+				url, err := ctx.Planner.StatementBundleURL(int64(tree.MustBeDInt(args[0])))
+				if err != nil {
+					return nil, err
+				}
+				return tree.NewDString(url), nil
+			},
+			Info: "Returns the URL from which the statement diagnostics bundle with the given " +
+				"ID (see crdb_internal.statement_bundles) can be downloaded.",
+			Volatility: tree.VolatilityStable,
+		},
+	),
+
+	"crdb_internal.statement_diagnostics_history": makeBuiltin(
+		tree.FunctionProperties{
+			Category:         categorySystemInfo,
+			DistsqlBlocklist: true,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{},
+			ReturnType: tree.FixedReturnType(types.Jsonb),
+			Fn: func(ctx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				j, err := ctx.Planner.StatementDiagnosticsHistory()
+				if err != nil {
+					return nil, err
+				}
+				return tree.NewDJSON(j), nil
+			},
+			Info: "Returns the diagnostics retained for the last statements of the session " +
+				"(plan gist, planning and execution times, and the longest spans of the " +
+				"trace), from the oldest to the most recent statement. Diagnostics are only " +
+				"retained while the statement_diagnostics_history_size session variable is set.",
+			Volatility: tree.VolatilityVolatile,
+		},
+	),
+
+	"crdb_internal.plan_gist": makeBuiltin(
+		tree.FunctionProperties{
+			Category:         categorySystemInfo,
+			DistsqlBlocklist: true,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"sql", types.String}},
+			ReturnType: tree.FixedReturnType(types.String),
+			Fn: func(ctx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				gist, _, _, err := ctx.Planner.PlanInfo(ctx.Ctx(), string(tree.MustBeDString(args[0])))
+				if err != nil {
+					return nil, err
+				}
+				return tree.NewDString(gist), nil
+			},
+			Info: "Plans the given statement without executing it and returns the gist of " +
+				"the plan, which only depends on the shape of the plan (operators, tables and " +
+				"indexes) and not on the values used by the statement.",
+			Volatility: tree.VolatilityVolatile,
+		},
+	),
+
+	"crdb_internal.plan_info": makeBuiltin(
+		tree.FunctionProperties{
+			Category:         categorySystemInfo,
+			DistsqlBlocklist: true,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"sql", types.String}},
+			ReturnType: tree.FixedReturnType(types.Jsonb),
+			Fn: func(ctx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				gist, distribution, vectorized, err := ctx.Planner.PlanInfo(
+					ctx.Ctx(), string(tree.MustBeDString(args[0])),
+				)
+				if err != nil {
+					return nil, err
+				}
+				b := json.NewObjectBuilder(3)
+				b.Add("gist", json.FromString(gist))
+				b.Add("distribution", json.FromString(distribution))
+				b.Add("vectorized", json.FromBool(vectorized))
+				return tree.NewDJSON(b.Build()), nil
+			},
+			Info: "Plans the given statement without executing it and returns the gist of " +
+				"the plan (see crdb_internal.plan_gist), its distribution and whether it " +
+				"would be executed by the vectorized engine.",
+			Volatility: tree.VolatilityVolatile,
+		},
+	),
+
 	// Returns the number of distinct inverted index entries that would be
 	// generated for a value.
 	"crdb_internal.num_geo_inverted_index_entries": makeBuiltin(
@@ -15,6 +15,7 @@ import (
 	"fmt"
 	"math"
 	"math/big"
+	"math/rand"
 	"regexp"
 	"strings"
 	"time"
@@ -44,6 +45,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/json"
 	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeofday"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/uuid"
@@ -3030,6 +3032,28 @@ type EvalPlanner interface {
 
 	// EvalSubquery returns the Datum for the given subquery node.
 	EvalSubquery(expr *Subquery) (Datum, error)
+
+	// RequestStmtDiagnostics creates a statement diagnostics request for the
+	// given statement fingerprint, after warning the client if the fingerprint
+	// doesn't match any recently executed statement.
+	RequestStmtDiagnostics(ctx context.Context, fingerprint string) error
+
+	// CancelStmtDiagnosticsRequest cancels the statement diagnostics request
+	// with the given ID.
+	CancelStmtDiagnosticsRequest(ctx context.Context, requestID int64) error
+
+	// StatementDiagnosticsHistory returns the lightweight diagnostics retained
+	// for the last statements of the session, as a JSON array.
+	StatementDiagnosticsHistory() (json.JSON, error)
+
+	// StatementBundleURL returns the URL from which the statement diagnostics
+	// bundle with the given ID can be downloaded.
+	StatementBundleURL(bundleID int64) (string, error)
+
+	// PlanInfo plans the given statement in the current session, without
+	// executing it, and returns the gist of the plan, its distribution and
+	// whether it would be executed by the vectorized engine.
+	PlanInfo(ctx context.Context, sql string) (gist, distribution string, vectorized bool, _ error)
 }
 
 // EvalSessionAccessor is a limited interface to access session variables.
@@ -3303,6 +3327,60 @@ type EvalContext struct {
 	SingleDatumAggMemAccount *mon.BoundAccount
 
 	SQLLivenessReader sqlliveness.Reader
+
+	// stmtRand is the source of randomness of the statement (see RandFloat64).
+	// It is shared by the copies of the EvalContext.
+	stmtRand *statementRand
+}
+
+// statementRand is the source of randomness of a statement. It can be used
+// concurrently by the copies of the EvalContext of the statement.
+type statementRand struct {
+	mu syncutil.Mutex
+	// rng is created (along with seed) the first time it is used.
+	rng  *rand.Rand
+	seed int64
+}
+
+// ResetRand gives a new source of randomness to the next statement evaluated
+// with this EvalContext.
+func (ctx *EvalContext) ResetRand() {
+	ctx.stmtRand = &statementRand{}
+}
+
+// RandFloat64 returns a pseudo-random number in [0.0,1.0) from the source of
+// randomness of the statement. The source is seeded with the random_seed
+// session variable if it is set, which makes the results reproducible, and
+// with a random seed otherwise; either way, the seed is available through
+// RandSeed. Only the copies of the EvalContext on the gateway node share the
+// source of randomness of the statement.
+func (ctx *EvalContext) RandFloat64() float64 {
+	r := ctx.stmtRand
+	if r == nil {
+		return rand.Float64()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rng == nil {
+		r.seed = rand.Int63()
+		if ctx.SessionData != nil && ctx.SessionData.RandomSeed != 0 {
+			r.seed = ctx.SessionData.RandomSeed
+		}
+		r.rng = rand.New(rand.NewSource(r.seed))
+	}
+	return r.rng.Float64()
+}
+
+// RandSeed returns the seed of the source of randomness of the statement, if
+// the statement used it.
+func (ctx *EvalContext) RandSeed() (seed int64, ok bool) {
+	r := ctx.stmtRand
+	if r == nil {
+		return 0, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seed, r.rng != nil
 }
 
 // MakeTestingEvalContext returns an EvalContext that includes a MemoryMonitor.
@@ -12,6 +12,7 @@ package tree
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
@@ -41,6 +42,18 @@ type ExplainAnalyze struct {
 type ExplainOptions struct {
 	Mode  ExplainMode
 	Flags [numExplainFlags + 1]bool
+	// RepeatCount is the number of executions requested with REPEAT <n>; it is
+	// zero if the REPEAT flag is not set or was set without a count.
+	RepeatCount int64
+}
+
+// flagString returns the string for the given flag, including its value (if
+// any).
+func (opts *ExplainOptions) flagString(f ExplainFlag) string {
+	if f == ExplainFlagRepeat && opts.RepeatCount > 0 {
+		return fmt.Sprintf("%s %d", f, opts.RepeatCount)
+	}
+	return f.String()
 }
 
 // ExplainMode indicates the mode of the explain. Currently there are two modes:
@@ -104,14 +117,30 @@ const (
 	ExplainFlagTypes
 	ExplainFlagEnv
 	ExplainFlagCatalog
+	ExplainFlagSummary
+	ExplainFlagJSON
+	ExplainFlagYAML
+	ExplainFlagRepeat
+	ExplainFlagJSONSummary
+	ExplainFlagSQL
+	ExplainFlagPrecise
+	ExplainFlagPretty
 	numExplainFlags = iota
 )
 
 var explainFlagStrings = [...]string{
-	ExplainFlagVerbose: "VERBOSE",
-	ExplainFlagTypes:   "TYPES",
-	ExplainFlagEnv:     "ENV",
-	ExplainFlagCatalog: "CATALOG",
+	ExplainFlagVerbose:     "VERBOSE",
+	ExplainFlagTypes:       "TYPES",
+	ExplainFlagEnv:         "ENV",
+	ExplainFlagCatalog:     "CATALOG",
+	ExplainFlagSummary:     "SUMMARY",
+	ExplainFlagJSON:        "JSON",
+	ExplainFlagYAML:        "YAML",
+	ExplainFlagRepeat:      "REPEAT",
+	ExplainFlagJSONSummary: "JSON_SUMMARY",
+	ExplainFlagSQL:         "SQL",
+	ExplainFlagPrecise:     "PRECISE",
+	ExplainFlagPretty:      "PRETTY",
 }
 
 var explainFlagStringMap = func() map[string]ExplainFlag {
@@ -146,7 +175,7 @@ func (node *Explain) Format(ctx *FmtCtx) {
 			} else {
 				ctx.WriteString(", ")
 			}
-			ctx.WriteString(f.String())
+			ctx.WriteString(node.flagString(f))
 		}
 	}
 	if wroteFlag {
@@ -164,7 +193,7 @@ func (node *Explain) doc(p *PrettyCfg) pretty.Doc {
 	}
 	for f := ExplainFlag(1); f <= numExplainFlags; f++ {
 		if node.Flags[f] {
-			opts = append(opts, pretty.Keyword(f.String()))
+			opts = append(opts, pretty.Keyword(node.flagString(f)))
 		}
 	}
 	if len(opts) > 0 {
@@ -182,7 +211,7 @@ func (node *ExplainAnalyze) Format(ctx *FmtCtx) {
 
 	for f := ExplainFlag(1); f <= numExplainFlags; f++ {
 		if node.Flags[f] {
-			fmt.Fprintf(ctx, ", %s", f.String())
+			fmt.Fprintf(ctx, ", %s", node.flagString(f))
 		}
 	}
 	ctx.WriteString(") ")
@@ -196,7 +225,7 @@ func (node *ExplainAnalyze) doc(p *PrettyCfg) pretty.Doc {
 	opts = append(opts, pretty.Keyword(node.Mode.String()))
 	for f := ExplainFlag(1); f <= numExplainFlags; f++ {
 		if node.Flags[f] {
-			opts = append(opts, pretty.Keyword(f.String()))
+			opts = append(opts, pretty.Keyword(node.flagString(f)))
 		}
 	}
 	if len(opts) > 0 {
@@ -218,6 +247,19 @@ func MakeExplain(options []string, stmt Statement) (Statement, error) {
 	var analyze bool
 	for _, opt := range options {
 		opt = strings.ToUpper(opt)
+		// Only REPEAT can be followed by a value (the number of executions).
+		if fields := strings.Fields(opt); len(fields) == 2 {
+			if fields[0] != explainFlagStrings[ExplainFlagRepeat] {
+				return nil, pgerror.Newf(pgcode.Syntax,
+					"EXPLAIN option %s does not take a value", fields[0])
+			}
+			n, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil || n <= 0 {
+				return nil, pgerror.Newf(pgcode.Syntax,
+					"REPEAT count must be a positive integer: %s", fields[1])
+			}
+			opt, opts.RepeatCount = fields[0], n
+		}
 		if m, ok := explainModeStringMap[opt]; ok {
 			if opts.Mode != 0 {
 				return nil, pgerror.Newf(pgcode.Syntax, "cannot set EXPLAIN mode more than once: %s", opt)
@@ -245,6 +287,47 @@ func MakeExplain(options []string, stmt Statement) (Statement, error) {
 		}
 	}
 
+	if opts.Flags[ExplainFlagJSON] || opts.Flags[ExplainFlagYAML] {
+		if !analyze || opts.Mode != ExplainPlan {
+			return nil, pgerror.Newf(pgcode.Syntax,
+				"JSON and YAML flags can only be used with EXPLAIN ANALYZE (PLAN)")
+		}
+		if opts.Flags[ExplainFlagJSON] && opts.Flags[ExplainFlagYAML] {
+			return nil, pgerror.Newf(pgcode.Syntax, "JSON and YAML flags cannot be used together")
+		}
+	}
+
+	if opts.Flags[ExplainFlagRepeat] && (!analyze || opts.Mode != ExplainPlan) {
+		return nil, pgerror.Newf(pgcode.Syntax,
+			"REPEAT flag can only be used with EXPLAIN ANALYZE (PLAN)")
+	}
+
+	if opts.Flags[ExplainFlagJSONSummary] {
+		if !analyze || opts.Mode != ExplainPlan {
+			return nil, pgerror.Newf(pgcode.Syntax,
+				"JSON_SUMMARY flag can only be used with EXPLAIN ANALYZE (PLAN)")
+		}
+		if opts.Flags[ExplainFlagJSON] || opts.Flags[ExplainFlagYAML] {
+			return nil, pgerror.Newf(pgcode.Syntax,
+				"JSON_SUMMARY flag cannot be used together with JSON or YAML")
+		}
+	}
+
+	if opts.Flags[ExplainFlagSQL] && (!analyze || opts.Mode != ExplainPlan) {
+		return nil, pgerror.Newf(pgcode.Syntax,
+			"SQL flag can only be used with EXPLAIN ANALYZE (PLAN)")
+	}
+
+	if opts.Flags[ExplainFlagPrecise] && (!analyze || opts.Mode != ExplainPlan) {
+		return nil, pgerror.Newf(pgcode.Syntax,
+			"PRECISE flag can only be used with EXPLAIN ANALYZE (PLAN)")
+	}
+
+	if opts.Flags[ExplainFlagPretty] && (!analyze || opts.Mode != ExplainPlan) {
+		return nil, pgerror.Newf(pgcode.Syntax,
+			"PRETTY flag can only be used with EXPLAIN ANALYZE (PLAN)")
+	}
+
 	if analyze {
 		if opts.Mode != ExplainDistSQL && opts.Mode != ExplainDebug && opts.Mode != ExplainPlan {
 			return nil, pgerror.Newf(pgcode.Syntax, "EXPLAIN ANALYZE cannot be used with %s", opts.Mode)
@@ -258,6 +341,9 @@ func MakeExplain(options []string, stmt Statement) (Statement, error) {
 	if opts.Mode == ExplainDebug {
 		return nil, pgerror.Newf(pgcode.Syntax, "DEBUG flag can only be used with EXPLAIN ANALYZE")
 	}
+	if opts.Flags[ExplainFlagSummary] {
+		return nil, pgerror.Newf(pgcode.Syntax, "SUMMARY flag can only be used with EXPLAIN ANALYZE")
+	}
 	return &Explain{
 		ExplainOptions: opts,
 		Statement:      stmt,
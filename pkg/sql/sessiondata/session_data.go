@@ -198,6 +198,25 @@ type LocalOnlySessionData struct {
 	// DisallowFullTableScans indicates whether queries that plan full table scans
 	// should be rejected.
 	DisallowFullTableScans bool
+	// CollectAllStatementBundles indicates whether a statement diagnostics
+	// bundle should be collected for every statement executed in the session.
+	CollectAllStatementBundles bool
+	// ExplainAnalyzeTraceSummary indicates whether the output of EXPLAIN
+	// ANALYZE (PLAN) should include a summary of the trace of the statement.
+	ExplainAnalyzeTraceSummary bool
+	// ExplainAnalyzeMaxRows, if positive, is the maximum number of rows of the
+	// plan shown by EXPLAIN ANALYZE (PLAN); larger plans are truncated by
+	// collapsing the subtrees which took the least time to execute.
+	ExplainAnalyzeMaxRows int
+	// RandomSeed, if not zero, is the seed of the source of randomness of each
+	// statement (used by the random() builtin), which makes the results of the
+	// statements reproducible.
+	RandomSeed int64
+	// StatementDiagnosticsHistorySize is the number of recently executed
+	// statements of the session for which lightweight diagnostics are retained
+	// (see crdb_internal.statement_diagnostics_history). If zero, no diagnostics
+	// are retained.
+	StatementDiagnosticsHistorySize int
 	// ImplicitSelectForUpdate is true if FOR UPDATE locking may be used during
 	// the row-fetch phase of mutation statements.
 	ImplicitSelectForUpdate bool
@@ -497,6 +497,19 @@ func mostRecentAutomaticStat(tableStats []*TableStatistic) *TableStatistic {
 	return nil
 }
 
+// AutomaticStatsOverdue returns true if an automatic refresh of the statistics
+// of a table is overdue at the given time: either the table has no automatic
+// statistics, or more than twice the average time between refreshes has passed
+// since the most recent one (see maybeRefreshStats). The statistics must be
+// sorted with the most recent first.
+func AutomaticStatsOverdue(tableStats []*TableStatistic, now time.Time) bool {
+	stat := mostRecentAutomaticStat(tableStats)
+	if stat == nil {
+		return true
+	}
+	return now.After(stat.CreatedAt.Add(2 * avgRefreshTime(tableStats)))
+}
+
 // avgRefreshTime returns the average time between automatic statistics
 // refreshes given a list of tableStats from one table. It does so by finding
 // the most recent automatically generated statistic (identified by the name
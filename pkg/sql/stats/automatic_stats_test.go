@@ -358,6 +358,51 @@ func TestAverageRefreshTime(t *testing.T) {
 	}
 }
 
+func TestAutomaticStatsOverdue(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	now := timeutil.Now()
+	stat := func(name string, age time.Duration) *TableStatistic {
+		return &TableStatistic{TableStatisticProto: TableStatisticProto{
+			Name:      name,
+			ColumnIDs: []descpb.ColumnID{1},
+			CreatedAt: now.Add(-age),
+		}}
+	}
+
+	testCases := []struct {
+		stats   []*TableStatistic
+		overdue bool
+	}{
+		// No statistics.
+		{stats: nil, overdue: true},
+		// No automatic statistics.
+		{stats: []*TableStatistic{stat("s1", time.Minute)}, overdue: true},
+		// A single automatic statistic uses the default average refresh time.
+		{stats: []*TableStatistic{stat(AutoStatsName, time.Hour)}, overdue: false},
+		{stats: []*TableStatistic{stat(AutoStatsName, 25*time.Hour)}, overdue: true},
+		// The average time between the two refreshes is one hour.
+		{
+			stats: []*TableStatistic{
+				stat(AutoStatsName, time.Hour), stat(AutoStatsName, 2*time.Hour),
+			},
+			overdue: false,
+		},
+		{
+			stats: []*TableStatistic{
+				stat(AutoStatsName, 3*time.Hour), stat(AutoStatsName, 4*time.Hour),
+			},
+			overdue: true,
+		},
+	}
+	for i, tc := range testCases {
+		if actual := AutomaticStatsOverdue(tc.stats, now); actual != tc.overdue {
+			t.Errorf("%d: expected overdue=%t, got %t", i, tc.overdue, actual)
+		}
+	}
+}
+
 func TestAutoStatsReadOnlyTables(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -0,0 +1,114 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package sql
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+)
+
+// maxStmtDiagnosticsHistorySize is the maximum value of the
+// statement_diagnostics_history_size session variable.
+const maxStmtDiagnosticsHistorySize = 1000
+
+// stmtDiagnosticsHistoryMaxSpans is the maximum number of spans included in the
+// trace summary of each statement retained in a stmtDiagnosticsHistory.
+const stmtDiagnosticsHistoryMaxSpans = 3
+
+// stmtDiagnosticsHistoryEntry contains the lightweight diagnostics retained for
+// a statement in a stmtDiagnosticsHistory.
+type stmtDiagnosticsHistoryEntry struct {
+	fingerprint string
+	planGist    string
+	// planningLatency and runLatency are the durations of the planning and
+	// execution phases of the statement.
+	planningLatency time.Duration
+	runLatency      time.Duration
+	// topSpans contains the spans of the trace of the statement with the
+	// longest duration (see stmtDiagnosticsHistoryMaxSpans).
+	topSpans []stmtDiagnosticsHistorySpan
+	// err is the error returned by the statement, if any.
+	err string
+}
+
+// stmtDiagnosticsHistorySpan is a span of the trace of a statement retained in
+// a stmtDiagnosticsHistory.
+type stmtDiagnosticsHistorySpan struct {
+	operation string
+	duration  time.Duration
+}
+
+// stmtDiagnosticsHistory retains lightweight diagnostics for the last
+// statements executed in a session, when the statement_diagnostics_history_size
+// session variable is set. The statements are traced (on the gateway node only)
+// but no bundles are built, which allows diagnosing a statement after the fact
+// without paying the price of collecting a bundle for every statement. The
+// diagnostics are returned by crdb_internal.statement_diagnostics_history().
+type stmtDiagnosticsHistory struct {
+	// entries is a ring buffer of at most statement_diagnostics_history_size
+	// entries; start is the index of the oldest entry.
+	entries []stmtDiagnosticsHistoryEntry
+	start   int
+}
+
+// add adds an entry to the history, evicting the oldest entries so that at
+// most size entries are retained. The size must be positive.
+func (h *stmtDiagnosticsHistory) add(size int, e stmtDiagnosticsHistoryEntry) {
+	if h.start != 0 && len(h.entries) != size {
+		// The size changed since the buffer wrapped around; reorder the entries
+		// so that the oldest entry is first.
+		h.entries, h.start = h.ordered(), 0
+	}
+	if len(h.entries) > size {
+		h.entries = h.entries[len(h.entries)-size:]
+	}
+	if len(h.entries) < size {
+		h.entries = append(h.entries, e)
+		return
+	}
+	h.entries[h.start] = e
+	h.start = (h.start + 1) % size
+}
+
+// ordered returns the entries of the history, from the oldest to the most
+// recent.
+func (h *stmtDiagnosticsHistory) ordered() []stmtDiagnosticsHistoryEntry {
+	res := make([]stmtDiagnosticsHistoryEntry, 0, len(h.entries))
+	res = append(res, h.entries[h.start:]...)
+	return append(res, h.entries[:h.start]...)
+}
+
+// toJSON returns a JSON array with an object for each entry of the history,
+// from the oldest to the most recent statement.
+func (h *stmtDiagnosticsHistory) toJSON() json.JSON {
+	arr := json.NewArrayBuilder(len(h.entries))
+	for _, e := range h.ordered() {
+		spans := json.NewArrayBuilder(len(e.topSpans))
+		for i := range e.topSpans {
+			span := json.NewObjectBuilder(2)
+			span.Add("operation", json.FromString(e.topSpans[i].operation))
+			span.Add("duration_ns", json.FromInt64(e.topSpans[i].duration.Nanoseconds()))
+			spans.Add(span.Build())
+		}
+		obj := json.NewObjectBuilder(6)
+		obj.Add("fingerprint", json.FromString(e.fingerprint))
+		obj.Add("plan_gist", json.FromString(e.planGist))
+		obj.Add("planning_time_ns", json.FromInt64(e.planningLatency.Nanoseconds()))
+		obj.Add("execution_time_ns", json.FromInt64(e.runLatency.Nanoseconds()))
+		obj.Add("top_spans", spans.Build())
+		if e.err != "" {
+			obj.Add("error", json.FromString(e.err))
+		}
+		arr.Add(obj.Build())
+	}
+	return arr.Build()
+}
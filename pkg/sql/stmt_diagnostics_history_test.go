@@ -0,0 +1,107 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStmtDiagnosticsHistoryRingBuffer(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	var h stmtDiagnosticsHistory
+	fingerprints := func() []string {
+		var res []string
+		for _, e := range h.ordered() {
+			res = append(res, e.fingerprint)
+		}
+		return res
+	}
+	add := func(size int, fingerprints ...string) {
+		for _, f := range fingerprints {
+			h.add(size, stmtDiagnosticsHistoryEntry{fingerprint: f})
+		}
+	}
+
+	add(3, "a", "b")
+	require.Equal(t, []string{"a", "b"}, fingerprints())
+	add(3, "c", "d", "e")
+	require.Equal(t, []string{"c", "d", "e"}, fingerprints())
+	// Shrinking the history evicts the oldest entries.
+	add(2, "f")
+	require.Equal(t, []string{"e", "f"}, fingerprints())
+	// Growing the history retains the existing entries.
+	add(4, "g", "h")
+	require.Equal(t, []string{"e", "f", "g", "h"}, fingerprints())
+	add(4, "i")
+	require.Equal(t, []string{"f", "g", "h", "i"}, fingerprints())
+}
+
+func TestStmtDiagnosticsHistory(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	srv, godb, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer srv.Stopper().Stop(ctx)
+	r := sqlutils.MakeSQLRunner(godb)
+	// Use a single connection, since the history is per session.
+	godb.SetMaxOpenConns(1)
+
+	history := func() json.JSON {
+		var s string
+		r.QueryRow(t, "SELECT crdb_internal.statement_diagnostics_history()").Scan(&s)
+		j, err := json.ParseJSON(s)
+		require.NoError(t, err)
+		return j
+	}
+	require.Equal(t, "[]", history().String())
+
+	r.ExpectErr(t, "must be between 0 and 1000", "SET statement_diagnostics_history_size = -1")
+	r.Exec(t, "SET statement_diagnostics_history_size = 2")
+	r.Exec(t, "SELECT 1")
+	r.Exec(t, "SELECT 1, 2")
+	r.Exec(t, "SELECT 1, 2, 3")
+
+	// The query of the history itself is only added once it finishes.
+	j := history()
+	require.Equal(t, 2, j.Len())
+	for i, exp := range []string{"SELECT _, _", "SELECT _, _, _"} {
+		e, err := j.FetchValIdx(i)
+		require.NoError(t, err)
+		for _, key := range []string{
+			"fingerprint", "plan_gist", "planning_time_ns", "execution_time_ns", "top_spans",
+		} {
+			v, err := e.FetchValKey(key)
+			require.NoError(t, err)
+			require.NotNil(t, v, fmt.Sprintf("missing %s in %s", key, e))
+		}
+		f, err := e.FetchValKey("fingerprint")
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("%q", exp), f.String())
+	}
+
+	// Resetting the session variable stops the collection.
+	r.Exec(t, "SET statement_diagnostics_history_size = 0")
+	r.Exec(t, "SELECT 1")
+	require.Equal(t, 2, history().Len())
+}
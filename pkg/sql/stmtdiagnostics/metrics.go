@@ -0,0 +1,146 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package stmtdiagnostics
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+)
+
+var storageMetricInterval = settings.RegisterNonNegativeDurationSetting(
+	"sql.stmt_diagnostics.storage_metric_interval",
+	"rate at which the size of the stored statement diagnostics bundles is computed, "+
+		"set to zero to disable",
+	10*time.Minute,
+)
+
+var _ metric.Struct = (*Metrics)(nil)
+
+// Metrics exposes the metrics of the statement diagnostics bundles.
+type Metrics struct {
+	// Bundles and BundleBytes count the bundles stored by this node, and their
+	// total size.
+	Bundles     *metric.Counter
+	BundleBytes *metric.Counter
+	// StoredBundleBytes is the size of all the stored bundles (collected by any
+	// node), as of the last time it was computed.
+	StoredBundleBytes *metric.Gauge
+}
+
+func makeMetrics() Metrics {
+	return Metrics{
+		Bundles:           metric.NewCounter(metaBundles),
+		BundleBytes:       metric.NewCounter(metaBundleBytes),
+		StoredBundleBytes: metric.NewGauge(metaStoredBundleBytes),
+	}
+}
+
+// MetricStruct makes Metrics a metric.Struct.
+func (m *Metrics) MetricStruct() {}
+
+var (
+	metaBundles = metric.Metadata{
+		Name:        "sql.stmt_diagnostics.bundles",
+		Help:        "Number of statement diagnostics bundles stored",
+		Measurement: "Bundles",
+		Unit:        metric.Unit_COUNT,
+		MetricType:  io_prometheus_client.MetricType_COUNTER,
+	}
+	metaBundleBytes = metric.Metadata{
+		Name:        "sql.stmt_diagnostics.bytes",
+		Help:        "Number of bytes of the statement diagnostics bundles stored",
+		Measurement: "Storage",
+		Unit:        metric.Unit_BYTES,
+		MetricType:  io_prometheus_client.MetricType_COUNTER,
+	}
+	metaStoredBundleBytes = metric.Metadata{
+		Name:        "sql.stmt_diagnostics.stored_bytes",
+		Help:        "Size of all the statement diagnostics bundles in system.statement_bundle_chunks",
+		Measurement: "Storage",
+		Unit:        metric.Unit_BYTES,
+		MetricType:  io_prometheus_client.MetricType_GAUGE,
+	}
+)
+
+// Metrics returns the metrics of the registry.
+func (r *Registry) Metrics() *Metrics {
+	return &r.metrics
+}
+
+// recordStoredBundle updates the metrics after a bundle of the given size was
+// stored.
+func (r *Registry) recordStoredBundle(size int) {
+	r.metrics.Bundles.Inc(1)
+	r.metrics.BundleBytes.Inc(int64(size))
+}
+
+// pollStorage periodically updates the StoredBundleBytes metric, at the rate
+// determined by sql.stmt_diagnostics.storage_metric_interval.
+func (r *Registry) pollStorage(ctx context.Context) {
+	var (
+		timer           timeutil.Timer
+		lastUpdate      time.Time
+		intervalChanged = make(chan struct{}, 1)
+	)
+	defer timer.Stop()
+	storageMetricInterval.SetOnChange(&r.st.SV, func() {
+		select {
+		case intervalChanged <- struct{}{}:
+		default:
+		}
+	})
+	for {
+		if interval := storageMetricInterval.Get(&r.st.SV); interval <= 0 {
+			// Setting the interval to a non-positive value stops the updates.
+			timer.Stop()
+		} else {
+			timer.Reset(timeutil.Until(lastUpdate.Add(interval)))
+		}
+		select {
+		case <-intervalChanged:
+			continue // go back around and maybe reset the timer
+		case <-timer.C:
+			timer.Read = true
+		case <-ctx.Done():
+			return
+		}
+		if err := r.updateStoredBundleBytes(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warningf(ctx, "error computing the size of statement diagnostics bundles: %s", err)
+		}
+		lastUpdate = timeutil.Now()
+	}
+}
+
+// updateStoredBundleBytes sets the StoredBundleBytes metric to the size of the
+// chunks in system.statement_bundle_chunks.
+func (r *Registry) updateStoredBundleBytes(ctx context.Context) error {
+	row, err := r.ie.QueryRowEx(ctx, "stmt-diag-stored-bytes", nil, /* txn */
+		sessiondata.InternalExecutorOverride{User: security.RootUserName()},
+		"SELECT coalesce(sum(length(data)), 0) FROM system.statement_bundle_chunks",
+	)
+	if err != nil {
+		return err
+	}
+	r.metrics.StoredBundleBytes.Update(int64(tree.MustBeDInt(row[0])))
+	return nil
+}
@@ -14,7 +14,18 @@ import "context"
 
 // InsertRequestInternal exposes the form of insert which returns the request ID
 // as an int64 to tests in this package.
-func (r *Registry) InsertRequestInternal(ctx context.Context, fprint string) (int64, error) {
-	id, err := r.insertRequestInternal(ctx, fprint)
+func (r *Registry) InsertRequestInternal(ctx context.Context, opts RequestOptions) (int64, error) {
+	id, err := r.insertRequestInternal(ctx, opts)
 	return int64(id), err
 }
+
+// PollRequestsInternal exposes pollRequests to tests in this package.
+func (r *Registry) PollRequestsInternal(ctx context.Context) error {
+	return r.pollRequests(ctx)
+}
+
+// UpdateStoredBundleBytesInternal exposes updateStoredBundleBytes to tests in
+// this package.
+func (r *Registry) UpdateStoredBundleBytesInternal(ctx context.Context) error {
+	return r.updateStoredBundleBytes(ctx)
+}
@@ -13,8 +13,12 @@ package stmtdiagnostics
 import (
 	"context"
 	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/clusterversion"
 	"github.com/cockroachdb/cockroach/pkg/gossip"
 	"github.com/cockroachdb/cockroach/pkg/kv"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
@@ -26,6 +30,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
 	"github.com/cockroachdb/cockroach/pkg/sql/types"
 	"github.com/cockroachdb/cockroach/pkg/util"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
@@ -60,20 +65,31 @@ type Registry struct {
 		// internally; it'd deadlock.
 		syncutil.Mutex
 		// requests waiting for the right query to come along.
-		requestFingerprints map[RequestID]string
-		// ids of requests that this node is in the process of servicing.
-		ongoing map[RequestID]struct{}
+		requests map[RequestID]request
+		// requests that this node is in the process of servicing.
+		ongoing map[RequestID]request
+		// requests that were canceled while this node was in the process of
+		// servicing them. See Cancel and WasCanceled.
+		canceled map[RequestID]struct{}
 
 		// epoch is observed before reading system.statement_diagnostics_requests, and then
 		// checked again before loading the tables contents. If the value changed in
 		// between, then the table contents might be stale.
 		epoch int
 	}
+	// numRequests is the number of entries in mu.requests; it is accessed
+	// atomically so that HasPendingRequests doesn't need to acquire the lock.
+	// It must be updated (see updateNumRequestsLocked) whenever mu.requests is
+	// modified.
+	numRequests int32
+
 	st     *cluster.Settings
 	ie     sqlutil.InternalExecutor
 	db     *kv.DB
 	gossip gossip.OptionalGossip
 
+	metrics Metrics
+
 	// gossipUpdateChan is used to notify the polling loop that a diagnostics
 	// request has been added. The gossip callback will not block sending on this
 	// channel.
@@ -90,6 +106,7 @@ func NewRegistry(
 		gossip:           gw,
 		gossipUpdateChan: make(chan RequestID, 1),
 		st:               st,
+		metrics:          makeMetrics(),
 	}
 	// Some tests pass a nil gossip, and gossip is not available on SQL tenant
 	// servers.
@@ -106,6 +123,7 @@ func (r *Registry) Start(ctx context.Context, stopper *stop.Stopper) {
 	// NB: The only error that should occur here would be if the server were
 	// shutting down so let's swallow it.
 	_ = stopper.RunAsyncTask(ctx, "stmt-diag-poll", r.poll)
+	_ = stopper.RunAsyncTask(ctx, "stmt-diag-poll-storage", r.pollStorage)
 }
 
 func (r *Registry) poll(ctx context.Context) {
@@ -161,6 +179,99 @@ func (r *Registry) poll(ctx context.Context) {
 	}
 }
 
+// request describes a diagnostics request.
+type request struct {
+	fingerprint string
+	// preparedName, if set, is the name of the prepared statement that the
+	// request targets; executions of the prepared statement match the request
+	// regardless of their fingerprint.
+	preparedName string
+	// planGist, if set, restricts the collection to executions of the statement
+	// with a plan that has this gist.
+	planGist string
+	// spanFilters, if set, restricts the trace included in the bundle to the
+	// spans with an operation name that starts with one of these prefixes.
+	spanFilters []string
+	// verbosity determines how much of the execution is recorded in the trace.
+	verbosity TraceVerbosity
+	// logVerbosity, if positive, is the verbosity level up to which the
+	// messages logged with log.VInfof during the execution of the statement are
+	// recorded in the trace.
+	logVerbosity int
+	// skipExecutions is the number of matching executions on this node that
+	// still need to be skipped before the diagnostics are collected, so that
+	// the bundle reflects the steady state of the statement (warm caches, etc.)
+	// rather than its first execution.
+	skipExecutions int
+	// userName and database, if set, restrict the collection to executions of
+	// the statement in sessions of this (normalized) user and with this current
+	// database, respectively.
+	userName string
+	database string
+	// tags are free-form key/value pairs attached to the request by its creator
+	// (for example a ticket number); they are included in the bundle.
+	tags map[string]string
+	// minRows, if positive, restricts the collection to executions of the
+	// statement that processed at least this many rows (see
+	// ShouldFinishCollection).
+	minRows int64
+}
+
+// matches returns whether an execution of the statement with the given
+// fingerprint (prepared under the given name, if not empty), in a session of
+// the given user and current database, matches the request.
+func (req *request) matches(fingerprint, preparedName, userName, database string) bool {
+	if req.preparedName != "" {
+		if req.preparedName != preparedName {
+			return false
+		}
+	} else if req.fingerprint != fingerprint {
+		return false
+	}
+	return (req.userName == "" || req.userName == userName) &&
+		(req.database == "" || req.database == database)
+}
+
+// TraceVerbosity determines how much of the execution of a statement is
+// recorded in the trace included in its diagnostics bundle. Lower verbosities
+// reduce the overhead of tracing the statement, as well as the size of the
+// bundle.
+type TraceVerbosity string
+
+const (
+	// TraceVerbosityFull records everything, including the KV requests on all
+	// the nodes. It is the default.
+	TraceVerbosityFull TraceVerbosity = "full"
+	// TraceVerbositySQL records the spans of the SQL layer on all the nodes; the
+	// spans of KV requests are excluded from the trace.
+	TraceVerbositySQL TraceVerbosity = "sql"
+	// TraceVerbositySummary only records the spans on the gateway node, without
+	// their log messages; the structured payloads of the spans (like execution
+	// statistics) are kept.
+	TraceVerbositySummary TraceVerbosity = "summary"
+	// TraceVerbosityPlanOnly doesn't trace the statement at all; the bundle
+	// only contains the plan, the schema, the settings and the placeholders,
+	// which is sufficient to reproduce the planning of the statement. Nodes
+	// that don't know about this verbosity collect a full trace instead.
+	TraceVerbosityPlanOnly TraceVerbosity = "plan"
+)
+
+// ParseTraceVerbosity parses the name of a trace verbosity; the empty string
+// corresponds to TraceVerbosityFull.
+func ParseTraceVerbosity(s string) (TraceVerbosity, error) {
+	switch v := TraceVerbosity(strings.ToLower(s)); v {
+	case "":
+		return TraceVerbosityFull, nil
+	case TraceVerbosityFull, TraceVerbositySQL, TraceVerbositySummary, TraceVerbosityPlanOnly:
+		return v, nil
+	default:
+		return "", errors.Errorf(
+			"invalid trace verbosity %q; valid values are %q, %q, %q and %q",
+			s, TraceVerbosityPlanOnly, TraceVerbositySummary, TraceVerbositySQL, TraceVerbosityFull,
+		)
+	}
+}
+
 // RequestID is the ID of a diagnostics request, corresponding to the id
 // column in statement_diagnostics_requests.
 // A zero ID is invalid.
@@ -172,17 +283,29 @@ type CollectedInstanceID int
 
 // addRequestInternalLocked adds a request to r.mu.requests. If the request is
 // already present, the call is a noop.
-func (r *Registry) addRequestInternalLocked(
-	ctx context.Context, id RequestID, queryFingerprint string,
-) {
+func (r *Registry) addRequestInternalLocked(ctx context.Context, id RequestID, req request) {
 	if r.findRequestLocked(id) {
 		// Request already exists.
 		return
 	}
-	if r.mu.requestFingerprints == nil {
-		r.mu.requestFingerprints = make(map[RequestID]string)
+	if r.mu.requests == nil {
+		r.mu.requests = make(map[RequestID]request)
 	}
-	r.mu.requestFingerprints[id] = queryFingerprint
+	r.mu.requests[id] = req
+	r.updateNumRequestsLocked()
+}
+
+// updateNumRequestsLocked updates numRequests after mu.requests was modified.
+func (r *Registry) updateNumRequestsLocked() {
+	atomic.StoreInt32(&r.numRequests, int32(len(r.mu.requests)))
+}
+
+// HasPendingRequests returns true if any request is waiting for a matching
+// statement to be executed on this node. It only performs an atomic load, so it
+// can be called for every statement to avoid the more expensive
+// ShouldCollectDiagnostics in the common case where nothing is requested.
+func (r *Registry) HasPendingRequests() bool {
+	return atomic.LoadInt32(&r.numRequests) > 0
 }
 
 func (r *Registry) findRequest(requestID RequestID) bool {
@@ -192,7 +315,7 @@ func (r *Registry) findRequest(requestID RequestID) bool {
 }
 
 func (r *Registry) findRequestLocked(requestID RequestID) bool {
-	_, ok := r.mu.requestFingerprints[requestID]
+	_, ok := r.mu.requests[requestID]
 	if ok {
 		return true
 	}
@@ -200,43 +323,278 @@ func (r *Registry) findRequestLocked(requestID RequestID) bool {
 	return ok
 }
 
+// Validate checks whether statements with the given fingerprint were executed
+// recently, according to the statement statistics of this node. If they
+// weren't, it returns a warning: the fingerprint may never match (e.g. because
+// it isn't anonymized like the fingerprints of the statements), in which case a
+// diagnostics request for it would remain pending forever. The absence of a
+// warning doesn't guarantee that such a request will be fulfilled, and a
+// warning doesn't mean that it won't be: the statement may be executed later,
+// or only on other nodes.
+func (r *Registry) Validate(ctx context.Context, fprint string) (warning string, _ error) {
+	row, err := r.ie.QueryRowEx(ctx, "stmt-diag-validate-request", nil, /* txn */
+		sessiondata.InternalExecutorOverride{
+			User: security.RootUserName(),
+		},
+		"SELECT count(*) FROM crdb_internal.node_statement_statistics WHERE key = $1",
+		fprint)
+	if err != nil {
+		return "", err
+	}
+	if row == nil {
+		return "", errors.New("failed to check the statement statistics")
+	}
+	if tree.MustBeDInt(row[0]) > 0 {
+		return "", nil
+	}
+	return fmt.Sprintf(
+		"no statement with fingerprint %q was executed recently on this node; the fingerprint "+
+			"must be anonymized like the ones in crdb_internal.node_statement_statistics, "+
+			"otherwise the diagnostics request will never be fulfilled", fprint,
+	), nil
+}
+
+// RequestOptions describes a diagnostics request (see InsertRequest). The
+// request targets either a statement fingerprint or a prepared statement name.
+// The zero value of the other options leaves the request unrestricted; they can
+// be combined.
+type RequestOptions struct {
+	// Fingerprint is the fingerprint of the statement targeted by the request.
+	Fingerprint string
+	// PreparedName, if set, targets the executions of the statement prepared
+	// under this name instead, regardless of the fingerprint of the prepared
+	// statement. This allows capturing diagnostics for a statement issued by an
+	// application that prepares it under a well-known name, even when the
+	// fingerprint isn't known in advance.
+	PreparedName string
+	// PlanGist, if set, restricts the collection to executions of the statement
+	// which use a plan with this gist. Executions with other plans leave the
+	// request pending.
+	PlanGist string
+	// MaxCaptures is the number of bundles, each from a different execution of
+	// the statement, after which the request is completed; it defaults to 1. The
+	// bundles reference the request through the request_id column of
+	// system.statement_diagnostics, and are numbered through the sample_index
+	// column.
+	MaxCaptures int
+	// SkipExecutions is the number of matching executions of the statement on
+	// each node which are not captured. This allows capturing the steady state
+	// of the statement rather than artifacts of its first (cold) execution.
+	SkipExecutions int
+	// UserName and Database, if set, restrict the collection to executions of
+	// the statement in sessions of this user and with this current database.
+	// This avoids capturing unrelated executions of the same statement
+	// fingerprint on shared clusters.
+	UserName security.SQLUsername
+	Database string
+	// SpanFilters, if set, restricts the trace included in the bundle to the
+	// spans with an operation name that starts with one of these prefixes
+	// (along with the root span of the statement). The unfiltered trace is
+	// included in the bundle separately.
+	SpanFilters []string
+	// Verbosity is the verbosity with which the trace included in the bundle is
+	// recorded; it defaults to TraceVerbosityFull.
+	Verbosity TraceVerbosity
+	// LogVerbosity, if positive, causes the messages logged with log.VInfof at
+	// this verbosity level (or below) during the execution of the statement on
+	// the gateway node to be recorded in the trace included in the bundle. This
+	// provides the context of these messages without raising the verbosity of
+	// the logs of the entire node.
+	LogVerbosity int
+	// Tags are free-form key/value pairs (for example the ticket or the engineer
+	// that the request is for) which are stored with the request and included
+	// in the metadata.json file of the bundle. This allows tooling to filter and
+	// attribute bundles.
+	Tags map[string]string
+	// MinRows, if positive, restricts the collection to executions of the
+	// statement that processed at least this many rows, i.e. that read that many
+	// rows from KV, wrote that many rows or returned that many rows to the
+	// client. This captures the executions which are slow because they
+	// unexpectedly process a large number of rows, without capturing the
+	// normal ones.
+	MinRows int64
+}
+
 // InsertRequest is part of the StmtDiagnosticsRequester interface.
-func (r *Registry) InsertRequest(ctx context.Context, fprint string) error {
-	_, err := r.insertRequestInternal(ctx, fprint)
+func (r *Registry) InsertRequest(ctx context.Context, opts RequestOptions) error {
+	_, err := r.insertRequestInternal(ctx, opts)
 	return err
 }
 
-func (r *Registry) insertRequestInternal(ctx context.Context, fprint string) (RequestID, error) {
+func (r *Registry) insertRequestInternal(
+	ctx context.Context, opts RequestOptions,
+) (RequestID, error) {
 	g, err := r.gossip.OptionalErr(48274)
 	if err != nil {
 		return 0, err
 	}
 
+	if opts.Fingerprint != "" && opts.PreparedName != "" {
+		return 0, errors.New(
+			"a diagnostics request can't target both a fingerprint and a prepared statement name")
+	}
+	maxCaptures := opts.MaxCaptures
+	if maxCaptures == 0 {
+		maxCaptures = 1
+	}
+	if maxCaptures < 1 {
+		return 0, errors.Errorf("invalid number of captures %d", maxCaptures)
+	}
+	if opts.SkipExecutions < 0 {
+		return 0, errors.Errorf("invalid number of skipped executions %d", opts.SkipExecutions)
+	}
+	verbosity, err := ParseTraceVerbosity(string(opts.Verbosity))
+	if err != nil {
+		return 0, err
+	}
+	if opts.LogVerbosity < 0 {
+		return 0, errors.Errorf("invalid log verbosity %d", opts.LogVerbosity)
+	}
+	for k := range opts.Tags {
+		if k == "" {
+			return 0, errors.New("diagnostics request tags must have a non-empty key")
+		}
+	}
+	if opts.MinRows < 0 {
+		return 0, errors.Errorf("invalid minimum number of rows %d", opts.MinRows)
+	}
+	hasOptions := opts.PreparedName != "" || opts.PlanGist != "" || maxCaptures > 1 ||
+		opts.SkipExecutions > 0 || !opts.UserName.Undefined() || opts.Database != "" ||
+		len(opts.SpanFilters) > 0 || verbosity != TraceVerbosityFull || opts.LogVerbosity > 0 ||
+		len(opts.Tags) > 0 || opts.MinRows > 0
+	if hasOptions &&
+		!r.st.Version.IsActive(ctx, clusterversion.VersionStatementDiagnosticsRequestOptions) {
+		return 0, errors.New(
+			"diagnostics requests with options are not supported until the cluster upgrade is finalized")
+	}
+	req := request{
+		fingerprint:    opts.Fingerprint,
+		preparedName:   opts.PreparedName,
+		planGist:       opts.PlanGist,
+		spanFilters:    opts.SpanFilters,
+		verbosity:      verbosity,
+		logVerbosity:   opts.LogVerbosity,
+		skipExecutions: opts.SkipExecutions,
+		userName:       opts.UserName.Normalized(),
+		database:       opts.Database,
+		tags:           opts.Tags,
+		minRows:        opts.MinRows,
+	}
+
 	var reqID RequestID
 	err = r.db.Txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
-		// Check if there's already a pending request for this fingerprint.
+		// Check if there's already a pending request for this fingerprint (or
+		// prepared statement name), user and database.
+		pendingFilter, pendingArgs, target :=
+			"statement_fingerprint = $1", []interface{}{req.fingerprint}, "fingerprint"
+		if req.preparedName != "" {
+			pendingFilter, pendingArgs, target =
+				"prepared_statement_name = $1", []interface{}{req.preparedName}, "prepared statement name"
+		}
+		// Requests restricted to different users or databases don't conflict.
+		// The columns don't exist until the cluster version is active, and
+		// neither do such requests.
+		if r.st.Version.IsActive(ctx, clusterversion.VersionStatementDiagnosticsRequestOptions) {
+			nullIfEmpty := func(s string) interface{} {
+				if s == "" {
+					return tree.DNull
+				}
+				return s
+			}
+			pendingFilter += " AND user_name IS NOT DISTINCT FROM $2" +
+				" AND database_name IS NOT DISTINCT FROM $3"
+			pendingArgs = append(pendingArgs, nullIfEmpty(req.userName), nullIfEmpty(req.database))
+		}
 		row, err := r.ie.QueryRowEx(ctx, "stmt-diag-check-pending", txn,
 			sessiondata.InternalExecutorOverride{
 				User: security.RootUserName(),
 			},
 			"SELECT count(1) FROM system.statement_diagnostics_requests "+
-				"WHERE completed = false AND statement_fingerprint = $1",
-			fprint)
+				"WHERE completed = false AND "+pendingFilter,
+			pendingArgs...)
 		if err != nil {
 			return err
 		}
 		count := int(*row[0].(*tree.DInt))
 		if count != 0 {
-			return errors.New("a pending request for the requested fingerprint already exists")
+			return errors.Errorf("a pending request for the requested %s already exists", target)
 		}
 
+		cols := "statement_fingerprint, requested_at"
+		placeholders := "$1, $2"
+		qargs := []interface{}{req.fingerprint, timeutil.Now()}
+		if req.planGist != "" {
+			qargs = append(qargs, req.planGist)
+			cols += ", plan_gist"
+			placeholders += fmt.Sprintf(", $%d", len(qargs))
+		}
+		if maxCaptures > 1 {
+			qargs = append(qargs, maxCaptures)
+			cols += ", max_captures"
+			placeholders += fmt.Sprintf(", $%d", len(qargs))
+		}
+		if req.skipExecutions > 0 {
+			qargs = append(qargs, req.skipExecutions)
+			cols += ", skip_executions"
+			placeholders += fmt.Sprintf(", $%d", len(qargs))
+		}
+		if req.preparedName != "" {
+			qargs = append(qargs, req.preparedName)
+			cols += ", prepared_statement_name"
+			placeholders += fmt.Sprintf(", $%d", len(qargs))
+		}
+		if req.userName != "" {
+			qargs = append(qargs, req.userName)
+			cols += ", user_name"
+			placeholders += fmt.Sprintf(", $%d", len(qargs))
+		}
+		if req.database != "" {
+			qargs = append(qargs, req.database)
+			cols += ", database_name"
+			placeholders += fmt.Sprintf(", $%d", len(qargs))
+		}
+		if len(req.spanFilters) > 0 {
+			filters := tree.NewDArray(types.String)
+			for _, f := range req.spanFilters {
+				if err := filters.Append(tree.NewDString(f)); err != nil {
+					return err
+				}
+			}
+			qargs = append(qargs, filters)
+			cols += ", span_filters"
+			placeholders += fmt.Sprintf(", $%d", len(qargs))
+		}
+		if req.verbosity != TraceVerbosityFull {
+			qargs = append(qargs, string(req.verbosity))
+			cols += ", verbosity"
+			placeholders += fmt.Sprintf(", $%d", len(qargs))
+		}
+		if req.logVerbosity > 0 {
+			qargs = append(qargs, req.logVerbosity)
+			cols += ", log_verbosity"
+			placeholders += fmt.Sprintf(", $%d", len(qargs))
+		}
+		if len(req.tags) > 0 {
+			b := json.NewObjectBuilder(len(req.tags))
+			for k, v := range req.tags {
+				b.Add(k, json.FromString(v))
+			}
+			qargs = append(qargs, tree.NewDJSON(b.Build()))
+			cols += ", tags"
+			placeholders += fmt.Sprintf(", $%d", len(qargs))
+		}
+		if req.minRows > 0 {
+			qargs = append(qargs, req.minRows)
+			cols += ", min_rows"
+			placeholders += fmt.Sprintf(", $%d", len(qargs))
+		}
+		insertStmt := "INSERT INTO system.statement_diagnostics_requests (" + cols + ") " +
+			"VALUES (" + placeholders + ") RETURNING id"
 		row, err = r.ie.QueryRowEx(ctx, "stmt-diag-insert-request", txn,
 			sessiondata.InternalExecutorOverride{
 				User: security.RootUserName(),
 			},
-			"INSERT INTO system.statement_diagnostics_requests (statement_fingerprint, requested_at) "+
-				"VALUES ($1, $2) RETURNING id",
-			fprint, timeutil.Now())
+			insertStmt, qargs...)
 		if err != nil {
 			return err
 		}
@@ -253,7 +611,7 @@ func (r *Registry) insertRequestInternal(ctx context.Context, fprint string) (Re
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.mu.epoch++
-	r.addRequestInternalLocked(ctx, reqID, fprint)
+	r.addRequestInternalLocked(ctx, reqID, req)
 
 	// Notify all the other nodes that they have to poll.
 	buf := make([]byte, 8)
@@ -265,6 +623,56 @@ func (r *Registry) insertRequestInternal(ctx context.Context, fprint string) (Re
 	return reqID, nil
 }
 
+// Cancel cancels the diagnostics request with the given ID. The request is
+// marked as completed (without any collected diagnostics), so that no node
+// collects diagnostics for it. If this node is in the process of collecting
+// diagnostics for the request, the collected data is discarded (see
+// WasCanceled); other nodes discard the data when they find that the request
+// was completed.
+func (r *Registry) Cancel(ctx context.Context, requestID RequestID) error {
+	n, err := r.ie.ExecEx(ctx, "stmt-diag-cancel-request", nil, /* txn */
+		sessiondata.InternalExecutorOverride{
+			User: security.RootUserName(),
+		},
+		"UPDATE system.statement_diagnostics_requests SET completed = true "+
+			"WHERE id = $1 AND completed = false",
+		requestID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.Errorf("no pending diagnostics request with ID %d", requestID)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Make sure that a concurrent poll doesn't add the request back.
+	r.mu.epoch++
+	delete(r.mu.requests, requestID)
+	r.updateNumRequestsLocked()
+	if _, ok := r.mu.ongoing[requestID]; ok {
+		delete(r.mu.ongoing, requestID)
+		if r.mu.canceled == nil {
+			r.mu.canceled = make(map[RequestID]struct{})
+		}
+		r.mu.canceled[requestID] = struct{}{}
+	}
+	return nil
+}
+
+// WasCanceled returns true if the given request was canceled (see Cancel)
+// while this node was collecting diagnostics for it. In that case, the
+// collected data must be discarded and the finishFn returned by
+// ShouldCollectDiagnostics must not be called. It must be called at most once
+// for a request for which ShouldCollectDiagnostics returned true.
+func (r *Registry) WasCanceled(reqID RequestID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.mu.canceled[reqID]
+	delete(r.mu.canceled, reqID)
+	return ok
+}
+
 func (r *Registry) removeOngoing(requestID RequestID) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -274,51 +682,157 @@ func (r *Registry) removeOngoing(requestID RequestID) {
 
 // ShouldCollectDiagnostics checks whether any data should be collected for the
 // given query, which is the case if the registry has a request for this
-// statement's fingerprint; in this case ShouldCollectDiagnostics will not
-// return true again on this note for the same diagnostics request.
+// statement's fingerprint (or for the name under which the statement was
+// prepared, if any) that isn't restricted to another user or database than the
+// session's (and the request doesn't ask for this execution to be
+// skipped); in this case ShouldCollectDiagnostics will not return true again on
+// this node for the same diagnostics request, unless the request asks for
+// multiple captures and more are still needed once the collected data was
+// inserted.
 //
 // If shouldCollect returns true, finishFn must always be called once the data
 // was collected and inserted (even if failures were encountered).
 func (r *Registry) ShouldCollectDiagnostics(
-	ctx context.Context, fingerprint string,
+	ctx context.Context,
+	fingerprint string,
+	preparedName string,
+	user security.SQLUsername,
+	database string,
 ) (shouldCollect bool, reqID RequestID, finishFn func()) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	// Return quickly if we have no requests to trace.
-	if len(r.mu.requestFingerprints) == 0 {
+	if len(r.mu.requests) == 0 {
 		return false, 0, nil
 	}
 
-	for id, f := range r.mu.requestFingerprints {
-		if f == fingerprint {
+	var req request
+	for id, f := range r.mu.requests {
+		if f.matches(fingerprint, preparedName, user.Normalized(), database) {
 			reqID = id
+			req = f
 			break
 		}
 	}
 	if reqID == 0 {
 		return false, 0, nil
 	}
+	if req.skipExecutions > 0 {
+		req.skipExecutions--
+		r.mu.requests[reqID] = req
+		return false, 0, nil
+	}
 
 	// Remove the request.
-	delete(r.mu.requestFingerprints, reqID)
+	delete(r.mu.requests, reqID)
+	r.updateNumRequestsLocked()
 	if r.mu.ongoing == nil {
-		r.mu.ongoing = make(map[RequestID]struct{})
+		r.mu.ongoing = make(map[RequestID]request)
 	}
 
-	r.mu.ongoing[reqID] = struct{}{}
+	r.mu.ongoing[reqID] = req
 	return true, reqID, func() {
 		r.removeOngoing(reqID)
 	}
 }
 
+// ShouldFinishCollection is called once a statement for which
+// ShouldCollectDiagnostics returned true has finished executing, with the gist
+// of its plan and the number of rows it processed. If the request targets a
+// different plan gist, or a minimum number of rows that the execution didn't
+// reach, the request is put back in the registry (so that a later execution
+// can service it) and false is returned; in that case the collected data must
+// be discarded and the finishFn returned by ShouldCollectDiagnostics must not
+// be called.
+func (r *Registry) ShouldFinishCollection(
+	reqID RequestID, planGist string, rowsProcessed int64,
+) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req, ok := r.mu.ongoing[reqID]
+	if !ok {
+		return true
+	}
+	if (req.planGist == "" || req.planGist == planGist) && rowsProcessed >= req.minRows {
+		return true
+	}
+	r.postponeCollectionLocked(reqID, req)
+	return false
+}
+
+// PostponeCollection puts a request for which ShouldCollectDiagnostics returned
+// true back in the registry, so that a later execution can service it. It is
+// used when the bundle could not be collected for reasons unrelated to the
+// request (e.g. too many bundles are being collected concurrently). The
+// finishFn returned by ShouldCollectDiagnostics must not be called.
+func (r *Registry) PostponeCollection(reqID RequestID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req, ok := r.mu.ongoing[reqID]; ok {
+		r.postponeCollectionLocked(reqID, req)
+	}
+}
+
+func (r *Registry) postponeCollectionLocked(reqID RequestID, req request) {
+	delete(r.mu.ongoing, reqID)
+	if r.mu.requests == nil {
+		r.mu.requests = make(map[RequestID]request)
+	}
+	r.mu.requests[reqID] = req
+	r.updateNumRequestsLocked()
+}
+
+// SpanFilters returns the operation name prefixes that the trace included in
+// the bundle for the given request should be restricted to, if any. It must be
+// called for a request for which ShouldCollectDiagnostics returned true.
+func (r *Registry) SpanFilters(reqID RequestID) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mu.ongoing[reqID].spanFilters
+}
+
+// Verbosity returns the verbosity with which the trace included in the bundle
+// for the given request should be recorded. It must be called for a request
+// for which ShouldCollectDiagnostics returned true.
+func (r *Registry) Verbosity(reqID RequestID) TraceVerbosity {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v := r.mu.ongoing[reqID].verbosity; v != "" {
+		return v
+	}
+	return TraceVerbosityFull
+}
+
+// LogVerbosity returns the verbosity level up to which the messages logged with
+// log.VInfof during the execution of the statement should be recorded in the
+// trace included in the bundle for the given request; zero means that only the
+// messages at the verbosity of the logs are recorded. It must be called for a
+// request for which ShouldCollectDiagnostics returned true.
+func (r *Registry) LogVerbosity(reqID RequestID) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mu.ongoing[reqID].logVerbosity
+}
+
+// Tags returns the tags attached to the given request, if any. It must be
+// called for a request for which ShouldCollectDiagnostics returned true.
+func (r *Registry) Tags(reqID RequestID) map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mu.ongoing[reqID].tags
+}
+
 // InsertStatementDiagnostics inserts a trace into system.statement_diagnostics.
 //
-// traceJSON is either DNull (when collectionErr should not be nil) or a *DJSON.
+// traceJSON is either DNull (when collectionErr should not be nil or when the
+// bundle was collected with TraceVerbosityPlanOnly) or a *DJSON.
+// traceHash is a structural hash of the trace (empty if not available), which
+// allows tooling to identify bundles with traces of the same shape.
 //
 // If requestID is not zero, it also marks the request as completed in
-// system.statement_diagnostics_requests. If requestID is zero, a new entry is
-// inserted.
+// system.statement_diagnostics_requests (once the requested number of captures
+// has been collected). If requestID is zero, a new entry is inserted.
 //
 // collectionErr should be any error generated during the collection or
 // generation of the bundle/trace.
@@ -328,26 +842,56 @@ func (r *Registry) InsertStatementDiagnostics(
 	stmtFingerprint string,
 	stmt string,
 	traceJSON tree.Datum,
+	traceHash string,
 	bundle []byte,
 	collectionErr error,
 ) (CollectedInstanceID, error) {
 	var diagID CollectedInstanceID
+	optionsSupported := r.st.Version.IsActive(
+		ctx, clusterversion.VersionStatementDiagnosticsRequestOptions,
+	)
+	// requestPending is set if the request still needs more captures after the
+	// new capture is inserted.
+	var requestPending bool
+	// stored is set if the capture was inserted (i.e. the request wasn't already
+	// completed).
+	var stored bool
+	bundleSize := len(bundle)
 	err := r.db.Txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		requestPending = false
+		stored = false
+		// sampleIndex is the (1-based) index of this capture among the captures
+		// for the request.
+		sampleIndex, maxCaptures := 1, 1
 		if requestID != 0 {
+			query := "SELECT count(1) FROM system.statement_diagnostics_requests WHERE id = $1 AND completed = false"
+			if optionsSupported {
+				// The number of captures collected so far is kept on the request
+				// row, since system.statement_diagnostics has no index on
+				// request_id.
+				query = "SELECT max_captures, num_captures " +
+					"FROM system.statement_diagnostics_requests WHERE id = $1 AND completed = false"
+			}
 			row, err := r.ie.QueryRowEx(ctx, "stmt-diag-check-completed", txn,
 				sessiondata.InternalExecutorOverride{User: security.RootUserName()},
-				"SELECT count(1) FROM system.statement_diagnostics_requests WHERE id = $1 AND completed = false",
-				requestID)
+				query, requestID)
 			if err != nil {
 				return err
 			}
-			cnt := int(*row[0].(*tree.DInt))
-			if cnt == 0 {
+			if row == nil || (!optionsSupported && int(*row[0].(*tree.DInt)) == 0) {
 				// Someone else already marked the request as completed. We've traced for nothing.
 				// This can only happen once per node, per request since we're going to
 				// remove the request from the registry.
 				return nil
 			}
+			if optionsSupported {
+				if n, ok := row[0].(*tree.DInt); ok {
+					maxCaptures = int(*n)
+				}
+				if n, ok := row[1].(*tree.DInt); ok {
+					sampleIndex = int(*n) + 1
+				}
+			}
 		}
 
 		// Generate the values that will be inserted.
@@ -385,26 +929,50 @@ func (r *Registry) InsertStatementDiagnostics(
 		collectionTime := timeutil.Now()
 
 		// Insert the trace into system.statement_diagnostics.
+		cols := "statement_fingerprint, statement, collected_at, trace, bundle_chunks, error"
+		qargs := []interface{}{stmtFingerprint, stmt, collectionTime, traceJSON, bundleChunksVal, errorVal}
+		if requestID != 0 && optionsSupported {
+			cols += ", request_id, sample_index"
+			qargs = append(qargs, requestID, sampleIndex)
+		}
+		if traceHash != "" && optionsSupported {
+			cols += ", trace_hash"
+			qargs = append(qargs, traceHash)
+		}
+		placeholders := make([]string, len(qargs))
+		for i := range placeholders {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+		}
+		insertStmt := fmt.Sprintf(
+			"INSERT INTO system.statement_diagnostics (%s) VALUES (%s) RETURNING id",
+			cols, strings.Join(placeholders, ", "),
+		)
 		row, err := r.ie.QueryRowEx(
 			ctx, "stmt-diag-insert", txn,
 			sessiondata.InternalExecutorOverride{User: security.RootUserName()},
-			"INSERT INTO system.statement_diagnostics "+
-				"(statement_fingerprint, statement, collected_at, trace, bundle_chunks, error) "+
-				"VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
-			stmtFingerprint, stmt, collectionTime, traceJSON, bundleChunksVal, errorVal,
+			insertStmt, qargs...,
 		)
 		if err != nil {
 			return err
 		}
 		diagID = CollectedInstanceID(*row[0].(*tree.DInt))
+		stored = true
 
 		if requestID != 0 {
-			// Mark the request from system.statement_diagnostics_request as completed.
+			// Point the request from system.statement_diagnostics_request to the
+			// latest capture, and mark it as completed if this was the last capture.
+			requestPending = sampleIndex < maxCaptures
+			update := "UPDATE system.statement_diagnostics_requests " +
+				"SET completed = $1, statement_diagnostics_id = $2 WHERE id = $3"
+			qargs := []interface{}{!requestPending, diagID, requestID}
+			if optionsSupported {
+				update = "UPDATE system.statement_diagnostics_requests " +
+					"SET completed = $1, statement_diagnostics_id = $2, num_captures = $4 WHERE id = $3"
+				qargs = append(qargs, sampleIndex)
+			}
 			_, err := r.ie.ExecEx(ctx, "stmt-diag-mark-completed", txn,
 				sessiondata.InternalExecutorOverride{User: security.RootUserName()},
-				"UPDATE system.statement_diagnostics_requests "+
-					"SET completed = true, statement_diagnostics_id = $1 WHERE id = $2",
-				diagID, requestID)
+				update, qargs...)
 			if err != nil {
 				return err
 			}
@@ -427,6 +995,23 @@ func (r *Registry) InsertStatementDiagnostics(
 	if err != nil {
 		return 0, err
 	}
+	if stored && bundleSize > 0 {
+		r.recordStoredBundle(bundleSize)
+	}
+	if requestPending {
+		// More captures are needed; make the request available to later
+		// executions on this node right away.
+		r.mu.Lock()
+		if req, ok := r.mu.ongoing[requestID]; ok {
+			delete(r.mu.ongoing, requestID)
+			if r.mu.requests == nil {
+				r.mu.requests = make(map[RequestID]request)
+			}
+			r.mu.requests[requestID] = req
+			r.updateNumRequestsLocked()
+		}
+		r.mu.Unlock()
+	}
 	return diagID, nil
 }
 
@@ -434,6 +1019,14 @@ func (r *Registry) InsertStatementDiagnostics(
 // updates r.mu.requests accordingly.
 func (r *Registry) pollRequests(ctx context.Context) error {
 	var rows []tree.Datums
+	optionsSupported := r.st.Version.IsActive(
+		ctx, clusterversion.VersionStatementDiagnosticsRequestOptions,
+	)
+	var extraColumns string
+	if optionsSupported {
+		extraColumns = ", plan_gist, span_filters, verbosity, skip_executions, user_name, " +
+			"database_name, prepared_statement_name, log_verbosity, tags, min_rows"
+	}
 	// Loop until we run the query without straddling an epoch increment.
 	for {
 		r.mu.Lock()
@@ -445,7 +1038,7 @@ func (r *Registry) pollRequests(ctx context.Context) error {
 			sessiondata.InternalExecutorOverride{
 				User: security.RootUserName(),
 			},
-			"SELECT id, statement_fingerprint FROM system.statement_diagnostics_requests "+
+			"SELECT id, statement_fingerprint"+extraColumns+" FROM system.statement_diagnostics_requests "+
 				"WHERE completed = false")
 		if err != nil {
 			return err
@@ -466,21 +1059,85 @@ func (r *Registry) pollRequests(ctx context.Context) error {
 	var ids util.FastIntSet
 	for _, row := range rows {
 		id := RequestID(*row[0].(*tree.DInt))
-		fprint := string(*row[1].(*tree.DString))
+		req := request{
+			fingerprint: string(*row[1].(*tree.DString)),
+			verbosity:   TraceVerbosityFull,
+		}
+		if optionsSupported {
+			if gist, ok := row[2].(*tree.DString); ok {
+				req.planGist = string(*gist)
+			}
+			if filters, ok := row[3].(*tree.DArray); ok {
+				for _, f := range filters.Array {
+					req.spanFilters = append(req.spanFilters, string(tree.MustBeDString(f)))
+				}
+			}
+			if v, ok := row[4].(*tree.DString); ok {
+				// Ignore verbosities that this node doesn't know about.
+				if parsed, err := ParseTraceVerbosity(string(*v)); err == nil {
+					req.verbosity = parsed
+				}
+			}
+			if n, ok := row[5].(*tree.DInt); ok {
+				req.skipExecutions = int(*n)
+			}
+			if u, ok := row[6].(*tree.DString); ok {
+				req.userName = string(*u)
+			}
+			if d, ok := row[7].(*tree.DString); ok {
+				req.database = string(*d)
+			}
+			if n, ok := row[8].(*tree.DString); ok {
+				req.preparedName = string(*n)
+			}
+			if v, ok := row[9].(*tree.DInt); ok {
+				req.logVerbosity = int(*v)
+			}
+			if j, ok := row[10].(*tree.DJSON); ok {
+				var err error
+				if req.tags, err = tagsFromJSON(j.JSON); err != nil {
+					log.Warningf(ctx, "ignoring invalid tags of diagnostics request %d: %v", id, err)
+				}
+			}
+			if v, ok := row[11].(*tree.DInt); ok {
+				req.minRows = int64(*v)
+			}
+		}
 
 		ids.Add(int(id))
-		r.addRequestInternalLocked(ctx, id, fprint)
+		r.addRequestInternalLocked(ctx, id, req)
 	}
 
 	// Remove all other requests.
-	for id := range r.mu.requestFingerprints {
+	for id := range r.mu.requests {
 		if !ids.Contains(int(id)) {
-			delete(r.mu.requestFingerprints, id)
+			delete(r.mu.requests, id)
 		}
 	}
+	r.updateNumRequestsLocked()
 	return nil
 }
 
+// tagsFromJSON decodes the tags of a request, as stored in the tags column of
+// system.statement_diagnostics_requests.
+func tagsFromJSON(j json.JSON) (map[string]string, error) {
+	it, err := j.ObjectIter()
+	if err != nil || it == nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	for it.Next() {
+		v, err := it.Value().AsText()
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			tags[it.Key()] = *v
+		}
+	}
+	return tags, nil
+}
+
 // gossipNotification is called in response to a gossip update informing us that
 // we need to poll.
 func (r *Registry) gossipNotification(s string, value roachpb.Value) {
@@ -21,7 +21,9 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/security"
 	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/stmtdiagnostics"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
@@ -41,7 +43,9 @@ func TestDiagnosticsRequest(t *testing.T) {
 
 	// Ask to trace a particular query.
 	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
-	reqID, err := registry.InsertRequestInternal(ctx, "INSERT INTO test VALUES (_)")
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "INSERT INTO test VALUES (_)",
+	})
 	require.NoError(t, err)
 	reqRow := db.QueryRow(
 		"SELECT completed, statement_diagnostics_id FROM system.statement_diagnostics_requests WHERE ID = $1", reqID)
@@ -74,11 +78,17 @@ func TestDiagnosticsRequest(t *testing.T) {
 	require.Contains(t, json, "statement execution committed the txn")
 
 	// Verify that we can handle multiple requests at the same time.
-	id1, err := registry.InsertRequestInternal(ctx, "INSERT INTO test VALUES (_)")
+	id1, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "INSERT INTO test VALUES (_)",
+	})
 	require.NoError(t, err)
-	id2, err := registry.InsertRequestInternal(ctx, "SELECT x FROM test")
+	id2, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test",
+	})
 	require.NoError(t, err)
-	id3, err := registry.InsertRequestInternal(ctx, "SELECT x FROM test WHERE x > _")
+	id3, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test WHERE x > _",
+	})
 	require.NoError(t, err)
 
 	// Run the queries in a different order.
@@ -95,6 +105,647 @@ func TestDiagnosticsRequest(t *testing.T) {
 	checkCompleted(id1)
 }
 
+// Test that the metrics reflect the bundles that are stored.
+func TestDiagnosticsBundleMetrics(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+	_, err := db.Exec("CREATE TABLE test (x int PRIMARY KEY)")
+	require.NoError(t, err)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	metrics := registry.Metrics()
+	require.Equal(t, int64(0), metrics.Bundles.Count())
+	require.Equal(t, int64(0), metrics.BundleBytes.Count())
+
+	_, err = registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test",
+	})
+	require.NoError(t, err)
+	_, err = db.Exec("SELECT x FROM test")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), metrics.Bundles.Count())
+	require.Greater(t, metrics.BundleBytes.Count(), int64(0))
+
+	require.NoError(t, registry.UpdateStoredBundleBytesInternal(ctx))
+	var stored int64
+	require.NoError(t, db.QueryRow(
+		"SELECT sum(length(data)) FROM system.statement_bundle_chunks",
+	).Scan(&stored))
+	require.Equal(t, stored, metrics.StoredBundleBytes.Value())
+	require.Equal(t, metrics.BundleBytes.Count(), stored)
+}
+
+// Test that a request targeting a plan gist is only serviced by executions
+// that use that plan.
+func TestDiagnosticsRequestPlanGist(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+	_, err := db.Exec("CREATE TABLE test (x int PRIMARY KEY)")
+	require.NoError(t, err)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", PlanGist: "0000000000000000",
+	})
+	require.NoError(t, err)
+
+	// The query uses a different plan, so the request is not serviced.
+	for i := 0; i < 2; i++ {
+		_, err = db.Exec("SELECT x FROM test")
+		require.NoError(t, err)
+	}
+	var completed bool
+	require.NoError(t, db.QueryRow(
+		"SELECT completed FROM system.statement_diagnostics_requests WHERE ID = $1", reqID,
+	).Scan(&completed))
+	require.False(t, completed)
+}
+
+// Test that a request with a minimum number of rows is only serviced by an
+// execution that processes at least this many rows.
+func TestDiagnosticsRequestMinRows(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+	_, err := db.Exec("CREATE TABLE test (x int PRIMARY KEY)")
+	require.NoError(t, err)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	_, err = registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", MinRows: -1,
+	})
+	require.Error(t, err)
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", MinRows: 10,
+	})
+	require.NoError(t, err)
+
+	checkCompleted := func(expected bool) {
+		var completed bool
+		require.NoError(t, db.QueryRow(
+			"SELECT completed FROM system.statement_diagnostics_requests WHERE ID = $1", reqID,
+		).Scan(&completed))
+		require.Equal(t, expected, completed)
+	}
+
+	// The query doesn't process enough rows, so the request is not serviced.
+	_, err = db.Exec("INSERT INTO test SELECT generate_series(1, 5)")
+	require.NoError(t, err)
+	_, err = db.Exec("SELECT x FROM test")
+	require.NoError(t, err)
+	checkCompleted(false)
+
+	_, err = db.Exec("INSERT INTO test SELECT generate_series(6, 20)")
+	require.NoError(t, err)
+	_, err = db.Exec("SELECT x FROM test")
+	require.NoError(t, err)
+	checkCompleted(true)
+}
+
+// Test that a request with multiple captures collects a bundle for each
+// execution until the requested number of captures is reached.
+func TestDiagnosticsRequestMaxCaptures(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+	_, err := db.Exec("CREATE TABLE test (x int PRIMARY KEY)")
+	require.NoError(t, err)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", MaxCaptures: 3,
+	})
+	require.NoError(t, err)
+
+	// checkCaptures verifies the completion of the request and the number of
+	// captures recorded on the request row.
+	checkCaptures := func(expectedCompleted bool, expectedCaptures int) {
+		var completed bool
+		var captures int
+		require.NoError(t, db.QueryRow(
+			"SELECT completed, num_captures FROM system.statement_diagnostics_requests WHERE ID = $1",
+			reqID,
+		).Scan(&completed, &captures))
+		require.Equal(t, expectedCompleted, completed)
+		require.Equal(t, expectedCaptures, captures)
+	}
+	for i := 1; i <= 2; i++ {
+		_, err = db.Exec("SELECT x FROM test")
+		require.NoError(t, err)
+		checkCaptures(false, i)
+	}
+	_, err = db.Exec("SELECT x FROM test")
+	require.NoError(t, err)
+	checkCaptures(true, 3)
+
+	// Further executions don't collect more bundles.
+	_, err = db.Exec("SELECT x FROM test")
+	require.NoError(t, err)
+
+	rows, err := db.Query(
+		"SELECT sample_index FROM system.statement_diagnostics WHERE request_id = $1 ORDER BY sample_index",
+		reqID,
+	)
+	require.NoError(t, err)
+	defer rows.Close()
+	var indexes []int
+	for rows.Next() {
+		var idx int
+		require.NoError(t, rows.Scan(&idx))
+		indexes = append(indexes, idx)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []int{1, 2, 3}, indexes)
+}
+
+// Test that a request with skipped executions only starts collecting bundles
+// once the requested number of executions was skipped.
+func TestDiagnosticsRequestSkippedExecutions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+	_, err := db.Exec("CREATE TABLE test (x int PRIMARY KEY)")
+	require.NoError(t, err)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	_, err = registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", SkipExecutions: -1,
+	})
+	require.Error(t, err)
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", SkipExecutions: 2, MaxCaptures: 2,
+	})
+	require.NoError(t, err)
+
+	checkCaptures := func(expectedCaptures int, expectedCompleted bool) {
+		var captures int
+		require.NoError(t, db.QueryRow(
+			"SELECT count(1) FROM system.statement_diagnostics WHERE request_id = $1", reqID,
+		).Scan(&captures))
+		require.Equal(t, expectedCaptures, captures)
+		var completed bool
+		require.NoError(t, db.QueryRow(
+			"SELECT completed FROM system.statement_diagnostics_requests WHERE ID = $1", reqID,
+		).Scan(&completed))
+		require.Equal(t, expectedCompleted, completed)
+	}
+	for i := 0; i < 2; i++ {
+		_, err = db.Exec("SELECT x FROM test")
+		require.NoError(t, err)
+		checkCaptures(0, false)
+	}
+	_, err = db.Exec("SELECT x FROM test")
+	require.NoError(t, err)
+	checkCaptures(1, false)
+	_, err = db.Exec("SELECT x FROM test")
+	require.NoError(t, err)
+	checkCaptures(2, true)
+}
+
+// Test that a request for a user and database is persisted, and is only
+// serviced by executions in a matching session.
+func TestDiagnosticsRequestUserAndDatabase(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", UserName: security.TestUserName(), Database: "defaultdb",
+	})
+	require.NoError(t, err)
+
+	var userName, database string
+	require.NoError(t, db.QueryRow(
+		"SELECT user_name, database_name FROM system.statement_diagnostics_requests WHERE ID = $1",
+		reqID,
+	).Scan(&userName, &database))
+	require.Equal(t, "testuser", userName)
+	require.Equal(t, "defaultdb", database)
+
+	// Executions in other sessions don't service the request.
+	shouldCollect, _, _ := registry.ShouldCollectDiagnostics(
+		ctx, "SELECT x FROM test", "" /* preparedName */, security.RootUserName(), "defaultdb",
+	)
+	require.False(t, shouldCollect)
+	shouldCollect, _, _ = registry.ShouldCollectDiagnostics(
+		ctx, "SELECT x FROM test", "" /* preparedName */, security.TestUserName(), "postgres",
+	)
+	require.False(t, shouldCollect)
+
+	shouldCollect, id, finish := registry.ShouldCollectDiagnostics(
+		ctx, "SELECT x FROM test", "" /* preparedName */, security.TestUserName(), "defaultdb",
+	)
+	require.True(t, shouldCollect)
+	require.Equal(t, reqID, int64(id))
+	finish()
+
+	// A request for a database only is serviced by executions of any user in a
+	// session with that database.
+	reqID, err = registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT y FROM test", Database: "postgres",
+	})
+	require.NoError(t, err)
+	shouldCollect, _, _ = registry.ShouldCollectDiagnostics(
+		ctx, "SELECT y FROM test", "" /* preparedName */, security.TestUserName(), "defaultdb",
+	)
+	require.False(t, shouldCollect)
+	shouldCollect, id, finish = registry.ShouldCollectDiagnostics(
+		ctx, "SELECT y FROM test", "" /* preparedName */, security.RootUserName(), "postgres",
+	)
+	require.True(t, shouldCollect)
+	require.Equal(t, reqID, int64(id))
+	finish()
+}
+
+// Test that only one request can be pending for a fingerprint, user and
+// database, and that requests for the same fingerprint restricted to different
+// users don't conflict.
+func TestDiagnosticsRequestPendingPerUser(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, _, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	for _, opts := range []stmtdiagnostics.RequestOptions{
+		{Fingerprint: "SELECT x FROM test", UserName: security.TestUserName()},
+		{Fingerprint: "SELECT x FROM test", UserName: security.RootUserName()},
+		{Fingerprint: "SELECT x FROM test"},
+		{Fingerprint: "SELECT x FROM test", UserName: security.TestUserName(), Database: "defaultdb"},
+	} {
+		_, err := registry.InsertRequestInternal(ctx, opts)
+		require.NoError(t, err)
+	}
+	for _, opts := range []stmtdiagnostics.RequestOptions{
+		{Fingerprint: "SELECT x FROM test", UserName: security.TestUserName()},
+		{Fingerprint: "SELECT x FROM test"},
+	} {
+		_, err := registry.InsertRequestInternal(ctx, opts)
+		require.EqualError(t, err, "a pending request for the requested fingerprint already exists")
+	}
+}
+
+// Test that the options of a request can be combined: the request is only
+// serviced by an execution that satisfies all of them.
+func TestDiagnosticsRequestCombinedOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test",
+		Database:    "defaultdb",
+		Verbosity:   stmtdiagnostics.TraceVerbositySQL,
+		MinRows:     10,
+	})
+	require.NoError(t, err)
+
+	var database, verbosity string
+	var minRows int64
+	require.NoError(t, db.QueryRow(
+		"SELECT database_name, verbosity, min_rows FROM system.statement_diagnostics_requests "+
+			"WHERE ID = $1",
+		reqID,
+	).Scan(&database, &verbosity, &minRows))
+	require.Equal(t, "defaultdb", database)
+	require.Equal(t, "sql", verbosity)
+	require.Equal(t, int64(10), minRows)
+
+	shouldCollect, _, _ := registry.ShouldCollectDiagnostics(
+		ctx, "SELECT x FROM test", "" /* preparedName */, security.RootUserName(), "postgres",
+	)
+	require.False(t, shouldCollect)
+	shouldCollect, id, _ := registry.ShouldCollectDiagnostics(
+		ctx, "SELECT x FROM test", "" /* preparedName */, security.RootUserName(), "defaultdb",
+	)
+	require.True(t, shouldCollect)
+	require.Equal(t, reqID, int64(id))
+	require.Equal(t, stmtdiagnostics.TraceVerbositySQL, registry.Verbosity(id))
+	// An execution that doesn't process enough rows leaves the request pending.
+	require.False(t, registry.ShouldFinishCollection(id, "" /* planGist */, 5 /* rowsProcessed */))
+	shouldCollect, id, _ = registry.ShouldCollectDiagnostics(
+		ctx, "SELECT x FROM test", "" /* preparedName */, security.RootUserName(), "defaultdb",
+	)
+	require.True(t, shouldCollect)
+	require.True(t, registry.ShouldFinishCollection(id, "" /* planGist */, 10 /* rowsProcessed */))
+}
+
+// Test that a request for a prepared statement name is persisted, and is
+// serviced by executions of the statement prepared under that name, regardless
+// of its fingerprint.
+func TestDiagnosticsRequestPreparedStatementName(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+	// PREPARE and EXECUTE need to use the same session.
+	db.SetMaxOpenConns(1)
+	_, err := db.Exec("CREATE TABLE test (x int PRIMARY KEY)")
+	require.NoError(t, err)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		PreparedName: "q1",
+	})
+	require.NoError(t, err)
+
+	var name string
+	require.NoError(t, db.QueryRow(
+		"SELECT prepared_statement_name FROM system.statement_diagnostics_requests WHERE ID = $1",
+		reqID,
+	).Scan(&name))
+	require.Equal(t, "q1", name)
+
+	// Only one request can be pending for a given name.
+	_, err = registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{PreparedName: "q1"})
+	require.EqualError(t, err, "a pending request for the requested prepared statement name already exists")
+
+	// Statements that are not prepared under the requested name don't service
+	// the request.
+	shouldCollect, _, _ := registry.ShouldCollectDiagnostics(
+		ctx, "SELECT x FROM test", "" /* preparedName */, security.RootUserName(), "defaultdb",
+	)
+	require.False(t, shouldCollect)
+	shouldCollect, _, _ = registry.ShouldCollectDiagnostics(
+		ctx, "SELECT x FROM test", "q2", security.RootUserName(), "defaultdb",
+	)
+	require.False(t, shouldCollect)
+
+	_, err = db.Exec("PREPARE q2 AS SELECT x FROM test WHERE x > $1")
+	require.NoError(t, err)
+	_, err = db.Exec("EXECUTE q2(1)")
+	require.NoError(t, err)
+	_, err = db.Exec("PREPARE q1 AS SELECT x FROM test WHERE x < $1")
+	require.NoError(t, err)
+	_, err = db.Exec("EXECUTE q1(1)")
+	require.NoError(t, err)
+
+	var completed bool
+	var traceID gosql.NullInt64
+	require.NoError(t, db.QueryRow(
+		"SELECT completed, statement_diagnostics_id FROM system.statement_diagnostics_requests WHERE ID = $1",
+		reqID,
+	).Scan(&completed, &traceID))
+	require.True(t, completed)
+	require.True(t, traceID.Valid)
+}
+
+// Test that HasPendingRequests reflects whether any request is waiting for a
+// statement on this node.
+func TestDiagnosticsRequestHasPending(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+	_, err := db.Exec("CREATE TABLE test (x int PRIMARY KEY)")
+	require.NoError(t, err)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	require.False(t, registry.HasPendingRequests())
+	_, err = registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test",
+	})
+	require.NoError(t, err)
+	require.True(t, registry.HasPendingRequests())
+	_, err = db.Exec("SELECT x FROM test")
+	require.NoError(t, err)
+	require.False(t, registry.HasPendingRequests())
+}
+
+// Test that the span filters of a request are persisted and made available to
+// the execution that services it.
+func TestDiagnosticsRequestSpanFilters(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", SpanFilters: []string{"flow", "colbatchscan"},
+	})
+	require.NoError(t, err)
+
+	var filters string
+	require.NoError(t, db.QueryRow(
+		"SELECT span_filters::STRING FROM system.statement_diagnostics_requests WHERE ID = $1", reqID,
+	).Scan(&filters))
+	require.Equal(t, "{flow,colbatchscan}", filters)
+
+	shouldCollect, id, finish := registry.ShouldCollectDiagnostics(
+		ctx, "SELECT x FROM test", "" /* preparedName */, security.RootUserName(), "defaultdb",
+	)
+	require.True(t, shouldCollect)
+	require.Equal(t, reqID, int64(id))
+	require.Equal(t, []string{"flow", "colbatchscan"}, registry.SpanFilters(id))
+	finish()
+}
+
+// Test that the trace verbosity of a request is persisted and made available to
+// the execution that services it.
+func TestDiagnosticsRequestVerbosity(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	_, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", Verbosity: "verbose",
+	})
+	require.Error(t, err)
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", Verbosity: stmtdiagnostics.TraceVerbositySQL,
+	})
+	require.NoError(t, err)
+
+	var verbosity string
+	require.NoError(t, db.QueryRow(
+		"SELECT verbosity FROM system.statement_diagnostics_requests WHERE ID = $1", reqID,
+	).Scan(&verbosity))
+	require.Equal(t, "sql", verbosity)
+
+	shouldCollect, id, finish := registry.ShouldCollectDiagnostics(
+		ctx, "SELECT x FROM test", "" /* preparedName */, security.RootUserName(), "defaultdb",
+	)
+	require.True(t, shouldCollect)
+	require.Equal(t, reqID, int64(id))
+	require.Equal(t, stmtdiagnostics.TraceVerbositySQL, registry.Verbosity(id))
+	finish()
+}
+
+func TestDiagnosticsRequestLogVerbosity(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	_, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", LogVerbosity: -1,
+	})
+	require.Error(t, err)
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", LogVerbosity: 2,
+	})
+	require.NoError(t, err)
+
+	var logVerbosity int
+	require.NoError(t, db.QueryRow(
+		"SELECT log_verbosity FROM system.statement_diagnostics_requests WHERE ID = $1", reqID,
+	).Scan(&logVerbosity))
+	require.Equal(t, 2, logVerbosity)
+
+	shouldCollect, id, finish := registry.ShouldCollectDiagnostics(
+		ctx, "SELECT x FROM test", "" /* preparedName */, security.RootUserName(), "defaultdb",
+	)
+	require.True(t, shouldCollect)
+	require.Equal(t, reqID, int64(id))
+	require.Equal(t, 2, registry.LogVerbosity(id))
+	finish()
+}
+
+func TestDiagnosticsRequestTags(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	_, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", Tags: map[string]string{"": "foo"},
+	})
+	require.Error(t, err)
+	tags := map[string]string{"ticket": "12345", "engineer": "alice"}
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test", Tags: tags,
+	})
+	require.NoError(t, err)
+
+	var ticket string
+	require.NoError(t, db.QueryRow(
+		"SELECT tags->>'ticket' FROM system.statement_diagnostics_requests WHERE ID = $1", reqID,
+	).Scan(&ticket))
+	require.Equal(t, "12345", ticket)
+
+	// Poll the requests, so that the tags are decoded from the system table.
+	require.NoError(t, registry.PollRequestsInternal(ctx))
+	shouldCollect, id, finish := registry.ShouldCollectDiagnostics(
+		ctx, "SELECT x FROM test", "" /* preparedName */, security.RootUserName(), "defaultdb",
+	)
+	require.True(t, shouldCollect)
+	require.Equal(t, reqID, int64(id))
+	require.Equal(t, tags, registry.Tags(id))
+	finish()
+}
+
+// Test that a canceled request is not serviced, and that the diagnostics
+// collected for a request canceled during the execution are discarded.
+func TestDiagnosticsRequestCancel(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+	_, err := db.Exec("CREATE TABLE test (x int PRIMARY KEY)")
+	require.NoError(t, err)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	checkCanceled := func(reqID int64, fprint string) {
+		var completed bool
+		var traceID gosql.NullInt64
+		require.NoError(t, db.QueryRow(
+			"SELECT completed, statement_diagnostics_id FROM system.statement_diagnostics_requests WHERE ID = $1",
+			reqID,
+		).Scan(&completed, &traceID))
+		require.True(t, completed)
+		require.False(t, traceID.Valid)
+		var count int
+		require.NoError(t, db.QueryRow(
+			"SELECT count(*) FROM system.statement_diagnostics WHERE statement_fingerprint = $1", fprint,
+		).Scan(&count))
+		require.Zero(t, count)
+	}
+
+	// Cancel a pending request.
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test",
+	})
+	require.NoError(t, err)
+	require.NoError(t, registry.Cancel(ctx, stmtdiagnostics.RequestID(reqID)))
+	shouldCollect, _, _ := registry.ShouldCollectDiagnostics(
+		ctx, "SELECT x FROM test", "" /* preparedName */, security.RootUserName(), "defaultdb",
+	)
+	require.False(t, shouldCollect)
+	_, err = db.Exec("SELECT x FROM test")
+	require.NoError(t, err)
+	checkCanceled(reqID, "SELECT x FROM test")
+
+	// A request can't be canceled twice.
+	require.Error(t, registry.Cancel(ctx, stmtdiagnostics.RequestID(reqID)))
+
+	// Cancel a request while it is being serviced. The statement cancels the
+	// request that it services.
+	const fprint = "SELECT crdb_internal.cancel_statement_diagnostics_request(_)"
+	reqID, err = registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: fprint,
+	})
+	require.NoError(t, err)
+	var canceled bool
+	require.NoError(t, db.QueryRow(
+		fmt.Sprintf("SELECT crdb_internal.cancel_statement_diagnostics_request(%d)", reqID),
+	).Scan(&canceled))
+	require.True(t, canceled)
+	checkCanceled(reqID, fprint)
+}
+
+// Test that Validate warns about fingerprints which don't match any recently
+// executed statement, and that the request-creation builtin still creates the
+// request.
+func TestDiagnosticsRequestValidate(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	ctx := context.Background()
+	defer s.Stopper().Stop(ctx)
+	_, err := db.Exec("CREATE TABLE test (x int PRIMARY KEY)")
+	require.NoError(t, err)
+	_, err = db.Exec("SELECT x FROM test WHERE x = 1")
+	require.NoError(t, err)
+
+	registry := s.ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
+	warning, err := registry.Validate(ctx, "SELECT x FROM test WHERE x = _")
+	require.NoError(t, err)
+	require.Empty(t, warning)
+
+	// The fingerprint isn't anonymized.
+	warning, err = registry.Validate(ctx, "SELECT x FROM test WHERE x = 1")
+	require.NoError(t, err)
+	require.Contains(t, warning, "was executed recently")
+
+	const fprint = "SELECT x FROM test WHERE x = 2"
+	var ok bool
+	require.NoError(t, db.QueryRow(
+		"SELECT crdb_internal.request_statement_diagnostics($1)", fprint,
+	).Scan(&ok))
+	require.True(t, ok)
+	var count int
+	require.NoError(t, db.QueryRow(
+		"SELECT count(*) FROM system.statement_diagnostics_requests WHERE statement_fingerprint = $1",
+		fprint,
+	).Scan(&count))
+	require.Equal(t, 1, count)
+}
+
 // Test that a different node can service a diagnostics request.
 func TestDiagnosticsRequestDifferentNode(t *testing.T) {
 	defer leaktest.AfterTest(t)()
@@ -108,7 +759,9 @@ func TestDiagnosticsRequestDifferentNode(t *testing.T) {
 
 	// Ask to trace a particular query using node 0.
 	registry := tc.Server(0).ExecutorConfig().(sql.ExecutorConfig).StmtDiagnosticsRecorder
-	reqID, err := registry.InsertRequestInternal(ctx, "INSERT INTO test VALUES (_)")
+	reqID, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "INSERT INTO test VALUES (_)",
+	})
 	require.NoError(t, err)
 	reqRow := db0.QueryRow(
 		`SELECT completed, statement_diagnostics_id FROM system.statement_diagnostics_requests
@@ -150,11 +803,17 @@ func TestDiagnosticsRequestDifferentNode(t *testing.T) {
 	runUntilTraced("INSERT INTO test VALUES (1)", reqID)
 
 	// Verify that we can handle multiple requests at the same time.
-	id1, err := registry.InsertRequestInternal(ctx, "INSERT INTO test VALUES (_)")
+	id1, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "INSERT INTO test VALUES (_)",
+	})
 	require.NoError(t, err)
-	id2, err := registry.InsertRequestInternal(ctx, "SELECT x FROM test")
+	id2, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test",
+	})
 	require.NoError(t, err)
-	id3, err := registry.InsertRequestInternal(ctx, "SELECT x FROM test WHERE x > _")
+	id3, err := registry.InsertRequestInternal(ctx, stmtdiagnostics.RequestOptions{
+		Fingerprint: "SELECT x FROM test WHERE x > _",
+	})
 	require.NoError(t, err)
 
 	// Run the queries in a different order.
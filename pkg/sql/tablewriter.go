@@ -15,11 +15,15 @@ import (
 
 	"github.com/cockroachdb/cockroach/pkg/kv"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
+	"github.com/cockroachdb/cockroach/pkg/sql/execstats/execstatspb"
 	"github.com/cockroachdb/cockroach/pkg/sql/mutations"
 	"github.com/cockroachdb/cockroach/pkg/sql/row"
 	"github.com/cockroachdb/cockroach/pkg/sql/rowcontainer"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
 )
 
 // expressionCarrier handles visiting sub-expressions.
@@ -174,3 +178,21 @@ func (tb *tableWriterBase) close(ctx context.Context) {
 		tb.rows = nil
 	}
 }
+
+// recordRowsWritten records in the trace (if we are tracing) that the given
+// number of rows were written to a table. The span it creates is picked up by
+// the TraceAnalyzer (see execstats.TraceAnalyzer.GetRowsWrittenByTable).
+func recordRowsWritten(ctx context.Context, tableID descpb.ID, rowsWritten int) {
+	if rowsWritten == 0 {
+		return
+	}
+	if sp := tracing.SpanFromContext(ctx); sp == nil || !sp.IsRecording() {
+		return
+	}
+	_, sp := tracing.ChildSpan(ctx, "rows written")
+	sp.SetTag(execinfrapb.TableIDTagKey, tableID)
+	sp.SetSpanStats(&execstatspb.ComponentStats{
+		KV: execstatspb.KVStats{RowsWritten: execstatspb.MakeIntValue(uint64(rowsWritten))},
+	})
+	sp.Finish()
+}
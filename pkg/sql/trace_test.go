@@ -18,6 +18,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/security"
@@ -619,3 +620,38 @@ func TestTraceDistSQL(t *testing.T) {
 	// Check that the table reader indeed came from a remote note.
 	require.Equal(t, "2", sp.Tags["node"])
 }
+
+// Test that the durations of the phases of a statement are attached as tags to
+// the statement span.
+func TestTracePhaseTimesTags(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	stmt := "SELECT count(1) FROM test.a"
+	recCh := make(chan tracing.Recording, 1)
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{
+		Knobs: base.TestingKnobs{
+			SQLExecutor: &sql.ExecutorTestingKnobs{
+				WithStatementTrace: func(trace tracing.Recording, stmtSQL string) {
+					if stmtSQL == stmt {
+						recCh <- trace
+					}
+				},
+			},
+		},
+	})
+	defer s.Stopper().Stop(ctx)
+
+	r := sqlutils.MakeSQLRunner(db)
+	r.Exec(t, "CREATE DATABASE test")
+	r.Exec(t, "CREATE TABLE test.a (a INT PRIMARY KEY)")
+	r.Exec(t, stmt)
+
+	rec := <-recCh
+	for _, tag := range []string{"phase.parsing", "phase.planning", "phase.execution"} {
+		val, ok := rec[0].Tags[tag]
+		require.True(t, ok, "tag %s not found", tag)
+		_, err := time.ParseDuration(val)
+		require.NoError(t, err)
+	}
+}
@@ -204,6 +204,7 @@ func (u *updateNode) BatchedNext(params runParams) (bool, error) {
 		u.run.tu.tableDesc().GetID(),
 		u.run.tu.lastBatchSize,
 	)
+	recordRowsWritten(params.ctx, u.run.tu.tableDesc().GetID(), u.run.tu.lastBatchSize)
 
 	return u.run.tu.lastBatchSize > 0, nil
 }
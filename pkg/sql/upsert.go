@@ -133,6 +133,7 @@ func (n *upsertNode) BatchedNext(params runParams) (bool, error) {
 		n.run.tw.tableDesc().GetID(),
 		n.run.tw.lastBatchSize,
 	)
+	recordRowsWritten(params.ctx, n.run.tw.tableDesc().GetID(), n.run.tw.lastBatchSize)
 
 	return n.run.tw.lastBatchSize > 0, nil
 }
@@ -369,6 +369,78 @@ var varGen = map[string]sessionVar{
 		GlobalDefault: globalFalse,
 	},
 
+	// CockroachDB extension.
+	`collect_all_statement_bundles`: {
+		GetStringVal: makePostgresBoolGetStringValFn(`collect_all_statement_bundles`),
+		Set: func(_ context.Context, m *sessionDataMutator, s string) error {
+			b, err := paramparse.ParseBoolVar(`collect_all_statement_bundles`, s)
+			if err != nil {
+				return err
+			}
+			m.SetCollectAllStatementBundles(b)
+			return nil
+		},
+		Get: func(evalCtx *extendedEvalContext) string {
+			return formatBoolAsPostgresSetting(evalCtx.SessionData.CollectAllStatementBundles)
+		},
+		GlobalDefault: globalFalse,
+	},
+
+	// CockroachDB extension.
+	`explain_analyze_trace_summary`: {
+		GetStringVal: makePostgresBoolGetStringValFn(`explain_analyze_trace_summary`),
+		Set: func(_ context.Context, m *sessionDataMutator, s string) error {
+			b, err := paramparse.ParseBoolVar(`explain_analyze_trace_summary`, s)
+			if err != nil {
+				return err
+			}
+			m.SetExplainAnalyzeTraceSummary(b)
+			return nil
+		},
+		Get: func(evalCtx *extendedEvalContext) string {
+			return formatBoolAsPostgresSetting(evalCtx.SessionData.ExplainAnalyzeTraceSummary)
+		},
+		GlobalDefault: globalFalse,
+	},
+
+	// CockroachDB extension.
+	`explain_analyze_max_rows`: {
+		GetStringVal: makeIntGetStringValFn(`explain_analyze_max_rows`),
+		Set: func(_ context.Context, m *sessionDataMutator, s string) error {
+			b, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			if b < 0 {
+				return pgerror.Newf(pgcode.InvalidParameterValue,
+					"cannot set explain_analyze_max_rows to a negative value: %d", b)
+			}
+			m.SetExplainAnalyzeMaxRows(int(b))
+			return nil
+		},
+		Get: func(evalCtx *extendedEvalContext) string {
+			return strconv.FormatInt(int64(evalCtx.SessionData.ExplainAnalyzeMaxRows), 10)
+		},
+		GlobalDefault: func(sv *settings.Values) string { return "0" },
+	},
+
+	// CockroachDB extension.
+	`random_seed`: {
+		GetStringVal: makeIntGetStringValFn(`random_seed`),
+		Set: func(_ context.Context, m *sessionDataMutator, s string) error {
+			seed, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			m.SetRandomSeed(seed)
+			return nil
+		},
+		Get: func(evalCtx *extendedEvalContext) string {
+			return strconv.FormatInt(evalCtx.SessionData.RandomSeed, 10)
+		},
+		GlobalDefault: func(sv *settings.Values) string { return "0" },
+	},
+
 	// CockroachDB extension.
 	`distsql`: {
 		Set: func(_ context.Context, m *sessionDataMutator, s string) error {
@@ -566,6 +638,28 @@ var varGen = map[string]sessionVar{
 		},
 	},
 
+	// CockroachDB extension.
+	`statement_diagnostics_history_size`: {
+		GetStringVal: makeIntGetStringValFn(`statement_diagnostics_history_size`),
+		Set: func(_ context.Context, m *sessionDataMutator, s string) error {
+			b, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			if b < 0 || b > maxStmtDiagnosticsHistorySize {
+				return pgerror.Newf(pgcode.InvalidParameterValue,
+					"statement_diagnostics_history_size must be between 0 and %d: %d",
+					maxStmtDiagnosticsHistorySize, b)
+			}
+			m.SetStatementDiagnosticsHistorySize(int(b))
+			return nil
+		},
+		Get: func(evalCtx *extendedEvalContext) string {
+			return strconv.Itoa(evalCtx.SessionData.StatementDiagnosticsHistorySize)
+		},
+		GlobalDefault: func(sv *settings.Values) string { return "0" },
+	},
+
 	// CockroachDB extension.
 	`foreign_key_cascades_limit`: {
 		GetStringVal: makeIntGetStringValFn(`foreign_key_cascades_limit`),
@@ -333,6 +333,13 @@ var backwardCompatibleMigrations = []migrationDescriptor{
 		workFn:              markDeprecatedSchemaChangeJobsFailed,
 		includedInBootstrap: clusterversion.VersionByKey(clusterversion.VersionLeasedDatabaseDescriptors),
 	},
+	{
+		// Introduced in v21.1.
+		name:   "add columns for diagnostics request options to statement diagnostics tables",
+		workFn: alterSystemStmtDiagAddRequestOptionsColumns,
+		includedInBootstrap: clusterversion.VersionByKey(
+			clusterversion.VersionStatementDiagnosticsRequestOptions),
+	},
 }
 
 func staticIDs(
@@ -1336,6 +1343,40 @@ func createTenantsTable(ctx context.Context, r runner) error {
 	return createSystemTable(ctx, r, systemschema.TenantsTable)
 }
 
+func alterSystemStmtDiagAddRequestOptionsColumns(ctx context.Context, r runner) error {
+	asNode := sessiondata.InternalExecutorOverride{User: security.NodeUserName()}
+	for _, stmt := range []string{
+		`
+ALTER TABLE system.statement_diagnostics_requests
+ADD COLUMN IF NOT EXISTS plan_gist STRING FAMILY "primary",
+ADD COLUMN IF NOT EXISTS max_captures INT8 FAMILY "primary",
+ADD COLUMN IF NOT EXISTS span_filters STRING[] FAMILY "primary",
+ADD COLUMN IF NOT EXISTS verbosity STRING FAMILY "primary",
+ADD COLUMN IF NOT EXISTS skip_executions INT8 FAMILY "primary",
+ADD COLUMN IF NOT EXISTS user_name STRING FAMILY "primary",
+ADD COLUMN IF NOT EXISTS database_name STRING FAMILY "primary",
+ADD COLUMN IF NOT EXISTS prepared_statement_name STRING FAMILY "primary",
+ADD COLUMN IF NOT EXISTS log_verbosity INT8 FAMILY "primary",
+ADD COLUMN IF NOT EXISTS tags JSONB FAMILY "primary",
+ADD COLUMN IF NOT EXISTS min_rows INT8 FAMILY "primary",
+ADD COLUMN IF NOT EXISTS num_captures INT8 FAMILY "primary"
+`,
+		`
+ALTER TABLE system.statement_diagnostics
+ADD COLUMN IF NOT EXISTS request_id INT8 FAMILY "primary",
+ADD COLUMN IF NOT EXISTS sample_index INT8 FAMILY "primary",
+ADD COLUMN IF NOT EXISTS trace_hash STRING FAMILY "primary"
+`,
+	} {
+		if _, err := r.sqlExecutor.ExecEx(
+			ctx, "add-stmt-diag-request-options", nil /* txn */, asNode, stmt,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func alterSystemScheduledJobsFixTableSchema(ctx context.Context, r runner) error {
 	setOwner := "UPDATE system.scheduled_jobs SET owner='root' WHERE owner IS NULL"
 	asNode := sessiondata.InternalExecutorOverride{User: security.NodeUserName()}
@@ -1061,3 +1061,118 @@ func TestMarkDeprecatedSchemaChangeJobsFailed(t *testing.T) {
 		}
 	}
 }
+
+func TestAlterSystemStmtDiagAddRequestOptionsColumns(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	ctx := context.Background()
+
+	// We need to use the "old" statement diagnostics table descriptors, without
+	// the new columns, in order to test the migration.
+	tables := []struct {
+		name       string
+		id         descpb.ID
+		desc       **tabledesc.Immutable
+		oldSchema  string
+		oldColumns []string
+		newColumns []string
+	}{
+		{
+			name: "statement_diagnostics_requests",
+			id:   keys.StatementDiagnosticsRequestsTableID,
+			desc: &systemschema.StatementDiagnosticsRequestsTable,
+			oldSchema: `
+CREATE TABLE system.statement_diagnostics_requests(
+	id INT8 DEFAULT unique_rowid() PRIMARY KEY NOT NULL,
+	completed BOOL NOT NULL DEFAULT FALSE,
+	statement_fingerprint STRING NOT NULL,
+	statement_diagnostics_id INT8,
+	requested_at TIMESTAMPTZ NOT NULL,
+	INDEX completed_idx (completed, id) STORING (statement_fingerprint),
+
+	FAMILY "primary" (id, completed, statement_fingerprint, statement_diagnostics_id, requested_at)
+)
+`,
+			oldColumns: []string{
+				"id", "completed", "statement_fingerprint", "statement_diagnostics_id", "requested_at",
+			},
+			newColumns: []string{
+				"plan_gist", "max_captures", "span_filters", "verbosity", "skip_executions", "user_name",
+				"database_name", "prepared_statement_name", "log_verbosity", "tags", "min_rows",
+				"num_captures",
+			},
+		},
+		{
+			name: "statement_diagnostics",
+			id:   keys.StatementDiagnosticsTableID,
+			desc: &systemschema.StatementDiagnosticsTable,
+			oldSchema: `
+CREATE TABLE system.statement_diagnostics(
+	id INT8 DEFAULT unique_rowid() PRIMARY KEY NOT NULL,
+	statement_fingerprint STRING NOT NULL,
+	statement STRING NOT NULL,
+	collected_at TIMESTAMPTZ NOT NULL,
+	trace JSONB,
+	bundle_chunks INT ARRAY,
+	error STRING,
+
+	FAMILY "primary" (id, statement_fingerprint, statement, collected_at, trace, bundle_chunks, error)
+)
+`,
+			oldColumns: []string{
+				"id", "statement_fingerprint", "statement", "collected_at", "trace", "bundle_chunks",
+				"error",
+			},
+			newColumns: []string{"request_id", "sample_index", "trace_hash"},
+		},
+	}
+	for _, table := range tables {
+		oldTable, err := sql.CreateTestTableDescriptor(
+			context.Background(),
+			keys.SystemDatabaseID,
+			table.id,
+			table.oldSchema,
+			(*table.desc).Privileges,
+		)
+		require.NoError(t, err)
+		require.Equal(t, len(table.oldColumns), len(oldTable.Columns))
+
+		desc, origDesc := table.desc, *table.desc
+		*desc = tabledesc.NewImmutable(*oldTable.TableDesc())
+		defer func() {
+			*desc = origDesc
+		}()
+	}
+
+	mt := makeMigrationTest(ctx, t)
+	defer mt.close(ctx)
+
+	migration := mt.pop(
+		t, "add columns for diagnostics request options to statement diagnostics tables",
+	)
+	mt.start(t, base.TestServerArgs{})
+
+	// Run the migration and verify that the columns were added to the primary
+	// families.
+	require.NoError(t, mt.runMigration(ctx, migration))
+	newTables := make([]*tabledesc.Immutable, len(tables))
+	for i, table := range tables {
+		newTables[i] = catalogkv.TestingGetTableDescriptor(
+			mt.kvDB, keys.SystemSQLCodec, "system", table.name)
+		expected := append(append([]string(nil), table.oldColumns...), table.newColumns...)
+		var columns []string
+		for _, col := range newTables[i].Columns {
+			columns = append(columns, col.Name)
+		}
+		require.Equal(t, expected, columns, table.name)
+		require.Equal(t, 1, len(newTables[i].Families), table.name)
+		require.Equal(t, expected, newTables[i].Families[0].ColumnNames, table.name)
+	}
+
+	// Run the migration again -- it should be a no-op.
+	require.NoError(t, mt.runMigration(ctx, migration))
+	for i, table := range tables {
+		newTableAgain := catalogkv.TestingGetTableDescriptor(
+			mt.kvDB, keys.SystemSQLCodec, "system", table.name)
+		require.True(t, newTables[i].TableDesc().Equal(newTableAgain.TableDesc()), table.name)
+	}
+}
@@ -1536,6 +1536,23 @@ var charts = []sectionDescription{
 			},
 		},
 	},
+	{
+		Organization: [][]string{{SQLLayer, "Statement Diagnostics"}},
+		Charts: []chartDescription{
+			{
+				Title:   "Bundles Stored",
+				Metrics: []string{"sql.stmt_diagnostics.bundles"},
+			},
+			{
+				Title:   "Bytes Stored",
+				Metrics: []string{"sql.stmt_diagnostics.bytes"},
+			},
+			{
+				Title:   "Bundle Storage",
+				Metrics: []string{"sql.stmt_diagnostics.stored_bytes"},
+			},
+		},
+	},
 	{
 		Organization: [][]string{{SQLLayer, "SQL Liveness"}},
 		Charts: []chartDescription{
@@ -1873,6 +1890,14 @@ var charts = []sectionDescription{
 				},
 				AxisLabel: "Latency",
 			},
+			{
+				Title: "Instrumentation Overhead",
+				Metrics: []string{
+					"sql.instrumentation.overhead",
+					"sql.instrumentation.overhead.internal",
+				},
+				AxisLabel: "Latency",
+			},
 		},
 	},
 	{
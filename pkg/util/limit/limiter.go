@@ -48,6 +48,12 @@ func (l *ConcurrentRequestLimiter) Begin(ctx context.Context) error {
 	return l.sem.Acquire(ctx, 1)
 }
 
+// TryBegin attempts to reserve a spot in the pool without blocking, and
+// returns whether it succeeded.
+func (l *ConcurrentRequestLimiter) TryBegin() bool {
+	return l.sem.TryAcquire(1)
+}
+
 // Finish indicates a concurrent request has completed and its reservation can
 // be returned to the pool.
 func (l *ConcurrentRequestLimiter) Finish() {
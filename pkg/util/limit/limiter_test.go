@@ -62,3 +62,20 @@ func TestConcurrentRequestLimiter(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestConcurrentRequestLimiterTryBegin(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	l := MakeConcurrentRequestLimiter("test", 1)
+	if !l.TryBegin() {
+		t.Fatal("expected to reserve a spot in an empty pool")
+	}
+	if l.TryBegin() {
+		t.Fatal("expected the pool to be full")
+	}
+	l.Finish()
+	if !l.TryBegin() {
+		t.Fatal("expected to reserve the spot that was returned to the pool")
+	}
+	l.Finish()
+}
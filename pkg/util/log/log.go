@@ -85,9 +85,15 @@ func Infof(ctx context.Context, format string, args ...interface{}) {
 // It extracts log tags from the context and logs them along with the given
 // message. Arguments are handled in the manner of fmt.Printf; a newline is
 // appended.
+//
+// If the verbosity level is not active but the context was set up with
+// WithTraceVerbosity at this level or above, the message is recorded in the
+// trace of the span in the context instead.
 func VInfof(ctx context.Context, level Level, format string, args ...interface{}) {
 	if VDepth(level, 1) {
 		logDepth(ctx, 1, Severity_INFO, format, args)
+	} else if traceVerbosityActive(ctx, level) {
+		eventfDepth(ctx, 1, format, args...)
 	}
 }
 
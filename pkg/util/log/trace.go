@@ -14,6 +14,7 @@ import (
 	"context"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
@@ -229,6 +230,55 @@ func Eventf(ctx context.Context, format string, args ...interface{}) {
 	eventInternal(sp, el, false /* isErr */, entry)
 }
 
+// ctxTraceVerbosityKey is an empty type for the handle associated with the
+// verbosity set by WithTraceVerbosity (see context.Value).
+type ctxTraceVerbosityKey struct{}
+
+// traceVerbosityUsed is set the first time WithTraceVerbosity is called, so
+// that VInfof doesn't need to look up the context value otherwise.
+var traceVerbosityUsed int32
+
+// WithTraceVerbosity returns a context in which the messages of VInfof at the
+// given verbosity level (or below) are recorded in the trace of the span in
+// the context, even if that level is not active for the log files. This
+// allows capturing these messages for a single operation without raising the
+// logging verbosity of the entire process. Note that the verbosity is only
+// propagated through the context, so it doesn't apply to remote operations.
+func WithTraceVerbosity(ctx context.Context, level Level) context.Context {
+	atomic.StoreInt32(&traceVerbosityUsed, 1)
+	return context.WithValue(ctx, ctxTraceVerbosityKey{}, level)
+}
+
+// traceVerbosityActive returns true if messages at the given verbosity level
+// should be recorded in the trace of the span in the context, as set up by
+// WithTraceVerbosity.
+func traceVerbosityActive(ctx context.Context, level Level) bool {
+	if atomic.LoadInt32(&traceVerbosityUsed) == 0 {
+		return false
+	}
+	v, ok := ctx.Value(ctxTraceVerbosityKey{}).(Level)
+	return ok && level <= v
+}
+
+// eventfDepth records a message in the trace of the span (or the event log)
+// in the context, if any, without logging it.
+func eventfDepth(ctx context.Context, depth int, format string, args ...interface{}) {
+	sp, el, ok := getSpanOrEventLog(ctx)
+	if !ok {
+		// Nothing to log. Skip the work.
+		return
+	}
+	entry := MakeEntry(ctx,
+		Severity_INFO, /* unused for trace events */
+		nil,           /* logCounter, unused for trace events */
+		depth+1,
+		// redactable is false because we want to flatten the data in traces
+		// -- we don't have infrastructure yet for trace redaction.
+		false, /* redactable */
+		format, args...)
+	eventInternal(sp, el, false /* isErr */, entry)
+}
+
 func vEventf(
 	ctx context.Context, isErr bool, depth int, level Level, format string, args ...interface{},
 ) {
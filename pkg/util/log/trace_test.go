@@ -115,6 +115,30 @@ func TestTraceWithTags(t *testing.T) {
 	}
 }
 
+func TestTraceVerbosity(t *testing.T) {
+	ctx := context.Background()
+
+	tracer := tracing.NewTracer()
+	sp := tracer.StartSpan("s", tracing.WithForceRealSpan())
+	sp.StartRecording(tracing.SingleNodeRecording)
+	ctxWithSpan := tracing.ContextWithSpan(ctx, sp)
+	v := noLogV()
+	VInfof(ctxWithSpan, v, "should-not-show-up")
+	ctxWithVerbosity := WithTraceVerbosity(ctxWithSpan, v+1)
+	VInfof(ctxWithVerbosity, v, "test1")
+	VInfof(ctxWithVerbosity, v+1, "test2")
+	VInfof(ctxWithVerbosity, v+2, "should-not-show-up")
+
+	sp.Finish()
+	if err := tracing.TestingCheckRecordedSpans(sp.GetRecording(), `
+		Span s:
+		  event: test1
+		  event: test2
+	`); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // testingEventLog is a simple implementation of trace.EventLog.
 type testingEventLog struct {
 	ev events
@@ -622,6 +622,14 @@ func ContextWithSpan(ctx context.Context, sp *Span) context.Context {
 // TODO(andrei): remove this method once EXPLAIN(TRACE) is gone.
 func StartSnowballTrace(
 	ctx context.Context, tracer *Tracer, opName string,
+) (context.Context, *Span) {
+	return StartRecordingTrace(ctx, tracer, opName, SnowballRecording)
+}
+
+// StartRecordingTrace is like StartSnowballTrace, but the Span records using
+// the given recording type.
+func StartRecordingTrace(
+	ctx context.Context, tracer *Tracer, opName string, recType RecordingType,
 ) (context.Context, *Span) {
 	var span *Span
 	if sp := SpanFromContext(ctx); sp != nil {
@@ -631,7 +639,7 @@ func StartSnowballTrace(
 	} else {
 		span = tracer.StartSpan(opName, WithForceRealSpan(), WithCtxLogTags(ctx))
 	}
-	span.StartRecording(SnowballRecording)
+	span.StartRecording(recType)
 	return ContextWithSpan(ctx, span), span
 }
 